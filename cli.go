@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/export"
+	"tgbackup/internal/importer"
+	"tgbackup/internal/media"
+)
+
+// runCommand runs a command line subcommand instead of the server and
+// returns the process exit code
+func runCommand(db *database.DB, args []string) int {
+	switch args[0] {
+	case "export":
+		return runExport(db, args[1:])
+	case "import":
+		return runImport(db, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, "usage: tgbackup [export|import] [flags]")
+		return 2
+	}
+}
+
+func runExport(db *database.DB, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	userID := fs.Int64("user", 0, "Telegram user ID of the account to export")
+	conversationID := fs.Int64("conversation", 0, "only export this conversation")
+	format := fs.String("format", "json", "json (Telegram Desktop result.json) or html (static site)")
+	out := fs.String("out", "export", "output directory, or a file ending in .zip for json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	switch *format {
+	case "html":
+		return runHTMLExport(db, *userID, *out)
+	case "json":
+	default:
+		fmt.Fprintf(os.Stderr, "export: unknown format %q\n", *format)
+		return 2
+	}
+
+	if *userID == 0 {
+		fmt.Fprintln(os.Stderr, "export: -user is required")
+		return 2
+	}
+
+	exporter := export.NewDesktop(db, media.NewStore(mediaDir))
+	run := func(sink export.Sink) error {
+		if *conversationID != 0 {
+			return exporter.ExportConversation(*userID, *conversationID, sink)
+		}
+		return exporter.ExportAccount(*userID, sink)
+	}
+
+	if err := writeExport(*out, run); err != nil {
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Export written to %s\n", *out)
+	return 0
+}
+
+// runHTMLExport writes the static HTML site for all accounts, or only userID
+// when given. Exporting into an existing site only rewrites changed conversations.
+func runHTMLExport(db *database.DB, userID int64, out string) int {
+	result, err := export.NewHTML(db, media.NewStore(mediaDir)).Export(out, userID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("HTML export written to %s: %d conversations, %d rewritten, %d unchanged\n",
+		out, result.Conversations, result.Written, result.Skipped)
+	return 0
+}
+
+func runImport(db *database.DB, args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	userID := fs.Int64("user", 0, "Telegram user ID to import into, defaults to the account of a full data export")
+	exportPath := fs.String("path", "", "Telegram Desktop export directory or its result.json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *exportPath == "" {
+		fmt.Fprintln(os.Stderr, "import: -path is required")
+		return 2
+	}
+
+	result, err := importer.NewDesktop(db, media.NewStore(mediaDir)).Import(*exportPath, *userID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Imported into user %d: %d conversations, %d new messages, %d already stored, %d skipped, %d media files\n",
+		result.UserID, result.Conversations, result.Imported, result.Existing, result.Skipped, result.MediaFiles)
+	return 0
+}
+
+// writeExport runs an export into a directory, or into a ZIP archive when
+// out ends in .zip
+func writeExport(out string, run func(sink export.Sink) error) error {
+	if !strings.HasSuffix(strings.ToLower(out), ".zip") {
+		return run(export.NewDirSink(out))
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sink := export.NewZipSink(f)
+	if err := run(sink); err != nil {
+		return err
+	}
+	if err := sink.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -8,6 +8,7 @@ require (
 	github.com/gotd/td v0.91.0
 	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/rs/cors v1.10.1
+	go.uber.org/zap v1.26.0
 )
 
 require (
@@ -40,7 +41,6 @@ require (
 	go.opentelemetry.io/otel/trace v1.21.0 // indirect
 	go.uber.org/atomic v1.11.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
-	go.uber.org/zap v1.26.0 // indirect
 	golang.org/x/arch v0.3.0 // indirect
 	golang.org/x/crypto v0.16.0 // indirect
 	golang.org/x/net v0.19.0 // indirect
@@ -0,0 +1,67 @@
+// Package album assembles the messages of a media album into one message.
+package album
+
+import (
+	"sort"
+
+	"tgbackup/internal/models"
+)
+
+// Assemble merges runs of consecutive messages sharing a GroupedID into one
+// message each, other messages are returned unchanged and in order
+func Assemble(messages []models.Message) []models.Message {
+	var result []models.Message
+	for i := 0; i < len(messages); {
+		j := i + 1
+		if messages[i].GroupedID != 0 {
+			for j < len(messages) && messages[j].GroupedID == messages[i].GroupedID {
+				j++
+			}
+		}
+
+		if j-i == 1 {
+			result = append(result, messages[i])
+		} else {
+			result = append(result, Merge(messages[i:j]))
+		}
+		i = j
+	}
+	return result
+}
+
+// Merge builds one message of type "album" from the items of an album. It
+// keeps the fields of the first item, the caption of whichever item carries
+// one and the media of all items ordered by message ID.
+func Merge(items []models.Message) models.Message {
+	sorted := make([]models.Message, len(items))
+	copy(sorted, items)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MessageID < sorted[j].MessageID })
+
+	merged := sorted[0]
+	merged.MessageType = "album"
+	merged.MediaURL = ""
+	merged.Content = ""
+	merged.Entities = nil
+	merged.Album = nil
+
+	for i := range sorted {
+		item := &sorted[i]
+		merged.Album = append(merged.Album, models.AlbumItem{
+			MessageID:   item.MessageID,
+			MessageType: item.MessageType,
+			MediaURL:    item.MediaURL,
+			Content:     item.Content,
+		})
+		if merged.Content == "" {
+			if text := item.Text(); text != "" {
+				merged.Content = text
+				merged.Entities = item.Entities
+			}
+		}
+	}
+
+	if merged.Content == "" {
+		merged.Content = "[Album]"
+	}
+	return merged
+}
@@ -2,29 +2,42 @@ package api
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"tgbackup/internal/album"
 	"tgbackup/internal/database"
+	"tgbackup/internal/export"
+	"tgbackup/internal/media"
 	"tgbackup/internal/models"
+	"tgbackup/internal/richtext"
+	tgsync "tgbackup/internal/sync"
 	"tgbackup/internal/telegram"
 )
 
 type Handler struct {
 	db       *database.DB
-	tgClient *telegram.Client
+	tgClient telegram.API
+	hub      *Hub
+	media    *media.Store
+	sync     *tgsync.Scheduler
 	upgrader websocket.Upgrader
 }
 
-func NewHandler(db *database.DB, tgClient *telegram.Client) *Handler {
+func NewHandler(db *database.DB, tgClient telegram.API, hub *Hub, store *media.Store, scheduler *tgsync.Scheduler) *Handler {
 	return &Handler{
 		db:       db,
 		tgClient: tgClient,
+		hub:      hub,
+		media:    store,
+		sync:     scheduler,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins for development
@@ -314,14 +327,147 @@ func (h *Handler) GetMessages(c *gin.Context) {
 		offset = 0
 	}
 
-	messages, err := h.db.GetMessages(conversationID, limit, offset)
+	// Optionally render the text with its formatting entities
+	format := c.Query("format")
+	if format != "" && format != "html" && format != "markdown" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, use html or markdown"})
+		return
+	}
+
+	fetch := func(limit, offset int) ([]models.Message, error) {
+		return h.db.GetMessages(conversationID, limit, offset)
+	}
+	if topicStr := c.Query("topic_id"); topicStr != "" {
+		topicID, err := strconv.Atoi(topicStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid topic ID"})
+			return
+		}
+		fetch = func(limit, offset int) ([]models.Message, error) {
+			return h.db.GetTopicMessages(conversationID, topicID, limit, offset)
+		}
+	}
+
+	messages, err := fetch(limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
 		return
 	}
+	// Offsets count stored messages, the page may hold fewer once albums are merged
+	rows := len(messages)
+	hasMore := rows == limit
+
+	// Albums are returned as one message unless albums=0
+	if c.DefaultQuery("albums", "1") != "0" {
+		// Take the rest of an album cut off by the limit, so it is not split across pages
+		if hasMore && rows > 0 && messages[rows-1].GroupedID != 0 {
+			groupedID := messages[rows-1].GroupedID
+			more, err := fetch(maxAlbumItems, offset+rows)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+				return
+			}
+			n := 0
+			for n < len(more) && more[n].GroupedID == groupedID {
+				n++
+			}
+			messages = append(messages, more[:n]...)
+			rows += n
+			hasMore = len(more) > n
+		}
+		messages = album.Assemble(messages)
+	}
+
+	for i := range messages {
+		switch format {
+		case "html":
+			messages[i].Formatted = richtext.HTML(messages[i].Content, messages[i].Entities)
+		case "markdown":
+			messages[i].Formatted = richtext.Markdown(messages[i].Content, messages[i].Entities)
+		}
+	}
+
+	if messages == nil {
+		messages = []models.Message{}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"messages": messages,
+		"messages":    messages,
+		"next_offset": offset + rows,
+		"has_more":    hasMore,
+	})
+}
+
+// maxAlbumItems is the most media Telegram allows in one album
+const maxAlbumItems = 10
+
+// maxReplyChain bounds how far GetThread follows replies upwards
+const maxReplyChain = 100
+
+// GetThread returns a message with the chain of messages it replies to,
+// oldest first, and the replies in its thread
+func (h *Handler) GetThread(c *gin.Context) {
+	conversationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	messageID, err := strconv.Atoi(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	message, err := h.db.GetMessage(conversationID, messageID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get message"})
+		return
+	}
+
+	// Walk up the replies. Messages that are not backed up or live in
+	// another chat end the chain.
+	chain := []models.Message{}
+	seen := map[int]bool{message.MessageID: true}
+	current := message
+	for current.ReplyToMsgID != 0 && current.ReplyToPeerID == 0 && len(chain) < maxReplyChain {
+		if seen[current.ReplyToMsgID] {
+			break
+		}
+		seen[current.ReplyToMsgID] = true
+
+		parent, err := h.db.GetMessage(conversationID, current.ReplyToMsgID)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reply chain"})
+			return
+		}
+		chain = append([]models.Message{*parent}, chain...)
+		current = parent
+	}
+
+	replies, err := h.db.GetReplies(conversationID, messageID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get replies"})
+		return
+	}
+	if replies == nil {
+		replies = []models.Message{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"chain":   chain,
+		"replies": replies,
 	})
 }
 
@@ -358,57 +504,302 @@ func (h *Handler) SyncMessages(c *gin.Context) {
 		log.Printf("Session successfully restored for sync")
 	}
 
-	// Get dialogs from Telegram
-	dialogs, err := h.tgClient.GetDialogs(ctx)
+	// Get current user ID for data association
+	userInfo, err := h.tgClient.GetCurrentUserInfo(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get dialogs"})
+		log.Printf("Failed to get current user for sync: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get current user"})
 		return
 	}
+	// Update user sync time
+	userInfo.LastSyncTime = time.Now()
+	h.db.SaveUser(userInfo)
 
-	// Get current user ID for data association
-	var currentUserID int64
-	if userInfo, err := h.tgClient.GetCurrentUserInfo(ctx); err == nil {
-		currentUserID = userInfo.ID
-		// Update user sync time
-		userInfo.LastSyncTime = time.Now()
-		h.db.SaveUser(userInfo)
+	go h.runSync(ctx, userInfo.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Sync started",
+	})
+}
+
+// runSync syncs the user and pushes the result to WebSocket clients
+func (h *Handler) runSync(ctx context.Context, userID int64) {
+	result := h.sync.Run(ctx, userID)
+	if result == nil {
+		return
 	}
+	h.hub.Broadcast(map[string]interface{}{
+		"type":   "sync",
+		"result": result,
+	})
+}
 
-	// Save conversations with user_id
-	for _, dialog := range dialogs {
-		dialog.UserID = currentUserID
-		if err := h.db.SaveConversation(&dialog); err != nil {
-			log.Printf("Failed to save conversation %d: %v", dialog.ID, err)
-		}
+// GetMessageStats returns a message's current counters and the snapshots
+// taken of them over time
+func (h *Handler) GetMessageStats(c *gin.Context) {
+	conversationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	messageID, err := strconv.Atoi(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
 	}
 
-	// Sync messages for each conversation
-	go func() {
-		for _, dialog := range dialogs {
-			log.Printf("Syncing messages for conversation %d (%s) - %s", dialog.ID, dialog.Type, dialog.Title)
-			messages, err := h.tgClient.GetMessagesWithConvInfo(ctx, dialog.ID, 100, dialog.Type, dialog.AccessHash)
-			if err != nil {
-				log.Printf("Failed to get messages for conversation %d (%s): %v", dialog.ID, dialog.Title, err)
-				continue
-			}
+	message, err := h.db.GetMessage(conversationID, messageID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get message"})
+		return
+	}
 
-			log.Printf("Retrieved %d messages for conversation %d (%s)", len(messages), dialog.ID, dialog.Title)
-			for _, msg := range messages {
-				msg.UserID = currentUserID
-				if err := h.db.SaveMessage(&msg); err != nil {
-					log.Printf("Failed to save message %d in conversation %d: %v", msg.MessageID, dialog.ID, err)
-				}
-			}
-		}
-	}()
+	history, err := h.db.GetMessageStats(conversationID, messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get message stats"})
+		return
+	}
+	if history == nil {
+		history = []models.MessageStats{}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Sync started",
-		"conversations": len(dialogs),
+		"message_id": message.MessageID,
+		"views":      message.Views,
+		"forwards":   message.Forwards,
+		"replies":    message.RepliesCount,
+		"reactions":  message.Reactions,
+		"history":    history,
+	})
+}
+
+// GetComments returns the backed up comments of a channel post
+func (h *Handler) GetComments(c *gin.Context) {
+	conversationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	messageID, err := strconv.Atoi(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if err != nil || limit <= 0 {
+		limit = 500
+	}
+
+	post, err := h.db.GetMessage(conversationID, messageID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get message"})
+		return
+	}
+
+	comments, err := h.db.GetPostComments(post.UserID, conversationID, messageID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get comments"})
+		return
+	}
+	if comments == nil {
+		comments = []models.Message{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"post":     post,
+		"comments": comments,
 	})
 }
 
+// GetTopics lists the forum topics of a conversation
+func (h *Handler) GetTopics(c *gin.Context) {
+	conversationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	topics, err := h.db.GetForumTopics(conversationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get topics"})
+		return
+	}
+	if topics == nil {
+		topics = []models.ForumTopic{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"topics": topics,
+	})
+}
+
+// GetPinnedMessages lists the pinned messages of a conversation
+func (h *Handler) GetPinnedMessages(c *gin.Context) {
+	conversationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	messages, err := h.db.GetPinnedMessages(conversationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pinned messages"})
+		return
+	}
+	if messages == nil {
+		messages = []models.Message{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages": messages,
+	})
+}
+
+// GetScheduledMessages lists the messages scheduled to be sent in a
+// conversation, their timestamp is the scheduled time
+func (h *Handler) GetScheduledMessages(c *gin.Context) {
+	conversationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	messages, err := h.db.GetScheduledMessages(conversationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get scheduled messages"})
+		return
+	}
+	if messages == nil {
+		messages = []models.Message{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages": messages,
+	})
+}
+
+// GetChatSnapshots lists the metadata snapshots of a group or channel
+func (h *Handler) GetChatSnapshots(c *gin.Context) {
+	conversationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	snapshots, err := h.db.GetChatSnapshots(conversationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get snapshots"})
+		return
+	}
+	if snapshots == nil {
+		snapshots = []models.ChatSnapshot{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"snapshots": snapshots,
+	})
+}
+
+// GetChatMembers returns the snapshot of a chat that was current at the time
+// given by ?at= (a date or RFC 3339 time, default now), with its members
+func (h *Handler) GetChatMembers(c *gin.Context) {
+	conversationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	at, err := parseTimeParam(c.Query("at"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time, use YYYY-MM-DD or RFC 3339"})
+		return
+	}
+
+	snap, err := h.db.GetChatSnapshotAt(conversationID, at)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No snapshot of this chat at that time"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snap)
+}
+
+// GetMemberDiff compares the members of a chat at ?from= and ?to=. Without
+// from the snapshot before the one current at to is used, to defaults to now.
+func (h *Handler) GetMemberDiff(c *gin.Context) {
+	conversationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	to, err := parseTimeParam(c.Query("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time, use YYYY-MM-DD or RFC 3339"})
+		return
+	}
+
+	newer, err := h.db.GetChatSnapshotAt(conversationID, to)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No snapshot of this chat at that time"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get snapshot"})
+		return
+	}
+
+	var older *models.ChatSnapshot
+	if fromParam := c.Query("from"); fromParam != "" {
+		from, err := parseTimeParam(fromParam, time.Time{})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time, use YYYY-MM-DD or RFC 3339"})
+			return
+		}
+		older, err = h.db.GetChatSnapshotAt(conversationID, from)
+	} else {
+		older, err = h.db.GetChatSnapshotBefore(newer)
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No earlier snapshot of this chat"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get snapshot"})
+		return
+	}
+
+	if !older.HasMembers || !newer.HasMembers {
+		c.JSON(http.StatusConflict, gin.H{"error": "Member list not available in both snapshots"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.DiffMembers(older, newer))
+}
+
+// parseTimeParam parses a date (interpreted as the end of that day in local
+// time) or an RFC 3339 time. Empty values return def.
+func parseTimeParam(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
 func (h *Handler) GetUsers(c *gin.Context) {
 	users, err := h.db.GetUsers()
 	if err != nil {
@@ -442,6 +833,495 @@ func (h *Handler) GetUserConversations(c *gin.Context) {
 	})
 }
 
+func (h *Handler) Logout(c *gin.Context) {
+	userIDStr := c.Param("id")
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	session, err := h.db.GetActiveAuthSessionByUserID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active session for user"})
+		return
+	}
+
+	h.logoutUser(context.Background(), userID, session)
+
+	if err := h.db.DeactivateUser(userID); err != nil {
+		log.Printf("Failed to deactivate user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Logged out",
+	})
+}
+
+func (h *Handler) DeleteUser(c *gin.Context) {
+	userIDStr := c.Param("id")
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	purge, err := strconv.ParseBool(c.DefaultQuery("purge", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purge flag"})
+		return
+	}
+
+	if _, err := h.db.GetUserByID(userID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	// Sign the account out first so no session outlives its user record
+	if session, err := h.db.GetActiveAuthSessionByUserID(userID); err == nil {
+		h.logoutUser(context.Background(), userID, session)
+	}
+
+	if err := h.db.DeleteUser(userID, purge); err != nil {
+		log.Printf("Failed to delete user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	// Files go only once the rows referencing them are gone
+	if purge {
+		if err := h.media.RemoveUser(userID); err != nil {
+			log.Printf("Failed to purge media of user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "User deleted, failed to remove media files"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "User deleted",
+		"purged":  purge,
+	})
+}
+
+// logoutUser signs the account out on Telegram and removes its session file.
+// Failures are logged only, the local records are deactivated regardless.
+func (h *Handler) logoutUser(ctx context.Context, userID int64, session *models.AuthSession) {
+	if err := h.tgClient.Connect(ctx, session.AppID, session.AppHash); err != nil {
+		log.Printf("Failed to connect for logout of user %d: %v", userID, err)
+	} else if info, err := h.tgClient.GetCurrentUserInfo(ctx); err == nil {
+		// The session file is shared per app, leave it alone if it belongs to another account
+		if info.ID != userID {
+			log.Printf("Session for app %d belongs to user %d, not logging out user %d on Telegram", session.AppID, info.ID, userID)
+			return
+		}
+		if err := h.tgClient.LogOut(ctx); err != nil {
+			log.Printf("Failed to log out user %d: %v", userID, err)
+		}
+		return
+	}
+
+	if err := telegram.RemoveSession(session.AppID); err != nil {
+		log.Printf("Failed to remove session file for user %d: %v", userID, err)
+	}
+}
+
+func (h *Handler) GetUserSession(c *gin.Context) {
+	userIDStr := c.Param("id")
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	session, err := h.db.GetLatestAuthSessionByUserID(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No session for user"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session"})
+		return
+	}
+
+	response := gin.H{
+		"user_id":   session.UserID,
+		"is_active": session.IsActive,
+		"phone":     session.Phone,
+	}
+	if !session.LastCheckedAt.IsZero() {
+		response["last_checked_at"] = session.LastCheckedAt
+	}
+	if !session.RevokedAt.IsZero() {
+		response["revoked_at"] = session.RevokedAt
+		response["revoke_reason"] = session.RevokeReason
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session": response,
+	})
+}
+
+// GetContacts returns the backed up contact book of a user
+func (h *Handler) GetContacts(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	contacts, err := h.db.GetContacts(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get contacts"})
+		return
+	}
+	if contacts == nil {
+		contacts = []models.AccountContact{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"contacts": contacts,
+	})
+}
+
+// GetPeers lists the users seen by a user, optionally filtered by ?q= on
+// their current or former names and usernames
+func (h *Handler) GetPeers(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	peers, err := h.db.GetPeers(userID, c.Query("q"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get peers"})
+		return
+	}
+	if peers == nil {
+		peers = []models.Peer{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"peers": peers,
+	})
+}
+
+// GetPeer returns a user seen by a user with the history of their profile
+func (h *Handler) GetPeer(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	peerID, err := strconv.ParseInt(c.Param("peer_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid peer ID"})
+		return
+	}
+
+	peer, err := h.db.GetPeer(userID, peerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Peer not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get peer"})
+		return
+	}
+	if peer.History == nil {
+		peer.History = []models.PeerProfile{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"peer": peer,
+	})
+}
+
+// GetAvatars lists the stored profile photos of a user or chat seen by a
+// user, the current one first
+func (h *Handler) GetAvatars(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	ownerID, err := strconv.ParseInt(c.Param("owner_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid owner ID"})
+		return
+	}
+
+	avatars, err := h.db.GetAvatars(userID, ownerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get avatars"})
+		return
+	}
+	if avatars == nil {
+		avatars = []models.Avatar{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"avatars": avatars,
+	})
+}
+
+// GetSyncPolicies lists the sync policies of a user, oldest first
+func (h *Handler) GetSyncPolicies(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	policies, err := h.db.GetSyncPolicies(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sync policies"})
+		return
+	}
+	if policies == nil {
+		policies = []models.SyncPolicy{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"policies": policies,
+		"default":  models.DefaultSyncPolicy,
+	})
+}
+
+// CreateSyncPolicy adds a sync policy to a user. Omitted fields get the
+// default policy's values, so a policy syncs unless "sync" is false.
+func (h *Handler) CreateSyncPolicy(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if _, err := h.db.GetUserByID(userID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	policy := models.DefaultSyncPolicy
+	policy.Name = ""
+	if !bindSyncPolicy(c, &policy) {
+		return
+	}
+	policy.ID = 0
+	policy.UserID = userID
+
+	if err := h.db.SaveSyncPolicy(&policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save sync policy"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"policy": policy})
+}
+
+// UpdateSyncPolicy changes the fields of a sync policy given in the body
+func (h *Handler) UpdateSyncPolicy(c *gin.Context) {
+	userID, policyID, ok := syncPolicyParams(c)
+	if !ok {
+		return
+	}
+
+	policy, err := h.db.GetSyncPolicy(userID, policyID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Sync policy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sync policy"})
+		return
+	}
+	if !bindSyncPolicy(c, policy) {
+		return
+	}
+	policy.ID = policyID
+	policy.UserID = userID
+
+	if err := h.db.SaveSyncPolicy(policy); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Sync policy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save sync policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"policy": policy})
+}
+
+func (h *Handler) DeleteSyncPolicy(c *gin.Context) {
+	userID, policyID, ok := syncPolicyParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteSyncPolicy(userID, policyID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Sync policy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete sync policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Sync policy deleted",
+	})
+}
+
+func syncPolicyParams(c *gin.Context) (userID, policyID int64, ok bool) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, 0, false
+	}
+	policyID, err = strconv.ParseInt(c.Param("policy_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return 0, 0, false
+	}
+	return userID, policyID, true
+}
+
+// bindSyncPolicy reads the body over the given policy and validates the result
+func bindSyncPolicy(c *gin.Context, policy *models.SyncPolicy) bool {
+	if err := c.ShouldBindJSON(policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if err := policy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// GetSchedule returns when the account's updates and each synced conversation
+// of a user are polled next, soonest first
+func (h *Handler) GetSchedule(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	entries, err := h.db.GetSchedule(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sync schedule"})
+		return
+	}
+	if entries == nil {
+		entries = []models.ScheduleEntry{}
+	}
+
+	var nextRunAt *time.Time
+	if len(entries) > 0 {
+		nextRunAt = &entries[0].NextRunAt
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schedule":    entries,
+		"next_run_at": nextRunAt,
+	})
+}
+
+// GetMedia serves a file from the media store, media://<path> is available
+// at /api/v1/media/<path>
+func (h *Handler) GetMedia(c *gin.Context) {
+	p, ok := h.media.Path(media.URL(strings.TrimPrefix(c.Param("path"), "/")))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		return
+	}
+	c.File(p)
+}
+
+func (h *Handler) Export(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Query("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var conversationID int64
+	if convStr := c.Query("conversation_id"); convStr != "" {
+		conversationID, err = strconv.ParseInt(convStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+			return
+		}
+	}
+
+	// Check the export target before streaming, errors can't be reported once the ZIP started
+	filename := fmt.Sprintf("tgbackup_%d.zip", userID)
+	if conversationID != 0 {
+		_, err = h.db.GetConversation(userID, conversationID)
+		filename = fmt.Sprintf("tgbackup_%d_%d.zip", userID, conversationID)
+	} else {
+		_, err = h.db.GetUserByID(userID)
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Nothing to export"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare export"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	exporter := export.NewDesktop(h.db, h.media)
+	sink := export.NewZipSink(c.Writer)
+	if conversationID != 0 {
+		err = exporter.ExportConversation(userID, conversationID, sink)
+	} else {
+		err = exporter.ExportAccount(userID, sink)
+	}
+	if err != nil {
+		// Without the central directory the partial ZIP can't pass for a complete one
+		log.Printf("Export for user %d failed: %v", userID, err)
+		abortResponse(c)
+		return
+	}
+	if err := sink.Close(); err != nil {
+		log.Printf("Failed to finish export ZIP for user %d: %v", userID, err)
+		abortResponse(c)
+	}
+}
+
+// abortResponse drops the connection of a response whose body has started,
+// so the client sees a failed download instead of a truncated one that ends
+// normally. Recovery middleware would swallow a panic(http.ErrAbortHandler).
+func abortResponse(c *gin.Context) {
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	conn.Close()
+}
+
 func (h *Handler) WebSocketHandler(c *gin.Context) {
 	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -450,6 +1330,9 @@ func (h *Handler) WebSocketHandler(c *gin.Context) {
 	}
 	defer conn.Close()
 
+	h.hub.register(conn)
+	defer h.hub.unregister(conn)
+
 	// Handle WebSocket messages
 	for {
 		var msg map[string]interface{}
@@ -461,12 +1344,12 @@ func (h *Handler) WebSocketHandler(c *gin.Context) {
 		// Handle different message types
 		switch msg["type"] {
 		case "ping":
-			conn.WriteJSON(map[string]interface{}{
+			h.hub.send(conn, map[string]interface{}{
 				"type": "pong",
 			})
 		case "sync_status":
 			// Send sync status
-			conn.WriteJSON(map[string]interface{}{
+			h.hub.send(conn, map[string]interface{}{
 				"type":    "sync_status",
 				"running": false, // TODO: Implement actual sync status
 			})
@@ -483,39 +1366,5 @@ func (h *Handler) autoSyncAfterLogin(ctx context.Context, userID int64) {
 		return
 	}
 
-	// Get dialogs from Telegram
-	dialogs, err := h.tgClient.GetDialogs(ctx)
-	if err != nil {
-		log.Printf("Auto-sync failed to get dialogs: %v", err)
-		return
-	}
-
-	// Save conversations with user_id
-	for _, dialog := range dialogs {
-		dialog.UserID = userID
-		if err := h.db.SaveConversation(&dialog); err != nil {
-			log.Printf("Auto-sync failed to save conversation %d: %v", dialog.ID, err)
-		}
-	}
-
-	// Sync messages for each conversation
-	go func() {
-		for _, dialog := range dialogs {
-			log.Printf("Auto-syncing messages for conversation %d (%s) - %s", dialog.ID, dialog.Type, dialog.Title)
-			messages, err := h.tgClient.GetMessagesWithConvInfo(ctx, dialog.ID, 50, dialog.Type, dialog.AccessHash)
-			if err != nil {
-				log.Printf("Auto-sync failed to get messages for conversation %d (%s): %v", dialog.ID, dialog.Title, err)
-				continue
-			}
-
-			log.Printf("Auto-sync retrieved %d messages for conversation %d (%s)", len(messages), dialog.ID, dialog.Title)
-			for _, msg := range messages {
-				msg.UserID = userID
-				if err := h.db.SaveMessage(&msg); err != nil {
-					log.Printf("Auto-sync failed to save message %d in conversation %d: %v", msg.MessageID, dialog.ID, err)
-				}
-			}
-		}
-		log.Printf("Auto-sync completed for user %d", userID)
-	}()
-}
\ No newline at end of file
+	h.runSync(ctx, userID)
+}
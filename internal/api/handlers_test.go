@@ -0,0 +1,324 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/media"
+	"tgbackup/internal/models"
+	tgsync "tgbackup/internal/sync"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *database.DB, *telegramtest.Fake) {
+	t.Helper()
+	user := models.User{ID: 1, FirstName: "Me", Phone: "+100"}
+	db := telegramtest.NewDB(t, user)
+	fake := telegramtest.NewFake(user)
+	fake.AddUser(&tg.User{ID: 2, FirstName: "Ann"})
+	fake.AddDialog(models.Conversation{ID: 2, Type: "user", Title: "Ann"})
+	fake.AddDialog(models.Conversation{ID: 300, Type: "group", Title: "Family"})
+
+	store := media.NewStore(filepath.Join(t.TempDir(), "media"))
+	return NewHandler(db, fake, NewHub(), store, tgsync.NewScheduler(db, tgsync.NewEngine(db, fake, store))), db, fake
+}
+
+func serve(h gin.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+	return serveRoute(h, method, "/", "/", body)
+}
+
+// serveRoute serves a request to target through a route with parameters
+func serveRoute(h gin.HandlerFunc, method, route, target, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(method, route, h)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, target, bytes.NewBufferString(body)))
+	return w
+}
+
+func TestSyncMessages(t *testing.T) {
+	h, db, fake := newTestHandler(t)
+	fake.AddMessage(2, &tg.Message{ID: 1, Message: "hello", Date: 1700000000})
+	fake.AddMessage(2, &tg.Message{ID: 2, Message: "bye", Date: 1700000060, Out: true})
+	fake.AddMessage(300, &tg.Message{ID: 3, Message: "dinner?", Date: 1700000100, FromID: &tg.PeerUser{UserID: 2}})
+
+	w := serve(h.SyncMessages, http.MethodPost, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	// Conversations and messages are saved in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		convs, _ := db.GetConversationsByUserID(1)
+		direct, _ := db.GetMessagesByUserAndConversation(1, 2, 10, 0)
+		group, _ := db.GetMessagesByUserAndConversation(1, 300, 10, 0)
+		if len(convs) == 2 && len(direct) == 2 && len(group) == 1 {
+			if group[0].FromFirstName != "Ann" || group[0].Content != "dinner?" {
+				t.Errorf("group message = %+v", group[0])
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("saved %d conversations, %d direct and %d group messages", len(convs), len(direct), len(group))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSyncMessagesWithoutUser(t *testing.T) {
+	h, db, fake := newTestHandler(t)
+	fake.Fail("GetCurrentUserInfo", errors.New("connection reset"))
+
+	if w := serve(h.SyncMessages, http.MethodPost, ""); w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if convs, _ := db.GetConversationsByUserID(1); len(convs) != 0 {
+		t.Errorf("conversations saved despite the failure: %d", len(convs))
+	}
+}
+
+func TestVerifyCode(t *testing.T) {
+	h, db, fake := newTestHandler(t)
+	fake.SetAuthorized(false)
+	if err := db.SaveAuthSession(&models.AuthSession{PhoneCode: "code-hash", Phone: "+100", AppID: 1, AppHash: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(h.VerifyCode, http.MethodPost, `{"phone":"+100","code":"00000"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code: status = %d, want 401", w.Code)
+	}
+
+	if w := serve(h.VerifyCode, http.MethodPost, `{"phone":"+100","code":"`+telegramtest.LoginCode+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	user, err := db.GetUserByID(1)
+	if err != nil || user.FirstName != "Me" {
+		t.Errorf("user = %+v, %v", user, err)
+	}
+	if session, err := db.GetActiveAuthSession(); err != nil || session.UserID != 1 {
+		t.Errorf("session = %+v, %v", session, err)
+	}
+}
+
+func TestSyncPolicies(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	const route = "/users/:id/sync-policies/:policy_id"
+
+	w := serveRoute(h.CreateSyncPolicy, http.MethodPost, "/users/:id/sync-policies", "/users/1/sync-policies",
+		`{"name":"news","username_pattern":"*_news","backfill":"days","backfill_limit":7,"media_types":["photo"],"priority":-1}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d: %s", w.Code, w.Body)
+	}
+	var created struct{ Policy models.SyncPolicy }
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if p := created.Policy; p.ID == 0 || !p.Sync || p.BackfillLimit != 7 || len(p.MediaTypes) != 1 {
+		t.Errorf("created = %+v", p)
+	}
+	target := fmt.Sprintf("/users/1/sync-policies/%d", created.Policy.ID)
+
+	for _, body := range []string{`{"backfill":"weeks"}`, `{"backfill":"messages","backfill_limit":0}`, `{"username_pattern":"["}`, `{"peer_type":"robot"}`} {
+		if w := serveRoute(h.UpdateSyncPolicy, http.MethodPut, route, target, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+
+	if w := serveRoute(h.UpdateSyncPolicy, http.MethodPut, route, target, `{"sync":false}`); w.Code != http.StatusOK {
+		t.Fatalf("update: status = %d: %s", w.Code, w.Body)
+	}
+	policies, _ := db.GetSyncPolicies(1)
+	if len(policies) != 1 || policies[0].Sync || policies[0].UsernamePattern != "*_news" || policies[0].Backfill != "days" {
+		t.Errorf("stored = %+v", policies)
+	}
+
+	if w := serveRoute(h.UpdateSyncPolicy, http.MethodPut, route, "/users/2"+target[len("/users/1"):], `{}`); w.Code != http.StatusNotFound {
+		t.Errorf("policy of another user: status = %d, want 404", w.Code)
+	}
+	if w := serveRoute(h.DeleteSyncPolicy, http.MethodDelete, route, target, ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d", w.Code)
+	}
+	if w := serveRoute(h.DeleteSyncPolicy, http.MethodDelete, route, target, ""); w.Code != http.StatusNotFound {
+		t.Errorf("second delete: status = %d, want 404", w.Code)
+	}
+}
+
+func TestGetSchedule(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	const route = "/users/:id/schedule"
+
+	w := serveRoute(h.GetSchedule, http.MethodGet, route, "/users/1/schedule", "")
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"schedule":[]`)) {
+		t.Fatalf("empty: status = %d: %s", w.Code, w.Body)
+	}
+
+	next := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := db.SaveConversation(&models.Conversation{ID: 300, UserID: 1, Type: "group", Title: "Family"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSchedule([]models.ScheduleEntry{
+		{UserID: 1, ConversationID: 0, Interval: 600, NextRunAt: next.Add(time.Minute)},
+		{UserID: 1, ConversationID: 300, Interval: 3600, Rate: 1, NextRunAt: next},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	w = serveRoute(h.GetSchedule, http.MethodGet, route, "/users/1/schedule", "")
+	var body struct {
+		Schedule  []models.ScheduleEntry
+		NextRunAt time.Time `json:"next_run_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Schedule) != 2 || body.Schedule[0].Title != "Family" || !body.NextRunAt.Equal(next) {
+		t.Errorf("schedule = %s", w.Body)
+	}
+
+	if w := serveRoute(h.GetSchedule, http.MethodGet, route, "/users/x/schedule", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bad id: status = %d, want 400", w.Code)
+	}
+}
+
+func TestDeleteUserPurgesMedia(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	if err := db.SaveUser(&models.User{ID: 2, FirstName: "Other"}); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := h.media.Save(1, 300, "photo.jpg", bytes.NewBufferString("photo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	purged, err := h.media.Save(2, 2, "avatar_1.jpg", bytes.NewBufferString("avatar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const route = "/users/:id"
+
+	if w := serveRoute(h.DeleteUser, http.MethodDelete, route, "/users/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d: %s", w.Code, w.Body)
+	}
+	if _, ok := h.media.Path(kept); !ok {
+		t.Error("media removed without purge")
+	}
+
+	if w := serveRoute(h.DeleteUser, http.MethodDelete, route, "/users/2?purge=true", ""); w.Code != http.StatusOK {
+		t.Fatalf("purge: status = %d: %s", w.Code, w.Body)
+	}
+	if _, ok := h.media.Path(purged); ok {
+		t.Error("media of a purged user still on disk")
+	}
+	if _, ok := h.media.Path(kept); !ok {
+		t.Error("purge removed media of another user")
+	}
+}
+
+func TestExportAbortsOnError(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	if err := db.SaveConversation(&models.Conversation{ID: 300, UserID: 1, Type: "group", Title: "Family"}); err != nil {
+		t.Fatal(err)
+	}
+	var messages []models.Message
+	for id := 1; id <= 3; id++ {
+		messages = append(messages, models.Message{UserID: 1, ConversationID: 300, MessageID: id, Content: "hi",
+			MessageType: "text", Timestamp: time.Unix(1700000000+int64(id), 0)})
+	}
+	if err := db.SaveMessages(messages); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.GET("/export", h.Export)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	download := func() ([]byte, error) {
+		resp, err := http.Get(srv.URL + "/export?user_id=1&conversation_id=300")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+
+	data, err := download()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("export is not a ZIP: %v", err)
+	}
+
+	// The second message can't be read, the export fails after the first
+	if _, err := db.Exec(`UPDATE messages SET entities = '{' WHERE message_id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := download(); err == nil {
+		t.Error("failed export downloaded without error")
+	}
+}
+
+func TestGetMessagesAlbumPages(t *testing.T) {
+	h, db, _ := newTestHandler(t)
+	var messages []models.Message
+	for id := 1; id <= 6; id++ {
+		msg := models.Message{UserID: 1, ConversationID: 300, MessageID: id, Content: "photo", MessageType: "photo",
+			Timestamp: time.Unix(1700000000+int64(id), 0)}
+		if id >= 2 && id <= 4 {
+			msg.GroupedID = 99
+		}
+		messages = append(messages, msg)
+	}
+	if err := db.SaveMessages(messages); err != nil {
+		t.Fatal(err)
+	}
+	const route = "/conversations/:id/messages"
+
+	page := func(offset int) (ids []int, nextOffset int, hasMore bool) {
+		t.Helper()
+		w := serveRoute(h.GetMessages, http.MethodGet, route, fmt.Sprintf("/conversations/300/messages?limit=3&offset=%d", offset), "")
+		var body struct {
+			Messages   []models.Message
+			NextOffset int  `json:"next_offset"`
+			HasMore    bool `json:"has_more"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range body.Messages {
+			ids = append(ids, msg.MessageID)
+			if msg.MessageType == "album" && len(msg.Album) != 3 {
+				t.Errorf("album of %d items, want 3", len(msg.Album))
+			}
+		}
+		return ids, body.NextOffset, body.HasMore
+	}
+
+	// The limit cuts the album after its newest item, the page takes the rest
+	ids, next, more := page(0)
+	if fmt.Sprint(ids) != "[6 5 2]" || next != 5 || !more {
+		t.Errorf("first page = %v, next %d, more %v", ids, next, more)
+	}
+	ids, next, more = page(next)
+	if fmt.Sprint(ids) != "[1]" || next != 6 || more {
+		t.Errorf("second page = %v, next %d, more %v", ids, next, more)
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const writeTimeout = 10 * time.Second
+
+// Hub keeps track of connected WebSocket clients and pushes events to them.
+// All writes go through the hub so a connection never has concurrent writers.
+type Hub struct {
+	mu    sync.Mutex
+	conns map[*websocket.Conn]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		conns: make(map[*websocket.Conn]struct{}),
+	}
+}
+
+func (h *Hub) register(conn *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conns[conn] = struct{}{}
+}
+
+func (h *Hub) unregister(conn *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, conn)
+}
+
+// send writes a single event to one connection
+func (h *Hub) send(conn *websocket.Conn, event interface{}) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteJSON(event)
+}
+
+// Broadcast sends an event to every connected client, dropping clients
+// that can no longer be written to
+func (h *Hub) Broadcast(event interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for conn := range h.conns {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := conn.WriteJSON(event); err != nil {
+			log.Printf("WebSocket broadcast error: %v", err)
+			conn.Close()
+			delete(h.conns, conn)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package avatars
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/media"
+	"tgbackup/internal/models"
+	"tgbackup/internal/schedule"
+	"tgbackup/internal/telegram"
+)
+
+const (
+	// Profile photos listed per user, older ones are left out
+	photosPerUser = 100
+	// Photos downloaded per refresh, the rest follow in the next one
+	downloadsPerRefresh = 200
+)
+
+// Refresher downloads the profile photos of an account, its contacts and
+// its chats into the media store. Every photo is kept, so the avatars a user
+// or chat had before stay available after they are changed or removed.
+type Refresher struct {
+	db       *database.DB
+	client   telegram.Files
+	store    *media.Store
+	throttle *schedule.Throttle
+}
+
+func NewRefresher(db *database.DB, client telegram.Files, store *media.Store, interval time.Duration) *Refresher {
+	return &Refresher{
+		db:       db,
+		client:   client,
+		store:    store,
+		throttle: schedule.NewThrottle(interval),
+	}
+}
+
+// RefreshIfDue downloads new avatars of the user's account, contacts and
+// chats at most once per interval; photos change rarely and a refresh lists
+// every contact. Run it while the client holds the user's session.
+func (r *Refresher) RefreshIfDue(ctx context.Context, userID int64) {
+	if r.throttle.Due(userID) {
+		r.Refresh(ctx, userID)
+	}
+}
+
+// refresh is the state of one refresh run
+type refresh struct {
+	*Refresher
+	userID    int64
+	have      map[int64]bool // 已下载的照片
+	done      map[int64]bool // 本次已处理的用户
+	downloads int
+}
+
+// Refresh downloads the photos that are not in the store yet
+func (r *Refresher) Refresh(ctx context.Context, userID int64) {
+	have, err := r.db.GetAvatarPhotoIDs(userID)
+	if err != nil {
+		log.Printf("Avatar refresh failed to get photos of user %d: %v", userID, err)
+		return
+	}
+	conversations, err := r.db.GetConversationsByUserID(userID)
+	if err != nil {
+		log.Printf("Avatar refresh failed to get conversations of user %d: %v", userID, err)
+		return
+	}
+	contacts, err := r.db.GetContactPeers(userID)
+	if err != nil {
+		log.Printf("Avatar refresh failed to get contacts of user %d: %v", userID, err)
+		return
+	}
+
+	run := &refresh{Refresher: r, userID: userID, have: have, done: make(map[int64]bool)}
+
+	// 自己的头像: 收藏夹会话记录了当前头像
+	var selfPhotoID int64
+	for i := range conversations {
+		if conversations[i].ID == userID {
+			selfPhotoID = conversations[i].PhotoID
+		}
+	}
+	run.refreshSelf(ctx, selfPhotoID)
+
+	for i := range contacts {
+		run.refreshUser(ctx, contacts[i].PeerID, contacts[i].AccessHash, contacts[i].PhotoID)
+	}
+
+	for i := range conversations {
+		conv := &conversations[i]
+		switch conv.Type {
+		case "user", "bot":
+			run.refreshUser(ctx, conv.ID, conv.AccessHash, conv.PhotoID)
+		default:
+			run.refreshChat(ctx, conv)
+		}
+	}
+}
+
+func (r *refresh) refreshSelf(ctx context.Context, currentID int64) {
+	r.done[r.userID] = true
+
+	photos, err := r.client.GetSelfPhotos(ctx, photosPerUser)
+	if err != nil {
+		log.Printf("Failed to get profile photos of user %d: %v", r.userID, err)
+		return
+	}
+	if currentID == 0 && len(photos) > 0 {
+		currentID = photos[0].ID
+	}
+	r.saveProfilePhotos(ctx, r.userID, photos)
+	r.setCurrent(r.userID, currentID)
+}
+
+// refreshUser lists the profile photos of a user when the current one is
+// new, older photos are picked up along with it
+func (r *refresh) refreshUser(ctx context.Context, peerID int64, accessHash string, currentID int64) {
+	if r.done[peerID] {
+		return
+	}
+	r.done[peerID] = true
+
+	if currentID != 0 && !r.have[currentID] && r.downloads < downloadsPerRefresh {
+		photos, err := r.client.GetUserPhotos(ctx, peerID, accessHash, photosPerUser)
+		if err != nil {
+			log.Printf("Failed to get profile photos of user %d: %v", peerID, err)
+		}
+		r.saveProfilePhotos(ctx, peerID, photos)
+
+		// 隐私设置可能隐藏照片列表, 当前头像仍可通过用户下载
+		if !r.have[currentID] {
+			r.savePeerPhoto(ctx, peerID, "user", accessHash, currentID)
+		}
+	}
+	r.setCurrent(peerID, currentID)
+}
+
+func (r *refresh) refreshChat(ctx context.Context, conv *models.Conversation) {
+	if conv.PhotoID != 0 && !r.have[conv.PhotoID] {
+		r.savePeerPhoto(ctx, conv.ID, conv.Type, conv.AccessHash, conv.PhotoID)
+	}
+	r.setCurrent(conv.ID, conv.PhotoID)
+}
+
+func (r *refresh) saveProfilePhotos(ctx context.Context, ownerID int64, photos []telegram.ProfilePhoto) {
+	for _, photo := range photos {
+		if r.have[photo.ID] || r.downloads >= downloadsPerRefresh {
+			continue
+		}
+		r.downloads++
+
+		var buf bytes.Buffer
+		if err := r.client.DownloadProfilePhoto(ctx, photo, &buf); err != nil {
+			log.Printf("Failed to download profile photo %d of %d: %v", photo.ID, ownerID, err)
+			continue
+		}
+		date := photo.Date
+		r.save(ownerID, photo.ID, &date, &buf)
+	}
+}
+
+func (r *refresh) savePeerPhoto(ctx context.Context, ownerID int64, convType, accessHash string, photoID int64) {
+	if r.downloads >= downloadsPerRefresh {
+		return
+	}
+	r.downloads++
+
+	var buf bytes.Buffer
+	if err := r.client.DownloadPeerPhoto(ctx, ownerID, convType, accessHash, photoID, &buf); err != nil {
+		log.Printf("Failed to download avatar of %s %d: %v", convType, ownerID, err)
+		return
+	}
+	r.save(ownerID, photoID, nil, &buf)
+}
+
+func (r *refresh) save(ownerID, photoID int64, date *time.Time, buf *bytes.Buffer) {
+	mediaURL, err := r.store.Save(r.userID, ownerID, fmt.Sprintf("avatar_%d.jpg", photoID), buf)
+	if err != nil {
+		log.Printf("Failed to store avatar %d of %d: %v", photoID, ownerID, err)
+		return
+	}
+
+	avatar := &models.Avatar{UserID: r.userID, OwnerID: ownerID, PhotoID: photoID, MediaURL: mediaURL, Date: date}
+	if err := r.db.SaveAvatar(avatar); err != nil {
+		log.Printf("Failed to save avatar %d of %d: %v", photoID, ownerID, err)
+		return
+	}
+	r.have[photoID] = true
+}
+
+func (r *refresh) setCurrent(ownerID, photoID int64) {
+	if err := r.db.SetCurrentAvatar(r.userID, ownerID, photoID); err != nil {
+		log.Printf("Failed to update current avatar of %d: %v", ownerID, err)
+	}
+}
@@ -0,0 +1,64 @@
+package avatars
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tgbackup/internal/media"
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: 1, FirstName: "Me"}
+	db := telegramtest.NewDB(t, user)
+	fake := telegramtest.NewFake(user)
+	store := media.NewStore(filepath.Join(t.TempDir(), "media"))
+	for _, conv := range []models.Conversation{
+		{UserID: 1, ID: 2, Type: "user", Title: "Ann", PhotoID: 71},
+		{UserID: 1, ID: 300, Type: "group", Title: "Family", PhotoID: 80},
+	} {
+		if _, err := db.SaveConversationIfAbsent(&conv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Ann changed her photo once, the old one is kept as well
+	fake.SetProfilePhotos(2, 71, 70)
+	fake.SetPhoto(70, []byte("old"))
+	fake.SetPhoto(71, []byte("new"))
+	fake.SetPhoto(80, []byte("family"))
+
+	r := NewRefresher(db, fake, store, time.Hour)
+	r.Refresh(ctx, 1)
+
+	avatars, err := db.GetAvatars(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(avatars) != 2 {
+		t.Fatalf("Ann's avatars = %+v, want 2", avatars)
+	}
+	for _, avatar := range avatars {
+		path, ok := store.Path(avatar.MediaURL)
+		if !ok {
+			t.Fatalf("avatar %d has media URL %q outside the store", avatar.PhotoID, avatar.MediaURL)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("avatar %d not stored: %v", avatar.PhotoID, err)
+		}
+	}
+	if avatars, err := db.GetAvatars(1, 300); err != nil || len(avatars) != 1 {
+		t.Errorf("group avatars = %+v (%v), want 1", avatars, err)
+	}
+
+	// Photos already stored are not downloaded again
+	before := fake.Calls("DownloadProfilePhoto") + fake.Calls("DownloadPeerPhoto")
+	r.Refresh(ctx, 1)
+	if calls := fake.Calls("DownloadProfilePhoto") + fake.Calls("DownloadPeerPhoto") - before; calls != 0 {
+		t.Errorf("second refresh downloaded %d photos, want 0", calls)
+	}
+}
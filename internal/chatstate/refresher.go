@@ -0,0 +1,184 @@
+package chatstate
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/models"
+	"tgbackup/internal/schedule"
+	"tgbackup/internal/telegram"
+)
+
+const (
+	// Chat metadata and member lists are snapshotted at most this often
+	snapshotInterval = 6 * time.Hour
+	// Conversations with a message in this window have their pinned and
+	// scheduled messages checked on every refresh. Pinning posts a service
+	// message, so a new pin makes the conversation active.
+	activeWindow = 24 * time.Hour
+	// Quiet conversations are checked at most this often, for unpins and
+	// scheduled messages that leave no trace in the history
+	quietInterval = 24 * time.Hour
+	// Quiet conversations checked per refresh, the least recently checked
+	// first, so the calls are spread over several refreshes
+	maxQuietPerRefresh = 20
+)
+
+type conversationKey struct {
+	userID, conversationID int64
+}
+
+// Refresher periodically backs up the state of conversations that changes
+// without new messages: which messages are pinned, which are scheduled to be
+// sent and the metadata and members of groups and channels.
+type Refresher struct {
+	db       *database.DB
+	client   telegram.ChatState
+	throttle *schedule.Throttle
+
+	mu sync.Mutex
+	// When the pinned and scheduled messages of a conversation were checked
+	checked map[conversationKey]time.Time
+}
+
+func NewRefresher(db *database.DB, client telegram.ChatState, interval time.Duration) *Refresher {
+	return &Refresher{
+		db:       db,
+		client:   client,
+		throttle: schedule.NewThrottle(interval),
+		checked:  make(map[conversationKey]time.Time),
+	}
+}
+
+// RefreshIfDue checks pinned and scheduled messages and takes due chat
+// snapshots unless this was done for the user within the refresher's
+// interval. It runs with the client connected with the user's session.
+func (r *Refresher) RefreshIfDue(ctx context.Context, userID int64) {
+	if r.throttle.Due(userID) {
+		r.Refresh(ctx, userID)
+	}
+}
+
+// Refresh updates the pinned and scheduled messages of the user's active
+// conversations and of the quiet ones that are due, and snapshots groups and
+// channels that are due
+func (r *Refresher) Refresh(ctx context.Context, userID int64) {
+	conversations, err := r.db.GetConversationsByUserID(userID)
+	if err != nil {
+		log.Printf("Chat state refresh failed to get conversations of user %d: %v", userID, err)
+		return
+	}
+
+	for _, conv := range r.due(userID, conversations) {
+		r.refreshPinned(ctx, userID, conv)
+		r.refreshScheduled(ctx, userID, conv)
+		r.mu.Lock()
+		r.checked[conversationKey{userID, conv.ID}] = time.Now()
+		r.mu.Unlock()
+	}
+
+	for i := range conversations {
+		conv := &conversations[i]
+		if conv.Type == "group" || conv.Type == "channel" {
+			r.refreshSnapshot(ctx, userID, conv)
+		}
+	}
+}
+
+// due returns the conversations whose pinned and scheduled messages are
+// checked in this refresh: all active ones and up to maxQuietPerRefresh quiet
+// ones not checked within quietInterval, least recently checked first
+func (r *Refresher) due(userID int64, conversations []models.Conversation) []*models.Conversation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var due, quiet []*models.Conversation
+	for i := range conversations {
+		conv := &conversations[i]
+		if !conv.LastTime.Before(now.Add(-activeWindow)) {
+			due = append(due, conv)
+		} else if now.Sub(r.checked[conversationKey{userID, conv.ID}]) >= quietInterval {
+			quiet = append(quiet, conv)
+		}
+	}
+
+	sort.SliceStable(quiet, func(i, j int) bool {
+		return r.checked[conversationKey{userID, quiet[i].ID}].Before(r.checked[conversationKey{userID, quiet[j].ID}])
+	})
+	if len(quiet) > maxQuietPerRefresh {
+		quiet = quiet[:maxQuietPerRefresh]
+	}
+	return append(due, quiet...)
+}
+
+func (r *Refresher) refreshPinned(ctx context.Context, userID int64, conv *models.Conversation) {
+	pinned, err := r.client.GetPinnedMessages(ctx, conv.ID, conv.Type, conv.AccessHash)
+	if err != nil {
+		log.Printf("Failed to get pinned messages of %s %d (%s): %v", conv.Type, conv.ID, conv.Title, err)
+		return
+	}
+
+	// Pinned messages can be older than anything backed up so far
+	ids := make([]int, 0, len(pinned))
+	for i := range pinned {
+		pinned[i].UserID = userID
+		if err := r.db.SaveMessage(&pinned[i]); err != nil {
+			log.Printf("Failed to save pinned message %d of %s %d: %v", pinned[i].MessageID, conv.Type, conv.ID, err)
+		}
+		ids = append(ids, pinned[i].MessageID)
+	}
+
+	if err := r.db.SetPinnedMessages(userID, conv.ID, ids); err != nil {
+		log.Printf("Failed to record pinned messages of %s %d: %v", conv.Type, conv.ID, err)
+	}
+}
+
+func (r *Refresher) refreshScheduled(ctx context.Context, userID int64, conv *models.Conversation) {
+	scheduled, err := r.client.GetScheduledMessages(ctx, conv.ID, conv.Type, conv.AccessHash)
+	if err != nil {
+		log.Printf("Failed to get scheduled messages of %s %d (%s): %v", conv.Type, conv.ID, conv.Title, err)
+		return
+	}
+
+	for i := range scheduled {
+		scheduled[i].UserID = userID
+	}
+	if err := r.db.SaveScheduledMessages(userID, conv.ID, scheduled); err != nil {
+		log.Printf("Failed to save scheduled messages of %s %d: %v", conv.Type, conv.ID, err)
+	}
+}
+
+func (r *Refresher) refreshSnapshot(ctx context.Context, userID int64, conv *models.Conversation) {
+	checkedAt, err := r.db.GetChatSnapshotCheckedAt(userID, conv.ID)
+	if err != nil {
+		log.Printf("Failed to get latest snapshot of %s %d: %v", conv.Type, conv.ID, err)
+		return
+	}
+	if time.Since(checkedAt) < snapshotInterval {
+		return
+	}
+
+	snap, err := r.client.GetChatSnapshot(ctx, conv)
+	if err != nil {
+		log.Printf("Failed to get info of %s %d (%s): %v", conv.Type, conv.ID, conv.Title, err)
+		return
+	}
+	if snap == nil {
+		return
+	}
+
+	snap.UserID = userID
+	changed, err := r.db.SaveChatSnapshot(snap)
+	if err != nil {
+		log.Printf("Failed to save snapshot of %s %d: %v", conv.Type, conv.ID, err)
+		return
+	}
+	if changed {
+		log.Printf("Saved snapshot of %s %d (%s) with %d members", conv.Type, conv.ID, conv.Title, len(snap.Members))
+	}
+}
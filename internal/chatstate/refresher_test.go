@@ -0,0 +1,85 @@
+package chatstate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: 1, FirstName: "Me"}
+	db := telegramtest.NewDB(t, user)
+	fake := telegramtest.NewFake(user)
+	now := time.Now()
+	addConversation := func(conv models.Conversation) {
+		t.Helper()
+		fake.AddDialog(conv)
+		conv.UserID = 1
+		if _, err := db.SaveConversationIfAbsent(&conv); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An active channel with a pinned post, a scheduled post and members
+	addConversation(models.Conversation{ID: 400, Type: "channel", Title: "News", AccessHash: "7", LastTime: now})
+	fake.AddMessage(400, &tg.Message{ID: 10, Message: "rules", Date: int(now.Unix()), Pinned: true})
+	fake.AddMessage(400, &tg.Message{ID: 11, Message: "hello", Date: int(now.Unix())})
+	fake.SetScheduled(400, &tg.Message{ID: 1, Message: "tomorrow", Date: int(now.Add(24 * time.Hour).Unix())})
+	fake.SetChatSnapshot(models.ChatSnapshot{ConversationID: 400, Title: "News", ParticipantsCount: 1,
+		HasMembers: true, Members: []models.ChatMember{{UserID: 2, FirstName: "Ann", Role: "creator"}}})
+
+	// More quiet chats than one refresh checks
+	quiet := maxQuietPerRefresh + 5
+	for i := 0; i < quiet; i++ {
+		addConversation(models.Conversation{ID: int64(1000 + i), Type: "user", Title: "Quiet", LastTime: now.Add(-72 * time.Hour)})
+	}
+
+	r := NewRefresher(db, fake, time.Hour)
+	r.Refresh(ctx, 1)
+
+	pinned, err := db.GetPinnedMessages(400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 1 || pinned[0].MessageID != 10 {
+		t.Errorf("pinned = %+v, want message 10", pinned)
+	}
+	scheduled, err := db.GetScheduledMessages(400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scheduled) != 1 {
+		t.Errorf("scheduled = %+v, want one message", scheduled)
+	}
+	snapshots, err := db.GetChatSnapshots(400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Errorf("snapshots = %+v, want one", snapshots)
+	}
+	if calls := fake.Calls("GetPinnedMessages"); calls != 1+maxQuietPerRefresh {
+		t.Errorf("first refresh checked %d chats, want the active one and %d quiet ones", calls, maxQuietPerRefresh)
+	}
+
+	// The next refresh checks the active chat and the quiet ones left over
+	r.Refresh(ctx, 1)
+	if calls := fake.Calls("GetPinnedMessages"); calls != 2+quiet {
+		t.Errorf("after two refreshes %d chats were checked, want %d", calls, 2+quiet)
+	}
+
+	// All quiet chats were checked within quietInterval
+	r.Refresh(ctx, 1)
+	if calls := fake.Calls("GetPinnedMessages"); calls != 3+quiet {
+		t.Errorf("after three refreshes %d chats were checked, want %d", calls, 3+quiet)
+	}
+	if calls := fake.Calls("GetChatSnapshot"); calls != 1 {
+		t.Errorf("GetChatSnapshot calls = %d, want 1 within the snapshot interval", calls)
+	}
+}
@@ -0,0 +1,165 @@
+package comments
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram"
+)
+
+const (
+	// Posts whose comments are fetched per sync of a channel
+	maxPostsPerSync = 20
+	// Comments requested per call
+	commentsPageSize = 100
+	// Older comments backfilled per post and sync
+	maxCommentsPerPost = 500
+)
+
+// Sync backs up the comments of the channel posts that gained comments since
+// they were last fetched. Comments are saved as messages of the linked
+// discussion group and linked to their post through a comment thread. The
+// client must already be connected with the user's session.
+func Sync(ctx context.Context, db *database.DB, client telegram.API, userID int64, channel *models.Conversation) {
+	if channel.Type != "channel" || channel.AccessHash == "" {
+		return
+	}
+
+	posts, err := db.GetPostsWithNewComments(userID, channel.ID, maxPostsPerSync)
+	if err != nil {
+		log.Printf("Failed to find commented posts of channel %d: %v", channel.ID, err)
+		return
+	}
+	if len(posts) == 0 {
+		return
+	}
+
+	discussionID := channel.LinkedChatID
+	if discussionID == 0 {
+		linked, err := client.GetLinkedChat(ctx, channel.ID, channel.AccessHash)
+		if err != nil {
+			log.Printf("Failed to get discussion group of channel %d (%s): %v", channel.ID, channel.Title, err)
+			return
+		}
+		if linked == nil {
+			return
+		}
+
+		linked.UserID = userID
+		if _, err := db.SaveConversationIfAbsent(linked); err != nil {
+			log.Printf("Failed to save discussion group %d: %v", linked.ID, err)
+		}
+		if err := db.SetLinkedChat(userID, channel.ID, linked.ID); err != nil {
+			log.Printf("Failed to record discussion group of channel %d: %v", channel.ID, err)
+		}
+		discussionID = linked.ID
+		channel.LinkedChatID = linked.ID
+	}
+
+	saved := 0
+	for _, postID := range posts {
+		post, err := db.GetMessage(channel.ID, postID)
+		if err != nil {
+			log.Printf("Failed to get post %d of channel %d: %v", postID, channel.ID, err)
+			continue
+		}
+
+		thread, err := db.GetCommentThread(userID, channel.ID, postID)
+		if err != nil {
+			log.Printf("Failed to get comment thread of post %d in channel %d: %v", postID, channel.ID, err)
+			continue
+		}
+		thread.DiscussionID = discussionID
+
+		comments, err := fetchComments(ctx, client, channel, thread)
+		if err != nil {
+			log.Printf("Failed to get comments of post %d in channel %d: %v", postID, channel.ID, err)
+		} else {
+			thread.RepliesCount = post.RepliesCount
+		}
+		if len(comments) == 0 && err != nil {
+			continue
+		}
+
+		for i := range comments {
+			comments[i].UserID = userID
+		}
+		if root := telegram.CommentsRoot(comments); root != 0 {
+			thread.RootMessageID = root
+		}
+		thread.SyncedAt = time.Now()
+		if err := db.SaveComments(comments, thread); err != nil {
+			log.Printf("Failed to save comments of post %d in channel %d: %v", postID, channel.ID, err)
+			continue
+		}
+		saved += len(comments)
+	}
+
+	log.Printf("Saved %d comments on %d posts of channel %d (%s)", saved, len(posts), channel.ID, channel.Title)
+}
+
+// fetchComments pages through the comments of a post the thread does not
+// have yet and moves the thread's cursor over them. New comments are fetched
+// down to the newest stored one, then older comments are backfilled from the
+// oldest stored one within maxCommentsPerPost, so long threads complete over
+// several syncs. On error the comments fetched so far are returned with the
+// cursor only covering them.
+func fetchComments(ctx context.Context, client telegram.API, channel *models.Conversation, thread *models.CommentThread) ([]models.Message, error) {
+	var comments []models.Message
+
+	if thread.NewestID > 0 || thread.Complete {
+		newest := 0
+		offsetID := 0
+	catchUp:
+		for {
+			page, err := client.GetPostComments(ctx, channel.ID, channel.AccessHash, thread.PostID, thread.DiscussionID, offsetID, commentsPageSize)
+			if err != nil {
+				// The stored range stays contiguous only if nothing newer is kept
+				return nil, err
+			}
+			for _, msg := range page {
+				if msg.MessageID <= thread.NewestID {
+					break catchUp
+				}
+				if newest == 0 {
+					newest = msg.MessageID
+				}
+				comments = append(comments, msg)
+			}
+			if len(page) < commentsPageSize {
+				break
+			}
+			offsetID = page[len(page)-1].MessageID
+		}
+		if newest != 0 {
+			thread.NewestID = newest
+		}
+	}
+
+	for budget := maxCommentsPerPost; !thread.Complete && budget > 0; {
+		limit := commentsPageSize
+		if budget < limit {
+			limit = budget
+		}
+		page, err := client.GetPostComments(ctx, channel.ID, channel.AccessHash, thread.PostID, thread.DiscussionID, thread.OldestID, limit)
+		if err != nil {
+			return comments, err
+		}
+		if len(page) > 0 {
+			if thread.NewestID == 0 {
+				thread.NewestID = page[0].MessageID
+			}
+			thread.OldestID = page[len(page)-1].MessageID
+			comments = append(comments, page...)
+		}
+		if len(page) < limit {
+			thread.Complete = true
+		}
+		budget -= len(page)
+	}
+
+	return comments, nil
+}
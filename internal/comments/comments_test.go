@@ -0,0 +1,90 @@
+package comments
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+func TestSyncBackfillsComments(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: 1, FirstName: "Me"}
+	db := telegramtest.NewDB(t, user)
+	fake := telegramtest.NewFake(user)
+	channel := &models.Conversation{UserID: 1, ID: 400, Type: "channel", Title: "News", AccessHash: "7", LinkedChatID: 500}
+	if _, err := db.SaveConversationIfAbsent(channel); err != nil {
+		t.Fatal(err)
+	}
+
+	addComments := func(from, to int) {
+		for id := from; id <= to; id++ {
+			fake.AddMessage(500, &tg.Message{ID: id, Message: "comment", Date: 1700000000 + id,
+				ReplyTo: &tg.MessageReplyHeader{ReplyToMsgID: 10}})
+		}
+	}
+	setReplies := func(n int) {
+		post := models.Message{UserID: 1, ConversationID: 400, MessageID: 10, Content: "post",
+			MessageType: "text", Timestamp: time.Unix(1700000000, 0), RepliesCount: n}
+		if err := db.SaveMessage(&post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storedComments := func() int {
+		t.Helper()
+		comments, err := db.GetPostComments(1, 400, 10, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(comments)
+	}
+
+	// More comments than one sync backfills: the newest are fetched first
+	addComments(1, maxCommentsPerPost+120)
+	setReplies(maxCommentsPerPost + 120)
+	Sync(ctx, db, fake, 1, channel)
+	if got := storedComments(); got != maxCommentsPerPost {
+		t.Fatalf("stored %d comments after the first sync, want %d", got, maxCommentsPerPost)
+	}
+	thread, err := db.GetCommentThread(1, 400, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thread.Complete || thread.NewestID != maxCommentsPerPost+120 || thread.OldestID != 121 {
+		t.Fatalf("thread = %+v, want comments 121-%d and not complete", thread, maxCommentsPerPost+120)
+	}
+
+	// A failed fetch keeps the thread due without moving its cursor
+	addComments(maxCommentsPerPost+121, maxCommentsPerPost+122)
+	setReplies(maxCommentsPerPost + 122)
+	fake.Fail("GetPostComments", errors.New("connection reset"))
+	Sync(ctx, db, fake, 1, channel)
+	if got := storedComments(); got != maxCommentsPerPost {
+		t.Errorf("stored %d comments after a failed sync, want %d", got, maxCommentsPerPost)
+	}
+
+	// New comments are caught up and the older ones are backfilled
+	Sync(ctx, db, fake, 1, channel)
+	if got := storedComments(); got != maxCommentsPerPost+122 {
+		t.Errorf("stored %d comments after the backfill, want %d", got, maxCommentsPerPost+122)
+	}
+	thread, err = db.GetCommentThread(1, 400, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !thread.Complete || thread.NewestID != maxCommentsPerPost+122 || thread.OldestID != 1 {
+		t.Errorf("thread = %+v, want all comments and complete", thread)
+	}
+
+	// Nothing new: the post is not fetched again
+	before := fake.Calls("GetPostComments")
+	Sync(ctx, db, fake, 1, channel)
+	if calls := fake.Calls("GetPostComments") - before; calls != 0 {
+		t.Errorf("GetPostComments calls = %d on an up to date thread, want 0", calls)
+	}
+}
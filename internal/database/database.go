@@ -1,11 +1,17 @@
 package database
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"tgbackup/internal/media"
 	"tgbackup/internal/models"
 )
 
@@ -14,7 +20,12 @@ type DB struct {
 }
 
 func InitDB() (*DB, error) {
-	db, err := sql.Open("sqlite3", "./tgbackup.db")
+	return Open("./tgbackup.db")
+}
+
+// Open opens the SQLite database at path and creates or migrates its tables
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, err
 	}
@@ -51,9 +62,21 @@ func (db *DB) createTables() error {
 			title TEXT NOT NULL,
 			username TEXT,
 			avatar_url TEXT,
+			photo_id INTEGER,
 			access_hash TEXT,
 			last_message TEXT,
 			last_time DATETIME,
+			last_message_id INTEGER,
+			last_sender_id INTEGER,
+			last_sender_name TEXT,
+			unread_count INTEGER DEFAULT 0,
+			unread_mentions_count INTEGER DEFAULT 0,
+			folder_id INTEGER DEFAULT 0,
+			is_forum BOOLEAN DEFAULT FALSE,
+			linked_chat_id INTEGER,
+			about TEXT,
+			participants_count INTEGER,
+			invite_link TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(id)
@@ -67,9 +90,36 @@ func (db *DB) createTables() error {
 			from_username TEXT,
 			from_first_name TEXT,
 			from_last_name TEXT,
+			sender_type TEXT,
+			post_author TEXT,
+			via_bot_id INTEGER,
 			content TEXT NOT NULL,
 			message_type TEXT DEFAULT 'text',
 			media_url TEXT,
+			entities TEXT,
+			action TEXT,
+			action_data TEXT,
+			reply_to_msg_id INTEGER,
+			reply_to_top_id INTEGER,
+			reply_to_peer_id INTEGER,
+			reply_quote TEXT,
+			fwd_from_id INTEGER,
+			fwd_from_type TEXT,
+			fwd_from_name TEXT,
+			fwd_channel_post INTEGER,
+			fwd_post_author TEXT,
+			fwd_date DATETIME,
+			topic_id INTEGER,
+			grouped_id INTEGER,
+			media_data TEXT,
+			views INTEGER,
+			forwards INTEGER,
+			replies_count INTEGER,
+			reactions TEXT,
+			is_pinned BOOLEAN DEFAULT FALSE,
+			is_out BOOLEAN DEFAULT FALSE,
+			mentioned BOOLEAN DEFAULT FALSE,
+			version INTEGER DEFAULT 0,
 			timestamp DATETIME NOT NULL,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(id),
@@ -85,10 +135,155 @@ func (db *DB) createTables() error {
 			app_id INTEGER,
 			app_hash TEXT,
 			phone TEXT,
+			last_checked_at DATETIME,
+			revoked_at DATETIME,
+			revoke_reason TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS forum_topics (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			conversation_id INTEGER NOT NULL,
+			topic_id INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			icon_color INTEGER,
+			icon_emoji_id INTEGER,
+			creator_id INTEGER,
+			is_closed BOOLEAN DEFAULT FALSE,
+			is_hidden BOOLEAN DEFAULT FALSE,
+			is_pinned BOOLEAN DEFAULT FALSE,
+			top_message INTEGER,
+			created_at DATETIME,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			UNIQUE(user_id, conversation_id, topic_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS comment_threads (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			channel_id INTEGER NOT NULL,
+			post_id INTEGER NOT NULL,
+			discussion_id INTEGER NOT NULL,
+			root_message_id INTEGER,
+			replies_count INTEGER DEFAULT 0,
+			newest_comment_id INTEGER DEFAULT 0,
+			oldest_comment_id INTEGER DEFAULT 0,
+			complete BOOLEAN DEFAULT FALSE,
+			synced_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			UNIQUE(user_id, channel_id, post_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS scheduled_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			conversation_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			scheduled_at DATETIME NOT NULL,
+			data TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			UNIQUE(user_id, conversation_id, message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS chat_snapshots (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			conversation_id INTEGER NOT NULL,
+			title TEXT,
+			about TEXT,
+			participants_count INTEGER DEFAULT 0,
+			linked_chat_id INTEGER,
+			invite_link TEXT,
+			has_members BOOLEAN DEFAULT FALSE,
+			fingerprint TEXT NOT NULL,
+			captured_at DATETIME NOT NULL,
+			checked_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS chat_members (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			snapshot_id INTEGER NOT NULL,
+			member_id INTEGER NOT NULL,
+			username TEXT,
+			first_name TEXT,
+			last_name TEXT,
+			role TEXT NOT NULL,
+			rank TEXT,
+			admin_rights TEXT,
+			inviter_id INTEGER,
+			joined_at DATETIME,
+			FOREIGN KEY (snapshot_id) REFERENCES chat_snapshots(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS contacts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			contact_id INTEGER NOT NULL,
+			phone TEXT,
+			first_name TEXT,
+			last_name TEXT,
+			username TEXT,
+			mutual BOOLEAN DEFAULT FALSE,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			UNIQUE(user_id, contact_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS peers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			peer_id INTEGER NOT NULL,
+			username TEXT,
+			first_name TEXT,
+			last_name TEXT,
+			phone TEXT,
+			bio TEXT,
+			photo_id INTEGER,
+			access_hash TEXT,
+			is_bot BOOLEAN DEFAULT FALSE,
+			is_deleted BOOLEAN DEFAULT FALSE,
+			bio_checked_at DATETIME,
+			first_seen DATETIME NOT NULL,
+			last_seen DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			UNIQUE(user_id, peer_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS peer_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			peer_id INTEGER NOT NULL,
+			username TEXT,
+			first_name TEXT,
+			last_name TEXT,
+			bio TEXT,
+			photo_id INTEGER,
+			is_deleted BOOLEAN DEFAULT FALSE,
+			changed_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS avatars (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			owner_id INTEGER NOT NULL,
+			photo_id INTEGER NOT NULL,
+			media_url TEXT NOT NULL,
+			date DATETIME,
+			is_current BOOLEAN DEFAULT FALSE,
+			fetched_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			UNIQUE(user_id, owner_id, photo_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_stats (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			conversation_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			views INTEGER DEFAULT 0,
+			forwards INTEGER DEFAULT 0,
+			replies INTEGER DEFAULT 0,
+			reactions TEXT,
+			captured_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
 		`CREATE TABLE IF NOT EXISTS updates_state (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id INTEGER NOT NULL,
@@ -100,8 +295,60 @@ func (db *DB) createTables() error {
 			FOREIGN KEY (user_id) REFERENCES users(id),
 			UNIQUE(user_id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS sync_cursors (
+			user_id INTEGER NOT NULL,
+			conversation_id INTEGER NOT NULL,
+			newest_id INTEGER DEFAULT 0,
+			oldest_id INTEGER DEFAULT 0,
+			complete BOOLEAN DEFAULT FALSE,
+			updated_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, conversation_id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS sync_policies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT,
+			peer_id INTEGER,
+			peer_type TEXT,
+			username_pattern TEXT,
+			folder_id INTEGER,
+			sync BOOLEAN DEFAULT TRUE,
+			backfill TEXT NOT NULL DEFAULT 'all',
+			backfill_limit INTEGER DEFAULT 0,
+			media_types TEXT,
+			max_media_size INTEGER DEFAULT 0,
+			priority INTEGER DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS sync_schedule (
+			user_id INTEGER NOT NULL,
+			conversation_id INTEGER NOT NULL,
+			interval_seconds INTEGER NOT NULL,
+			rate REAL DEFAULT 0,
+			priority INTEGER DEFAULT 0,
+			next_run_at DATETIME NOT NULL,
+			last_run_at DATETIME,
+			failures INTEGER DEFAULT 0,
+			PRIMARY KEY (user_id, conversation_id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages(conversation_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp)`,
+		`CREATE INDEX IF NOT EXISTS idx_chat_snapshots_conversation ON chat_snapshots(conversation_id, captured_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_chat_members_snapshot ON chat_members(snapshot_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_peer_history_peer ON peer_history(user_id, peer_id, changed_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_message_stats_message ON message_stats(conversation_id, message_id, captured_at)`,
+	}
+
+	// Indexes on columns that older databases only get in migrateColumns
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages(conversation_id, reply_to_msg_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_reply_top ON messages(conversation_id, reply_to_top_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_topic ON messages(conversation_id, topic_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_pinned ON messages(conversation_id, is_pinned)`,
 	}
 
 	for _, query := range queries {
@@ -110,9 +357,113 @@ func (db *DB) createTables() error {
 		}
 	}
 
+	if err := db.migrateColumns(); err != nil {
+		return err
+	}
+
+	for _, query := range indexes {
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to create index: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// addedColumns lists columns introduced after a table was first created.
+// Databases created by older versions get them through ALTER TABLE.
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"auth_sessions", "last_checked_at", "DATETIME"},
+	{"auth_sessions", "revoked_at", "DATETIME"},
+	{"auth_sessions", "revoke_reason", "TEXT"},
+	{"messages", "entities", "TEXT"},
+	{"messages", "action", "TEXT"},
+	{"messages", "action_data", "TEXT"},
+	{"messages", "reply_to_msg_id", "INTEGER"},
+	{"messages", "reply_to_top_id", "INTEGER"},
+	{"messages", "reply_to_peer_id", "INTEGER"},
+	{"messages", "reply_quote", "TEXT"},
+	{"messages", "fwd_from_id", "INTEGER"},
+	{"messages", "fwd_from_type", "TEXT"},
+	{"messages", "fwd_from_name", "TEXT"},
+	{"messages", "fwd_channel_post", "INTEGER"},
+	{"messages", "fwd_post_author", "TEXT"},
+	{"messages", "fwd_date", "DATETIME"},
+	{"messages", "topic_id", "INTEGER"},
+	{"messages", "grouped_id", "INTEGER"},
+	{"messages", "media_data", "TEXT"},
+	{"conversations", "linked_chat_id", "INTEGER"},
+	{"conversations", "about", "TEXT"},
+	{"conversations", "participants_count", "INTEGER"},
+	{"conversations", "invite_link", "TEXT"},
+	{"messages", "views", "INTEGER"},
+	{"messages", "forwards", "INTEGER"},
+	{"messages", "replies_count", "INTEGER"},
+	{"messages", "reactions", "TEXT"},
+	{"messages", "is_pinned", "BOOLEAN DEFAULT FALSE"},
+	{"conversations", "is_forum", "BOOLEAN DEFAULT FALSE"},
+	{"conversations", "photo_id", "INTEGER"},
+	{"peers", "access_hash", "TEXT"},
+	{"messages", "sender_type", "TEXT"},
+	{"messages", "post_author", "TEXT"},
+	{"messages", "via_bot_id", "INTEGER"},
+	{"messages", "is_out", "BOOLEAN DEFAULT FALSE"},
+	{"messages", "mentioned", "BOOLEAN DEFAULT FALSE"},
+	{"conversations", "last_message_id", "INTEGER"},
+	{"conversations", "last_sender_id", "INTEGER"},
+	{"conversations", "last_sender_name", "TEXT"},
+	{"conversations", "unread_count", "INTEGER DEFAULT 0"},
+	{"conversations", "unread_mentions_count", "INTEGER DEFAULT 0"},
+	{"conversations", "folder_id", "INTEGER DEFAULT 0"},
+	{"messages", "version", "INTEGER DEFAULT 0"},
+	{"comment_threads", "newest_comment_id", "INTEGER DEFAULT 0"},
+	{"comment_threads", "oldest_comment_id", "INTEGER DEFAULT 0"},
+	{"comment_threads", "complete", "BOOLEAN DEFAULT FALSE"},
+	{"sync_schedule", "failures", "INTEGER DEFAULT 0"},
+}
+
+func (db *DB) migrateColumns() error {
+	for _, col := range addedColumns {
+		exists, err := db.columnExists(col.table, col.column)
+		if err != nil {
+			return fmt.Errorf("failed to inspect table %s: %v", col.table, err)
+		}
+		if exists {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.column, col.definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %v", col.table, col.column, err)
+		}
+	}
+
 	return nil
 }
 
+func (db *DB) columnExists(table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
 func (db *DB) SaveUser(user *models.User) error {
 	query := `INSERT OR REPLACE INTO users 
 		(id, first_name, last_name, username, phone, is_active, last_sync_time, updated_at) 
@@ -123,6 +474,17 @@ func (db *DB) SaveUser(user *models.User) error {
 	return err
 }
 
+// SaveUserIfAbsent stores a user unless a user with the same ID exists
+func (db *DB) SaveUserIfAbsent(user *models.User) error {
+	query := `INSERT OR IGNORE INTO users 
+		(id, first_name, last_name, username, phone, is_active, last_sync_time, updated_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := db.Exec(query, user.ID, user.FirstName, user.LastName, user.Username, 
+		user.Phone, user.IsActive, user.LastSyncTime, time.Now())
+	return err
+}
+
 func (db *DB) GetUsers() ([]models.User, error) {
 	query := `SELECT id, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(username, ''), 
 		COALESCE(phone, ''), is_active, last_sync_time, created_at, updated_at 
@@ -172,20 +534,196 @@ func (db *DB) GetUserByID(userID int64) (*models.User, error) {
 	return &user, nil
 }
 
-func (db *DB) SaveConversation(conv *models.Conversation) error {
-	query := `INSERT OR REPLACE INTO conversations 
-		(id, user_id, type, title, username, avatar_url, access_hash, last_message, last_time, updated_at) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const conversationColumns = `id, user_id, type, title, COALESCE(username, ''), COALESCE(avatar_url, ''), 
+	COALESCE(photo_id, 0), COALESCE(access_hash, ''), COALESCE(last_message, ''), last_time, 
+	COALESCE(last_message_id, 0), COALESCE(last_sender_id, 0), COALESCE(last_sender_name, ''), 
+	COALESCE(unread_count, 0), COALESCE(unread_mentions_count, 0), COALESCE(folder_id, 0), COALESCE(is_forum, 0), COALESCE(linked_chat_id, 0), 
+	COALESCE(about, ''), COALESCE(participants_count, 0), COALESCE(invite_link, ''), created_at, updated_at`
+
+func scanConversation(row scanner) (*models.Conversation, error) {
+	var conv models.Conversation
+	err := row.Scan(&conv.ID, &conv.UserID, &conv.Type, &conv.Title, &conv.Username, 
+		&conv.AvatarURL, &conv.PhotoID, &conv.AccessHash, &conv.LastMessage, &conv.LastTime, 
+		&conv.LastMessageID, &conv.LastSenderID, &conv.LastSenderName, &conv.UnreadCount, &conv.UnreadMentionsCount, &conv.FolderID, &conv.IsForum, 
+		&conv.LinkedChatID, &conv.About, &conv.ParticipantsCount, &conv.InviteLink, &conv.CreatedAt, &conv.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// conversationInsertColumns are the columns written by SaveConversation and
+// SaveConversationIfAbsent, in the order of conversationValues
+const conversationInsertColumns = `(id, user_id, type, title, username, avatar_url, photo_id, access_hash, last_message, last_time, 
+		last_message_id, last_sender_id, last_sender_name, unread_count, unread_mentions_count, folder_id, is_forum, linked_chat_id, updated_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func conversationValues(conv *models.Conversation) []interface{} {
+	return []interface{}{conv.ID, conv.UserID, conv.Type, conv.Title, conv.Username, 
+		conv.AvatarURL, nullInt(conv.PhotoID), conv.AccessHash, conv.LastMessage, conv.LastTime, 
+		nullInt(int64(conv.LastMessageID)), nullInt(conv.LastSenderID), nullString(conv.LastSenderName), conv.UnreadCount, conv.UnreadMentionsCount, 
+		conv.FolderID, conv.IsForum, nullInt(conv.LinkedChatID), time.Now()}
+}
 
-	_, err := db.Exec(query, conv.ID, conv.UserID, conv.Type, conv.Title, conv.Username, 
-		conv.AvatarURL, conv.AccessHash, conv.LastMessage, conv.LastTime, time.Now())
+const messageColumns = `id, user_id, conversation_id, message_id, COALESCE(from_id, 0), COALESCE(from_username, ''), 
+	COALESCE(from_first_name, ''), COALESCE(from_last_name, ''), COALESCE(sender_type, ''), COALESCE(post_author, ''), 
+	COALESCE(via_bot_id, 0), content, COALESCE(message_type, 'text'), 
+	COALESCE(media_url, ''), COALESCE(entities, ''), COALESCE(action_data, ''), 
+	COALESCE(reply_to_msg_id, 0), COALESCE(reply_to_top_id, 0), COALESCE(reply_to_peer_id, 0), COALESCE(reply_quote, ''), 
+	COALESCE(fwd_from_id, 0), COALESCE(fwd_from_type, ''), COALESCE(fwd_from_name, ''), COALESCE(fwd_channel_post, 0), 
+	COALESCE(fwd_post_author, ''), fwd_date, COALESCE(topic_id, 0), COALESCE(grouped_id, 0), COALESCE(media_data, ''), 
+	COALESCE(views, 0), COALESCE(forwards, 0), COALESCE(replies_count, 0), COALESCE(reactions, ''), 
+	COALESCE(is_pinned, 0), COALESCE(is_out, 0), COALESCE(mentioned, 0), timestamp, created_at`
+
+func scanMessage(row scanner) (*models.Message, error) {
+	var msg models.Message
+	var entities, action, mediaData, reactions string
+	var fwdDate sql.NullTime
+	err := row.Scan(&msg.ID, &msg.UserID, &msg.ConversationID, &msg.MessageID, &msg.FromID, 
+		&msg.FromUsername, &msg.FromFirstName, &msg.FromLastName, &msg.SenderType, &msg.PostAuthor, 
+		&msg.ViaBotID, &msg.Content, 
+		&msg.MessageType, &msg.MediaURL, &entities, &action, 
+		&msg.ReplyToMsgID, &msg.ReplyToTopID, &msg.ReplyToPeerID, &msg.ReplyQuote, 
+		&msg.FwdFromID, &msg.FwdFromType, &msg.FwdFromName, &msg.FwdChannelPost, 
+		&msg.FwdPostAuthor, &fwdDate, &msg.TopicID, &msg.GroupedID, &mediaData, 
+		&msg.Views, &msg.Forwards, &msg.RepliesCount, &reactions, &msg.IsPinned, &msg.IsOut, &msg.Mentioned, &msg.Timestamp, &msg.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if fwdDate.Valid {
+		msg.FwdDate = &fwdDate.Time
+	}
+	if err := decodeJSONColumn(entities, &msg.Entities); err != nil {
+		return nil, fmt.Errorf("invalid entities of message %d: %v", msg.MessageID, err)
+	}
+	if err := decodeJSONColumn(action, &msg.Action); err != nil {
+		return nil, fmt.Errorf("invalid action of message %d: %v", msg.MessageID, err)
+	}
+	if err := decodeJSONColumn(mediaData, &msg.MediaData); err != nil {
+		return nil, fmt.Errorf("invalid media data of message %d: %v", msg.MessageID, err)
+	}
+	if err := decodeJSONColumn(reactions, &msg.Reactions); err != nil {
+		return nil, fmt.Errorf("invalid reactions of message %d: %v", msg.MessageID, err)
+	}
+	return &msg, nil
+}
+
+// messageInsertColumns are the columns written by SaveMessage and
+// ImportChat, in the order of messageValues
+const messageInsertColumns = `(user_id, conversation_id, message_id, from_id, from_username, from_first_name, from_last_name, 
+		sender_type, post_author, via_bot_id, content, message_type, media_url, entities, action, action_data, 
+		reply_to_msg_id, reply_to_top_id, reply_to_peer_id, reply_quote, 
+		fwd_from_id, fwd_from_type, fwd_from_name, fwd_channel_post, fwd_post_author, fwd_date, topic_id, grouped_id, media_data, 
+		views, forwards, replies_count, reactions, is_pinned, is_out, mentioned, timestamp) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func messageValues(msg *models.Message) ([]interface{}, error) {
+	entities, err := jsonColumn(msg.Entities, len(msg.Entities) == 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var actionType interface{}
+	if msg.Action != nil {
+		actionType = msg.Action.Type
+	}
+	action, err := jsonColumn(msg.Action, msg.Action == nil)
+	if err != nil {
+		return nil, err
+	}
+	mediaData, err := jsonColumn(msg.MediaData, msg.MediaData == nil)
+	if err != nil {
+		return nil, err
+	}
+	reactions, err := jsonColumn(msg.Reactions, len(msg.Reactions) == 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var fwdDate interface{}
+	if msg.FwdDate != nil {
+		fwdDate = *msg.FwdDate
+	}
+
+	return []interface{}{msg.UserID, msg.ConversationID, msg.MessageID, msg.FromID, 
+		msg.FromUsername, msg.FromFirstName, msg.FromLastName, nullString(msg.SenderType), nullString(msg.PostAuthor), 
+		nullInt(msg.ViaBotID), msg.Content, 
+		msg.MessageType, msg.MediaURL, entities, actionType, action, 
+		nullInt(int64(msg.ReplyToMsgID)), nullInt(int64(msg.ReplyToTopID)), nullInt(msg.ReplyToPeerID), nullString(msg.ReplyQuote), 
+		nullInt(msg.FwdFromID), nullString(msg.FwdFromType), nullString(msg.FwdFromName), nullInt(int64(msg.FwdChannelPost)), 
+		nullString(msg.FwdPostAuthor), fwdDate, nullInt(int64(msg.TopicID)), nullInt(msg.GroupedID), mediaData, 
+		nullInt(int64(msg.Views)), nullInt(int64(msg.Forwards)), nullInt(int64(msg.RepliesCount)), reactions, msg.IsPinned, msg.IsOut, msg.Mentioned, msg.Timestamp}, nil
+}
+
+// nullInt stores zero as NULL, for optional references such as reply IDs
+func nullInt(v int64) interface{} {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
+func nullString(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// jsonColumn encodes structured data for a TEXT column, empty data is stored as NULL
+func jsonColumn(v interface{}, empty bool) (interface{}, error) {
+	if empty {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func decodeJSONColumn(data string, v interface{}) error {
+	if data == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+// newerLastMessage keeps the stored last message of a conversation unless
+// the saved one is at least as new, e.g. a dialog without its top message
+const newerLastMessage = `last_message = CASE WHEN excluded.last_message_id >= COALESCE(conversations.last_message_id, 0) 
+			THEN excluded.last_message ELSE conversations.last_message END, 
+		last_time = CASE WHEN excluded.last_message_id >= COALESCE(conversations.last_message_id, 0) 
+			THEN excluded.last_time ELSE conversations.last_time END, 
+		last_sender_id = CASE WHEN excluded.last_message_id >= COALESCE(conversations.last_message_id, 0) 
+			THEN excluded.last_sender_id ELSE conversations.last_sender_id END, 
+		last_sender_name = CASE WHEN excluded.last_message_id >= COALESCE(conversations.last_message_id, 0) 
+			THEN excluded.last_sender_name ELSE conversations.last_sender_name END, 
+		last_message_id = COALESCE(MAX(excluded.last_message_id, COALESCE(conversations.last_message_id, 0)), conversations.last_message_id)`
+
+// SaveConversation inserts or updates a conversation from a dialog. Details
+// that dialogs don't carry (description, member count, linked chat, ...) are
+// kept, and the avatar points to the downloaded photo once there is one.
+func (db *DB) SaveConversation(conv *models.Conversation) error {
+	_, err := db.Exec(`INSERT INTO conversations `+conversationInsertColumns+` 
+		ON CONFLICT(id) DO UPDATE SET user_id = excluded.user_id, type = excluded.type, title = excluded.title, 
+		username = excluded.username, photo_id = excluded.photo_id, access_hash = excluded.access_hash, 
+		avatar_url = COALESCE((SELECT a.media_url FROM avatars a WHERE a.user_id = excluded.user_id 
+			AND a.owner_id = excluded.id AND a.photo_id = excluded.photo_id), excluded.avatar_url), 
+		`+newerLastMessage+`, unread_count = excluded.unread_count, 
+		unread_mentions_count = excluded.unread_mentions_count, folder_id = excluded.folder_id, is_forum = excluded.is_forum, 
+		linked_chat_id = COALESCE(excluded.linked_chat_id, conversations.linked_chat_id), updated_at = excluded.updated_at`,
+		conversationValues(conv)...)
 	return err
 }
 
 func (db *DB) GetConversations() ([]models.Conversation, error) {
-	query := `SELECT id, user_id, type, title, username, COALESCE(avatar_url, ''), COALESCE(access_hash, ''), 
-		COALESCE(last_message, ''), last_time, created_at, updated_at 
-		FROM conversations ORDER BY last_time DESC`
+	query := `SELECT ` + conversationColumns + ` FROM conversations ORDER BY last_time DESC`
 
 	rows, err := db.Query(query)
 	if err != nil {
@@ -195,22 +733,18 @@ func (db *DB) GetConversations() ([]models.Conversation, error) {
 
 	var conversations []models.Conversation
 	for rows.Next() {
-		var conv models.Conversation
-		err := rows.Scan(&conv.ID, &conv.UserID, &conv.Type, &conv.Title, &conv.Username, 
-			&conv.AvatarURL, &conv.AccessHash, &conv.LastMessage, &conv.LastTime, &conv.CreatedAt, &conv.UpdatedAt)
+		conv, err := scanConversation(rows)
 		if err != nil {
 			return nil, err
 		}
-		conversations = append(conversations, conv)
+		conversations = append(conversations, *conv)
 	}
 
 	return conversations, nil
 }
 
 func (db *DB) GetConversationsByUserID(userID int64) ([]models.Conversation, error) {
-	query := `SELECT id, user_id, type, title, username, COALESCE(avatar_url, ''), COALESCE(access_hash, ''), 
-		COALESCE(last_message, ''), last_time, created_at, updated_at 
-		FROM conversations WHERE user_id = ? ORDER BY last_time DESC`
+	query := `SELECT ` + conversationColumns + ` FROM conversations WHERE user_id = ? ORDER BY last_time DESC`
 
 	rows, err := db.Query(query, userID)
 	if err != nil {
@@ -220,106 +754,1689 @@ func (db *DB) GetConversationsByUserID(userID int64) ([]models.Conversation, err
 
 	var conversations []models.Conversation
 	for rows.Next() {
-		var conv models.Conversation
-		err := rows.Scan(&conv.ID, &conv.UserID, &conv.Type, &conv.Title, &conv.Username, 
-			&conv.AvatarURL, &conv.AccessHash, &conv.LastMessage, &conv.LastTime, &conv.CreatedAt, &conv.UpdatedAt)
+		conv, err := scanConversation(rows)
 		if err != nil {
 			return nil, err
 		}
-		conversations = append(conversations, conv)
+		conversations = append(conversations, *conv)
 	}
 
 	return conversations, nil
 }
 
+// SaveConversationIfAbsent stores a conversation unless it already exists,
+// so imported data never overwrites what live sync recorded
+func (db *DB) SaveConversationIfAbsent(conv *models.Conversation) (bool, error) {
+	res, err := db.Exec(`INSERT OR IGNORE INTO conversations `+conversationInsertColumns, conversationValues(conv)...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetConversation returns a single conversation of a user
+func (db *DB) GetConversation(userID, conversationID int64) (*models.Conversation, error) {
+	query := `SELECT ` + conversationColumns + ` FROM conversations WHERE user_id = ? AND id = ?`
+
+	return scanConversation(db.QueryRow(query, userID, conversationID))
+}
+
+// SaveMessage stores a message and moves the last message of its conversation
 func (db *DB) SaveMessage(msg *models.Message) error {
-	query := `INSERT OR REPLACE INTO messages 
-		(user_id, conversation_id, message_id, from_id, from_username, from_first_name, from_last_name, 
-		content, message_type, media_url, timestamp) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
-	_, err := db.Exec(query, msg.UserID, msg.ConversationID, msg.MessageID, msg.FromID, 
-		msg.FromUsername, msg.FromFirstName, msg.FromLastName, msg.Content, 
-		msg.MessageType, msg.MediaURL, msg.Timestamp)
-	return err
+	return db.saveMessages([]models.Message{*msg}, nil)
 }
 
-func (db *DB) GetMessages(conversationID int64, limit, offset int) ([]models.Message, error) {
-	query := `SELECT id, user_id, conversation_id, message_id, from_id, from_username, from_first_name, 
-		from_last_name, content, message_type, media_url, timestamp, created_at 
-		FROM messages WHERE conversation_id = ? ORDER BY timestamp DESC LIMIT ? OFFSET ?`
+// ImportChat stores an imported conversation and its messages in one
+// transaction, so a failed import leaves nothing of the chat behind. Neither
+// the conversation nor messages that are already stored are overwritten.
+// It returns the number of messages inserted.
+func (db *DB) ImportChat(conv *models.Conversation, messages []models.Message) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
-	rows, err := db.Query(query, conversationID, limit, offset)
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO conversations `+conversationInsertColumns, conversationValues(conv)...); err != nil {
+		return 0, fmt.Errorf("failed to save conversation %d: %v", conv.ID, err)
+	}
+
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO messages ` + messageInsertColumns)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer rows.Close()
+	defer stmt.Close()
 
-	var messages []models.Message
-	for rows.Next() {
-		var msg models.Message
-		err := rows.Scan(&msg.ID, &msg.UserID, &msg.ConversationID, &msg.MessageID, &msg.FromID, 
-			&msg.FromUsername, &msg.FromFirstName, &msg.FromLastName, &msg.Content, 
-			&msg.MessageType, &msg.MediaURL, &msg.Timestamp, &msg.CreatedAt)
+	inserted := 0
+	for i := range messages {
+		values, err := messageValues(&messages[i])
 		if err != nil {
-			return nil, err
+			return 0, err
+		}
+		res, err := stmt.Exec(values...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to save message %d: %v", messages[i].MessageID, err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return 0, err
+		} else if n > 0 {
+			inserted++
 		}
-		messages = append(messages, msg)
 	}
 
-	return messages, nil
+	if err := updateLastMessage(tx, conv.UserID, conv.ID, nil); err != nil {
+		return 0, fmt.Errorf("failed to update last message of conversation %d: %v", conv.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return inserted, nil
 }
 
-func (db *DB) GetMessagesByUserAndConversation(userID, conversationID int64, limit, offset int) ([]models.Message, error) {
-	query := `SELECT id, user_id, conversation_id, message_id, from_id, from_username, from_first_name, 
-		from_last_name, content, message_type, media_url, timestamp, created_at 
-		FROM messages WHERE user_id = ? AND conversation_id = ? ORDER BY timestamp DESC LIMIT ? OFFSET ?`
+// SaveMessages stores a batch of messages in one transaction and moves the
+// last message of every conversation they belong to
+func (db *DB) SaveMessages(messages []models.Message) error {
+	return db.saveMessages(messages, nil)
+}
 
-	rows, err := db.Query(query, userID, conversationID, limit, offset)
+// SaveMessagePage stores a page of a conversation's history together with
+// the conversation's last message and the sync cursor, so the cursor is never
+// ahead of the stored messages. A nil cursor leaves the stored one as is.
+func (db *DB) SaveMessagePage(messages []models.Message, cursor *models.SyncCursor) error {
+	if cursor == nil {
+		return db.saveMessages(messages, nil)
+	}
+	return db.saveMessages(messages, func(tx *sql.Tx) error {
+		cursor.UpdatedAt = time.Now()
+		_, err := tx.Exec(`INSERT OR REPLACE INTO sync_cursors 
+			(user_id, conversation_id, newest_id, oldest_id, complete, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			cursor.UserID, cursor.ConversationID, cursor.NewestID, cursor.OldestID, cursor.Complete, cursor.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save sync cursor of conversation %d: %v", cursor.ConversationID, err)
+		}
+		return nil
+	})
+}
+
+// saveMessages stores messages and moves the last messages in one
+// transaction, then runs also, if given, in the same transaction
+func (db *DB) saveMessages(messages []models.Message, also func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var messages []models.Message
-	for rows.Next() {
-		var msg models.Message
-		err := rows.Scan(&msg.ID, &msg.UserID, &msg.ConversationID, &msg.MessageID, &msg.FromID, 
-			&msg.FromUsername, &msg.FromFirstName, &msg.FromLastName, &msg.Content, 
-			&msg.MessageType, &msg.MediaURL, &msg.Timestamp, &msg.CreatedAt)
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO messages ` + messageInsertColumns)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	stored, err := tx.Prepare(`SELECT COALESCE(media_url, '') FROM messages 
+		WHERE user_id = ? AND conversation_id = ? AND message_id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stored.Close()
+
+	type conversationKey struct{ userID, conversationID int64 }
+	touched := make(map[conversationKey][]*models.Message)
+	for i := range messages {
+		msg := &messages[i]
+		// A downloaded or imported file stays in place of a placeholder
+		if msg.MediaURL != "" && !media.IsLocal(msg.MediaURL) {
+			var mediaURL string
+			err := stored.QueryRow(msg.UserID, msg.ConversationID, msg.MessageID).Scan(&mediaURL)
+			if err != nil && err != sql.ErrNoRows {
+				return fmt.Errorf("failed to get stored message %d: %v", msg.MessageID, err)
+			}
+			if media.IsLocal(mediaURL) {
+				msg.MediaURL = mediaURL
+			}
+		}
+		values, err := messageValues(msg)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		messages = append(messages, msg)
+		if _, err := stmt.Exec(values...); err != nil {
+			return fmt.Errorf("failed to save message %d: %v", msg.MessageID, err)
+		}
+		key := conversationKey{msg.UserID, msg.ConversationID}
+		touched[key] = append(touched[key], msg)
 	}
 
-	return messages, nil
-}
+	for key, saved := range touched {
+		if err := updateLastMessage(tx, key.userID, key.conversationID, saved); err != nil {
+			return fmt.Errorf("failed to update last message of conversation %d: %v", key.conversationID, err)
+		}
+	}
 
-func (db *DB) SaveAuthSession(session *models.AuthSession) error {
-	query := `INSERT OR REPLACE INTO auth_sessions (user_id, phone_code, is_active, session_data, app_id, app_hash, phone, updated_at) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	if also != nil {
+		if err := also(tx); err != nil {
+			return err
+		}
+	}
 
-	_, err := db.Exec(query, session.UserID, session.PhoneCode, session.IsActive, session.SessionData, 
-		session.AppID, session.AppHash, session.Phone, time.Now())
-	return err
+	return tx.Commit()
 }
 
-func (db *DB) GetActiveAuthSession() (*models.AuthSession, error) {
-	query := `SELECT id, COALESCE(user_id, 0), COALESCE(phone_code, ''), is_active, COALESCE(session_data, ''), 
-		COALESCE(app_id, 0), COALESCE(app_hash, ''), COALESCE(phone, ''), created_at, updated_at 
-		FROM auth_sessions WHERE is_active = 1 ORDER BY created_at DESC LIMIT 1`
-
-	var session models.AuthSession
-	err := db.QueryRow(query).Scan(&session.ID, &session.UserID, &session.PhoneCode, &session.IsActive, 
-		&session.SessionData, &session.AppID, &session.AppHash, &session.Phone,
-		&session.CreatedAt, &session.UpdatedAt)
+// updateLastMessage moves the last message of a conversation to its newest
+// stored message. Saved messages newer than the previous last one are unread
+// unless the account sent a message after them, as Telegram marks a chat read
+// when you write in it.
+func updateLastMessage(tx *sql.Tx, userID, conversationID int64, saved []*models.Message) error {
+	var lastID, unread, mentions int
+	err := tx.QueryRow(`SELECT COALESCE(last_message_id, 0), COALESCE(unread_count, 0), COALESCE(unread_mentions_count, 0) 
+		FROM conversations WHERE user_id = ? AND id = ?`, userID, conversationID).Scan(&lastID, &unread, &mentions)
+	if err == sql.ErrNoRows {
+		return nil
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &session, nil
-}
+	newest, err := scanMessage(tx.QueryRow(`SELECT `+messageColumns+` FROM messages 
+		WHERE user_id = ? AND conversation_id = ? ORDER BY message_id DESC LIMIT 1`, userID, conversationID))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Older pages of a backfill leave the last message as is
+	if newest.MessageID < lastID {
+		return nil
+	}
+
+	// Without a known last message all of the history would count as unread,
+	// the counts of the dialog are kept then
+	if lastID > 0 {
+		sort.Slice(saved, func(i, j int) bool { return saved[i].MessageID < saved[j].MessageID })
+		for _, msg := range saved {
+			if msg.MessageID <= lastID {
+				continue
+			}
+			if msg.IsOut {
+				unread, mentions = 0, 0
+				continue
+			}
+			unread++
+			if msg.Mentioned {
+				mentions++
+			}
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE conversations SET last_message_id = ?, last_message = ?, last_time = ?, 
+		last_sender_id = ?, last_sender_name = ?, unread_count = ?, unread_mentions_count = ? 
+		WHERE user_id = ? AND id = ?`,
+		newest.MessageID, newest.Content, newest.Timestamp, nullInt(newest.FromID), nullString(senderName(newest)),
+		unread, mentions, userID, conversationID)
+	return err
+}
+
+func senderName(msg *models.Message) string {
+	return strings.TrimSpace(msg.FromFirstName + " " + msg.FromLastName)
+}
+
+// GetSyncCursor returns the sync cursor of a conversation. A conversation
+// that was never synced has an empty cursor.
+func (db *DB) GetSyncCursor(userID, conversationID int64) (*models.SyncCursor, error) {
+	query := `SELECT newest_id, oldest_id, complete, updated_at FROM sync_cursors 
+		WHERE user_id = ? AND conversation_id = ?`
+
+	cursor := models.SyncCursor{UserID: userID, ConversationID: conversationID}
+	err := db.QueryRow(query, userID, conversationID).Scan(&cursor.NewestID, &cursor.OldestID, &cursor.Complete, &cursor.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return &cursor, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+const syncPolicyColumns = `id, user_id, COALESCE(name, ''), COALESCE(peer_id, 0), COALESCE(peer_type, ''), 
+	COALESCE(username_pattern, ''), folder_id, COALESCE(sync, 1), backfill, COALESCE(backfill_limit, 0), 
+	COALESCE(media_types, ''), COALESCE(max_media_size, 0), COALESCE(priority, 0), created_at, updated_at`
+
+func scanSyncPolicy(row scanner) (*models.SyncPolicy, error) {
+	var p models.SyncPolicy
+	var folderID sql.NullInt64
+	var mediaTypes string
+	err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.PeerID, &p.PeerType, &p.UsernamePattern, &folderID, 
+		&p.Sync, &p.Backfill, &p.BackfillLimit, &mediaTypes, &p.MaxMediaSize, &p.Priority, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if folderID.Valid {
+		folder := int(folderID.Int64)
+		p.FolderID = &folder
+	}
+	if err := decodeJSONColumn(mediaTypes, &p.MediaTypes); err != nil {
+		return nil, fmt.Errorf("invalid media types of sync policy %d: %v", p.ID, err)
+	}
+	return &p, nil
+}
+
+// SaveSyncPolicy creates a policy when its ID is 0 and replaces the stored
+// one of the same user otherwise, sql.ErrNoRows if there is none
+func (db *DB) SaveSyncPolicy(p *models.SyncPolicy) error {
+	mediaTypes, err := jsonColumn(p.MediaTypes, len(p.MediaTypes) == 0)
+	if err != nil {
+		return err
+	}
+	var folderID interface{}
+	if p.FolderID != nil {
+		folderID = *p.FolderID
+	}
+
+	now := time.Now()
+	if p.ID == 0 {
+		res, err := db.Exec(`INSERT INTO sync_policies 
+			(user_id, name, peer_id, peer_type, username_pattern, folder_id, sync, backfill, backfill_limit, 
+			media_types, max_media_size, priority, created_at, updated_at) 
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.UserID, nullString(p.Name), nullInt(p.PeerID), nullString(p.PeerType), nullString(p.UsernamePattern), folderID, 
+			p.Sync, p.Backfill, p.BackfillLimit, mediaTypes, p.MaxMediaSize, p.Priority, now, now)
+		if err != nil {
+			return err
+		}
+		p.ID, err = res.LastInsertId()
+		p.CreatedAt, p.UpdatedAt = now, now
+		return err
+	}
+
+	res, err := db.Exec(`UPDATE sync_policies SET name = ?, peer_id = ?, peer_type = ?, username_pattern = ?, 
+		folder_id = ?, sync = ?, backfill = ?, backfill_limit = ?, media_types = ?, max_media_size = ?, priority = ?, 
+		updated_at = ? WHERE id = ? AND user_id = ?`,
+		nullString(p.Name), nullInt(p.PeerID), nullString(p.PeerType), nullString(p.UsernamePattern), folderID, 
+		p.Sync, p.Backfill, p.BackfillLimit, mediaTypes, p.MaxMediaSize, p.Priority, now, p.ID, p.UserID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	p.UpdatedAt = now
+	return db.QueryRow(`SELECT created_at FROM sync_policies WHERE id = ?`, p.ID).Scan(&p.CreatedAt)
+}
+
+// GetSyncPolicies returns the sync policies of a user, oldest first
+func (db *DB) GetSyncPolicies(userID int64) ([]models.SyncPolicy, error) {
+	query := `SELECT ` + syncPolicyColumns + ` FROM sync_policies WHERE user_id = ? ORDER BY id`
+
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []models.SyncPolicy
+	for rows.Next() {
+		p, err := scanSyncPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *p)
+	}
+	return policies, rows.Err()
+}
+
+func (db *DB) GetSyncPolicy(userID, policyID int64) (*models.SyncPolicy, error) {
+	query := `SELECT ` + syncPolicyColumns + ` FROM sync_policies WHERE user_id = ? AND id = ?`
+
+	return scanSyncPolicy(db.QueryRow(query, userID, policyID))
+}
+
+// DeleteSyncPolicy removes a policy of a user, sql.ErrNoRows if there is none
+func (db *DB) DeleteSyncPolicy(userID, policyID int64) error {
+	res, err := db.Exec(`DELETE FROM sync_policies WHERE user_id = ? AND id = ?`, userID, policyID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = sql.ErrNoRows
+	}
+	return err
+}
+
+// GetMessageRange returns how many messages of a conversation are stored and
+// the time of the oldest one
+func (db *DB) GetMessageRange(userID, conversationID int64) (count int, oldest time.Time, err error) {
+	query := `SELECT timestamp FROM messages WHERE user_id = ? AND conversation_id = ? ORDER BY message_id LIMIT 1`
+
+	err = db.QueryRow(query, userID, conversationID).Scan(&oldest)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	err = db.QueryRow(`SELECT COUNT(*) FROM messages WHERE user_id = ? AND conversation_id = ?`, userID, conversationID).Scan(&count)
+	return count, oldest, err
+}
+
+// SaveSchedule stores the schedule entries of a user in one transaction
+func (db *DB) SaveSchedule(entries []models.ScheduleEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, entry := range entries {
+		var lastRun interface{}
+		if !entry.LastRunAt.IsZero() {
+			lastRun = entry.LastRunAt
+		}
+		_, err := tx.Exec(`INSERT OR REPLACE INTO sync_schedule 
+			(user_id, conversation_id, interval_seconds, rate, priority, next_run_at, last_run_at, failures) 
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			entry.UserID, entry.ConversationID, entry.Interval, entry.Rate, entry.Priority, entry.NextRunAt, lastRun,
+			entry.Failures)
+		if err != nil {
+			return fmt.Errorf("failed to save schedule of conversation %d: %v", entry.ConversationID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetSchedule returns the schedule of a user, soonest first, with the titles
+// of the conversations
+func (db *DB) GetSchedule(userID int64) ([]models.ScheduleEntry, error) {
+	query := `SELECT s.user_id, s.conversation_id, COALESCE(c.title, ''), s.interval_seconds, COALESCE(s.rate, 0), 
+		COALESCE(s.priority, 0), s.next_run_at, s.last_run_at, COALESCE(s.failures, 0) 
+		FROM sync_schedule s LEFT JOIN conversations c ON c.id = s.conversation_id AND c.user_id = s.user_id 
+		WHERE s.user_id = ? ORDER BY s.next_run_at, s.priority DESC`
+
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.ScheduleEntry
+	for rows.Next() {
+		var entry models.ScheduleEntry
+		var lastRun sql.NullTime
+		err := rows.Scan(&entry.UserID, &entry.ConversationID, &entry.Title, &entry.Interval, &entry.Rate, 
+			&entry.Priority, &entry.NextRunAt, &lastRun, &entry.Failures)
+		if err != nil {
+			return nil, err
+		}
+		if lastRun.Valid {
+			entry.LastRunAt = lastRun.Time
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteScheduleEntries removes conversations from the schedule of a user
+func (db *DB) DeleteScheduleEntries(userID int64, conversationIDs []int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range conversationIDs {
+		if _, err := tx.Exec(`DELETE FROM sync_schedule WHERE user_id = ? AND conversation_id = ?`, userID, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// NextScheduledRun returns the soonest next run of a user, zero when nothing
+// is scheduled yet
+func (db *DB) NextScheduledRun(userID int64) (time.Time, error) {
+	var next time.Time
+	err := db.QueryRow(`SELECT next_run_at FROM sync_schedule WHERE user_id = ?
+		ORDER BY next_run_at LIMIT 1`, userID).Scan(&next)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return next, err
+}
+
+// CountMessagesSince counts the messages of a conversation sent since a time.
+// A conversationID of 0 counts those of all conversations of the user.
+func (db *DB) CountMessagesSince(userID, conversationID int64, since time.Time) (int, error) {
+	query := `SELECT COUNT(*) FROM messages WHERE user_id = ? AND timestamp >= ?`
+	args := []interface{}{userID, since}
+	if conversationID != 0 {
+		query += ` AND conversation_id = ?`
+		args = append(args, conversationID)
+	}
+
+	var count int
+	err := db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// MessageExists reports whether a message of a conversation is stored
+func (db *DB) MessageExists(userID, conversationID int64, messageID int) (bool, error) {
+	query := `SELECT 1 FROM messages WHERE user_id = ? AND conversation_id = ? AND message_id = ?`
+
+	var one int
+	err := db.QueryRow(query, userID, conversationID, messageID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (db *DB) GetMessages(conversationID int64, limit, offset int) ([]models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE conversation_id = ? 
+		ORDER BY timestamp DESC LIMIT ? OFFSET ?`
+
+	rows, err := db.Query(query, conversationID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+
+	return messages, nil
+}
+
+// GetTopicMessages returns the messages of a forum topic, newest first.
+// Messages outside any topic belong to the General topic, which has ID 1.
+func (db *DB) GetTopicMessages(conversationID int64, topicID, limit, offset int) ([]models.Message, error) {
+	topicFilter := `topic_id = ?`
+	if topicID == models.GeneralTopicID {
+		topicFilter = `(topic_id = ? OR topic_id IS NULL)`
+	}
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE conversation_id = ? AND ` + topicFilter + ` 
+		ORDER BY timestamp DESC LIMIT ? OFFSET ?`
+
+	rows, err := db.Query(query, conversationID, topicID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// SaveForumTopics stores the topics of a forum conversation, replacing the
+// stored state of topics seen again
+func (db *DB) SaveForumTopics(userID, conversationID int64, topics []models.ForumTopic) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO forum_topics 
+		(user_id, conversation_id, topic_id, title, icon_color, icon_emoji_id, creator_id, 
+		is_closed, is_hidden, is_pinned, top_message, created_at, updated_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) 
+		ON CONFLICT(user_id, conversation_id, topic_id) DO UPDATE SET 
+		title = excluded.title, icon_color = excluded.icon_color, icon_emoji_id = excluded.icon_emoji_id, 
+		is_closed = excluded.is_closed, is_hidden = excluded.is_hidden, is_pinned = excluded.is_pinned, 
+		top_message = excluded.top_message, updated_at = excluded.updated_at`
+
+	for _, topic := range topics {
+		_, err := tx.Exec(query, userID, conversationID, topic.TopicID, topic.Title, topic.IconColor, 
+			topic.IconEmojiID, topic.CreatorID, topic.IsClosed, topic.IsHidden, topic.IsPinned, 
+			topic.TopMessage, topic.CreatedAt, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to save topic %d: %v", topic.TopicID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetForumTopics returns the topics of a conversation with their number of
+// backed up messages, pinned topics first
+func (db *DB) GetForumTopics(conversationID int64) ([]models.ForumTopic, error) {
+	query := `SELECT t.user_id, t.conversation_id, t.topic_id, t.title, COALESCE(t.icon_color, 0), 
+		COALESCE(t.icon_emoji_id, 0), COALESCE(t.creator_id, 0), t.is_closed, t.is_hidden, t.is_pinned, 
+		COALESCE(t.top_message, 0), t.created_at, t.updated_at, 
+		(SELECT COUNT(*) FROM messages m WHERE m.user_id = t.user_id AND m.conversation_id = t.conversation_id 
+			AND (m.topic_id = t.topic_id OR (t.topic_id = ? AND m.topic_id IS NULL))) 
+		FROM forum_topics t WHERE t.conversation_id = ? 
+		ORDER BY t.is_pinned DESC, t.top_message DESC`
+
+	rows, err := db.Query(query, models.GeneralTopicID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var topics []models.ForumTopic
+	for rows.Next() {
+		var topic models.ForumTopic
+		var createdAt sql.NullTime
+		err := rows.Scan(&topic.UserID, &topic.ConversationID, &topic.TopicID, &topic.Title, 
+			&topic.IconColor, &topic.IconEmojiID, &topic.CreatorID, &topic.IsClosed, &topic.IsHidden, 
+			&topic.IsPinned, &topic.TopMessage, &createdAt, &topic.UpdatedAt, &topic.MessageCount)
+		if err != nil {
+			return nil, err
+		}
+		topic.CreatedAt = createdAt.Time
+		topics = append(topics, topic)
+	}
+
+	return topics, rows.Err()
+}
+
+// GetMessage returns a single message of a conversation
+func (db *DB) GetMessage(conversationID int64, messageID int) (*models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE conversation_id = ? AND message_id = ?`
+
+	return scanMessage(db.QueryRow(query, conversationID, messageID))
+}
+
+// GetReplies returns the messages replying to a message, directly or further
+// down its thread, oldest first
+func (db *DB) GetReplies(conversationID int64, messageID int, limit int) ([]models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE conversation_id = ? 
+		AND (reply_to_msg_id = ? OR reply_to_top_id = ?) 
+		ORDER BY message_id ASC LIMIT ?`
+
+	rows, err := db.Query(query, conversationID, messageID, messageID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+
+	return messages, rows.Err()
+}
+
+func (db *DB) GetMessagesByUserAndConversation(userID, conversationID int64, limit, offset int) ([]models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE user_id = ? AND conversation_id = ? 
+		ORDER BY timestamp DESC LIMIT ? OFFSET ?`
+
+	rows, err := db.Query(query, userID, conversationID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+
+	return messages, nil
+}
+
+// ForEachMessage calls fn for every message of a conversation in chronological
+// order without loading the whole conversation into memory
+func (db *DB) ForEachMessage(userID, conversationID int64, fn func(msg *models.Message) error) error {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE user_id = ? AND conversation_id = ? 
+		ORDER BY message_id ASC`
+
+	rows, err := db.Query(query, userID, conversationID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetConversationFingerprint summarizes the stored messages of a conversation.
+// Saving a message replaces its row with a new id, so the count and the
+// highest row id change whenever a message is added or rewritten. Updates in
+// place (counters, pins) bump the version of the row, which the sum of
+// versions picks up.
+func (db *DB) GetConversationFingerprint(userID, conversationID int64) (count int, maxRowID, version int64, err error) {
+	query := `SELECT COUNT(*), COALESCE(MAX(id), 0), COALESCE(SUM(version), 0) 
+		FROM messages WHERE user_id = ? AND conversation_id = ?`
+
+	err = db.QueryRow(query, userID, conversationID).Scan(&count, &maxRowID, &version)
+	return count, maxRowID, version, err
+}
+
+// SaveMessageStats records snapshots of message counters and updates the
+// current counters of the messages. A snapshot is only stored when the
+// counters differ from the latest one of the message. It returns the number
+// of snapshots stored.
+func (db *DB) SaveMessageStats(stats []models.MessageStats) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	for _, st := range stats {
+		reactions, err := jsonColumn(st.Reactions, len(st.Reactions) == 0)
+		if err != nil {
+			return 0, err
+		}
+		reactionsText, _ := reactions.(string)
+
+		var views, forwards, replies int
+		var latestReactions string
+		err = tx.QueryRow(`SELECT views, forwards, replies, COALESCE(reactions, '') FROM message_stats 
+			WHERE user_id = ? AND conversation_id = ? AND message_id = ? ORDER BY captured_at DESC, id DESC LIMIT 1`,
+			st.UserID, st.ConversationID, st.MessageID).Scan(&views, &forwards, &replies, &latestReactions)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, fmt.Errorf("failed to get stats of message %d: %v", st.MessageID, err)
+		}
+		if err == sql.ErrNoRows || views != st.Views || forwards != st.Forwards || replies != st.Replies ||
+			latestReactions != reactionsText {
+			_, err = tx.Exec(`INSERT INTO message_stats 
+				(user_id, conversation_id, message_id, views, forwards, replies, reactions, captured_at) 
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				st.UserID, st.ConversationID, st.MessageID, st.Views, st.Forwards, st.Replies, reactions, st.CapturedAt)
+			if err != nil {
+				return 0, fmt.Errorf("failed to save stats of message %d: %v", st.MessageID, err)
+			}
+			changed++
+		}
+
+		// The message may hold other counters than the latest snapshot, e.g.
+		// after it was saved again by a sync
+		_, err = tx.Exec(`UPDATE messages SET views = ?, forwards = ?, replies_count = ?, reactions = ?, 
+			version = version + 1 WHERE user_id = ? AND conversation_id = ? AND message_id = ? 
+			AND (COALESCE(views, 0) != ? OR COALESCE(forwards, 0) != ? OR COALESCE(replies_count, 0) != ? 
+			OR COALESCE(reactions, '') != ?)`,
+			nullInt(int64(st.Views)), nullInt(int64(st.Forwards)), nullInt(int64(st.Replies)), reactions, 
+			st.UserID, st.ConversationID, st.MessageID, st.Views, st.Forwards, st.Replies, reactionsText)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update counters of message %d: %v", st.MessageID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// GetMessageStats returns the counter snapshots of a message, oldest first
+func (db *DB) GetMessageStats(conversationID int64, messageID int) ([]models.MessageStats, error) {
+	query := `SELECT user_id, conversation_id, message_id, views, forwards, replies, COALESCE(reactions, ''), captured_at 
+		FROM message_stats WHERE conversation_id = ? AND message_id = ? ORDER BY captured_at ASC`
+
+	rows, err := db.Query(query, conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []models.MessageStats
+	for rows.Next() {
+		var st models.MessageStats
+		var reactions string
+		err := rows.Scan(&st.UserID, &st.ConversationID, &st.MessageID, &st.Views, &st.Forwards, 
+			&st.Replies, &reactions, &st.CapturedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeJSONColumn(reactions, &st.Reactions); err != nil {
+			return nil, fmt.Errorf("invalid reactions of message %d: %v", messageID, err)
+		}
+		stats = append(stats, st)
+	}
+
+	return stats, rows.Err()
+}
+
+// GetPendingMedia returns the messages of a conversation of the given types
+// whose media is not downloaded yet, newest first. Only the message ID, type
+// and placeholder media URL are set.
+func (db *DB) GetPendingMedia(userID, conversationID int64, types []string) ([]models.Message, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+	args := []interface{}{userID, conversationID}
+	for _, t := range types {
+		args = append(args, t)
+	}
+	query := `SELECT message_id, message_type, media_url FROM messages 
+		WHERE user_id = ? AND conversation_id = ? AND media_url LIKE 'telegram://%' 
+		AND message_type IN (?` + strings.Repeat(", ?", len(types)-1) + `) ORDER BY message_id DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		msg := models.Message{UserID: userID, ConversationID: conversationID}
+		if err := rows.Scan(&msg.MessageID, &msg.MessageType, &msg.MediaURL); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// SetMessageMedia points a message at its downloaded media file
+func (db *DB) SetMessageMedia(userID, conversationID int64, messageID int, mediaURL string) error {
+	_, err := db.Exec(`UPDATE messages SET media_url = ?, version = version + 1 
+		WHERE user_id = ? AND conversation_id = ? AND message_id = ?`, mediaURL, userID, conversationID, messageID)
+	return err
+}
+
+// GetRecentMessageIDs returns the IDs of the messages of a conversation sent
+// since the given time, newest first
+func (db *DB) GetRecentMessageIDs(userID, conversationID int64, since time.Time, limit int) ([]int, error) {
+	query := `SELECT message_id FROM messages WHERE user_id = ? AND conversation_id = ? AND timestamp >= ? 
+		AND COALESCE(message_type, 'text') != 'service' ORDER BY message_id DESC LIMIT ?`
+
+	rows, err := db.Query(query, userID, conversationID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// CountConversationEntries counts the messages of a conversation with each
+// album counted once
+func (db *DB) CountConversationEntries(userID, conversationID int64) (int, error) {
+	query := `SELECT COUNT(*) - COUNT(grouped_id) + COUNT(DISTINCT grouped_id) FROM messages 
+		WHERE user_id = ? AND conversation_id = ?`
+
+	var count int
+	err := db.QueryRow(query, userID, conversationID).Scan(&count)
+	return count, err
+}
+
+// SetLinkedChat records the discussion group linked to a channel
+func (db *DB) SetLinkedChat(userID, channelID, linkedChatID int64) error {
+	_, err := db.Exec(`UPDATE conversations SET linked_chat_id = ? WHERE user_id = ? AND id = ?`,
+		nullInt(linkedChatID), userID, channelID)
+	return err
+}
+
+// GetPostsWithNewComments returns the IDs of channel posts that have more
+// comments than when their comments were last fetched, or whose older
+// comments are not all fetched yet, newest first
+func (db *DB) GetPostsWithNewComments(userID, channelID int64, limit int) ([]int, error) {
+	query := `SELECT m.message_id FROM messages m 
+		LEFT JOIN comment_threads t ON t.user_id = m.user_id AND t.channel_id = m.conversation_id AND t.post_id = m.message_id 
+		WHERE m.user_id = ? AND m.conversation_id = ? AND (COALESCE(m.replies_count, 0) > COALESCE(t.replies_count, 0) 
+		OR (t.id IS NOT NULL AND NOT COALESCE(t.complete, FALSE))) 
+		ORDER BY m.message_id DESC LIMIT ?`
+
+	rows, err := db.Query(query, userID, channelID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// SaveComments stores fetched comments of a channel post together with its
+// comment thread, so the thread's cursor is never ahead of the stored comments
+func (db *DB) SaveComments(comments []models.Message, thread *models.CommentThread) error {
+	return db.saveMessages(comments, func(tx *sql.Tx) error {
+		return saveCommentThread(tx, thread)
+	})
+}
+
+// saveCommentThread records where the comments of a channel post live, how
+// many there were when they were fetched and how far they are fetched
+func saveCommentThread(tx *sql.Tx, thread *models.CommentThread) error {
+	query := `INSERT INTO comment_threads 
+		(user_id, channel_id, post_id, discussion_id, root_message_id, replies_count, 
+		newest_comment_id, oldest_comment_id, complete, synced_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) 
+		ON CONFLICT(user_id, channel_id, post_id) DO UPDATE SET 
+		discussion_id = excluded.discussion_id, 
+		root_message_id = COALESCE(excluded.root_message_id, comment_threads.root_message_id), 
+		replies_count = excluded.replies_count, newest_comment_id = excluded.newest_comment_id, 
+		oldest_comment_id = excluded.oldest_comment_id, complete = excluded.complete, synced_at = excluded.synced_at`
+
+	_, err := tx.Exec(query, thread.UserID, thread.ChannelID, thread.PostID, thread.DiscussionID,
+		nullInt(int64(thread.RootMessageID)), thread.RepliesCount, thread.NewestID, thread.OldestID, thread.Complete,
+		thread.SyncedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save comment thread of post %d: %v", thread.PostID, err)
+	}
+	return nil
+}
+
+// GetCommentThread returns the comment thread of a channel post, an empty
+// thread of the post when its comments were never fetched
+func (db *DB) GetCommentThread(userID, channelID int64, postID int) (*models.CommentThread, error) {
+	t := &models.CommentThread{UserID: userID, ChannelID: channelID, PostID: postID}
+	err := db.QueryRow(`SELECT discussion_id, COALESCE(root_message_id, 0), replies_count, 
+		COALESCE(newest_comment_id, 0), COALESCE(oldest_comment_id, 0), COALESCE(complete, FALSE), synced_at 
+		FROM comment_threads WHERE user_id = ? AND channel_id = ? AND post_id = ?`, userID, channelID, postID).Scan(
+		&t.DiscussionID, &t.RootMessageID, &t.RepliesCount, &t.NewestID, &t.OldestID, &t.Complete, &t.SyncedAt)
+	if err == sql.ErrNoRows {
+		return t, nil
+	}
+	return t, err
+}
+
+// GetCommentThreads returns the comment threads of the posts of a channel,
+// keyed by post ID
+func (db *DB) GetCommentThreads(userID, channelID int64) (map[int]models.CommentThread, error) {
+	query := `SELECT user_id, channel_id, post_id, discussion_id, COALESCE(root_message_id, 0), replies_count, 
+		COALESCE(newest_comment_id, 0), COALESCE(oldest_comment_id, 0), COALESCE(complete, FALSE), synced_at 
+		FROM comment_threads WHERE user_id = ? AND channel_id = ?`
+
+	rows, err := db.Query(query, userID, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	threads := make(map[int]models.CommentThread)
+	for rows.Next() {
+		var t models.CommentThread
+		err := rows.Scan(&t.UserID, &t.ChannelID, &t.PostID, &t.DiscussionID, &t.RootMessageID, &t.RepliesCount,
+			&t.NewestID, &t.OldestID, &t.Complete, &t.SyncedAt)
+		if err != nil {
+			return nil, err
+		}
+		threads[t.PostID] = t
+	}
+
+	return threads, rows.Err()
+}
+
+// GetPostComments returns the backed up comments of a channel post, oldest
+// first. Comments are messages of the discussion group replying to the
+// group's copy of the post, directly or within the thread.
+func (db *DB) GetPostComments(userID, channelID int64, postID int, limit int) ([]models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE user_id = ? AND EXISTS (
+		SELECT 1 FROM comment_threads t WHERE t.user_id = messages.user_id AND t.channel_id = ? AND t.post_id = ? 
+		AND t.discussion_id = messages.conversation_id AND t.root_message_id > 0 
+		AND (messages.reply_to_msg_id = t.root_message_id OR messages.reply_to_top_id = t.root_message_id)) 
+		ORDER BY message_id ASC LIMIT ?`
+
+	rows, err := db.Query(query, userID, channelID, postID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// GetCommentsFingerprint summarizes the stored comments of a channel the way
+// GetConversationFingerprint does for its messages
+func (db *DB) GetCommentsFingerprint(userID, channelID int64) (count int, maxRowID, version int64, err error) {
+	query := `SELECT COUNT(*), COALESCE(MAX(m.id), 0), COALESCE(SUM(m.version), 0) FROM messages m 
+		JOIN comment_threads t ON t.user_id = m.user_id AND t.discussion_id = m.conversation_id AND t.root_message_id > 0 
+		AND (m.reply_to_msg_id = t.root_message_id OR m.reply_to_top_id = t.root_message_id) 
+		WHERE t.user_id = ? AND t.channel_id = ?`
+
+	err = db.QueryRow(query, userID, channelID).Scan(&count, &maxRowID, &version)
+	return count, maxRowID, version, err
+}
+
+// SetPinnedMessages marks exactly the given messages of a conversation as
+// pinned. Only messages whose pin changes are updated.
+func (db *DB) SetPinnedMessages(userID, conversationID int64, messageIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE messages SET is_pinned = FALSE, version = version + 1 
+		WHERE user_id = ? AND conversation_id = ? AND is_pinned`
+	args := []interface{}{userID, conversationID}
+	if len(messageIDs) > 0 {
+		query += ` AND message_id NOT IN (?` + strings.Repeat(", ?", len(messageIDs)-1) + `)`
+		for _, id := range messageIDs {
+			args = append(args, id)
+		}
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+	for _, id := range messageIDs {
+		_, err := tx.Exec(`UPDATE messages SET is_pinned = TRUE, version = version + 1 
+			WHERE user_id = ? AND conversation_id = ? AND message_id = ? AND NOT COALESCE(is_pinned, FALSE)`,
+			userID, conversationID, id)
+		if err != nil {
+			return fmt.Errorf("failed to pin message %d: %v", id, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetPinnedMessages returns the pinned messages of a conversation, newest first
+func (db *DB) GetPinnedMessages(conversationID int64) ([]models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE conversation_id = ? AND is_pinned 
+		ORDER BY message_id DESC`
+
+	rows, err := db.Query(query, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// SaveScheduledMessages replaces the stored scheduled messages of a
+// conversation. Messages that were sent or cancelled since are dropped.
+func (db *DB) SaveScheduledMessages(userID, conversationID int64, messages []models.Message) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM scheduled_messages WHERE user_id = ? AND conversation_id = ?`, userID, conversationID)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		data, err := json.Marshal(&messages[i])
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO scheduled_messages (user_id, conversation_id, message_id, scheduled_at, data, updated_at) 
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			userID, conversationID, messages[i].MessageID, messages[i].Timestamp, string(data), time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to save scheduled message %d: %v", messages[i].MessageID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetScheduledMessages returns the scheduled messages of a conversation in the
+// order they will be sent. Their timestamp is the time they are scheduled for.
+func (db *DB) GetScheduledMessages(conversationID int64) ([]models.Message, error) {
+	rows, err := db.Query(`SELECT data FROM scheduled_messages WHERE conversation_id = ? ORDER BY scheduled_at ASC`,
+		conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var msg models.Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, fmt.Errorf("invalid scheduled message: %v", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// chatFingerprint identifies the content of a snapshot so unchanged chats
+// don't get a new snapshot
+func chatFingerprint(snap *models.ChatSnapshot) (string, error) {
+	members := append([]models.ChatMember(nil), snap.Members...)
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+
+	data, err := json.Marshal(struct {
+		Title, About, InviteLink string
+		ParticipantsCount        int
+		LinkedChatID             int64
+		HasMembers               bool
+		Members                  []models.ChatMember
+	}{snap.Title, snap.About, snap.InviteLink, snap.ParticipantsCount, snap.LinkedChatID, snap.HasMembers, members})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SaveChatSnapshot stores a snapshot of a chat's metadata and members and
+// updates the conversation with the metadata. When nothing changed since the
+// latest snapshot only its check time is updated. It returns whether a new
+// snapshot was stored.
+func (db *DB) SaveChatSnapshot(snap *models.ChatSnapshot) (bool, error) {
+	fingerprint, err := chatFingerprint(snap)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE conversations SET about = ?, participants_count = ?, invite_link = ?, 
+		linked_chat_id = COALESCE(?, linked_chat_id) WHERE user_id = ? AND id = ?`,
+		snap.About, snap.ParticipantsCount, snap.InviteLink, nullInt(snap.LinkedChatID), snap.UserID, snap.ConversationID)
+	if err != nil {
+		return false, err
+	}
+
+	var latestID int64
+	var latestFingerprint string
+	err = tx.QueryRow(`SELECT id, fingerprint FROM chat_snapshots WHERE user_id = ? AND conversation_id = ? 
+		ORDER BY captured_at DESC, id DESC LIMIT 1`, snap.UserID, snap.ConversationID).Scan(&latestID, &latestFingerprint)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	if err == nil && latestFingerprint == fingerprint {
+		if _, err := tx.Exec(`UPDATE chat_snapshots SET checked_at = ? WHERE id = ?`, snap.CheckedAt, latestID); err != nil {
+			return false, err
+		}
+		return false, tx.Commit()
+	}
+
+	res, err := tx.Exec(`INSERT INTO chat_snapshots 
+		(user_id, conversation_id, title, about, participants_count, linked_chat_id, invite_link, has_members, fingerprint, captured_at, checked_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		snap.UserID, snap.ConversationID, snap.Title, snap.About, snap.ParticipantsCount, nullInt(snap.LinkedChatID),
+		snap.InviteLink, snap.HasMembers, fingerprint, snap.CapturedAt, snap.CheckedAt)
+	if err != nil {
+		return false, err
+	}
+	snap.ID, err = res.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range snap.Members {
+		rights, err := jsonColumn(m.AdminRights, len(m.AdminRights) == 0)
+		if err != nil {
+			return false, err
+		}
+		_, err = tx.Exec(`INSERT INTO chat_members 
+			(snapshot_id, member_id, username, first_name, last_name, role, rank, admin_rights, inviter_id, joined_at) 
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			snap.ID, m.UserID, m.Username, m.FirstName, m.LastName, m.Role, m.Rank, rights, nullInt(m.InviterID), m.JoinedAt)
+		if err != nil {
+			return false, fmt.Errorf("failed to save member %d: %v", m.UserID, err)
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+const chatSnapshotColumns = `id, user_id, conversation_id, COALESCE(title, ''), COALESCE(about, ''), participants_count, 
+	COALESCE(linked_chat_id, 0), COALESCE(invite_link, ''), has_members, captured_at, checked_at`
+
+func scanChatSnapshot(row scanner) (*models.ChatSnapshot, error) {
+	var snap models.ChatSnapshot
+	err := row.Scan(&snap.ID, &snap.UserID, &snap.ConversationID, &snap.Title, &snap.About, &snap.ParticipantsCount,
+		&snap.LinkedChatID, &snap.InviteLink, &snap.HasMembers, &snap.CapturedAt, &snap.CheckedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// GetChatSnapshots lists the snapshots of a chat without their members,
+// newest first
+func (db *DB) GetChatSnapshots(conversationID int64) ([]models.ChatSnapshot, error) {
+	query := `SELECT ` + chatSnapshotColumns + ` FROM chat_snapshots WHERE conversation_id = ? 
+		ORDER BY captured_at DESC, id DESC`
+
+	rows, err := db.Query(query, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []models.ChatSnapshot
+	for rows.Next() {
+		snap, err := scanChatSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, *snap)
+	}
+
+	return snapshots, rows.Err()
+}
+
+// GetChatSnapshotAt returns the snapshot of a chat that was current at the
+// given time, with its members. It returns sql.ErrNoRows when the chat had no
+// snapshot yet.
+func (db *DB) GetChatSnapshotAt(conversationID int64, at time.Time) (*models.ChatSnapshot, error) {
+	query := `SELECT ` + chatSnapshotColumns + ` FROM chat_snapshots WHERE conversation_id = ? AND captured_at <= ? 
+		ORDER BY captured_at DESC, id DESC LIMIT 1`
+
+	snap, err := scanChatSnapshot(db.QueryRow(query, conversationID, at))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.loadChatMembers(snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// GetChatSnapshotBefore returns the snapshot preceding the given one, with its
+// members
+func (db *DB) GetChatSnapshotBefore(snap *models.ChatSnapshot) (*models.ChatSnapshot, error) {
+	query := `SELECT ` + chatSnapshotColumns + ` FROM chat_snapshots WHERE conversation_id = ? 
+		AND (captured_at < ? OR (captured_at = ? AND id < ?)) ORDER BY captured_at DESC, id DESC LIMIT 1`
+
+	prev, err := scanChatSnapshot(db.QueryRow(query, snap.ConversationID, snap.CapturedAt, snap.CapturedAt, snap.ID))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.loadChatMembers(prev); err != nil {
+		return nil, err
+	}
+	return prev, nil
+}
+
+func (db *DB) loadChatMembers(snap *models.ChatSnapshot) error {
+	rows, err := db.Query(`SELECT member_id, COALESCE(username, ''), COALESCE(first_name, ''), COALESCE(last_name, ''), 
+		role, COALESCE(rank, ''), COALESCE(admin_rights, ''), COALESCE(inviter_id, 0), joined_at 
+		FROM chat_members WHERE snapshot_id = ? ORDER BY member_id`, snap.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	snap.Members = []models.ChatMember{}
+	for rows.Next() {
+		var m models.ChatMember
+		var rights string
+		var joinedAt sql.NullTime
+		err := rows.Scan(&m.UserID, &m.Username, &m.FirstName, &m.LastName, &m.Role, &m.Rank, &rights, &m.InviterID, &joinedAt)
+		if err != nil {
+			return err
+		}
+		if err := decodeJSONColumn(rights, &m.AdminRights); err != nil {
+			return fmt.Errorf("invalid admin rights of member %d: %v", m.UserID, err)
+		}
+		if joinedAt.Valid {
+			m.JoinedAt = &joinedAt.Time
+		}
+		snap.Members = append(snap.Members, m)
+	}
+
+	return rows.Err()
+}
+
+// GetChatSnapshotCheckedAt returns when the latest snapshot of a chat was
+// last confirmed, the zero time if there is none
+func (db *DB) GetChatSnapshotCheckedAt(userID, conversationID int64) (time.Time, error) {
+	var checkedAt time.Time
+	err := db.QueryRow(`SELECT checked_at FROM chat_snapshots WHERE user_id = ? AND conversation_id = ? 
+		ORDER BY captured_at DESC, id DESC LIMIT 1`, userID, conversationID).Scan(&checkedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return checkedAt, err
+}
+
+// SaveContacts replaces the stored contact book of an account
+func (db *DB) SaveContacts(userID int64, contacts []models.AccountContact) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM contacts WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, contact := range contacts {
+		_, err := tx.Exec(`INSERT INTO contacts (user_id, contact_id, phone, first_name, last_name, username, mutual, updated_at) 
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, contact.ContactID, contact.Phone, contact.FirstName, contact.LastName, contact.Username,
+			contact.Mutual, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to save contact %d: %v", contact.ContactID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetContacts returns the contact book of an account
+func (db *DB) GetContacts(userID int64) ([]models.AccountContact, error) {
+	rows, err := db.Query(`SELECT user_id, contact_id, COALESCE(phone, ''), COALESCE(first_name, ''), 
+		COALESCE(last_name, ''), COALESCE(username, ''), mutual, updated_at 
+		FROM contacts WHERE user_id = ? ORDER BY first_name, last_name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []models.AccountContact
+	for rows.Next() {
+		var c models.AccountContact
+		err := rows.Scan(&c.UserID, &c.ContactID, &c.Phone, &c.FirstName, &c.LastName, &c.Username, &c.Mutual, &c.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, c)
+	}
+
+	return contacts, rows.Err()
+}
+
+// SavePeers records users seen by an account. A history entry is added when a
+// user is new or its name, username, photo or deleted state changed. The bio
+// is kept, it is only updated by SavePeerBio.
+func (db *DB) SavePeers(userID int64, peers []models.Peer) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, p := range peers {
+		var current models.PeerProfile
+		err := tx.QueryRow(`SELECT COALESCE(username, ''), COALESCE(first_name, ''), COALESCE(last_name, ''), 
+			COALESCE(bio, ''), COALESCE(photo_id, 0), is_deleted FROM peers WHERE user_id = ? AND peer_id = ?`,
+			userID, p.PeerID).Scan(&current.Username, &current.FirstName, &current.LastName, &current.Bio,
+			&current.PhotoID, &current.IsDeleted)
+
+		switch {
+		case err == sql.ErrNoRows:
+			_, err = tx.Exec(`INSERT INTO peers 
+				(user_id, peer_id, username, first_name, last_name, phone, photo_id, access_hash, is_bot, is_deleted, first_seen, last_seen) 
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				userID, p.PeerID, p.Username, p.FirstName, p.LastName, p.Phone, nullInt(p.PhotoID), p.AccessHash, p.IsBot, p.IsDeleted, now, now)
+		case err != nil:
+			return err
+		case current.Username == p.Username && current.FirstName == p.FirstName && current.LastName == p.LastName &&
+			current.PhotoID == p.PhotoID && current.IsDeleted == p.IsDeleted:
+			_, err = tx.Exec(`UPDATE peers SET last_seen = ?, phone = COALESCE(NULLIF(?, ''), phone), 
+				access_hash = COALESCE(NULLIF(?, ''), access_hash) WHERE user_id = ? AND peer_id = ?`,
+				now, p.Phone, p.AccessHash, userID, p.PeerID)
+			if err != nil {
+				return err
+			}
+			continue
+		default:
+			p.Bio = current.Bio
+			_, err = tx.Exec(`UPDATE peers SET username = ?, first_name = ?, last_name = ?, 
+				phone = COALESCE(NULLIF(?, ''), phone), photo_id = ?, access_hash = COALESCE(NULLIF(?, ''), access_hash), 
+				is_bot = ?, is_deleted = ?, last_seen = ? WHERE user_id = ? AND peer_id = ?`,
+				p.Username, p.FirstName, p.LastName, p.Phone, nullInt(p.PhotoID), p.AccessHash, p.IsBot, p.IsDeleted, now, userID, p.PeerID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save peer %d: %v", p.PeerID, err)
+		}
+
+		if err := insertPeerHistory(tx, userID, &p, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SavePeerBio records the bio of a user, adding a history entry when it
+// changed
+func (db *DB) SavePeerBio(userID, peerID int64, bio string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var p models.Peer
+	err = tx.QueryRow(`SELECT peer_id, COALESCE(username, ''), COALESCE(first_name, ''), COALESCE(last_name, ''), 
+		COALESCE(bio, ''), COALESCE(photo_id, 0), is_deleted FROM peers WHERE user_id = ? AND peer_id = ?`,
+		userID, peerID).Scan(&p.PeerID, &p.Username, &p.FirstName, &p.LastName, &p.Bio, &p.PhotoID, &p.IsDeleted)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if _, err := tx.Exec(`UPDATE peers SET bio = ?, bio_checked_at = ? WHERE user_id = ? AND peer_id = ?`,
+		bio, now, userID, peerID); err != nil {
+		return err
+	}
+	if p.Bio != bio {
+		p.Bio = bio
+		if err := insertPeerHistory(tx, userID, &p, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertPeerHistory(tx *sql.Tx, userID int64, p *models.Peer, changedAt time.Time) error {
+	_, err := tx.Exec(`INSERT INTO peer_history 
+		(user_id, peer_id, username, first_name, last_name, bio, photo_id, is_deleted, changed_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, p.PeerID, p.Username, p.FirstName, p.LastName, p.Bio, nullInt(p.PhotoID), p.IsDeleted, changedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record history of peer %d: %v", p.PeerID, err)
+	}
+	return nil
+}
+
+// GetPeersWithStaleBio returns the users the account has a private chat with
+// whose bio was not fetched since the given time, least recently checked
+// first
+func (db *DB) GetPeersWithStaleBio(userID int64, checkedBefore time.Time, limit int) ([]int64, error) {
+	rows, err := db.Query(`SELECT p.peer_id FROM peers p 
+		JOIN conversations c ON c.user_id = p.user_id AND c.id = p.peer_id AND c.type IN ('user', 'bot') 
+		WHERE p.user_id = ? AND NOT p.is_deleted AND (p.bio_checked_at IS NULL OR p.bio_checked_at < ?) 
+		ORDER BY p.bio_checked_at IS NOT NULL, p.bio_checked_at LIMIT ?`, userID, checkedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+const peerColumns = `user_id, peer_id, COALESCE(username, ''), COALESCE(first_name, ''), COALESCE(last_name, ''), 
+	COALESCE(phone, ''), COALESCE(bio, ''), COALESCE(photo_id, 0), COALESCE(access_hash, ''), is_bot, is_deleted, first_seen, last_seen, 
+	COALESCE((SELECT TRIM(COALESCE(h.first_name, '') || ' ' || COALESCE(h.last_name, '')) FROM peer_history h 
+		WHERE h.user_id = peers.user_id AND h.peer_id = peers.peer_id AND NOT h.is_deleted 
+		AND TRIM(COALESCE(h.first_name, '') || COALESCE(h.last_name, '')) != '' 
+		ORDER BY h.changed_at DESC, h.id DESC LIMIT 1), '')`
+
+func scanPeer(row scanner) (*models.Peer, error) {
+	var p models.Peer
+	err := row.Scan(&p.UserID, &p.PeerID, &p.Username, &p.FirstName, &p.LastName, &p.Phone, &p.Bio, &p.PhotoID,
+		&p.AccessHash, &p.IsBot, &p.IsDeleted, &p.FirstSeen, &p.LastSeen, &p.KnownAs)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetPeers lists the users seen by an account. A non-empty query matches
+// current and former names and usernames.
+func (db *DB) GetPeers(userID int64, query string, limit, offset int) ([]models.Peer, error) {
+	sqlQuery := `SELECT ` + peerColumns + ` FROM peers WHERE user_id = ?`
+	args := []interface{}{userID}
+	if query != "" {
+		pattern := "%" + query + "%"
+		sqlQuery += ` AND EXISTS (SELECT 1 FROM peer_history h WHERE h.user_id = peers.user_id AND h.peer_id = peers.peer_id 
+			AND (h.username LIKE ? OR h.first_name || ' ' || h.last_name LIKE ?))`
+		args = append(args, pattern, pattern)
+	}
+	sqlQuery += ` ORDER BY last_seen DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var peers []models.Peer
+	for rows.Next() {
+		p, err := scanPeer(rows)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, *p)
+	}
+
+	return peers, rows.Err()
+}
+
+// GetPeer returns a user seen by an account with its profile history, oldest
+// first
+func (db *DB) GetPeer(userID, peerID int64) (*models.Peer, error) {
+	p, err := scanPeer(db.QueryRow(`SELECT `+peerColumns+` FROM peers WHERE user_id = ? AND peer_id = ?`, userID, peerID))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT COALESCE(username, ''), COALESCE(first_name, ''), COALESCE(last_name, ''), 
+		COALESCE(bio, ''), COALESCE(photo_id, 0), is_deleted, changed_at 
+		FROM peer_history WHERE user_id = ? AND peer_id = ? ORDER BY changed_at ASC, id ASC`, userID, peerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h models.PeerProfile
+		if err := rows.Scan(&h.Username, &h.FirstName, &h.LastName, &h.Bio, &h.PhotoID, &h.IsDeleted, &h.ChangedAt); err != nil {
+			return nil, err
+		}
+		p.History = append(p.History, h)
+	}
+
+	return p, rows.Err()
+}
+
+// GetContactPeers returns the profiles of the users in the account's contact
+// book
+func (db *DB) GetContactPeers(userID int64) ([]models.Peer, error) {
+	rows, err := db.Query(`SELECT `+peerColumns+` FROM peers 
+		WHERE user_id = ? AND peer_id IN (SELECT contact_id FROM contacts WHERE user_id = ?)`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var peers []models.Peer
+	for rows.Next() {
+		p, err := scanPeer(rows)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, *p)
+	}
+
+	return peers, rows.Err()
+}
+
+// SaveAvatar records a downloaded profile photo. Conversations whose current
+// photo it is show it as their avatar from now on.
+func (db *DB) SaveAvatar(avatar *models.Avatar) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var date interface{}
+	if avatar.Date != nil {
+		date = *avatar.Date
+	}
+	_, err = tx.Exec(`INSERT INTO avatars (user_id, owner_id, photo_id, media_url, date, is_current, fetched_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?) 
+		ON CONFLICT(user_id, owner_id, photo_id) DO UPDATE SET media_url = excluded.media_url, 
+		date = COALESCE(excluded.date, avatars.date), fetched_at = excluded.fetched_at`,
+		avatar.UserID, avatar.OwnerID, avatar.PhotoID, avatar.MediaURL, date, avatar.IsCurrent, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save avatar %d of %d: %v", avatar.PhotoID, avatar.OwnerID, err)
+	}
+
+	_, err = tx.Exec(`UPDATE conversations SET avatar_url = ? WHERE user_id = ? AND id = ? AND photo_id = ?`,
+		avatar.MediaURL, avatar.UserID, avatar.OwnerID, avatar.PhotoID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetCurrentAvatar marks photoID as the photo the owner has now, 0 when the
+// owner has no photo
+func (db *DB) SetCurrentAvatar(userID, ownerID, photoID int64) error {
+	_, err := db.Exec(`UPDATE avatars SET is_current = (photo_id = ?) WHERE user_id = ? AND owner_id = ?`,
+		photoID, userID, ownerID)
+	return err
+}
+
+// GetAvatarPhotoIDs returns the IDs of all photos downloaded for the account
+func (db *DB) GetAvatarPhotoIDs(userID int64) (map[int64]bool, error) {
+	rows, err := db.Query(`SELECT photo_id FROM avatars WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+
+	return ids, rows.Err()
+}
+
+// GetAvatars returns the stored profile photos of a user or chat, the current
+// one first, then newest first
+func (db *DB) GetAvatars(userID, ownerID int64) ([]models.Avatar, error) {
+	rows, err := db.Query(`SELECT user_id, owner_id, photo_id, media_url, date, is_current, fetched_at 
+		FROM avatars WHERE user_id = ? AND owner_id = ? 
+		ORDER BY is_current DESC, COALESCE(date, fetched_at) DESC`, userID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var avatars []models.Avatar
+	for rows.Next() {
+		var a models.Avatar
+		var date sql.NullTime
+		if err := rows.Scan(&a.UserID, &a.OwnerID, &a.PhotoID, &a.MediaURL, &date, &a.IsCurrent, &a.FetchedAt); err != nil {
+			return nil, err
+		}
+		if date.Valid {
+			a.Date = &date.Time
+		}
+		avatars = append(avatars, a)
+	}
+
+	return avatars, rows.Err()
+}
+
+func (db *DB) SaveAuthSession(session *models.AuthSession) error {
+	query := `INSERT OR REPLACE INTO auth_sessions (user_id, phone_code, is_active, session_data, app_id, app_hash, phone, updated_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := db.Exec(query, session.UserID, session.PhoneCode, session.IsActive, session.SessionData, 
+		session.AppID, session.AppHash, session.Phone, time.Now())
+	return err
+}
+
+func (db *DB) GetActiveAuthSession() (*models.AuthSession, error) {
+	query := `SELECT id, COALESCE(user_id, 0), COALESCE(phone_code, ''), is_active, COALESCE(session_data, ''), 
+		COALESCE(app_id, 0), COALESCE(app_hash, ''), COALESCE(phone, ''), created_at, updated_at 
+		FROM auth_sessions WHERE is_active = 1 ORDER BY created_at DESC LIMIT 1`
+
+	var session models.AuthSession
+	err := db.QueryRow(query).Scan(&session.ID, &session.UserID, &session.PhoneCode, &session.IsActive, 
+		&session.SessionData, &session.AppID, &session.AppHash, &session.Phone,
+		&session.CreatedAt, &session.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
 
 func (db *DB) GetActiveAuthSessionByUserID(userID int64) (*models.AuthSession, error) {
 	query := `SELECT id, user_id, COALESCE(phone_code, ''), is_active, COALESCE(session_data, ''), 
@@ -372,4 +2489,122 @@ func (db *DB) GetUpdatesState(userID int64) (pts, qts, date, seq int, err error)
 	}
 	
 	return pts, qts, date, seq, err
-}
\ No newline at end of file
+}
+// DeactivateUser marks the user and all of their auth sessions inactive
+// while keeping the archived conversations and messages
+func (db *DB) DeactivateUser(userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`UPDATE auth_sessions SET is_active = 0, updated_at = ? WHERE user_id = ?`, now, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET is_active = 0, updated_at = ? WHERE id = ?`, now, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUser removes a user and their auth sessions. When purge is set, the
+// user's conversations, messages and updates state are deleted as well.
+func (db *DB) DeleteUser(userID int64, purge bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		`DELETE FROM auth_sessions WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+	}
+	if purge {
+		queries = append([]string{
+			`DELETE FROM messages WHERE user_id = ?`,
+			`DELETE FROM forum_topics WHERE user_id = ?`,
+			`DELETE FROM message_stats WHERE user_id = ?`,
+			`DELETE FROM comment_threads WHERE user_id = ?`,
+			`DELETE FROM scheduled_messages WHERE user_id = ?`,
+			`DELETE FROM chat_members WHERE snapshot_id IN (SELECT id FROM chat_snapshots WHERE user_id = ?)`,
+			`DELETE FROM chat_snapshots WHERE user_id = ?`,
+			`DELETE FROM contacts WHERE user_id = ?`,
+			`DELETE FROM peers WHERE user_id = ?`,
+			`DELETE FROM peer_history WHERE user_id = ?`,
+			`DELETE FROM avatars WHERE user_id = ?`,
+			`DELETE FROM conversations WHERE user_id = ?`,
+			`DELETE FROM updates_state WHERE user_id = ?`,
+			`DELETE FROM sync_cursors WHERE user_id = ?`,
+			`DELETE FROM sync_policies WHERE user_id = ?`,
+			`DELETE FROM sync_schedule WHERE user_id = ?`,
+		}, queries...)
+	}
+
+	for _, query := range queries {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return fmt.Errorf("failed to delete user %d: %v", userID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// TouchAuthSession records a successful health check of the user's active session
+func (db *DB) TouchAuthSession(userID int64) error {
+	query := `UPDATE auth_sessions SET last_checked_at = ? WHERE user_id = ? AND is_active = 1`
+
+	_, err := db.Exec(query, time.Now(), userID)
+	return err
+}
+
+// MarkAuthSessionRevoked records when and why the user's session died and
+// marks the session and the user inactive
+func (db *DB) MarkAuthSessionRevoked(userID int64, reason string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`UPDATE auth_sessions SET is_active = 0, revoked_at = ?, revoke_reason = ?, 
+		last_checked_at = ?, updated_at = ? WHERE user_id = ? AND is_active = 1`,
+		now, reason, now, now, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET is_active = 0, updated_at = ? WHERE id = ?`, now, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetLatestAuthSessionByUserID returns the user's most recent session, active or not
+func (db *DB) GetLatestAuthSessionByUserID(userID int64) (*models.AuthSession, error) {
+	query := `SELECT id, user_id, COALESCE(phone_code, ''), is_active, COALESCE(session_data, ''), 
+		COALESCE(app_id, 0), COALESCE(app_hash, ''), COALESCE(phone, ''), last_checked_at, revoked_at, 
+		COALESCE(revoke_reason, ''), created_at, updated_at 
+		FROM auth_sessions WHERE user_id = ? ORDER BY created_at DESC, id DESC LIMIT 1`
+
+	var session models.AuthSession
+	var lastCheckedAt, revokedAt sql.NullTime
+	err := db.QueryRow(query, userID).Scan(&session.ID, &session.UserID, &session.PhoneCode, &session.IsActive, 
+		&session.SessionData, &session.AppID, &session.AppHash, &session.Phone, &lastCheckedAt, &revokedAt, 
+		&session.RevokeReason, &session.CreatedAt, &session.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastCheckedAt.Valid {
+		session.LastCheckedAt = lastCheckedAt.Time
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = revokedAt.Time
+	}
+
+	return &session, nil
+}
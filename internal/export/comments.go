@@ -0,0 +1,54 @@
+package export
+
+import (
+	"fmt"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/models"
+)
+
+// commentLoader returns the comments of a channel post together with the
+// discussion group they were written in
+type commentLoader func(postID int) ([]models.Message, *models.Conversation, error)
+
+// newCommentLoader returns the comment loader of conv. Conversations other
+// than channels with backed up comments get a loader that returns nothing.
+func newCommentLoader(db *database.DB, conv *models.Conversation) (commentLoader, error) {
+	none := func(int) ([]models.Message, *models.Conversation, error) { return nil, nil, nil }
+	if conv.Type != "channel" {
+		return none, nil
+	}
+
+	threads, err := db.GetCommentThreads(conv.UserID, conv.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comment threads of channel %d: %v", conv.ID, err)
+	}
+	if len(threads) == 0 {
+		return none, nil
+	}
+
+	groups := map[int64]*models.Conversation{}
+	return func(postID int) ([]models.Message, *models.Conversation, error) {
+		thread, ok := threads[postID]
+		if !ok || thread.RootMessageID == 0 {
+			return nil, nil, nil
+		}
+
+		group, ok := groups[thread.DiscussionID]
+		if !ok {
+			var err error
+			group, err = db.GetConversation(conv.UserID, thread.DiscussionID)
+			if err != nil {
+				// 讨论组未保存时按普通群组处理
+				group = &models.Conversation{ID: thread.DiscussionID, UserID: conv.UserID, Type: "group"}
+			}
+			groups[thread.DiscussionID] = group
+		}
+
+		comments, err := db.GetPostComments(conv.UserID, conv.ID, postID, -1) // -1: 不限制数量
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get comments of post %d: %v", postID, err)
+		}
+		return comments, group, nil
+	}, nil
+}
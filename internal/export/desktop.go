@@ -0,0 +1,578 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/media"
+	"tgbackup/internal/models"
+	"tgbackup/internal/richtext"
+)
+
+const (
+	desktopDateLayout = "2006-01-02T15:04:05"
+	fileNotIncluded   = "(File not included. Change data exporting settings to download.)"
+)
+
+// Desktop writes result.json in the schema of Telegram Desktop's
+// "Export chat history", so archives open in tools made for those exports
+type Desktop struct {
+	db    *database.DB
+	media *media.Store
+}
+
+func NewDesktop(db *database.DB, store *media.Store) *Desktop {
+	return &Desktop{db: db, media: store}
+}
+
+type desktopPersonalInfo struct {
+	UserID      int64  `json:"user_id"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	PhoneNumber string `json:"phone_number"`
+	Username    string `json:"username"`
+	Bio         string `json:"bio"`
+}
+
+type desktopProfilePicture struct {
+	Date         string `json:"date"`
+	DateUnixtime string `json:"date_unixtime"`
+	Photo        string `json:"photo"`
+}
+
+type desktopTextEntity struct {
+	Type       string `json:"type"`
+	Text       string `json:"text"`
+	Href       string `json:"href,omitempty"`
+	UserID     int64  `json:"user_id,omitempty"`
+	Language   string `json:"language,omitempty"`
+	DocumentID string `json:"document_id,omitempty"`
+}
+
+type desktopMessage struct {
+	ID            int                 `json:"id"`
+	Type          string              `json:"type"`
+	Date          string              `json:"date"`
+	DateUnixtime  string              `json:"date_unixtime"`
+	From          string              `json:"from,omitempty"`
+	FromID        string              `json:"from_id,omitempty"`
+	Author        string              `json:"author,omitempty"`
+	ForwardedFrom string              `json:"forwarded_from,omitempty"`
+	ReplyTo       int                 `json:"reply_to_message_id,omitempty"`
+	Actor         string              `json:"actor,omitempty"`
+	ActorID       string              `json:"actor_id,omitempty"`
+	Action        string              `json:"action,omitempty"`
+	Title         string              `json:"title,omitempty"`
+	Members       []string            `json:"members,omitempty"`
+	MessageID     int                 `json:"message_id,omitempty"`
+	Duration      int                 `json:"duration_seconds,omitempty"`
+	Reason        string              `json:"discard_reason,omitempty"`
+	Video         bool                `json:"is_video,omitempty"`
+	Period        int                 `json:"period,omitempty"`
+	Score         int                 `json:"score,omitempty"`
+	Photo         string              `json:"photo,omitempty"`
+	File          string              `json:"file,omitempty"`
+	MediaType     string              `json:"media_type,omitempty"`
+	Poll          *desktopPoll        `json:"poll,omitempty"`
+	Contact       *desktopContact     `json:"contact_information,omitempty"`
+	Location      *desktopLocation    `json:"location_information,omitempty"`
+	PlaceName     string              `json:"place_name,omitempty"`
+	Address       string              `json:"address,omitempty"`
+	LivePeriod    int                 `json:"live_location_period_seconds,omitempty"`
+	Reactions     []desktopReaction   `json:"reactions,omitempty"`
+	Text          interface{}         `json:"text"`
+	TextEntities  []desktopTextEntity `json:"text_entities"`
+	Comments      []desktopMessage    `json:"comments,omitempty"` // 频道消息的评论, 非官方格式
+}
+
+type desktopPoll struct {
+	Question    string              `json:"question"`
+	Closed      bool                `json:"closed"`
+	TotalVoters int                 `json:"total_voters"`
+	Answers     []desktopPollAnswer `json:"answers"`
+}
+
+type desktopPollAnswer struct {
+	Text   string `json:"text"`
+	Voters int    `json:"voters"`
+	Chosen bool   `json:"chosen"`
+}
+
+type desktopContact struct {
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	PhoneNumber string `json:"phone_number"`
+}
+
+type desktopReaction struct {
+	Type       string `json:"type"`
+	Count      int    `json:"count"`
+	Emoji      string `json:"emoji,omitempty"`
+	DocumentID string `json:"document_id,omitempty"`
+}
+
+type desktopLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// exportFile is a media file to copy from the store into the export
+type exportFile struct {
+	src, dst string
+}
+
+// ExportAccount writes all conversations of a user as a full account export
+func (e *Desktop) ExportAccount(userID int64, sink Sink) error {
+	user, err := e.db.GetUserByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user %d: %v", userID, err)
+	}
+
+	conversations, err := e.db.GetConversationsByUserID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get conversations for user %d: %v", userID, err)
+	}
+
+	w, err := sink.Create("result.json")
+	if err != nil {
+		return err
+	}
+
+	username := ""
+	if user.Username != "" {
+		username = "@" + user.Username
+	}
+	info, err := json.MarshalIndent(desktopPersonalInfo{
+		UserID:      user.ID,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		PhoneNumber: user.Phone,
+		Username:    username,
+	}, " ", " ")
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	var files []exportFile
+	pictures, err := e.profilePictures(userID, &files)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	picturesJSON, err := json.MarshalIndent(pictures, " ", " ")
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := e.writeAccount(w, info, picturesJSON, conversations, &files); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return e.copyFiles(sink, files)
+}
+
+// writeAccount streams the account object of result.json around its chats
+func (e *Desktop) writeAccount(w io.Writer, info, pictures []byte, conversations []models.Conversation, files *[]exportFile) error {
+	_, err := fmt.Fprintf(w, "{\n \"about\": %s,\n \"personal_information\": %s,\n \"profile_pictures\": %s,\n \"chats\": {\n  \"about\": %s,\n  \"list\": [",
+		jsonString("Here is the data you requested. Exported by tgBackup."), info, pictures,
+		jsonString("This page lists all chats from this export."))
+	if err != nil {
+		return err
+	}
+
+	for i := range conversations {
+		sep := "\n   "
+		if i > 0 {
+			sep = "," + sep
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		dir := fmt.Sprintf("chats/chat_%03d/", i+1)
+		if err := e.writeChat(w, &conversations[i], dir, "   ", files); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "\n  ]\n }\n}\n")
+	return err
+}
+
+// profilePictures lists the stored profile photos of the account, newest
+// first, and records their files
+func (e *Desktop) profilePictures(userID int64, files *[]exportFile) ([]desktopProfilePicture, error) {
+	avatars, err := e.db.GetAvatars(userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile photos of user %d: %v", userID, err)
+	}
+
+	pictures := []desktopProfilePicture{}
+	for i, avatar := range avatars {
+		src, ok := e.media.Path(avatar.MediaURL)
+		if !ok {
+			continue
+		}
+		date := avatar.FetchedAt
+		if avatar.Date != nil {
+			date = *avatar.Date
+		}
+		date = date.Local()
+
+		photo := fmt.Sprintf("profile_pictures/photo_%d@%s%s", i+1, date.Format("02-01-2006_15-04-05"), path.Ext(src))
+		*files = append(*files, exportFile{src: src, dst: photo})
+		pictures = append(pictures, desktopProfilePicture{
+			Date:         date.Format(desktopDateLayout),
+			DateUnixtime: fmt.Sprintf("%d", date.Unix()),
+			Photo:        photo,
+		})
+	}
+	return pictures, nil
+}
+
+// ExportConversation writes a single conversation as a chat export
+func (e *Desktop) ExportConversation(userID, conversationID int64, sink Sink) error {
+	conv, err := e.db.GetConversation(userID, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to get conversation %d: %v", conversationID, err)
+	}
+
+	w, err := sink.Create("result.json")
+	if err != nil {
+		return err
+	}
+
+	var files []exportFile
+	if err := e.writeChat(w, conv, "", "", &files); err != nil {
+		w.Close()
+		return err
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return e.copyFiles(sink, files)
+}
+
+// writeChat streams one chat object with its messages. Media files are
+// recorded in files under dir and copied once result.json is complete.
+func (e *Desktop) writeChat(w io.Writer, conv *models.Conversation, dir, indent string, files *[]exportFile) error {
+	_, err := fmt.Fprintf(w, "{\n%s \"name\": %s,\n%s \"type\": %s,\n%s \"id\": %d,\n%s \"messages\": [",
+		indent, jsonString(conv.Title), indent, jsonString(desktopChatType(conv)), indent, conv.ID, indent)
+	if err != nil {
+		return err
+	}
+
+	comments, err := newCommentLoader(e.db, conv)
+	if err != nil {
+		return err
+	}
+
+	first := true
+	err = e.db.ForEachMessage(conv.UserID, conv.ID, func(msg *models.Message) error {
+		out := e.desktopMessage(msg, conv, dir, files)
+		postComments, discussion, err := comments(msg.MessageID)
+		if err != nil {
+			return err
+		}
+		ids := map[int]bool{}
+		for i := range postComments {
+			ids[postComments[i].MessageID] = true
+		}
+		for i := range postComments {
+			comment := e.desktopMessage(&postComments[i], discussion, dir, files)
+			if !ids[comment.ReplyTo] {
+				comment.ReplyTo = 0 // 直接评论回复的是讨论组中的帖子副本
+			}
+			out.Comments = append(out.Comments, comment)
+		}
+
+		data, err := json.MarshalIndent(out, indent+"  ", " ")
+		if err != nil {
+			return err
+		}
+		sep := ","
+		if first {
+			sep = ""
+		}
+		first = false
+		_, err = fmt.Fprintf(w, "%s\n%s  %s", sep, indent, data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export messages of conversation %d: %v", conv.ID, err)
+	}
+
+	_, err = fmt.Fprintf(w, "\n%s ]\n%s}", indent, indent)
+	return err
+}
+
+func (e *Desktop) desktopMessage(msg *models.Message, conv *models.Conversation, dir string, files *[]exportFile) desktopMessage {
+	if msg.Action != nil {
+		return desktopServiceMessage(msg, conv)
+	}
+
+	text := msg.Text()
+	out := desktopMessage{
+		ID:           msg.MessageID,
+		Type:         "message",
+		Date:         msg.Timestamp.Local().Format(desktopDateLayout),
+		DateUnixtime: fmt.Sprintf("%d", msg.Timestamp.Unix()),
+		From:         senderName(msg, conv),
+	}
+	out.Text, out.TextEntities = desktopText(text, msg.Entities)
+	out.FromID = desktopPeerID(msg, conv)
+	out.Author = msg.PostAuthor
+	out.ForwardedFrom = msg.FwdFromName
+	if msg.ReplyToPeerID == 0 {
+		out.ReplyTo = msg.ReplyToMsgID
+	}
+
+	if msg.MediaData != nil {
+		setDesktopMediaData(&out, msg.MediaData)
+	}
+	out.Reactions = desktopReactions(msg.Reactions)
+
+	folder, mediaType := desktopMediaKind(msg.MessageType)
+	if folder == "" {
+		return out
+	}
+
+	filePath := fileNotIncluded
+	if src, ok := e.media.Path(msg.MediaURL); ok {
+		filePath = dir + folder + "/" + path.Base(strings.ReplaceAll(src, "\\", "/"))
+		*files = append(*files, exportFile{src: src, dst: filePath})
+	}
+
+	if msg.MessageType == "photo" {
+		out.Photo = filePath
+	} else {
+		out.File = filePath
+		out.MediaType = mediaType
+	}
+
+	return out
+}
+
+// desktopReactions lists reactions the way Telegram Desktop does, custom
+// emoji are identified by their document ID
+func desktopReactions(reactions []models.Reaction) []desktopReaction {
+	var out []desktopReaction
+	for _, r := range reactions {
+		if r.Emoji != "" {
+			out = append(out, desktopReaction{Type: "emoji", Count: r.Count, Emoji: r.Emoji})
+		} else {
+			out = append(out, desktopReaction{Type: "custom_emoji", Count: r.Count, DocumentID: fmt.Sprintf("%d", r.DocumentID)})
+		}
+	}
+	return out
+}
+
+// setDesktopMediaData writes polls, contacts and locations the way Telegram
+// Desktop does. Web page previews are not part of its format.
+func setDesktopMediaData(out *desktopMessage, data *models.MediaData) {
+	if poll := data.Poll; poll != nil {
+		out.Poll = &desktopPoll{
+			Question:    poll.Question,
+			Closed:      poll.Closed,
+			TotalVoters: poll.TotalVoters,
+			Answers:     []desktopPollAnswer{},
+		}
+		for _, answer := range poll.Answers {
+			out.Poll.Answers = append(out.Poll.Answers, desktopPollAnswer{
+				Text:   answer.Text,
+				Voters: answer.Voters,
+				Chosen: answer.Chosen,
+			})
+		}
+	}
+
+	if contact := data.Contact; contact != nil {
+		out.Contact = &desktopContact{
+			FirstName:   contact.FirstName,
+			LastName:    contact.LastName,
+			PhoneNumber: contact.PhoneNumber,
+		}
+	}
+
+	if location := data.Location; location != nil {
+		out.Location = &desktopLocation{Latitude: location.Latitude, Longitude: location.Longitude}
+		out.PlaceName = location.Title
+		out.Address = location.Address
+		if location.Live {
+			out.LivePeriod = location.Period
+		}
+	}
+}
+
+// desktopServiceMessage writes a service message with its action fields.
+// Actions without a counterpart in Telegram Desktop keep the readable
+// description as text.
+func desktopServiceMessage(msg *models.Message, conv *models.Conversation) desktopMessage {
+	action := msg.Action
+	out := desktopMessage{
+		ID:           msg.MessageID,
+		Type:         "service",
+		Date:         msg.Timestamp.Local().Format(desktopDateLayout),
+		DateUnixtime: fmt.Sprintf("%d", msg.Timestamp.Unix()),
+		Actor:        senderName(msg, conv),
+		ActorID:      desktopPeerID(msg, conv),
+		Action:       action.Type,
+		Title:        action.Title,
+		Members:      action.Members,
+		MessageID:    action.MessageID,
+		Duration:     action.Duration,
+		Reason:       action.Reason,
+		Video:        action.Video,
+		Period:       action.Period,
+		Score:        action.Score,
+		Text:         "",
+		TextEntities: []desktopTextEntity{},
+	}
+
+	if action.Type == "unknown" || action.Type == "custom_action" {
+		out.Text, out.TextEntities = desktopText(msg.Content, nil)
+	}
+
+	return out
+}
+
+func desktopPeerID(msg *models.Message, conv *models.Conversation) string {
+	switch {
+	case isChatSender(msg, conv):
+		return fmt.Sprintf("channel%d", conv.ID)
+	case msg.SenderType == "channel" || msg.SenderType == "chat":
+		return fmt.Sprintf("%s%d", msg.SenderType, msg.FromID)
+	default:
+		return fmt.Sprintf("user%d", msg.FromID)
+	}
+}
+
+// desktopText returns the text field and text_entities of a message. Text is
+// a plain string without formatting, otherwise a list mixing plain strings
+// and entity objects, as Telegram Desktop writes it.
+func desktopText(text string, entities []models.MessageEntity) (interface{}, []desktopTextEntity) {
+	parts := []interface{}{}
+	textEntities := []desktopTextEntity{}
+	formatted := false
+
+	for _, seg := range richtext.Segments(text, entities) {
+		entity := desktopTextEntity{Type: "plain", Text: seg.Text}
+		if e := seg.Entity; e != nil {
+			formatted = true
+			entity.Type = e.Type
+			entity.Href = e.URL
+			entity.UserID = e.UserID
+			entity.Language = e.Language
+			if e.DocumentID != 0 {
+				entity.DocumentID = fmt.Sprintf("%d", e.DocumentID)
+			}
+			parts = append(parts, entity)
+		} else {
+			parts = append(parts, seg.Text)
+		}
+		textEntities = append(textEntities, entity)
+	}
+
+	if !formatted {
+		return text, textEntities
+	}
+	return parts, textEntities
+}
+
+func (e *Desktop) copyFiles(sink Sink, files []exportFile) error {
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if seen[f.dst] {
+			continue
+		}
+		seen[f.dst] = true
+
+		if err := copyFile(sink, f.src, f.dst); err != nil {
+			return fmt.Errorf("failed to export media %s: %v", f.dst, err)
+		}
+	}
+	return nil
+}
+
+func copyFile(sink Sink, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := sink.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// desktopChatType maps a conversation to the chat types used by Telegram Desktop
+func desktopChatType(conv *models.Conversation) string {
+	switch conv.Type {
+	case "bot":
+		return "bot_chat"
+	case "channel":
+		if conv.Username != "" {
+			return "public_channel"
+		}
+		return "private_channel"
+	case "group":
+		// Basic groups have no access hash, supergroups do
+		if conv.AccessHash == "" {
+			return "private_group"
+		}
+		if conv.Username != "" {
+			return "public_supergroup"
+		}
+		return "private_supergroup"
+	default:
+		return "personal_chat"
+	}
+}
+
+// desktopMediaKind returns the export folder and media_type for a message type.
+// The folder is empty for messages without a downloadable file.
+func desktopMediaKind(messageType string) (folder, mediaType string) {
+	switch messageType {
+	case "photo":
+		return "photos", ""
+	case "video":
+		return "video_files", "video_file"
+	case "gif":
+		return "video_files", "animation"
+	case "sticker":
+		return "stickers", "sticker"
+	case "audio":
+		return "files", "audio_file"
+	case "image", "document":
+		return "files", ""
+	default:
+		return "", ""
+	}
+}
+
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
@@ -0,0 +1,587 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tgbackup/internal/album"
+	"tgbackup/internal/database"
+	"tgbackup/internal/media"
+	"tgbackup/internal/models"
+	"tgbackup/internal/richtext"
+)
+
+const (
+	htmlPageSize     = 500
+	htmlManifestName = ".tgbackup-export.json"
+
+	// htmlVersion is stored in the manifest, bump it when the page layout
+	// changes so the next export rewrites every conversation
+	htmlVersion = 11
+)
+
+// HTML generates a static site of the archive that can be browsed offline
+// without the server: an index of accounts and conversations plus paginated
+// message pages, linked with relative paths only. Re-exporting into the same
+// directory only rewrites conversations whose messages changed.
+type HTML struct {
+	db    *database.DB
+	media *media.Store
+}
+
+func NewHTML(db *database.DB, store *media.Store) *HTML {
+	return &HTML{db: db, media: store}
+}
+
+// HTMLResult reports what an export wrote
+type HTMLResult struct {
+	Conversations int `json:"conversations"`
+	Written       int `json:"written"`
+	Skipped       int `json:"skipped"`
+}
+
+type htmlManifest struct {
+	Version       int                          `json:"version"`
+	Conversations map[string]htmlManifestEntry `json:"conversations"`
+}
+
+type htmlManifestEntry struct {
+	Fingerprint string `json:"fingerprint"`
+	Pages       int    `json:"pages"`
+}
+
+type htmlIndex struct {
+	Exported string
+	Accounts []htmlAccount
+}
+
+type htmlAccount struct {
+	Name          string
+	Username      string
+	Avatars       []htmlAvatar
+	Conversations []htmlConversationLink
+}
+
+type htmlConversationLink struct {
+	Title  string
+	Type   string
+	Count  int
+	Link   string
+	Avatar string
+}
+
+type htmlPage struct {
+	Title      string
+	Root       string
+	Page       int
+	Pages      int
+	Prev, Next string
+	Avatar     string       // 当前头像
+	Avatars    []htmlAvatar // 所有头像, 只在第一页显示
+	Messages   []htmlMessage
+}
+
+// htmlAvatar is a profile photo copied into the avatars folder of the site
+type htmlAvatar struct {
+	Src     string // 相对于站点根目录
+	Date    string
+	Current bool
+}
+
+type htmlMessage struct {
+	ID      int
+	From    string
+	Author  string // 频道签名或匿名管理员的头衔
+	Date    string
+	Text    template.HTML
+	Media   []htmlMedia
+	Data    *models.MediaData
+	Service bool
+
+	Forwarded string // 转发来源名称
+	ReplyTo   int
+	ReplyHref string // 被回复消息的链接, 不在备份中时为空
+
+	Views     int
+	Reactions []models.Reaction
+
+	Pinned   bool
+	Anchor   string        // 页面内锚点
+	Comments []htmlMessage // 频道消息的评论
+}
+
+type htmlMedia struct {
+	Kind  string
+	Src   string
+	Label string
+}
+
+// Export writes the site into dir. When userID is not zero only that
+// account is exported, the index keeps listing the pages other accounts got
+// from earlier exports into dir.
+func (e *HTML) Export(dir string, userID int64) (*HTMLResult, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %v", err)
+	}
+
+	manifest := readHTMLManifest(dir)
+	if manifest.Version != htmlVersion {
+		// Pages of an older layout are rewritten when their account is
+		// exported next, until then the index keeps listing them
+		conversations := make(map[string]htmlManifestEntry, len(manifest.Conversations))
+		for key, entry := range manifest.Conversations {
+			conversations[key] = htmlManifestEntry{Pages: entry.Pages}
+		}
+		manifest = &htmlManifest{Version: htmlVersion, Conversations: conversations}
+	}
+
+	users, err := e.db.GetUsers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %v", err)
+	}
+
+	result := &HTMLResult{}
+	index := htmlIndex{Exported: time.Now().Format("2006-01-02 15:04")}
+
+	for _, user := range users {
+		other := userID != 0 && user.ID != userID // 只列出之前导出过的会话
+
+		conversations, err := e.db.GetConversationsByUserID(user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get conversations for user %d: %v", user.ID, err)
+		}
+
+		account := htmlAccount{
+			Name:     strings.TrimSpace(user.FirstName + " " + user.LastName),
+			Username: user.Username,
+		}
+		if account.Name == "" {
+			account.Name = fmt.Sprintf("User %d", user.ID)
+		}
+		if account.Avatars, err = e.htmlAvatars(dir, user.ID, user.ID); err != nil {
+			return nil, err
+		}
+
+		for i := range conversations {
+			conv := &conversations[i]
+			var count int
+			if other {
+				if _, ok := manifest.Conversations[conversationDir(conv)]; !ok {
+					continue
+				}
+				if count, _, _, err = e.db.GetConversationFingerprint(conv.UserID, conv.ID); err != nil {
+					return nil, fmt.Errorf("failed to inspect conversation %d: %v", conv.ID, err)
+				}
+			} else {
+				var written bool
+				if count, written, err = e.exportConversation(dir, conv, manifest); err != nil {
+					return nil, err
+				}
+
+				result.Conversations++
+				if written {
+					result.Written++
+				} else {
+					result.Skipped++
+				}
+			}
+
+			avatars, err := e.htmlAvatars(dir, conv.UserID, conv.ID)
+			if err != nil {
+				return nil, err
+			}
+			account.Conversations = append(account.Conversations, htmlConversationLink{
+				Title:  conv.Title,
+				Type:   conv.Type,
+				Count:  count,
+				Link:   conversationDir(conv) + "/" + htmlPageName(1),
+				Avatar: currentAvatar(avatars),
+			})
+		}
+
+		if other && len(account.Conversations) == 0 {
+			continue // 从未导出过的账号
+		}
+		index.Accounts = append(index.Accounts, account)
+	}
+
+	if err := writeTemplateFile(filepath.Join(dir, "index.html"), func(w io.Writer) error {
+		return htmlIndexTemplate.Execute(w, index)
+	}); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "style.css"), []byte(htmlStyle), 0644); err != nil {
+		return nil, err
+	}
+	if err := writeHTMLManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// exportConversation writes the pages of one conversation unless the
+// manifest shows they are up to date. It returns the message count and
+// whether the pages were rewritten.
+func (e *HTML) exportConversation(dir string, conv *models.Conversation, manifest *htmlManifest) (int, bool, error) {
+	count, maxRowID, version, err := e.db.GetConversationFingerprint(conv.UserID, conv.ID)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to inspect conversation %d: %v", conv.ID, err)
+	}
+	entries, err := e.db.CountConversationEntries(conv.UserID, conv.ID)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to inspect conversation %d: %v", conv.ID, err)
+	}
+
+	comments, err := newCommentLoader(e.db, conv)
+	if err != nil {
+		return 0, false, err
+	}
+	commentCount, commentMaxRowID, commentVersion, err := e.db.GetCommentsFingerprint(conv.UserID, conv.ID)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to inspect comments of conversation %d: %v", conv.ID, err)
+	}
+
+	avatars, err := e.htmlAvatars(dir, conv.UserID, conv.ID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	key := conversationDir(conv)
+	fingerprint := fmt.Sprintf("%d:%d:%d:%s", count, maxRowID, version, conv.Title)
+	if commentCount > 0 {
+		fingerprint += fmt.Sprintf(":%d:%d:%d", commentCount, commentMaxRowID, commentVersion)
+	}
+	if len(avatars) > 0 {
+		fingerprint += fmt.Sprintf(":%d:%s", len(avatars), currentAvatar(avatars))
+	}
+	convDir := filepath.Join(dir, filepath.FromSlash(key))
+
+	if entry, ok := manifest.Conversations[key]; ok && entry.Fingerprint == fingerprint {
+		if _, err := os.Stat(filepath.Join(convDir, htmlPageName(1))); err == nil {
+			return count, false, nil
+		}
+	}
+
+	pages := (entries + htmlPageSize - 1) / htmlPageSize
+	if pages == 0 {
+		pages = 1
+	}
+
+	page := htmlPage{Title: conv.Title, Root: "../../../", Page: 1, Pages: pages}
+	for _, avatar := range avatars {
+		avatar.Src = page.Root + avatar.Src
+		page.Avatars = append(page.Avatars, avatar)
+	}
+	page.Avatar = currentAvatar(page.Avatars)
+	flush := func() error {
+		if page.Page > 1 {
+			page.Prev = htmlPageName(page.Page - 1)
+		}
+		if page.Page < pages {
+			page.Next = htmlPageName(page.Page + 1)
+		}
+		err := writeTemplateFile(filepath.Join(convDir, htmlPageName(page.Page)), func(w io.Writer) error {
+			return htmlPageTemplate.Execute(w, page)
+		})
+		page.Page++
+		page.Prev, page.Next = "", ""
+		page.Avatars = nil
+		page.Messages = page.Messages[:0]
+		return err
+	}
+
+	// Where every message written so far is shown, replies link to earlier
+	// messages. Album items are shown as part of the album's first message.
+	type anchor struct{ page, id int }
+	anchors := map[int]anchor{}
+	add := func(msg *models.Message) error {
+		out, err := e.htmlMessage(msg, conv, convDir)
+		if err != nil {
+			return err
+		}
+		if target, ok := anchors[out.ReplyTo]; ok {
+			out.ReplyHref = fmt.Sprintf("#message%d", target.id)
+			if target.page != page.Page {
+				out.ReplyHref = htmlPageName(target.page) + out.ReplyHref
+			}
+		}
+		anchors[msg.MessageID] = anchor{page.Page, msg.MessageID}
+		for _, item := range msg.Album {
+			anchors[item.MessageID] = anchor{page.Page, msg.MessageID}
+		}
+		if out.Comments, err = e.htmlComments(msg, comments, convDir); err != nil {
+			return err
+		}
+		page.Messages = append(page.Messages, out)
+		if len(page.Messages) == htmlPageSize && page.Page < pages {
+			return flush()
+		}
+		return nil
+	}
+
+	// Items of an album are collected and written as one message
+	var pending []models.Message
+	addPending := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		msg := pending[0]
+		if len(pending) > 1 {
+			msg = album.Merge(pending)
+		}
+		pending = pending[:0]
+		return add(&msg)
+	}
+
+	err = e.db.ForEachMessage(conv.UserID, conv.ID, func(msg *models.Message) error {
+		if len(pending) > 0 && (msg.GroupedID == 0 || msg.GroupedID != pending[0].GroupedID) {
+			if err := addPending(); err != nil {
+				return err
+			}
+		}
+		if msg.GroupedID != 0 {
+			pending = append(pending, *msg)
+			return nil
+		}
+		return add(msg)
+	})
+	if err == nil {
+		err = addPending()
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to export conversation %d: %v", conv.ID, err)
+	}
+	if err := flush(); err != nil {
+		return 0, false, err
+	}
+
+	// Drop pages left over from a previous export with more messages
+	if old, ok := manifest.Conversations[key]; ok {
+		for n := pages + 1; n <= old.Pages; n++ {
+			os.Remove(filepath.Join(convDir, htmlPageName(n)))
+		}
+	}
+
+	manifest.Conversations[key] = htmlManifestEntry{Fingerprint: fingerprint, Pages: pages}
+	return count, true, nil
+}
+
+// htmlComments renders the comments of a channel post. Replies between
+// comments link within the comment list.
+func (e *HTML) htmlComments(post *models.Message, load commentLoader, convDir string) ([]htmlMessage, error) {
+	postComments, discussion, err := load(post.MessageID)
+	if err != nil || len(postComments) == 0 {
+		return nil, err
+	}
+
+	ids := map[int]bool{}
+	for i := range postComments {
+		ids[postComments[i].MessageID] = true
+	}
+
+	var out []htmlMessage
+	for i := range postComments {
+		comment, err := e.htmlMessage(&postComments[i], discussion, convDir)
+		if err != nil {
+			return nil, err
+		}
+		comment.Anchor = fmt.Sprintf("comment%d-%d", post.MessageID, comment.ID)
+		if ids[comment.ReplyTo] {
+			comment.ReplyHref = fmt.Sprintf("#comment%d-%d", post.MessageID, comment.ReplyTo)
+		} else {
+			comment.ReplyTo = 0 // 直接评论回复的是讨论组中的帖子副本
+		}
+		out = append(out, comment)
+	}
+	return out, nil
+}
+
+func (e *HTML) htmlMessage(msg *models.Message, conv *models.Conversation, convDir string) (htmlMessage, error) {
+	out := htmlMessage{
+		ID:     msg.MessageID,
+		From:   senderName(msg, conv),
+		Author: msg.PostAuthor,
+		Date:   msg.Timestamp.Local().Format("2006-01-02 15:04:05"),
+		Text:   template.HTML(richtext.HTML(msg.Text(), msg.Entities)),
+
+		Forwarded: msg.FwdFromName,
+		Data:      msg.MediaData,
+		Views:     msg.Views,
+		Reactions: msg.Reactions,
+		Pinned:    msg.IsPinned,
+		Anchor:    fmt.Sprintf("message%d", msg.MessageID),
+	}
+	if msg.ReplyToPeerID == 0 {
+		out.ReplyTo = msg.ReplyToMsgID
+	}
+
+	if msg.Action != nil {
+		out.Service = true
+		out.Text = template.HTML(richtext.HTML(msg.Content, nil))
+		return out, nil
+	}
+
+	items := msg.Album
+	if len(items) == 0 {
+		items = []models.AlbumItem{{MessageID: msg.MessageID, MessageType: msg.MessageType, MediaURL: msg.MediaURL}}
+	}
+
+	for _, item := range items {
+		media, err := e.htmlMedia(&item, convDir)
+		if err != nil {
+			return out, err
+		}
+		if media != nil {
+			out.Media = append(out.Media, *media)
+		}
+	}
+
+	return out, nil
+}
+
+// htmlMedia copies the media of a message next to the pages, nil is returned
+// for messages without media
+func (e *HTML) htmlMedia(item *models.AlbumItem, convDir string) (*htmlMedia, error) {
+	kind, label := htmlMediaKind(item.MessageType)
+	if kind == "" {
+		return nil, nil
+	}
+
+	media := &htmlMedia{Kind: kind, Label: label}
+	src, ok := e.media.Path(item.MediaURL)
+	if !ok {
+		return media, nil
+	}
+
+	rel := "media/" + path.Base(filepath.ToSlash(src))
+	if err := copyIfChanged(src, filepath.Join(convDir, filepath.FromSlash(rel))); err != nil {
+		return nil, fmt.Errorf("failed to copy media of message %d: %v", item.MessageID, err)
+	}
+	media.Src = rel
+
+	return media, nil
+}
+
+// htmlAvatars copies the stored profile photos of a user or chat into the
+// avatars folder, the current one first
+func (e *HTML) htmlAvatars(dir string, userID, ownerID int64) ([]htmlAvatar, error) {
+	avatars, err := e.db.GetAvatars(userID, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get avatars of %d: %v", ownerID, err)
+	}
+
+	var out []htmlAvatar
+	for _, avatar := range avatars {
+		src, ok := e.media.Path(avatar.MediaURL)
+		if !ok {
+			continue
+		}
+		rel := "avatars/" + path.Base(filepath.ToSlash(src))
+		if err := copyIfChanged(src, filepath.Join(dir, filepath.FromSlash(rel))); err != nil {
+			return nil, fmt.Errorf("failed to copy avatar %d of %d: %v", avatar.PhotoID, ownerID, err)
+		}
+
+		item := htmlAvatar{Src: rel, Current: avatar.IsCurrent}
+		if avatar.Date != nil {
+			item.Date = avatar.Date.Local().Format("2006-01-02")
+		}
+		out = append(out, item)
+	}
+	return out, nil
+}
+
+// currentAvatar returns the current avatar of a list from htmlAvatars, empty
+// when the photo was removed
+func currentAvatar(avatars []htmlAvatar) string {
+	if len(avatars) > 0 && avatars[0].Current {
+		return avatars[0].Src
+	}
+	return ""
+}
+
+// htmlMediaKind returns how a message's media is shown and its label
+func htmlMediaKind(messageType string) (kind, label string) {
+	switch messageType {
+	case "photo":
+		return "image", "Photo"
+	case "image":
+		return "image", "Image"
+	case "sticker":
+		return "image", "Sticker"
+	case "video":
+		return "video", "Video"
+	case "gif":
+		return "animation", "GIF"
+	case "audio":
+		return "audio", "Audio"
+	case "document":
+		return "file", "Document"
+	default:
+		return "", ""
+	}
+}
+
+func conversationDir(conv *models.Conversation) string {
+	return fmt.Sprintf("chats/%d/%d", conv.UserID, conv.ID)
+}
+
+func htmlPageName(n int) string {
+	if n == 1 {
+		return "messages.html"
+	}
+	return fmt.Sprintf("messages%d.html", n)
+}
+
+func writeTemplateFile(p string, execute func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if err := execute(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// copyIfChanged copies src to dst unless dst already has the same size
+func copyIfChanged(src, dst string) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := os.Stat(dst); err == nil && dstInfo.Size() == srcInfo.Size() {
+		return nil
+	}
+
+	return copyFile(NewDirSink(filepath.Dir(dst)), src, filepath.Base(dst))
+}
+
+func readHTMLManifest(dir string) *htmlManifest {
+	manifest := &htmlManifest{}
+	data, err := os.ReadFile(filepath.Join(dir, htmlManifestName))
+	if err != nil || json.Unmarshal(data, manifest) != nil || manifest.Conversations == nil {
+		return &htmlManifest{}
+	}
+	return manifest
+}
+
+func writeHTMLManifest(dir string, manifest *htmlManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, htmlManifestName), data, 0644)
+}
@@ -0,0 +1,135 @@
+package export
+
+import (
+	"html/template"
+)
+
+const htmlStyle = `body { margin: 0; font-family: -apple-system, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif; background: #f4f4f5; color: #1f2328; }
+header { background: #2b5278; color: #fff; padding: 14px 24px; }
+header a { color: #cfe3ff; text-decoration: none; }
+main { max-width: 860px; margin: 0 auto; padding: 16px; }
+h1 { font-size: 20px; margin: 0; }
+h2 { font-size: 17px; margin: 24px 0 8px; }
+.account { background: #fff; border-radius: 8px; padding: 8px 16px 16px; margin-bottom: 16px; }
+.conversations { list-style: none; margin: 0; padding: 0; }
+.conversations li { padding: 8px 0; border-bottom: 1px solid #eee; display: flex; justify-content: space-between; }
+.conversations a { color: #2b5278; text-decoration: none; font-weight: 500; }
+.meta { color: #6b7280; font-size: 13px; }
+.message { background: #fff; border-radius: 8px; padding: 8px 12px; margin: 8px 0; }
+.message .from { font-weight: 600; color: #2b5278; }
+.message .author { color: #6b7280; font-size: 13px; margin-left: 6px; }
+.message .date { color: #6b7280; font-size: 12px; margin-left: 8px; }
+.message .text { white-space: pre-wrap; word-wrap: break-word; margin-top: 4px; }
+.message img, .message video { max-width: 100%; max-height: 480px; border-radius: 6px; margin-top: 6px; display: block; }
+.message pre { background: #f6f8fa; padding: 8px; border-radius: 6px; overflow-x: auto; white-space: pre; }
+.message code { font-family: SFMono-Regular, Consolas, monospace; font-size: 13px; }
+.message blockquote { border-left: 3px solid #2b5278; margin: 4px 0; padding-left: 8px; }
+.message .spoiler { background: #d1d5db; color: transparent; border-radius: 3px; }
+.message .spoiler:hover { color: inherit; background: none; }
+.message .pinned { color: #2b5278; font-size: 12px; margin-left: 8px; }
+.forwarded, .reply { color: #2b5278; font-size: 13px; margin-top: 2px; }
+.album { display: grid; grid-template-columns: repeat(auto-fill, minmax(160px, 1fr)); gap: 4px; }
+.album img, .album video { width: 100%; height: 100%; object-fit: cover; }
+.card { border-left: 3px solid #2b5278; background: #f6f8fa; padding: 6px 10px; margin-top: 4px; border-radius: 4px; }
+.card-title { font-weight: 600; }
+.card-note { color: #6b7280; font-size: 13px; }
+.card ul { margin: 4px 0; padding-left: 18px; }
+.card .chosen { font-weight: 600; }
+.card .count { color: #6b7280; }
+.counters { margin-top: 4px; font-size: 13px; }
+.counters .reaction { background: #e8eef5; border-radius: 10px; padding: 1px 8px; margin-right: 4px; }
+.counters .views { color: #6b7280; float: right; }
+.comments { margin-top: 6px; }
+.comments summary { color: #2b5278; font-size: 13px; cursor: pointer; }
+.comments .message { background: #f6f8fa; margin: 6px 0 0 12px; }
+.service { text-align: center; color: #6b7280; font-size: 13px; margin: 8px 0; }
+.missing { color: #9ca3af; font-style: italic; }
+.pages { text-align: center; margin: 16px 0; }
+.avatar { width: 40px; height: 40px; border-radius: 50%; object-fit: cover; vertical-align: middle; margin-right: 8px; }
+header .avatar { float: left; margin-top: 2px; }
+.conversations .avatar { width: 28px; height: 28px; }
+.avatars { margin: 8px 0; }
+.avatars summary { color: #2b5278; font-size: 13px; cursor: pointer; }
+.avatars figure { display: inline-block; margin: 6px 6px 0 0; text-align: center; }
+.avatars figure img { width: 96px; height: 96px; border-radius: 8px; object-fit: cover; display: block; }
+.avatars figcaption { color: #6b7280; font-size: 12px; }
+.pages a, .pages span { margin: 0 6px; }
+`
+
+// htmlAvatarsTemplate lists the profile photos of an account or chat, it is
+// shared by the index and the message pages
+const htmlAvatarsTemplate = `{{define "avatars"}}{{if .}}<details class="avatars"><summary>{{len .}} profile photos</summary>
+{{range .}}<figure><img src="{{.Src}}" alt="">{{if .Date}}<figcaption>{{.Date}}{{if .Current}} · current{{end}}</figcaption>{{else if .Current}}<figcaption>current</figcaption>{{end}}</figure>{{end}}
+</details>{{end}}{{end}}`
+
+var htmlIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Telegram Backup</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<header><h1>Telegram Backup</h1><div class="meta">Exported {{.Exported}}</div></header>
+<main>
+{{range .Accounts}}<section class="account">
+<h2>{{.Name}}{{if .Username}} <span class="meta">@{{.Username}}</span>{{end}}</h2>
+{{template "avatars" .Avatars}}
+<ul class="conversations">
+{{range .Conversations}}<li><a href="{{.Link}}">{{with .Avatar}}<img class="avatar" src="{{.}}" alt="">{{end}}{{.Title}}</a><span class="meta">{{.Type}} · {{.Count}} messages</span></li>
+{{else}}<li class="missing">No conversations</li>
+{{end}}</ul>
+</section>
+{{end}}</main>
+</body>
+</html>
+` + htmlAvatarsTemplate))
+
+var htmlPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="{{.Root}}style.css">
+</head>
+<body>
+<header>{{with .Avatar}}<img class="avatar" src="{{.}}" alt="">{{end}}<a href="{{.Root}}index.html">&larr; All conversations</a><h1>{{.Title}}</h1><div class="meta">Page {{.Page}} of {{.Pages}}</div></header>
+<main>
+{{template "avatars" .Avatars}}
+{{template "pager" .}}
+{{range .Messages}}{{template "message" .}}{{end}}
+{{template "pager" .}}
+</main>
+</body>
+</html>
+{{define "data"}}{{with .Poll}}<div class="card"><div class="card-title">{{if .Quiz}}Quiz{{else}}Poll{{end}}: {{.Question}}</div>
+<ul>{{range .Answers}}<li{{if .Chosen}} class="chosen"{{end}}>{{.Text}} <span class="count">{{.Voters}}</span>{{if .Correct}} ✓{{end}}</li>{{end}}</ul>
+<div class="card-note">{{.TotalVoters}} votes{{if .Closed}} · final results{{end}}</div>{{with .Solution}}<div class="card-note">{{.}}</div>{{end}}</div>
+{{end}}{{with .Contact}}<div class="card"><div class="card-title">Contact: {{.FirstName}} {{.LastName}}</div><div>{{.PhoneNumber}}</div></div>
+{{end}}{{with .Location}}<div class="card"><div class="card-title">{{if .Title}}{{.Title}}{{else if .Live}}Live location{{else}}Location{{end}}</div>{{with .Address}}<div>{{.}}</div>{{end}}
+<a href="https://www.openstreetmap.org/?mlat={{.Latitude}}&amp;mlon={{.Longitude}}">{{.Latitude}}, {{.Longitude}}</a></div>
+{{end}}{{with .WebPage}}<div class="card"><div class="card-note">{{.SiteName}}</div><div class="card-title"><a href="{{.URL}}">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a></div>{{with .Description}}<div>{{.}}</div>{{end}}</div>
+{{end}}{{end}}
+{{define "message"}}{{if .Service}}<div class="service" id="{{.Anchor}}">{{.Text}} <span class="date">{{.Date}}</span></div>
+{{else}}<div class="message" id="{{.Anchor}}">
+<div><span class="from">{{.From}}</span>{{with .Author}}<span class="author">{{.}}</span>{{end}}<span class="date">{{.Date}}</span>{{if .Pinned}}<span class="pinned">Pinned</span>{{end}}</div>
+{{if .Forwarded}}<div class="forwarded">Forwarded from {{.Forwarded}}</div>{{end}}
+{{if .ReplyHref}}<div class="reply"><a href="{{.ReplyHref}}">In reply to this message</a></div>{{else if .ReplyTo}}<div class="reply">In reply to a message that is not in the backup</div>{{end}}
+{{if gt (len .Media) 1}}<div class="album">{{end}}
+{{range .Media}}{{if not .Src}}<div class="missing">{{.Label}} (file not included)</div>
+{{else if eq .Kind "image"}}<img src="{{.Src}}" alt="{{.Label}}" loading="lazy">
+{{else if eq .Kind "video"}}<video src="{{.Src}}" controls preload="metadata"></video>
+{{else if eq .Kind "animation"}}<video src="{{.Src}}" autoplay loop muted playsinline></video>
+{{else if eq .Kind "audio"}}<audio src="{{.Src}}" controls preload="none"></audio>
+{{else}}<a href="{{.Src}}">{{.Label}}</a>
+{{end}}{{end}}
+{{if gt (len .Media) 1}}</div>{{end}}
+{{if .Text}}<div class="text">{{.Text}}</div>{{end}}
+{{with .Data}}{{template "data" .}}{{end}}
+{{if or .Reactions .Views}}<div class="counters">{{range .Reactions}}<span class="reaction">{{if .Emoji}}{{.Emoji}}{{else}}&#9733;{{end}} {{.Count}}</span>{{end}}{{if .Views}}<span class="views">{{.Views}} views</span>{{end}}</div>{{end}}
+{{with .Comments}}<details class="comments"><summary>{{len .}} comments</summary>
+{{range .}}{{template "message" .}}{{end}}</details>
+{{end}}</div>
+{{end}}{{end}}
+{{define "pager"}}{{if gt .Pages 1}}<div class="pages">{{if .Prev}}<a href="{{.Prev}}">&larr; Previous</a>{{end}}<span>{{.Page}} / {{.Pages}}</span>{{if .Next}}<a href="{{.Next}}">Next &rarr;</a>{{end}}</div>{{end}}{{end}}
+` + htmlAvatarsTemplate))
@@ -0,0 +1,95 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbackup/internal/media"
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+func TestHTMLIncremental(t *testing.T) {
+	dir := t.TempDir()
+	users := []models.User{{ID: 1, FirstName: "Ann"}, {ID: 2, FirstName: "Bob"}}
+	db := telegramtest.NewDB(t, users...)
+
+	for _, user := range users {
+		conv := models.Conversation{ID: 100 + user.ID, UserID: user.ID, Type: "group", Title: user.FirstName + "'s group"}
+		if err := db.SaveConversation(&conv); err != nil {
+			t.Fatal(err)
+		}
+		err := db.SaveMessages([]models.Message{{UserID: user.ID, ConversationID: conv.ID, MessageID: 1,
+			Content: "hi", MessageType: "text", Timestamp: time.Unix(1700000000, 0)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	site := filepath.Join(dir, "site")
+	exporter := NewHTML(db, media.NewStore(filepath.Join(dir, "media")))
+	export := func(userID int64) *HTMLResult {
+		t.Helper()
+		result, err := exporter.Export(site, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if result := export(0); result.Written != 2 {
+		t.Fatalf("first export = %+v, want 2 written", result)
+	}
+	if result := export(0); result.Written != 0 || result.Skipped != 2 {
+		t.Errorf("unchanged export = %+v, want all skipped", result)
+	}
+
+	// A pin changes the message in place
+	if err := db.SetPinnedMessages(1, 101, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if result := export(1); result.Written != 1 || result.Conversations != 1 {
+		t.Errorf("export after pin = %+v, want 1 written", result)
+	}
+	if err := db.SetPinnedMessages(1, 101, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if result := export(1); result.Written != 0 {
+		t.Errorf("export after same pins = %+v, want nothing written", result)
+	}
+
+	// Exporting one account keeps the others in the index
+	index, err := os.ReadFile(filepath.Join(site, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"Ann&#39;s group", "Bob&#39;s group"} {
+		if !strings.Contains(string(index), title) {
+			t.Errorf("index does not list %s", title)
+		}
+	}
+
+	// After a layout change the account exported is rewritten, the others
+	// stay in the index until their own export rewrites them
+	manifest := readHTMLManifest(site)
+	manifest.Version = htmlVersion - 1
+	if err := writeHTMLManifest(site, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if result := export(1); result.Written != 1 {
+		t.Errorf("export after a layout change = %+v, want 1 written", result)
+	}
+	index, err = os.ReadFile(filepath.Join(site, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(index), "Bob&#39;s group") {
+		t.Error("index lost the other account after a layout change")
+	}
+	if result := export(2); result.Written != 1 {
+		t.Errorf("export of the other account = %+v, want 1 written", result)
+	}
+}
@@ -0,0 +1,65 @@
+package export
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Sink receives the files of an export. Only one file is written at a
+// time, the previous one is closed before the next is created.
+type Sink interface {
+	Create(name string) (io.WriteCloser, error)
+}
+
+// DirSink writes export files into a directory on disk
+type DirSink struct {
+	Root string
+}
+
+func NewDirSink(root string) *DirSink {
+	return &DirSink{Root: root}
+}
+
+func (s *DirSink) Create(name string) (io.WriteCloser, error) {
+	p := filepath.Join(s.Root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %v", err)
+	}
+	return os.Create(p)
+}
+
+// ZipSink streams export files into a ZIP archive
+type ZipSink struct {
+	zw *zip.Writer
+}
+
+func NewZipSink(w io.Writer) *ZipSink {
+	return &ZipSink{zw: zip.NewWriter(w)}
+}
+
+func (s *ZipSink) Create(name string) (io.WriteCloser, error) {
+	w, err := s.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{w}, nil
+}
+
+// Close writes the ZIP central directory, it does not close the underlying writer
+func (s *ZipSink) Close() error {
+	return s.zw.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
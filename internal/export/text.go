@@ -0,0 +1,32 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"tgbackup/internal/models"
+)
+
+// senderName returns a display name for the sender of a message
+func senderName(msg *models.Message, conv *models.Conversation) string {
+	name := strings.TrimSpace(msg.FromFirstName + " " + msg.FromLastName)
+	switch {
+	case name != "":
+		return name
+	case msg.FromUsername != "":
+		return "@" + msg.FromUsername
+	case msg.FromID == 0 || msg.FromID == conv.ID:
+		return conv.Title
+	default:
+		return fmt.Sprintf("%d", msg.FromID)
+	}
+}
+
+// isChatSender reports whether the message was sent on behalf of the chat
+// itself, e.g. a channel post
+func isChatSender(msg *models.Message, conv *models.Conversation) bool {
+	if conv.Type != "channel" && conv.Type != "group" {
+		return false
+	}
+	return msg.FromID == 0 || msg.FromID == conv.ID
+}
@@ -0,0 +1,628 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/mapper"
+	"tgbackup/internal/media"
+	"tgbackup/internal/models"
+)
+
+const desktopDateLayout = "2006-01-02T15:04:05"
+
+// Desktop imports exports made with Telegram Desktop's "Export chat history"
+// or "Export Telegram data" (result.json plus its media folders). Importing is
+// idempotent: messages that are already stored, whether synced live or
+// imported before, are left untouched.
+type Desktop struct {
+	db    *database.DB
+	media *media.Store
+}
+
+func NewDesktop(db *database.DB, store *media.Store) *Desktop {
+	return &Desktop{db: db, media: store}
+}
+
+// Result reports what an import did
+type Result struct {
+	UserID        int64 `json:"user_id"`
+	Conversations int   `json:"conversations"`
+	Imported      int   `json:"imported"`
+	Existing      int   `json:"existing"`
+	Skipped       int   `json:"skipped"`
+	MediaFiles    int   `json:"media_files"`
+}
+
+type desktopPersonalInfo struct {
+	UserID      int64  `json:"user_id"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	PhoneNumber string `json:"phone_number"`
+	Username    string `json:"username"`
+}
+
+type desktopChat struct {
+	Name     string           `json:"name"`
+	Type     string           `json:"type"`
+	ID       int64            `json:"id"`
+	Messages []desktopMessage `json:"messages"`
+}
+
+type desktopMessage struct {
+	ID           int             `json:"id"`
+	Type         string          `json:"type"`
+	Date         string          `json:"date"`
+	DateUnixtime string          `json:"date_unixtime"`
+	From         string          `json:"from"`
+	FromID       string          `json:"from_id"`
+	Author       string          `json:"author"`
+	Photo        string          `json:"photo"`
+	File         string          `json:"file"`
+	FileName     string          `json:"file_name"`
+	MediaType    string          `json:"media_type"`
+	MimeType     string          `json:"mime_type"`
+	Text         json.RawMessage `json:"text"`
+
+	ForwardedFrom string `json:"forwarded_from"`
+	ReplyTo       int    `json:"reply_to_message_id"`
+
+	Actor     string   `json:"actor"`
+	ActorID   string   `json:"actor_id"`
+	Action    string   `json:"action"`
+	Title     string   `json:"title"`
+	Members   []string `json:"members"`
+	MessageID int      `json:"message_id"`
+	Duration  int      `json:"duration_seconds"`
+	Reason    string   `json:"discard_reason"`
+	Video     bool     `json:"is_video"`
+	Period    int      `json:"period"`
+	Score     int      `json:"score"`
+
+	ContactInformation  *models.Contact `json:"contact_information"`
+	LocationInformation *struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"location_information"`
+	PlaceName  string `json:"place_name"`
+	Address    string `json:"address"`
+	LivePeriod int    `json:"live_location_period_seconds"`
+	Poll       *struct {
+		Question    string `json:"question"`
+		Closed      bool   `json:"closed"`
+		TotalVoters int    `json:"total_voters"`
+		Answers     []struct {
+			Text   string `json:"text"`
+			Voters int    `json:"voters"`
+			Chosen bool   `json:"chosen"`
+		} `json:"answers"`
+	} `json:"poll"`
+	Reactions []struct {
+		Type       string `json:"type"`
+		Count      int    `json:"count"`
+		Emoji      string `json:"emoji"`
+		DocumentID string `json:"document_id"`
+	} `json:"reactions"`
+}
+
+// Import reads result.json from dir (or the given result.json file) and
+// stores its chats for userID. When userID is zero the account ID from a
+// full data export is used.
+func (im *Desktop) Import(exportPath string, userID int64) (*Result, error) {
+	root, jsonPath := exportPath, filepath.Join(exportPath, "result.json")
+	if info, err := os.Stat(exportPath); err == nil && !info.IsDir() {
+		root, jsonPath = filepath.Dir(exportPath), exportPath
+	}
+
+	f, err := os.Open(jsonPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export: %v", err)
+	}
+	defer f.Close()
+
+	result := &Result{UserID: userID}
+	account := &models.User{ID: userID}
+	accountSaved := false
+
+	err = readDesktopExport(f, func(info *desktopPersonalInfo) {
+		if result.UserID == 0 {
+			result.UserID = info.UserID
+		}
+		if info.UserID == result.UserID {
+			account = &models.User{
+				ID:        info.UserID,
+				FirstName: info.FirstName,
+				LastName:  info.LastName,
+				Username:  strings.TrimPrefix(info.Username, "@"),
+				Phone:     info.PhoneNumber,
+			}
+		}
+	}, func(chat *desktopChat) error {
+		if result.UserID == 0 {
+			return fmt.Errorf("export does not name its account, pass the user ID explicitly")
+		}
+		// Accounts that were never logged in here (e.g. banned ones) still need
+		// a user record for their archive to be listed
+		if !accountSaved {
+			account.ID = result.UserID
+			if err := im.db.SaveUserIfAbsent(account); err != nil {
+				return fmt.Errorf("failed to save user %d: %v", account.ID, err)
+			}
+			accountSaved = true
+		}
+		return im.importChat(root, result, chat)
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (im *Desktop) importChat(root string, result *Result, chat *desktopChat) error {
+	conv := conversationFromChat(chat, result.UserID)
+	if len(chat.Messages) > 0 {
+		conv.LastTime = messageTime(&chat.Messages[len(chat.Messages)-1])
+	}
+
+	// Messages are collected and stored with the conversation in one go
+	var messages []models.Message
+	for i := range chat.Messages {
+		dm := &chat.Messages[i]
+		if dm.Type != "message" && dm.Type != "service" {
+			result.Skipped++
+			continue
+		}
+
+		exists, err := im.db.MessageExists(result.UserID, conv.ID, dm.ID)
+		if err != nil {
+			return fmt.Errorf("failed to check message %d of conversation %d: %v", dm.ID, conv.ID, err)
+		}
+		if exists {
+			result.Existing++
+			continue
+		}
+
+		messages = append(messages, im.messageFromDesktop(root, result, &conv, dm))
+	}
+
+	inserted, err := im.db.ImportChat(&conv, messages)
+	if err != nil {
+		return fmt.Errorf("failed to import conversation %d: %v", conv.ID, err)
+	}
+	result.Conversations++
+	result.Imported += inserted
+	result.Existing += len(messages) - inserted
+	return nil
+}
+
+func (im *Desktop) messageFromDesktop(root string, result *Result, conv *models.Conversation, dm *desktopMessage) models.Message {
+	if dm.Type == "service" {
+		return serviceMessageFromDesktop(result.UserID, conv, dm)
+	}
+
+	msg := models.Message{
+		UserID:         result.UserID,
+		ConversationID: conv.ID,
+		MessageID:      dm.ID,
+		FromID:         peerID(dm.FromID),
+		FromFirstName:  dm.From,
+		SenderType:     senderType(dm.FromID, conv),
+		PostAuthor:     dm.Author,
+		MessageType:    "text",
+		ReplyToMsgID:   dm.ReplyTo,
+		FwdFromName:    dm.ForwardedFrom,
+		Timestamp:      messageTime(dm),
+	}
+
+	text, entities := parseText(dm.Text)
+	msg.Entities = entities
+	for _, r := range dm.Reactions {
+		reaction := models.Reaction{Emoji: r.Emoji, Count: r.Count}
+		if r.Type == "custom_emoji" {
+			reaction.DocumentID, _ = strconv.ParseInt(r.DocumentID, 10, 64)
+		}
+		if reaction.Emoji == "" && reaction.DocumentID == 0 {
+			continue // 无法识别的反应
+		}
+		msg.Reactions = append(msg.Reactions, reaction)
+	}
+	var placeholder, mediaName, file string
+
+	switch {
+	case dm.Photo != "":
+		msg.MessageType, placeholder, file = "photo", "[Photo]", dm.Photo
+	case dm.File != "":
+		msg.MessageType, placeholder = fileMessageType(dm.MediaType, dm.MimeType)
+		file = dm.File
+		mediaName = dm.FileName
+		if mediaName == "" && !isNotIncluded(dm.File) {
+			mediaName = path.Base(filepath.ToSlash(dm.File))
+		}
+	case dm.ContactInformation != nil:
+		msg.MessageType, placeholder = "contact", "[Contact]"
+		msg.MediaData = &models.MediaData{Contact: dm.ContactInformation}
+	case dm.LocationInformation != nil:
+		msg.MessageType, placeholder = "location", "[Location]"
+		msg.MediaData = &models.MediaData{Location: &models.Location{
+			Latitude:  dm.LocationInformation.Latitude,
+			Longitude: dm.LocationInformation.Longitude,
+			Title:     dm.PlaceName,
+			Address:   dm.Address,
+			Live:      dm.LivePeriod > 0,
+			Period:    dm.LivePeriod,
+		}}
+	case dm.Poll != nil:
+		msg.MessageType, placeholder = "poll", "[Poll]"
+		poll := &models.Poll{
+			Question:    dm.Poll.Question,
+			Closed:      dm.Poll.Closed,
+			TotalVoters: dm.Poll.TotalVoters,
+		}
+		for _, answer := range dm.Poll.Answers {
+			poll.Answers = append(poll.Answers, models.PollAnswer{
+				Text:   answer.Text,
+				Voters: answer.Voters,
+				Chosen: answer.Chosen,
+			})
+		}
+		msg.MediaData = &models.MediaData{Poll: poll}
+	}
+
+	// Same content layout as messages stored by sync
+	msg.Content = text
+	if msg.Content == "" {
+		msg.Content = placeholder
+	}
+	if mediaName != "" && msg.Content != "" {
+		msg.Content = fmt.Sprintf("%s\n📁 %s", msg.Content, mediaName)
+	}
+
+	if file != "" && !isNotIncluded(file) {
+		url, err := im.copyMedia(root, result.UserID, conv.ID, dm.ID, file)
+		if err != nil {
+			log.Printf("Failed to import media of message %d in conversation %d: %v", dm.ID, conv.ID, err)
+		} else {
+			msg.MediaURL = url
+			result.MediaFiles++
+		}
+	}
+
+	return msg
+}
+
+// serviceMessageFromDesktop rebuilds the action of a service message and its
+// readable description
+func serviceMessageFromDesktop(userID int64, conv *models.Conversation, dm *desktopMessage) models.Message {
+	action := &models.MessageAction{
+		Type:      dm.Action,
+		ActorID:   peerID(dm.ActorID),
+		Actor:     dm.Actor,
+		Title:     dm.Title,
+		Members:   dm.Members,
+		MessageID: dm.MessageID,
+		Duration:  dm.Duration,
+		Reason:    dm.Reason,
+		Video:     dm.Video,
+		Period:    dm.Period,
+		Score:     dm.Score,
+	}
+	if action.Type == "" {
+		action.Type = "unknown"
+	}
+
+	actor := dm.Actor
+	if actor == "" {
+		actor = "Someone"
+	}
+	content := mapper.DescribeAction(action, actor)
+	if text, _ := parseText(dm.Text); text != "" && (action.Type == "unknown" || action.Type == "custom_action") {
+		action.Text = text
+		content = text
+	}
+
+	return models.Message{
+		UserID:         userID,
+		ConversationID: conv.ID,
+		MessageID:      dm.ID,
+		FromID:         action.ActorID,
+		FromFirstName:  dm.Actor,
+		SenderType:     senderType(dm.ActorID, conv),
+		Content:        content,
+		MessageType:    "service",
+		Action:         action,
+		Timestamp:      messageTime(dm),
+	}
+}
+
+func (im *Desktop) copyMedia(root string, userID, convID int64, messageID int, file string) (string, error) {
+	rel := path.Clean("/" + filepath.ToSlash(file))
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// Prefix with the message ID so names are unique and re-imports hit the same file
+	name := fmt.Sprintf("%d_%s", messageID, path.Base(rel))
+	return im.media.Save(userID, convID, name, f)
+}
+
+// readDesktopExport streams result.json, decoding one chat at a time. Both
+// full data exports (chats.list) and single chat exports are supported.
+func readDesktopExport(r io.Reader, onInfo func(*desktopPersonalInfo), onChat func(*desktopChat) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	var single desktopChat
+	isSingle := false
+
+	for dec.More() {
+		key, err := readKey(dec)
+		if err != nil {
+			return err
+		}
+
+		switch key {
+		case "personal_information":
+			var info desktopPersonalInfo
+			if err := dec.Decode(&info); err != nil {
+				return fmt.Errorf("invalid personal_information: %v", err)
+			}
+			onInfo(&info)
+		case "chats", "left_chats":
+			if err := readChatList(dec, onChat); err != nil {
+				return err
+			}
+		case "name":
+			err = dec.Decode(&single.Name)
+		case "type":
+			err = dec.Decode(&single.Type)
+		case "id":
+			err = dec.Decode(&single.ID)
+		case "messages":
+			isSingle = true
+			err = dec.Decode(&single.Messages)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid export field %q: %v", key, err)
+		}
+	}
+
+	if isSingle {
+		return onChat(&single)
+	}
+	return nil
+}
+
+func readChatList(dec *json.Decoder, onChat func(*desktopChat) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		key, err := readKey(dec)
+		if err != nil {
+			return err
+		}
+		if key != "list" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for dec.More() {
+			var chat desktopChat
+			if err := dec.Decode(&chat); err != nil {
+				return fmt.Errorf("invalid chat: %v", err)
+			}
+			if err := onChat(&chat); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+func readKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected token %v in export", tok)
+	}
+	return key, nil
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("unexpected token %v in export, expected %v", tok, want)
+	}
+	return nil
+}
+
+func conversationFromChat(chat *desktopChat, userID int64) models.Conversation {
+	conv := models.Conversation{
+		ID:     chat.ID,
+		UserID: userID,
+		Title:  chat.Name,
+	}
+
+	switch chat.Type {
+	case "bot_chat":
+		conv.Type = "bot"
+	case "private_group", "private_supergroup", "public_supergroup":
+		conv.Type = "group"
+	case "private_channel", "public_channel":
+		conv.Type = "channel"
+	case "saved_messages":
+		conv.Type = "user"
+		if conv.ID == 0 {
+			conv.ID = userID
+		}
+		if conv.Title == "" {
+			conv.Title = "Saved Messages"
+		}
+	default:
+		conv.Type = "user"
+	}
+
+	if conv.Title == "" {
+		conv.Title = fmt.Sprintf("Chat %d", conv.ID)
+	}
+
+	return conv
+}
+
+// fileMessageType maps Telegram Desktop media types to our message types
+func fileMessageType(mediaType, mimeType string) (messageType, placeholder string) {
+	switch mediaType {
+	case "video_file", "video_message":
+		return "video", "[Video]"
+	case "audio_file", "voice_message":
+		return "audio", "[Audio]"
+	case "animation":
+		return "gif", "[GIF]"
+	case "sticker":
+		return "sticker", "[Sticker]"
+	}
+	if strings.HasPrefix(mimeType, "image/") {
+		return "image", "[Image]"
+	}
+	return "document", "[Document]"
+}
+
+// parseText reads the text field, which is either a plain string or a list
+// of strings and entity objects, into the text and its formatting entities
+func parseText(raw json.RawMessage) (string, []models.MessageEntity) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil
+	}
+
+	var b strings.Builder
+	var entities []models.MessageEntity
+	offset := 0 // in UTF-16 code units, like Telegram entity offsets
+
+	for _, part := range parts {
+		var text string
+		if err := json.Unmarshal(part, &text); err == nil {
+			b.WriteString(text)
+			offset += utf16Len(text)
+			continue
+		}
+
+		var entity struct {
+			Type       string `json:"type"`
+			Text       string `json:"text"`
+			Href       string `json:"href"`
+			UserID     int64  `json:"user_id"`
+			Language   string `json:"language"`
+			DocumentID string `json:"document_id"`
+		}
+		if err := json.Unmarshal(part, &entity); err != nil {
+			continue
+		}
+
+		length := utf16Len(entity.Text)
+		if entity.Type != "" && entity.Type != "plain" && length > 0 {
+			documentID, _ := strconv.ParseInt(entity.DocumentID, 10, 64)
+			entities = append(entities, models.MessageEntity{
+				Type:       entity.Type,
+				Offset:     offset,
+				Length:     length,
+				URL:        entity.Href,
+				UserID:     entity.UserID,
+				Language:   entity.Language,
+				DocumentID: documentID,
+			})
+		}
+		b.WriteString(entity.Text)
+		offset += length
+	}
+
+	return b.String(), entities
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+func messageTime(dm *desktopMessage) time.Time {
+	if unix, err := strconv.ParseInt(dm.DateUnixtime, 10, 64); err == nil {
+		return time.Unix(unix, 0)
+	}
+	if t, err := time.ParseInLocation(desktopDateLayout, dm.Date, time.Local); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// peerID parses from_id values such as "user123" or "channel456"
+func peerID(s string) int64 {
+	for _, prefix := range []string{"user", "channel", "chat"} {
+		if strings.HasPrefix(s, prefix) {
+			id, _ := strconv.ParseInt(strings.TrimPrefix(s, prefix), 10, 64)
+			return id
+		}
+	}
+	return 0
+}
+
+// senderType returns the sender type of a from_id value. Messages a group
+// sends as itself come from anonymous admins.
+func senderType(s string, conv *models.Conversation) string {
+	for _, prefix := range []string{"user", "channel", "chat"} {
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+		if prefix == "channel" && conv.Type == "group" && peerID(s) == conv.ID {
+			return "anonymous_admin"
+		}
+		return prefix
+	}
+	return ""
+}
+
+func isNotIncluded(file string) bool {
+	return strings.HasPrefix(file, "(File ")
+}
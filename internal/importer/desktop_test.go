@@ -0,0 +1,100 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tgbackup/internal/media"
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+const testExport = `{
+ "name": "Family",
+ "type": "private_group",
+ "id": 300,
+ "messages": [
+  {"id": 1, "type": "message", "date": "2023-11-14T22:13:20", "date_unixtime": "1700000000",
+   "from": "Ann", "from_id": "user2", "text": "dinner?", "text_entities": [{"type": "plain", "text": "dinner?"}]},
+  {"id": 2, "type": "message", "date": "2023-11-14T22:14:20", "date_unixtime": "1700000060",
+   "from": "Me", "from_id": "user1", "text": "yes", "text_entities": [{"type": "plain", "text": "yes"}]}
+ ]
+}`
+
+func TestImportChat(t *testing.T) {
+	dir := t.TempDir()
+	db := telegramtest.NewDB(t, models.User{ID: 1, FirstName: "Me"})
+	exportPath := filepath.Join(dir, "result.json")
+	if err := os.WriteFile(exportPath, []byte(testExport), 0644); err != nil {
+		t.Fatal(err)
+	}
+	im := NewDesktop(db, media.NewStore(filepath.Join(dir, "media")))
+
+	result, err := im.Import(exportPath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Conversations != 1 || result.Imported != 2 || result.Existing != 0 {
+		t.Errorf("first import = %+v", result)
+	}
+	conv, err := db.GetConversation(1, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.LastMessageID != 2 || conv.LastMessage != "yes" {
+		t.Errorf("last message = %d %q, want 2 \"yes\"", conv.LastMessageID, conv.LastMessage)
+	}
+
+	// Importing again leaves the stored messages alone
+	result, err = im.Import(exportPath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 0 || result.Existing != 2 {
+		t.Errorf("second import = %+v", result)
+	}
+}
+
+func TestImportedMediaKept(t *testing.T) {
+	dir := t.TempDir()
+	db := telegramtest.NewDB(t, models.User{ID: 1, FirstName: "Me"})
+	export := `{"name": "Family", "type": "private_group", "id": 300, "messages": [
+  {"id": 1, "type": "message", "date": "2023-11-14T22:13:20", "date_unixtime": "1700000000",
+   "from": "Ann", "from_id": "user2", "photo": "photos/photo_1@14-11-2023_22-13-20.jpg", "text": ""}]}`
+	if err := os.MkdirAll(filepath.Join(dir, "photos"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "photos", "photo_1@14-11-2023_22-13-20.jpg"), []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exportPath := filepath.Join(dir, "result.json")
+	if err := os.WriteFile(exportPath, []byte(export), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := media.NewStore(filepath.Join(dir, "media"))
+	if _, err := NewDesktop(db, store).Import(exportPath, 1); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := db.GetMessage(300, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Path(imported.MediaURL); !ok {
+		t.Fatalf("imported media URL = %q, want a stored file", imported.MediaURL)
+	}
+
+	// A sync fetching the message again only has a placeholder for the photo
+	synced := *imported
+	synced.MediaURL = "telegram://photo/501_500"
+	if err := db.SaveMessage(&synced); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := db.GetMessage(300, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.MediaURL != imported.MediaURL {
+		t.Errorf("media URL after a sync = %q, want the imported %q", saved.MediaURL, imported.MediaURL)
+	}
+}
@@ -0,0 +1,39 @@
+package mapper
+
+import (
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+// applyCounters copies the view, forward, reply and reaction counters of a
+// message
+func applyCounters(msg *models.Message, message *tg.Message) {
+	msg.Views = message.Views
+	msg.Forwards = message.Forwards
+	if replies, ok := message.GetReplies(); ok {
+		msg.RepliesCount = replies.Replies
+	}
+	if reactions, ok := message.GetReactions(); ok {
+		msg.Reactions = ParseReactions(reactions)
+	}
+}
+
+// ParseReactions converts the reaction counters of a message, reactions of
+// unknown kinds are skipped
+func ParseReactions(reactions tg.MessageReactions) []models.Reaction {
+	var result []models.Reaction
+	for _, rc := range reactions.Results {
+		reaction := models.Reaction{Count: rc.Count, Chosen: rc.ChosenOrder > 0}
+		switch r := rc.Reaction.(type) {
+		case *tg.ReactionEmoji:
+			reaction.Emoji = r.Emoticon
+		case *tg.ReactionCustomEmoji:
+			reaction.DocumentID = r.DocumentID
+		default:
+			continue
+		}
+		result = append(result, reaction)
+	}
+	return result
+}
@@ -0,0 +1,69 @@
+package mapper
+
+import (
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+// parseEntities converts Telegram formatting entities. Offsets stay in UTF-16
+// code units and refer to msg.Message, which is always the start of Content.
+func parseEntities(entities []tg.MessageEntityClass) []models.MessageEntity {
+	var result []models.MessageEntity
+	for _, entity := range entities {
+		e := models.MessageEntity{
+			Offset: entity.GetOffset(),
+			Length: entity.GetLength(),
+		}
+
+		switch en := entity.(type) {
+		case *tg.MessageEntityBold:
+			e.Type = "bold"
+		case *tg.MessageEntityItalic:
+			e.Type = "italic"
+		case *tg.MessageEntityUnderline:
+			e.Type = "underline"
+		case *tg.MessageEntityStrike:
+			e.Type = "strikethrough"
+		case *tg.MessageEntityCode:
+			e.Type = "code"
+		case *tg.MessageEntityPre:
+			e.Type = "pre"
+			e.Language = en.Language
+		case *tg.MessageEntityTextURL:
+			e.Type = "text_link"
+			e.URL = en.URL
+		case *tg.MessageEntityURL:
+			e.Type = "link"
+		case *tg.MessageEntityMention:
+			e.Type = "mention"
+		case *tg.MessageEntityMentionName:
+			e.Type = "mention_name"
+			e.UserID = en.UserID
+		case *tg.MessageEntityHashtag:
+			e.Type = "hashtag"
+		case *tg.MessageEntityCashtag:
+			e.Type = "cashtag"
+		case *tg.MessageEntityBotCommand:
+			e.Type = "bot_command"
+		case *tg.MessageEntityEmail:
+			e.Type = "email"
+		case *tg.MessageEntityPhone:
+			e.Type = "phone"
+		case *tg.MessageEntitySpoiler:
+			e.Type = "spoiler"
+		case *tg.MessageEntityCustomEmoji:
+			e.Type = "custom_emoji"
+			e.DocumentID = en.DocumentID
+		case *tg.MessageEntityBlockquote:
+			e.Type = "blockquote"
+		case *tg.MessageEntityBankCard:
+			e.Type = "bank_card"
+		default:
+			continue
+		}
+
+		result = append(result, e)
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+// Package mapper translates Telegram messages into the stored models.Message.
+// It works on the objects of a response alone and never calls the API, so it
+// can be tested with constructed tg values.
+package mapper
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+// Mapper converts messages, media is handled by its parsers in order
+type Mapper struct {
+	parsers []MediaParser
+}
+
+// New returns a mapper with the default media parsers
+func New() *Mapper {
+	return &Mapper{parsers: DefaultMediaParsers()}
+}
+
+// Register adds a media parser. It is tried before the parsers registered
+// earlier, so it can also take over media the defaults already handle.
+func (m *Mapper) Register(p MediaParser) {
+	m.parsers = append([]MediaParser{p}, m.parsers...)
+}
+
+// Message converts regular and service messages, empty messages are skipped.
+// users and chats are the ones sent along with the message, they name senders
+// and forward origins.
+func (m *Mapper) Message(msg tg.MessageClass, users []tg.UserClass, chats []tg.ChatClass) (models.Message, bool) {
+	switch message := msg.(type) {
+	case *tg.Message:
+		return m.regularMessage(message, users, chats), true
+	case *tg.MessageService:
+		return parseServiceMessage(message, users, chats), true
+	default:
+		return models.Message{}, false
+	}
+}
+
+// Media runs the parsers on the media of a message, nil means the media is
+// absent or unknown
+func (m *Mapper) Media(media tg.MessageMediaClass) *Media {
+	if media == nil {
+		return nil
+	}
+	for _, p := range m.parsers {
+		if parsed, ok := p.ParseMedia(media); ok {
+			return parsed
+		}
+	}
+	return nil
+}
+
+func (m *Mapper) regularMessage(msg *tg.Message, users []tg.UserClass, chats []tg.ChatClass) models.Message {
+	parsed := models.Message{
+		MessageID:   msg.ID,
+		PostAuthor:  msg.PostAuthor,
+		ViaBotID:    msg.ViaBotID,
+		Content:     msg.Message,
+		MessageType: "text",
+		Entities:    parseEntities(msg.Entities),
+		GroupedID:   msg.GroupedID,
+		IsPinned:    msg.Pinned,
+		IsOut:       msg.Out,
+		Mentioned:   msg.Mentioned,
+		Timestamp:   time.Unix(int64(msg.Date), 0),
+	}
+
+	if media := m.Media(msg.Media); media != nil {
+		parsed.MessageType = media.Type
+		parsed.MediaURL = media.URL
+		parsed.MediaData = media.Data
+		if parsed.Content == "" {
+			parsed.Content = media.Placeholder
+		}
+		// 文件名显示在文本下方
+		if media.FileName != "" && parsed.Content != "" {
+			parsed.Content = fmt.Sprintf("%s\n📁 %s", parsed.Content, media.FileName)
+		}
+	}
+
+	// FromID can be nil, e.g. for channel posts
+	applySender(&parsed, msg.FromID, msg.PeerID, msg.Out, msg.Post, users, chats)
+	applyReplyHeader(&parsed, msg.ReplyTo)
+	applyCounters(&parsed, msg)
+	if fwd, ok := msg.GetFwdFrom(); ok {
+		applyFwdHeader(&parsed, fwd, users, chats)
+	}
+
+	return parsed
+}
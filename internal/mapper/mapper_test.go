@@ -0,0 +1,234 @@
+package mapper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+var (
+	testUsers = []tg.UserClass{
+		&tg.User{ID: 1, FirstName: "Ann", LastName: "Lee", Username: "ann"},
+		&tg.User{ID: 2, Username: "bob"},
+	}
+	testChats = []tg.ChatClass{
+		&tg.Channel{ID: 100, Title: "News", Username: "news"},
+		&tg.Channel{ID: 200, Title: "Group", Megagroup: true},
+		&tg.Chat{ID: 300, Title: "Old group"},
+		&tg.ChannelForbidden{ID: 400, Title: "Private"},
+	}
+)
+
+func TestMessageSkipsEmpty(t *testing.T) {
+	if _, ok := New().Message(&tg.MessageEmpty{ID: 1}, nil, nil); ok {
+		t.Error("empty message not skipped")
+	}
+}
+
+func TestMessageFields(t *testing.T) {
+	msg := &tg.Message{
+		ID:         42,
+		Message:    "hi",
+		Date:       1700000000,
+		PostAuthor: "Editor",
+		ViaBotID:   7,
+		GroupedID:  99,
+		Pinned:     true,
+		Out:        true,
+		Mentioned:  true,
+		Views:      10,
+		Forwards:   2,
+	}
+	msg.SetReplies(tg.MessageReplies{Replies: 5})
+	msg.SetReactions(tg.MessageReactions{Results: []tg.ReactionCount{
+		{Reaction: &tg.ReactionEmoji{Emoticon: "👍"}, Count: 3, ChosenOrder: 1},
+		{Reaction: &tg.ReactionCustomEmoji{DocumentID: 8}, Count: 1},
+		{Reaction: &tg.ReactionEmpty{}, Count: 1},
+	}})
+
+	got, _ := New().Message(msg, nil, nil)
+	want := models.Message{
+		MessageID:    42,
+		Content:      "hi",
+		MessageType:  "text",
+		PostAuthor:   "Editor",
+		ViaBotID:     7,
+		GroupedID:    99,
+		IsPinned:     true,
+		IsOut:        true,
+		Mentioned:    true,
+		Views:        10,
+		Forwards:     2,
+		RepliesCount: 5,
+		Reactions: []models.Reaction{
+			{Emoji: "👍", Count: 3, Chosen: true},
+			{DocumentID: 8, Count: 1},
+		},
+		Timestamp: time.Unix(1700000000, 0),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestSender(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      tg.PeerClass
+		peer      tg.PeerClass
+		out, post bool
+		wantID    int64
+		wantType  string
+		wantFirst string
+		wantUser  string
+	}{
+		{"user in group", &tg.PeerUser{UserID: 1}, &tg.PeerChat{ChatID: 300}, false, false, 1, "user", "Ann", "ann"},
+		{"unknown user", &tg.PeerUser{UserID: 9}, &tg.PeerChat{ChatID: 300}, false, false, 9, "user", "", ""},
+		{"incoming private message", nil, &tg.PeerUser{UserID: 2}, false, false, 2, "user", "", "bob"},
+		{"outgoing private message", nil, &tg.PeerUser{UserID: 2}, true, false, 0, "", "", ""},
+		{"channel post", nil, &tg.PeerChannel{ChannelID: 100}, false, true, 100, "channel", "News", "news"},
+		{"anonymous admin", &tg.PeerChannel{ChannelID: 200}, &tg.PeerChannel{ChannelID: 200}, false, false, 200, "anonymous_admin", "Group", ""},
+		{"user posting as channel", &tg.PeerChannel{ChannelID: 100}, &tg.PeerChannel{ChannelID: 200}, false, false, 100, "channel", "News", "news"},
+		{"basic group", &tg.PeerChat{ChatID: 300}, &tg.PeerChat{ChatID: 300}, false, false, 300, "chat", "Old group", ""},
+	}
+
+	m := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &tg.Message{PeerID: tt.peer, Out: tt.out, Post: tt.post}
+			if tt.from != nil {
+				msg.SetFromID(tt.from)
+			}
+			got, _ := m.Message(msg, testUsers, testChats)
+			if got.FromID != tt.wantID || got.SenderType != tt.wantType || got.FromFirstName != tt.wantFirst || got.FromUsername != tt.wantUser {
+				t.Errorf("got %d %q %q %q, want %d %q %q %q",
+					got.FromID, got.SenderType, got.FromFirstName, got.FromUsername,
+					tt.wantID, tt.wantType, tt.wantFirst, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestReplyHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header tg.MessageReplyHeaderClass
+		want   models.Message
+	}{
+		{"none", nil, models.Message{}},
+		{"story", &tg.MessageReplyStoryHeader{StoryID: 1}, models.Message{}},
+		{"reply", &tg.MessageReplyHeader{ReplyToMsgID: 5, QuoteText: "quoted"},
+			models.Message{ReplyToMsgID: 5, ReplyQuote: "quoted"}},
+		{"thread", &tg.MessageReplyHeader{ReplyToMsgID: 5, ReplyToTopID: 3},
+			models.Message{ReplyToMsgID: 5, ReplyToTopID: 3}},
+		{"forum topic", &tg.MessageReplyHeader{ForumTopic: true, ReplyToMsgID: 5, ReplyToTopID: 3},
+			models.Message{ReplyToMsgID: 5, ReplyToTopID: 3, TopicID: 3}},
+		{"first message of a topic", &tg.MessageReplyHeader{ForumTopic: true, ReplyToMsgID: 3},
+			models.Message{ReplyToMsgID: 3, TopicID: 3}},
+		{"other chat", func() tg.MessageReplyHeaderClass {
+			h := &tg.MessageReplyHeader{ReplyToMsgID: 5}
+			h.SetReplyToPeerID(&tg.PeerChannel{ChannelID: 100})
+			return h
+		}(), models.Message{ReplyToMsgID: 5, ReplyToPeerID: 100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got models.Message
+			applyReplyHeader(&got, tt.header)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFwdHeader(t *testing.T) {
+	date := time.Unix(1600000000, 0)
+	tests := []struct {
+		name string
+		fwd  tg.MessageFwdHeader
+		want models.Message
+	}{
+		{"hidden user", tg.MessageFwdHeader{FromName: "Someone", Date: 1600000000},
+			models.Message{FwdFromName: "Someone", FwdDate: &date}},
+		{"user", tg.MessageFwdHeader{FromID: &tg.PeerUser{UserID: 1}},
+			models.Message{FwdFromID: 1, FwdFromType: "user", FwdFromName: "Ann Lee"}},
+		{"user without name", tg.MessageFwdHeader{FromID: &tg.PeerUser{UserID: 2}},
+			models.Message{FwdFromID: 2, FwdFromType: "user", FwdFromName: "@bob"}},
+		{"channel post", tg.MessageFwdHeader{FromID: &tg.PeerChannel{ChannelID: 100}, ChannelPost: 12, PostAuthor: "Editor"},
+			models.Message{FwdFromID: 100, FwdFromType: "channel", FwdFromName: "News", FwdChannelPost: 12, FwdPostAuthor: "Editor"}},
+		{"inaccessible channel", tg.MessageFwdHeader{FromID: &tg.PeerChannel{ChannelID: 400}},
+			models.Message{FwdFromID: 400, FwdFromType: "channel", FwdFromName: "Private"}},
+		{"group", tg.MessageFwdHeader{FromID: &tg.PeerChat{ChatID: 300}},
+			models.Message{FwdFromID: 300, FwdFromType: "chat", FwdFromName: "Old group"}},
+	}
+
+	m := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &tg.Message{}
+			msg.SetFwdFrom(tt.fwd)
+			got, _ := m.Message(msg, testUsers, testChats)
+			got.MessageType, got.Timestamp = "", time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntities(t *testing.T) {
+	entities := []tg.MessageEntityClass{
+		&tg.MessageEntityBold{Offset: 0, Length: 4},
+		&tg.MessageEntityItalic{Offset: 1, Length: 2},
+		&tg.MessageEntityUnderline{},
+		&tg.MessageEntityStrike{},
+		&tg.MessageEntityCode{},
+		&tg.MessageEntityPre{Language: "go"},
+		&tg.MessageEntityTextURL{URL: "https://example.com"},
+		&tg.MessageEntityURL{},
+		&tg.MessageEntityMention{},
+		&tg.MessageEntityMentionName{UserID: 1},
+		&tg.MessageEntityHashtag{},
+		&tg.MessageEntityCashtag{},
+		&tg.MessageEntityBotCommand{},
+		&tg.MessageEntityEmail{},
+		&tg.MessageEntityPhone{},
+		&tg.MessageEntitySpoiler{},
+		&tg.MessageEntityCustomEmoji{DocumentID: 5},
+		&tg.MessageEntityBlockquote{},
+		&tg.MessageEntityBankCard{},
+		&tg.MessageEntityUnknown{},
+	}
+	want := []models.MessageEntity{
+		{Type: "bold", Offset: 0, Length: 4},
+		{Type: "italic", Offset: 1, Length: 2},
+		{Type: "underline"},
+		{Type: "strikethrough"},
+		{Type: "code"},
+		{Type: "pre", Language: "go"},
+		{Type: "text_link", URL: "https://example.com"},
+		{Type: "link"},
+		{Type: "mention"},
+		{Type: "mention_name", UserID: 1},
+		{Type: "hashtag"},
+		{Type: "cashtag"},
+		{Type: "bot_command"},
+		{Type: "email"},
+		{Type: "phone"},
+		{Type: "spoiler"},
+		{Type: "custom_emoji", DocumentID: 5},
+		{Type: "blockquote"},
+		{Type: "bank_card"},
+	}
+
+	got, _ := New().Message(&tg.Message{Message: "text", Entities: entities}, nil, nil)
+	if !reflect.DeepEqual(got.Entities, want) {
+		t.Errorf("got %+v\nwant %+v", got.Entities, want)
+	}
+}
@@ -0,0 +1,260 @@
+package mapper
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+// Media is what a MediaParser extracts from the media of a message
+type Media struct {
+	Type        string            // message_type, e.g. photo, video, poll
+	Placeholder string            // content of messages without text, e.g. "[Photo]"
+	FileName    string            // shown below the text
+	URL         string            // placeholder URL of the file until it is downloaded
+	Data        *models.MediaData // structured content of media without a file
+}
+
+// MediaParser converts one kind of message media. It reports false for media
+// it does not handle, so the next parser is tried.
+type MediaParser interface {
+	ParseMedia(media tg.MessageMediaClass) (*Media, bool)
+}
+
+// MediaParserFunc adapts a function to MediaParser
+type MediaParserFunc func(media tg.MessageMediaClass) (*Media, bool)
+
+func (f MediaParserFunc) ParseMedia(media tg.MessageMediaClass) (*Media, bool) {
+	return f(media)
+}
+
+// DefaultMediaParsers returns the parsers for the media types Telegram clients
+// commonly send
+func DefaultMediaParsers() []MediaParser {
+	return []MediaParser{
+		MediaParserFunc(parsePhoto),
+		MediaParserFunc(parseDocument),
+		MediaParserFunc(parseWebPage),
+		MediaParserFunc(parseContact),
+		MediaParserFunc(parseLocation),
+		MediaParserFunc(parsePollMedia),
+	}
+}
+
+func parsePhoto(media tg.MessageMediaClass) (*Media, bool) {
+	m, ok := media.(*tg.MessageMediaPhoto)
+	if !ok {
+		return nil, false
+	}
+	parsed := &Media{Type: "photo", Placeholder: "[Photo]"}
+	if photo, ok := m.Photo.(*tg.Photo); ok {
+		parsed.URL = photoURL(photo)
+	}
+	return parsed, true
+}
+
+// documentKinds lists the kinds of documents by precedence: an animated
+// sticker is a sticker, a video with the animated flag is a GIF
+var documentKinds = []struct {
+	kind, placeholder string
+	match             func(tg.DocumentAttributeClass) bool
+}{
+	{"sticker", "[Sticker]", func(a tg.DocumentAttributeClass) bool { _, ok := a.(*tg.DocumentAttributeSticker); return ok }},
+	{"gif", "[GIF]", func(a tg.DocumentAttributeClass) bool { _, ok := a.(*tg.DocumentAttributeAnimated); return ok }},
+	{"video", "[Video]", func(a tg.DocumentAttributeClass) bool { _, ok := a.(*tg.DocumentAttributeVideo); return ok }},
+	{"audio", "[Audio]", func(a tg.DocumentAttributeClass) bool { _, ok := a.(*tg.DocumentAttributeAudio); return ok }},
+	{"image", "[Image]", func(a tg.DocumentAttributeClass) bool { _, ok := a.(*tg.DocumentAttributeImageSize); return ok }},
+}
+
+func parseDocument(media tg.MessageMediaClass) (*Media, bool) {
+	m, ok := media.(*tg.MessageMediaDocument)
+	if !ok {
+		return nil, false
+	}
+	doc, ok := m.Document.(*tg.Document)
+	if !ok {
+		// 文档已被删除
+		return &Media{Type: "document", Placeholder: "[Document]"}, true
+	}
+
+	parsed := &Media{Type: "document", Placeholder: "[Document]", URL: documentURL(doc)}
+kinds:
+	for _, kind := range documentKinds {
+		for _, attr := range doc.Attributes {
+			if kind.match(attr) {
+				parsed.Type, parsed.Placeholder = kind.kind, kind.placeholder
+				break kinds
+			}
+		}
+	}
+
+	// 文件名优先, 其次是音频标题
+	var title string
+	for _, attr := range doc.Attributes {
+		switch a := attr.(type) {
+		case *tg.DocumentAttributeFilename:
+			parsed.FileName = a.FileName
+		case *tg.DocumentAttributeAudio:
+			title = a.Title
+		}
+	}
+	if parsed.FileName == "" {
+		parsed.FileName = title
+	}
+	if parsed.FileName == "" {
+		parsed.FileName = fmt.Sprintf("%s.%s", parsed.Type, "file")
+	}
+
+	return parsed, true
+}
+
+// parseWebPage keeps link previews, the message stays a text message
+func parseWebPage(media tg.MessageMediaClass) (*Media, bool) {
+	m, ok := media.(*tg.MessageMediaWebPage)
+	if !ok {
+		return nil, false
+	}
+	parsed := &Media{Type: "text", Placeholder: "[Webpage]"}
+
+	page, ok := m.Webpage.(*tg.WebPage)
+	if !ok {
+		return parsed, true
+	}
+	webPage := &models.WebPage{
+		URL:         page.URL,
+		DisplayURL:  page.DisplayURL,
+		Type:        page.Type,
+		SiteName:    page.SiteName,
+		Title:       page.Title,
+		Description: page.Description,
+		Author:      page.Author,
+		EmbedURL:    page.EmbedURL,
+		Duration:    page.Duration,
+	}
+	if photo, ok := page.Photo.(*tg.Photo); ok {
+		webPage.PhotoURL = photoURL(photo)
+	}
+	parsed.Data = &models.MediaData{WebPage: webPage}
+	return parsed, true
+}
+
+func parseContact(media tg.MessageMediaClass) (*Media, bool) {
+	m, ok := media.(*tg.MessageMediaContact)
+	if !ok {
+		return nil, false
+	}
+	return &Media{Type: "contact", Placeholder: "[Contact]", Data: &models.MediaData{Contact: &models.Contact{
+		PhoneNumber: m.PhoneNumber,
+		FirstName:   m.FirstName,
+		LastName:    m.LastName,
+		UserID:      m.UserID,
+		VCard:       m.Vcard,
+	}}}, true
+}
+
+func parseLocation(media tg.MessageMediaClass) (*Media, bool) {
+	var location *models.Location
+	switch m := media.(type) {
+	case *tg.MessageMediaGeo:
+		location = parseGeoPoint(m.Geo)
+	case *tg.MessageMediaVenue:
+		location = parseGeoPoint(m.Geo)
+		location.Title = m.Title
+		location.Address = m.Address
+		location.Provider = m.Provider
+		location.VenueID = m.VenueID
+		location.VenueType = m.VenueType
+	case *tg.MessageMediaGeoLive:
+		location = parseGeoPoint(m.Geo)
+		location.Live = true
+		location.Period = m.Period
+		location.Heading = m.Heading
+	default:
+		return nil, false
+	}
+	return &Media{Type: "location", Placeholder: "[Location]", Data: &models.MediaData{Location: location}}, true
+}
+
+func parsePollMedia(media tg.MessageMediaClass) (*Media, bool) {
+	m, ok := media.(*tg.MessageMediaPoll)
+	if !ok {
+		return nil, false
+	}
+	return &Media{Type: "poll", Placeholder: "[Poll]", Data: &models.MediaData{Poll: parsePoll(&m.Poll, &m.Results)}}, true
+}
+
+func parsePoll(poll *tg.Poll, results *tg.PollResults) *models.Poll {
+	parsed := &models.Poll{
+		ID:             poll.ID,
+		Question:       poll.Question,
+		Closed:         poll.Closed,
+		Quiz:           poll.Quiz,
+		MultipleChoice: poll.MultipleChoice,
+		PublicVoters:   poll.PublicVoters,
+		CloseDate:      int64(poll.CloseDate),
+	}
+	for _, answer := range poll.Answers {
+		parsed.Answers = append(parsed.Answers, models.PollAnswer{Text: answer.Text, Option: answer.Option})
+	}
+	ApplyPollResults(parsed, results)
+	return parsed
+}
+
+// ApplyPollResults copies vote counts onto the answers of a poll
+func ApplyPollResults(poll *models.Poll, results *tg.PollResults) {
+	if results == nil {
+		return
+	}
+	if total, ok := results.GetTotalVoters(); ok {
+		poll.TotalVoters = total
+	}
+	if solution, ok := results.GetSolution(); ok {
+		poll.Solution = solution
+	}
+	for _, voters := range results.Results {
+		for i := range poll.Answers {
+			answer := &poll.Answers[i]
+			if bytes.Equal(answer.Option, voters.Option) {
+				answer.Voters = voters.Voters
+				answer.Chosen = voters.Chosen
+				answer.Correct = voters.Correct
+			}
+		}
+	}
+}
+
+func parseGeoPoint(geo tg.GeoPointClass) *models.Location {
+	point, ok := geo.(*tg.GeoPoint)
+	if !ok {
+		return &models.Location{}
+	}
+	return &models.Location{
+		Latitude:       point.Lat,
+		Longitude:      point.Long,
+		AccuracyRadius: point.AccuracyRadius,
+	}
+}
+
+// photoURL returns a placeholder URL for the largest size of a photo
+func photoURL(photo *tg.Photo) string {
+	var largest *tg.PhotoSize
+	var maxArea int
+	for _, size := range photo.Sizes {
+		if photoSize, ok := size.(*tg.PhotoSize); ok && photoSize.W*photoSize.H > maxArea {
+			maxArea = photoSize.W * photoSize.H
+			largest = photoSize
+		}
+	}
+	if largest == nil {
+		return ""
+	}
+	return fmt.Sprintf("telegram://photo/%d_%d", photo.ID, largest.Size)
+}
+
+// documentURL returns a placeholder URL for a document
+func documentURL(doc *tg.Document) string {
+	return fmt.Sprintf("telegram://document/%d_%d", doc.ID, doc.Size)
+}
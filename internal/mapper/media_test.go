@@ -0,0 +1,199 @@
+package mapper
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+func document(attrs ...tg.DocumentAttributeClass) *tg.MessageMediaDocument {
+	return &tg.MessageMediaDocument{Document: &tg.Document{ID: 7, Size: 1024, Attributes: attrs}}
+}
+
+func TestMessageMedia(t *testing.T) {
+	photo := &tg.Photo{ID: 5, Sizes: []tg.PhotoSizeClass{
+		&tg.PhotoSize{Type: "s", W: 90, H: 90, Size: 100},
+		&tg.PhotoSize{Type: "x", W: 800, H: 600, Size: 5000},
+		&tg.PhotoSize{Type: "m", W: 320, H: 240, Size: 900},
+	}}
+
+	tests := []struct {
+		name     string
+		text     string
+		media    tg.MessageMediaClass
+		wantType string
+		wantText string
+		wantURL  string
+	}{
+		{"text", "hello", nil, "text", "hello", ""},
+		{"photo", "", &tg.MessageMediaPhoto{Photo: photo}, "photo", "[Photo]", "telegram://photo/5_5000"},
+		{"photo with caption", "look", &tg.MessageMediaPhoto{Photo: photo}, "photo", "look", "telegram://photo/5_5000"},
+		{"deleted photo", "", &tg.MessageMediaPhoto{}, "photo", "[Photo]", ""},
+		{"photo without sizes", "", &tg.MessageMediaPhoto{Photo: &tg.Photo{ID: 5}}, "photo", "[Photo]", ""},
+		{"plain document", "", document(),
+			"document", "[Document]\n📁 document.file", "telegram://document/7_1024"},
+		{"named document", "report", document(&tg.DocumentAttributeFilename{FileName: "q3.pdf"}),
+			"document", "report\n📁 q3.pdf", "telegram://document/7_1024"},
+		{"deleted document", "", &tg.MessageMediaDocument{}, "document", "[Document]", ""},
+		{"video", "", document(&tg.DocumentAttributeVideo{Duration: 3}, &tg.DocumentAttributeFilename{FileName: "clip.mp4"}),
+			"video", "[Video]\n📁 clip.mp4", "telegram://document/7_1024"},
+		{"round video", "", document(&tg.DocumentAttributeVideo{RoundMessage: true}),
+			"video", "[Video]\n📁 video.file", "telegram://document/7_1024"},
+		{"audio with title", "", document(&tg.DocumentAttributeAudio{Title: "Song"}),
+			"audio", "[Audio]\n📁 Song", "telegram://document/7_1024"},
+		{"audio file name before title", "", document(&tg.DocumentAttributeFilename{FileName: "song.mp3"}, &tg.DocumentAttributeAudio{Title: "Song"}),
+			"audio", "[Audio]\n📁 song.mp3", "telegram://document/7_1024"},
+		{"audio title before file name", "", document(&tg.DocumentAttributeAudio{Title: "Song"}, &tg.DocumentAttributeFilename{FileName: "song.mp3"}),
+			"audio", "[Audio]\n📁 song.mp3", "telegram://document/7_1024"},
+		{"voice note", "", document(&tg.DocumentAttributeAudio{Voice: true}),
+			"audio", "[Audio]\n📁 audio.file", "telegram://document/7_1024"},
+		{"image", "", document(&tg.DocumentAttributeImageSize{W: 10, H: 10}, &tg.DocumentAttributeFilename{FileName: "a.png"}),
+			"image", "[Image]\n📁 a.png", "telegram://document/7_1024"},
+		{"image size of a video", "", document(&tg.DocumentAttributeImageSize{W: 10, H: 10}, &tg.DocumentAttributeVideo{}),
+			"video", "[Video]\n📁 video.file", "telegram://document/7_1024"},
+		{"gif", "", document(&tg.DocumentAttributeVideo{}, &tg.DocumentAttributeAnimated{}),
+			"gif", "[GIF]\n📁 gif.file", "telegram://document/7_1024"},
+		{"gif before video", "", document(&tg.DocumentAttributeAnimated{}, &tg.DocumentAttributeVideo{}),
+			"gif", "[GIF]\n📁 gif.file", "telegram://document/7_1024"},
+		{"sticker", "", document(&tg.DocumentAttributeImageSize{W: 512, H: 512}, &tg.DocumentAttributeSticker{Alt: "😀"}),
+			"sticker", "[Sticker]\n📁 sticker.file", "telegram://document/7_1024"},
+		{"video sticker", "", document(&tg.DocumentAttributeVideo{}, &tg.DocumentAttributeSticker{}, &tg.DocumentAttributeFilename{FileName: "s.webm"}),
+			"sticker", "[Sticker]\n📁 s.webm", "telegram://document/7_1024"},
+		{"animated sticker", "", document(&tg.DocumentAttributeAnimated{}, &tg.DocumentAttributeSticker{}),
+			"sticker", "[Sticker]\n📁 sticker.file", "telegram://document/7_1024"},
+		{"webpage", "https://example.com", &tg.MessageMediaWebPage{Webpage: &tg.WebPage{URL: "https://example.com"}},
+			"text", "https://example.com", ""},
+		{"empty webpage", "", &tg.MessageMediaWebPage{Webpage: &tg.WebPageEmpty{}}, "text", "[Webpage]", ""},
+		{"contact", "", &tg.MessageMediaContact{PhoneNumber: "+1"}, "contact", "[Contact]", ""},
+		{"geo", "", &tg.MessageMediaGeo{Geo: &tg.GeoPoint{Lat: 1, Long: 2}}, "location", "[Location]", ""},
+		{"venue", "", &tg.MessageMediaVenue{Geo: &tg.GeoPoint{}, Title: "Cafe"}, "location", "[Location]", ""},
+		{"live location", "", &tg.MessageMediaGeoLive{Geo: &tg.GeoPointEmpty{}, Period: 60}, "location", "[Location]", ""},
+		{"poll", "", &tg.MessageMediaPoll{Poll: tg.Poll{Question: "?"}}, "poll", "[Poll]", ""},
+		{"unsupported media", "hi", &tg.MessageMediaDice{Value: 3, Emoticon: "🎲"}, "text", "hi", ""},
+	}
+
+	m := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, ok := m.Message(&tg.Message{ID: 1, Message: tt.text, Media: tt.media}, nil, nil)
+			if !ok {
+				t.Fatal("message skipped")
+			}
+			if msg.MessageType != tt.wantType {
+				t.Errorf("type = %q, want %q", msg.MessageType, tt.wantType)
+			}
+			if msg.Content != tt.wantText {
+				t.Errorf("content = %q, want %q", msg.Content, tt.wantText)
+			}
+			if msg.MediaURL != tt.wantURL {
+				t.Errorf("media url = %q, want %q", msg.MediaURL, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestMediaData(t *testing.T) {
+	tests := []struct {
+		name  string
+		media tg.MessageMediaClass
+		want  *models.MediaData
+	}{
+		{"photo has no data", &tg.MessageMediaPhoto{}, nil},
+		{"document has no data", document(), nil},
+		{"contact", &tg.MessageMediaContact{PhoneNumber: "+1", FirstName: "Ann", LastName: "Lee", UserID: 9, Vcard: "BEGIN:VCARD"},
+			&models.MediaData{Contact: &models.Contact{PhoneNumber: "+1", FirstName: "Ann", LastName: "Lee", UserID: 9, VCard: "BEGIN:VCARD"}}},
+		{"geo", &tg.MessageMediaGeo{Geo: &tg.GeoPoint{Lat: 1.5, Long: 2.5, AccuracyRadius: 10}},
+			&models.MediaData{Location: &models.Location{Latitude: 1.5, Longitude: 2.5, AccuracyRadius: 10}}},
+		{"empty geo", &tg.MessageMediaGeo{Geo: &tg.GeoPointEmpty{}},
+			&models.MediaData{Location: &models.Location{}}},
+		{"venue", &tg.MessageMediaVenue{Geo: &tg.GeoPoint{Lat: 1, Long: 2}, Title: "Cafe", Address: "Main St", Provider: "foursquare", VenueID: "v1", VenueType: "food"},
+			&models.MediaData{Location: &models.Location{Latitude: 1, Longitude: 2, Title: "Cafe", Address: "Main St", Provider: "foursquare", VenueID: "v1", VenueType: "food"}}},
+		{"live location", &tg.MessageMediaGeoLive{Geo: &tg.GeoPoint{Lat: 3, Long: 4}, Period: 900, Heading: 90},
+			&models.MediaData{Location: &models.Location{Latitude: 3, Longitude: 4, Live: true, Period: 900, Heading: 90}}},
+		{"webpage", &tg.MessageMediaWebPage{Webpage: &tg.WebPage{
+			URL: "https://example.com/a", DisplayURL: "example.com/a", Type: "article", SiteName: "Example",
+			Title: "A", Description: "About A", Author: "Ann", Duration: 0,
+			Photo: &tg.Photo{ID: 3, Sizes: []tg.PhotoSizeClass{&tg.PhotoSize{W: 10, H: 10, Size: 42}}},
+		}}, &models.MediaData{WebPage: &models.WebPage{
+			URL: "https://example.com/a", DisplayURL: "example.com/a", Type: "article", SiteName: "Example",
+			Title: "A", Description: "About A", Author: "Ann", PhotoURL: "telegram://photo/3_42",
+		}}},
+		{"pending webpage", &tg.MessageMediaWebPage{Webpage: &tg.WebPagePending{}}, nil},
+	}
+
+	m := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, _ := m.Message(&tg.Message{Media: tt.media}, nil, nil)
+			if !reflect.DeepEqual(msg.MediaData, tt.want) {
+				t.Errorf("media data = %+v, want %+v", msg.MediaData, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoll(t *testing.T) {
+	results := tg.PollResults{Results: []tg.PollAnswerVoters{
+		{Option: []byte("0"), Voters: 3},
+		{Option: []byte("1"), Voters: 1, Chosen: true, Correct: true},
+	}}
+	results.SetTotalVoters(4)
+	results.SetSolution("Because")
+
+	media := &tg.MessageMediaPoll{
+		Poll: tg.Poll{ID: 11, Question: "Which?", Quiz: true, Closed: true, CloseDate: 100, Answers: []tg.PollAnswer{
+			{Text: "A", Option: []byte("0")},
+			{Text: "B", Option: []byte("1")},
+		}},
+		Results: results,
+	}
+
+	msg, _ := New().Message(&tg.Message{Media: media}, nil, nil)
+	want := &models.Poll{
+		ID: 11, Question: "Which?", Quiz: true, Closed: true, CloseDate: 100, TotalVoters: 4, Solution: "Because",
+		Answers: []models.PollAnswer{
+			{Text: "A", Option: []byte("0"), Voters: 3},
+			{Text: "B", Option: []byte("1"), Voters: 1, Chosen: true, Correct: true},
+		},
+	}
+	if msg.MediaData == nil || !reflect.DeepEqual(msg.MediaData.Poll, want) {
+		t.Errorf("poll = %+v, want %+v", msg.MediaData, want)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	m := New()
+	m.Register(MediaParserFunc(func(media tg.MessageMediaClass) (*Media, bool) {
+		dice, ok := media.(*tg.MessageMediaDice)
+		if !ok {
+			return nil, false
+		}
+		return &Media{Type: "dice", Placeholder: dice.Emoticon}, true
+	}))
+	// A registered parser takes priority over the defaults
+	m.Register(MediaParserFunc(func(media tg.MessageMediaClass) (*Media, bool) {
+		if _, ok := media.(*tg.MessageMediaContact); !ok {
+			return nil, false
+		}
+		return &Media{Type: "vcard", Placeholder: "[vCard]"}, true
+	}))
+
+	tests := []struct {
+		media    tg.MessageMediaClass
+		wantType string
+		wantText string
+	}{
+		{&tg.MessageMediaDice{Emoticon: "🎲", Value: 6}, "dice", "🎲"},
+		{&tg.MessageMediaContact{}, "vcard", "[vCard]"},
+		{&tg.MessageMediaPhoto{}, "photo", "[Photo]"},
+	}
+	for _, tt := range tests {
+		msg, _ := m.Message(&tg.Message{Media: tt.media}, nil, nil)
+		if msg.MessageType != tt.wantType || msg.Content != tt.wantText {
+			t.Errorf("%T: got %q %q, want %q %q", tt.media, msg.MessageType, msg.Content, tt.wantType, tt.wantText)
+		}
+	}
+}
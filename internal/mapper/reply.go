@@ -0,0 +1,99 @@
+package mapper
+
+import (
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+// applyReplyHeader copies what a message replies to: the replied message,
+// the top message of its thread and the quoted text. In forums the thread is
+// the topic the message was sent to.
+func applyReplyHeader(msg *models.Message, replyTo tg.MessageReplyHeaderClass) {
+	header, ok := replyTo.(*tg.MessageReplyHeader)
+	if !ok {
+		return
+	}
+
+	msg.ReplyToMsgID = header.ReplyToMsgID
+	msg.ReplyToTopID = header.ReplyToTopID
+	msg.ReplyQuote = header.QuoteText
+	if header.ForumTopic {
+		msg.TopicID = header.ReplyToTopID
+		if msg.TopicID == 0 {
+			msg.TopicID = header.ReplyToMsgID
+		}
+	}
+	if peer, ok := header.GetReplyToPeerID(); ok {
+		msg.ReplyToPeerID, _ = PeerInfo(peer)
+	}
+}
+
+// applyFwdHeader copies where a forwarded message comes from. The original
+// sender is named from the users and chats sent along with the message, a
+// hidden sender only has fwd.FromName.
+func applyFwdHeader(msg *models.Message, fwd tg.MessageFwdHeader, users []tg.UserClass, chats []tg.ChatClass) {
+	msg.FwdFromName = fwd.FromName
+	msg.FwdChannelPost = fwd.ChannelPost
+	msg.FwdPostAuthor = fwd.PostAuthor
+	if fwd.Date != 0 {
+		date := time.Unix(int64(fwd.Date), 0)
+		msg.FwdDate = &date
+	}
+
+	if fwd.FromID == nil {
+		return
+	}
+	msg.FwdFromID, msg.FwdFromType = PeerInfo(fwd.FromID)
+	if msg.FwdFromName != "" {
+		return
+	}
+	switch msg.FwdFromType {
+	case "user":
+		if user := FindUser(users, msg.FwdFromID); user != nil {
+			msg.FwdFromName = userDisplayName(user, msg.FwdFromID)
+		}
+	default:
+		msg.FwdFromName = findChatTitle(chats, msg.FwdFromID)
+	}
+}
+
+// PeerInfo returns the ID of a peer and whether it is a user, chat or channel
+func PeerInfo(peer tg.PeerClass) (int64, string) {
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		return p.UserID, "user"
+	case *tg.PeerChat:
+		return p.ChatID, "chat"
+	case *tg.PeerChannel:
+		return p.ChannelID, "channel"
+	default:
+		return 0, ""
+	}
+}
+
+func findChatTitle(chats []tg.ChatClass, id int64) string {
+	for _, c := range chats {
+		switch chat := c.(type) {
+		case *tg.Chat:
+			if chat.ID == id {
+				return chat.Title
+			}
+		case *tg.Channel:
+			if chat.ID == id {
+				return chat.Title
+			}
+		case *tg.ChannelForbidden:
+			if chat.ID == id {
+				return chat.Title
+			}
+		case *tg.ChatForbidden:
+			if chat.ID == id {
+				return chat.Title
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package mapper
+
+import (
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+// applySender resolves who sent a message. Users get their names; channels
+// and chats sending a message (channel posts, anonymous group admins, users
+// posting as a channel) get the chat title as first name and their username.
+func applySender(msg *models.Message, from, peer tg.PeerClass, out, post bool, users []tg.UserClass, chats []tg.ChatClass) {
+	// Without from_id a channel post comes from the channel and an incoming
+	// private message from the other user
+	if from == nil {
+		switch p := peer.(type) {
+		case *tg.PeerChannel:
+			from = p
+		case *tg.PeerUser:
+			if !out {
+				from = p
+			}
+		}
+	}
+	if from == nil {
+		return
+	}
+
+	id, senderType := PeerInfo(from)
+	msg.FromID = id
+	msg.SenderType = senderType
+
+	if senderType == "user" {
+		if user := FindUser(users, id); user != nil {
+			msg.FromUsername = user.Username
+			msg.FromFirstName = user.FirstName
+			msg.FromLastName = user.LastName
+		}
+		return
+	}
+
+	msg.FromFirstName = findChatTitle(chats, id)
+	msg.FromUsername = findChatUsername(chats, id)
+	// 超级群组以群组自身身份发送的消息来自匿名管理员
+	if p, ok := peer.(*tg.PeerChannel); ok && p.ChannelID == id && !post && isMegagroup(chats, id) {
+		msg.SenderType = "anonymous_admin"
+	}
+}
+
+func findChatUsername(chats []tg.ChatClass, id int64) string {
+	for _, c := range chats {
+		if channel, ok := c.(*tg.Channel); ok && channel.ID == id {
+			return channel.Username
+		}
+	}
+	return ""
+}
+
+func isMegagroup(chats []tg.ChatClass, id int64) bool {
+	for _, c := range chats {
+		if channel, ok := c.(*tg.Channel); ok && channel.ID == id {
+			return channel.Megagroup
+		}
+	}
+	return false
+}
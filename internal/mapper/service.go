@@ -0,0 +1,316 @@
+package mapper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+// parseServiceMessage converts a service message such as a member joining or
+// a title change. The action is kept structured and Content gets a readable
+// description of it, e.g. "Alice added Bob".
+func parseServiceMessage(msg *tg.MessageService, users []tg.UserClass, chats []tg.ChatClass) models.Message {
+	parsed := models.Message{
+		MessageID:   msg.ID,
+		MessageType: "service",
+		IsOut:       msg.Out,
+		Mentioned:   msg.Mentioned,
+		Timestamp:   time.Unix(int64(msg.Date), 0),
+	}
+
+	applySender(&parsed, msg.FromID, msg.PeerID, msg.Out, msg.Post, users, chats)
+
+	action := parseAction(msg, users)
+	action.ActorID = parsed.FromID
+	action.Actor = strings.TrimSpace(parsed.FromFirstName + " " + parsed.FromLastName)
+	if action.Actor == "" && parsed.FromUsername != "" {
+		action.Actor = "@" + parsed.FromUsername
+	}
+
+	actor := action.Actor
+	if actor == "" {
+		actor = "Someone"
+		if msg.Post {
+			actor = "The channel"
+		}
+	}
+
+	// A pinned message is referenced by the action, not as a reply
+	if action.Type != "pin_message" {
+		applyReplyHeader(&parsed, msg.ReplyTo)
+	}
+	// The message creating a topic is the first message of that topic
+	if action.Type == "topic_created" {
+		parsed.TopicID = msg.ID
+	}
+
+	parsed.Action = action
+	parsed.Content = DescribeAction(action, actor)
+	return parsed
+}
+
+// parseAction maps a Telegram message action to its stored form
+func parseAction(msg *tg.MessageService, users []tg.UserClass) *models.MessageAction {
+	action := &models.MessageAction{Type: "unknown"}
+
+	switch a := msg.Action.(type) {
+	case *tg.MessageActionChatCreate:
+		action.Type = "create_group"
+		action.Title = a.Title
+		action.UserIDs = a.Users
+	case *tg.MessageActionChannelCreate:
+		action.Type = "create_channel"
+		action.Title = a.Title
+	case *tg.MessageActionChatEditTitle:
+		action.Type = "edit_group_title"
+		action.Title = a.Title
+	case *tg.MessageActionChatEditPhoto:
+		action.Type = "edit_group_photo"
+	case *tg.MessageActionChatDeletePhoto:
+		action.Type = "delete_group_photo"
+	case *tg.MessageActionChatAddUser:
+		action.Type = "invite_members"
+		action.UserIDs = a.Users
+	case *tg.MessageActionChatDeleteUser:
+		action.Type = "remove_members"
+		action.UserIDs = []int64{a.UserID}
+	case *tg.MessageActionChatJoinedByLink:
+		action.Type = "join_group_by_link"
+		action.InviterID = a.InviterID
+	case *tg.MessageActionChatJoinedByRequest:
+		action.Type = "join_group_by_request"
+	case *tg.MessageActionChatMigrateTo:
+		action.Type = "migrate_to_supergroup"
+		action.PeerID = a.ChannelID
+	case *tg.MessageActionChannelMigrateFrom:
+		action.Type = "migrate_from_group"
+		action.Title = a.Title
+		action.PeerID = a.ChatID
+	case *tg.MessageActionPinMessage:
+		action.Type = "pin_message"
+		if header, ok := msg.ReplyTo.(*tg.MessageReplyHeader); ok {
+			action.MessageID = header.ReplyToMsgID
+		}
+	case *tg.MessageActionHistoryClear:
+		action.Type = "clear_history"
+	case *tg.MessageActionPhoneCall:
+		action.Type = "phone_call"
+		action.Video = a.Video
+		action.Duration = a.Duration
+		action.Reason = discardReason(a.Reason)
+	case *tg.MessageActionGroupCall:
+		action.Type = "group_call"
+		action.Duration = a.Duration
+	case *tg.MessageActionInviteToGroupCall:
+		action.Type = "invite_to_group_call"
+		action.UserIDs = a.Users
+	case *tg.MessageActionGroupCallScheduled:
+		action.Type = "group_call_scheduled"
+		action.Schedule = int64(a.ScheduleDate)
+	case *tg.MessageActionScreenshotTaken:
+		action.Type = "take_screenshot"
+	case *tg.MessageActionCustomAction:
+		action.Type = "custom_action"
+		action.Text = a.Message
+	case *tg.MessageActionSetMessagesTTL:
+		action.Type = "set_messages_ttl"
+		action.Period = a.Period
+	case *tg.MessageActionSetChatTheme:
+		action.Type = "edit_chat_theme"
+		action.Text = a.Emoticon
+	case *tg.MessageActionTopicCreate:
+		action.Type = "topic_created"
+		action.Title = a.Title
+	case *tg.MessageActionTopicEdit:
+		action.Type = "topic_edit"
+		action.Title = a.Title
+		if closed, ok := a.GetClosed(); ok {
+			action.Text = "reopened"
+			if closed {
+				action.Text = "closed"
+			}
+		}
+	case *tg.MessageActionContactSignUp:
+		action.Type = "joined_telegram"
+	case *tg.MessageActionGameScore:
+		action.Type = "score_in_game"
+		action.Score = a.Score
+	case *tg.MessageActionPaymentSent:
+		action.Type = "send_payment"
+		action.Text = fmt.Sprintf("%d.%02d %s", a.TotalAmount/100, a.TotalAmount%100, a.Currency)
+	}
+
+	for _, id := range action.UserIDs {
+		action.Members = append(action.Members, userDisplayName(FindUser(users, id), id))
+	}
+
+	return action
+}
+
+// DescribeAction renders a service action as a readable sentence. actor is
+// the display name of whoever performed it.
+func DescribeAction(action *models.MessageAction, actor string) string {
+	members := strings.Join(action.Members, ", ")
+
+	switch action.Type {
+	case "create_group":
+		return fmt.Sprintf("%s created group «%s»", actor, action.Title)
+	case "create_channel":
+		return fmt.Sprintf("Channel «%s» created", action.Title)
+	case "edit_group_title":
+		return fmt.Sprintf("%s changed the title to «%s»", actor, action.Title)
+	case "edit_group_photo":
+		return fmt.Sprintf("%s changed the photo", actor)
+	case "delete_group_photo":
+		return fmt.Sprintf("%s removed the photo", actor)
+	case "invite_members":
+		if isSelfAction(action) {
+			return fmt.Sprintf("%s joined the group", actor)
+		}
+		return fmt.Sprintf("%s added %s", actor, members)
+	case "remove_members":
+		if isSelfAction(action) {
+			return fmt.Sprintf("%s left the group", actor)
+		}
+		return fmt.Sprintf("%s removed %s", actor, members)
+	case "join_group_by_link":
+		return fmt.Sprintf("%s joined the group via invite link", actor)
+	case "join_group_by_request":
+		return fmt.Sprintf("%s was accepted into the group", actor)
+	case "migrate_to_supergroup":
+		return "Group converted to a supergroup"
+	case "migrate_from_group":
+		return fmt.Sprintf("Supergroup created from group «%s»", action.Title)
+	case "pin_message":
+		return fmt.Sprintf("%s pinned a message", actor)
+	case "clear_history":
+		return "History cleared"
+	case "phone_call":
+		call := "Call"
+		if action.Video {
+			call = "Video call"
+		}
+		switch action.Reason {
+		case "missed":
+			return "Missed " + strings.ToLower(call)
+		case "busy":
+			return "Declined " + strings.ToLower(call)
+		}
+		if action.Duration > 0 {
+			return fmt.Sprintf("%s (%s)", call, formatDuration(action.Duration))
+		}
+		return call
+	case "group_call":
+		if action.Duration > 0 {
+			return fmt.Sprintf("Video chat ended (%s)", formatDuration(action.Duration))
+		}
+		return fmt.Sprintf("%s started a video chat", actor)
+	case "invite_to_group_call":
+		return fmt.Sprintf("%s invited %s to the video chat", actor, members)
+	case "group_call_scheduled":
+		return fmt.Sprintf("%s scheduled a video chat for %s", actor,
+			time.Unix(action.Schedule, 0).Format("2006-01-02 15:04"))
+	case "take_screenshot":
+		return fmt.Sprintf("%s took a screenshot", actor)
+	case "custom_action":
+		return action.Text
+	case "set_messages_ttl":
+		if action.Period == 0 {
+			return fmt.Sprintf("%s disabled auto-delete", actor)
+		}
+		return fmt.Sprintf("%s set messages to auto-delete after %s", actor, formatDuration(action.Period))
+	case "edit_chat_theme":
+		if action.Text == "" {
+			return fmt.Sprintf("%s disabled the chat theme", actor)
+		}
+		return fmt.Sprintf("%s changed the chat theme to %s", actor, action.Text)
+	case "topic_created":
+		return fmt.Sprintf("%s created topic «%s»", actor, action.Title)
+	case "topic_edit":
+		if action.Text != "" {
+			return fmt.Sprintf("%s %s the topic", actor, action.Text)
+		}
+		return fmt.Sprintf("%s renamed the topic to «%s»", actor, action.Title)
+	case "joined_telegram":
+		return fmt.Sprintf("%s joined Telegram", actor)
+	case "score_in_game":
+		return fmt.Sprintf("%s scored %d", actor, action.Score)
+	case "send_payment":
+		return fmt.Sprintf("Payment of %s sent", action.Text)
+	default:
+		return "[Service message]"
+	}
+}
+
+// isSelfAction reports whether the actor is the only member the action
+// applies to, e.g. a user joining or leaving by themselves
+func isSelfAction(action *models.MessageAction) bool {
+	if len(action.UserIDs) == 1 {
+		return action.UserIDs[0] == action.ActorID
+	}
+	return len(action.Members) == 1 && action.Members[0] == action.Actor
+}
+
+func discardReason(reason tg.PhoneCallDiscardReasonClass) string {
+	switch reason.(type) {
+	case *tg.PhoneCallDiscardReasonMissed:
+		return "missed"
+	case *tg.PhoneCallDiscardReasonBusy:
+		return "busy"
+	case *tg.PhoneCallDiscardReasonHangup:
+		return "hangup"
+	case *tg.PhoneCallDiscardReasonDisconnect:
+		return "disconnect"
+	default:
+		return ""
+	}
+}
+
+// formatDuration renders seconds as e.g. "1h 5m", "3m 20s" or "2 days"
+func formatDuration(seconds int) string {
+	d := time.Duration(seconds) * time.Second
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		days := int(d / (24 * time.Hour))
+		if days == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", days)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm %ds", int(d/time.Minute), int(d%time.Minute/time.Second))
+	default:
+		return fmt.Sprintf("%ds", seconds)
+	}
+}
+
+// FindUser returns the user with the given ID among users sent along with a
+// response
+func FindUser(users []tg.UserClass, id int64) *tg.User {
+	for _, u := range users {
+		if user, ok := u.(*tg.User); ok && user.ID == id {
+			return user
+		}
+	}
+	return nil
+}
+
+// userDisplayName returns the full name of a user, falling back to the
+// username and then the ID
+func userDisplayName(user *tg.User, id int64) string {
+	if user == nil {
+		return fmt.Sprintf("%d", id)
+	}
+	if name := strings.TrimSpace(user.FirstName + " " + user.LastName); name != "" {
+		return name
+	}
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	return fmt.Sprintf("%d", id)
+}
@@ -0,0 +1,111 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestServiceMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     tg.PeerClass
+		post     bool
+		action   tg.MessageActionClass
+		replyTo  tg.MessageReplyHeaderClass
+		wantType string
+		wantText string
+	}{
+		{"create group", &tg.PeerUser{UserID: 1}, false, &tg.MessageActionChatCreate{Title: "Team", Users: []int64{1, 2}},
+			nil, "create_group", "Ann Lee created group «Team»"},
+		{"add members", &tg.PeerUser{UserID: 1}, false, &tg.MessageActionChatAddUser{Users: []int64{2, 3}},
+			nil, "invite_members", "Ann Lee added @bob, 3"},
+		{"join", &tg.PeerUser{UserID: 2}, false, &tg.MessageActionChatAddUser{Users: []int64{2}},
+			nil, "invite_members", "@bob joined the group"},
+		{"leave", &tg.PeerUser{UserID: 2}, false, &tg.MessageActionChatDeleteUser{UserID: 2},
+			nil, "remove_members", "@bob left the group"},
+		{"channel post", nil, true, &tg.MessageActionChatEditTitle{Title: "New"},
+			nil, "edit_group_title", "News changed the title to «New»"},
+		{"pin", &tg.PeerUser{UserID: 1}, false, &tg.MessageActionPinMessage{},
+			&tg.MessageReplyHeader{ReplyToMsgID: 9}, "pin_message", "Ann Lee pinned a message"},
+		{"missed call", &tg.PeerUser{UserID: 1}, false, &tg.MessageActionPhoneCall{Reason: &tg.PhoneCallDiscardReasonMissed{}},
+			nil, "phone_call", "Missed call"},
+		{"video call", &tg.PeerUser{UserID: 1}, false, &tg.MessageActionPhoneCall{Video: true, Duration: 65},
+			nil, "phone_call", "Video call (1m 5s)"},
+		{"auto-delete", &tg.PeerUser{UserID: 1}, false, &tg.MessageActionSetMessagesTTL{Period: 86400},
+			nil, "set_messages_ttl", "Ann Lee set messages to auto-delete after 1 day"},
+		{"topic closed", &tg.PeerUser{UserID: 1}, false, func() tg.MessageActionClass {
+			a := &tg.MessageActionTopicEdit{}
+			a.SetClosed(true)
+			return a
+		}(), nil, "topic_edit", "Ann Lee closed the topic"},
+		{"unsupported", &tg.PeerUser{UserID: 1}, false, &tg.MessageActionEmpty{},
+			nil, "unknown", "[Service message]"},
+	}
+
+	m := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &tg.MessageService{ID: 10, PeerID: &tg.PeerChannel{ChannelID: 100}, Post: tt.post, Action: tt.action}
+			if tt.from != nil {
+				msg.SetFromID(tt.from)
+			}
+			if tt.replyTo != nil {
+				msg.SetReplyTo(tt.replyTo)
+			}
+			got, ok := m.Message(msg, testUsers, testChats)
+			if !ok {
+				t.Fatal("message skipped")
+			}
+			if got.MessageType != "service" || got.Action == nil {
+				t.Fatalf("type = %q, action = %v", got.MessageType, got.Action)
+			}
+			if got.Action.Type != tt.wantType {
+				t.Errorf("action = %q, want %q", got.Action.Type, tt.wantType)
+			}
+			if got.Content != tt.wantText {
+				t.Errorf("content = %q, want %q", got.Content, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestServiceMessageHeaders(t *testing.T) {
+	m := New()
+
+	// Without a sender the action still gets a subject
+	photo := &tg.MessageService{ID: 9, PeerID: &tg.PeerChat{ChatID: 300}, Action: &tg.MessageActionChatDeletePhoto{}}
+	got, _ := m.Message(photo, nil, nil)
+	if got.Content != "Someone removed the photo" {
+		t.Errorf("content = %q", got.Content)
+	}
+
+	pin := &tg.MessageService{ID: 10, PeerID: &tg.PeerChat{ChatID: 300}, Action: &tg.MessageActionPinMessage{}}
+	pin.SetReplyTo(&tg.MessageReplyHeader{ReplyToMsgID: 9})
+	got, _ = m.Message(pin, nil, nil)
+	if got.ReplyToMsgID != 0 || got.Action.MessageID != 9 {
+		t.Errorf("pin: reply to %d, action message %d", got.ReplyToMsgID, got.Action.MessageID)
+	}
+
+	topic := &tg.MessageService{ID: 11, PeerID: &tg.PeerChannel{ChannelID: 200}, Action: &tg.MessageActionTopicCreate{Title: "Ideas"}}
+	got, _ = m.Message(topic, nil, nil)
+	if got.TopicID != 11 {
+		t.Errorf("topic = %d, want 11", got.TopicID)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[int]string{
+		5:      "5s",
+		200:    "3m 20s",
+		3900:   "1h 5m",
+		86400:  "1 day",
+		604800: "7 days",
+		90000:  "25h 0m",
+	}
+	for seconds, want := range tests {
+		if got := formatDuration(seconds); got != want {
+			t.Errorf("formatDuration(%d) = %q, want %q", seconds, got, want)
+		}
+	}
+}
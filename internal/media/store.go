@@ -0,0 +1,123 @@
+package media
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// scheme prefixes media_url values that point into the local store. Anything
+// else (e.g. telegram://photo/...) is a placeholder for a file not on disk.
+const scheme = "media://"
+
+// placeholderScheme prefixes the placeholders of files that can be downloaded,
+// telegram://<photo|document>/<file ID>_<size in bytes>
+const placeholderScheme = "telegram://"
+
+// Store keeps media files on local disk under Root
+type Store struct {
+	Root string
+}
+
+func NewStore(root string) *Store {
+	return &Store{Root: root}
+}
+
+// URL returns the media_url for a file stored at the given relative path
+func URL(rel string) string {
+	return scheme + path.Clean(filepath.ToSlash(rel))
+}
+
+// IsLocal reports whether mediaURL refers to a file in the store
+func IsLocal(mediaURL string) bool {
+	return strings.HasPrefix(mediaURL, scheme)
+}
+
+// ParsePlaceholder returns the size in bytes of the file a placeholder stands
+// for, ok is false for anything that is not a downloadable placeholder
+func ParsePlaceholder(mediaURL string) (size int64, ok bool) {
+	rest := strings.TrimPrefix(mediaURL, placeholderScheme)
+	if rest == mediaURL {
+		return 0, false
+	}
+	kind, file, found := strings.Cut(rest, "/")
+	if !found || (kind != "photo" && kind != "document") {
+		return 0, false
+	}
+	_, sizeText, found := strings.Cut(file, "_")
+	if !found {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(sizeText, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return size, true
+}
+
+// FileName returns the name the file of a placeholder is stored under
+func FileName(placeholder string) string {
+	name := strings.ReplaceAll(strings.TrimPrefix(placeholder, placeholderScheme), "/", "_")
+	if strings.HasPrefix(name, "photo_") {
+		name += ".jpg"
+	}
+	return name
+}
+
+// Path resolves a media URL to a file on disk. ok is false for placeholders
+// and for files that are missing from the store.
+func (s *Store) Path(mediaURL string) (string, bool) {
+	if !IsLocal(mediaURL) {
+		return "", false
+	}
+
+	rel := path.Clean("/" + strings.TrimPrefix(mediaURL, scheme))
+	p := filepath.Join(s.Root, filepath.FromSlash(rel))
+	if info, err := os.Stat(p); err != nil || info.IsDir() {
+		return "", false
+	}
+
+	return p, true
+}
+
+// Save writes r into the store as <userID>/<convID>/<name> and returns its media URL
+func (s *Store) Save(userID, convID int64, name string, r io.Reader) (string, error) {
+	base := path.Base("/" + filepath.ToSlash(name))
+	if base == "/" || base == "." {
+		return "", fmt.Errorf("invalid media file name %q", name)
+	}
+
+	rel := path.Join(fmt.Sprint(userID), fmt.Sprint(convID), base)
+	p := filepath.Join(s.Root, filepath.FromSlash(rel))
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", fmt.Errorf("failed to create media directory: %v", err)
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to create media file: %v", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(p)
+		return "", fmt.Errorf("failed to write media file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write media file: %v", err)
+	}
+
+	return URL(rel), nil
+}
+
+// RemoveUser deletes every file stored for a user, including avatars
+func (s *Store) RemoveUser(userID int64) error {
+	if err := os.RemoveAll(filepath.Join(s.Root, fmt.Sprint(userID))); err != nil {
+		return fmt.Errorf("failed to remove media of user %d: %v", userID, err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package models
+
+import "strings"
+
+// placeholders are stored as content for media messages without a caption
+var placeholders = map[string]bool{
+	"[Photo]":    true,
+	"[Video]":    true,
+	"[Audio]":    true,
+	"[Image]":    true,
+	"[GIF]":      true,
+	"[Sticker]":  true,
+	"[Document]": true,
+	"[Webpage]":  true,
+	"[Contact]":  true,
+	"[Location]": true,
+	"[Poll]":     true,
+	"[Album]":    true,
+}
+
+// Text returns the text the sender wrote, without the media placeholder and
+// file name line that sync adds to the stored content
+func (m *Message) Text() string {
+	text := m.Content
+	if m.MessageType != "text" {
+		if i := strings.LastIndex(text, "\n📁 "); i >= 0 {
+			text = text[:i]
+		}
+	}
+	if placeholders[text] {
+		return ""
+	}
+	return text
+}
@@ -0,0 +1,37 @@
+package models
+
+// DiffMembers compares the members of two snapshots. Members whose role or
+// rank changed are listed with their new role.
+func DiffMembers(from, to *ChatSnapshot) MemberDiff {
+	diff := MemberDiff{
+		From:        from.CapturedAt,
+		To:          to.CapturedAt,
+		Joined:      []ChatMember{},
+		Left:        []ChatMember{},
+		RoleChanged: []ChatMember{},
+	}
+
+	before := make(map[int64]ChatMember, len(from.Members))
+	for _, m := range from.Members {
+		before[m.UserID] = m
+	}
+
+	for _, m := range to.Members {
+		old, ok := before[m.UserID]
+		switch {
+		case !ok:
+			diff.Joined = append(diff.Joined, m)
+		case old.Role != m.Role || old.Rank != m.Rank:
+			diff.RoleChanged = append(diff.RoleChanged, m)
+		}
+		delete(before, m.UserID)
+	}
+
+	for _, m := range from.Members {
+		if _, ok := before[m.UserID]; ok {
+			diff.Left = append(diff.Left, m)
+		}
+	}
+
+	return diff
+}
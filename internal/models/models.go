@@ -23,9 +23,21 @@ type Conversation struct {
 	Title       string    `json:"title" db:"title"`
 	Username    string    `json:"username" db:"username"`
 	AvatarURL   string    `json:"avatar_url" db:"avatar_url"`
+	PhotoID     int64     `json:"photo_id,omitempty" db:"photo_id"` // 当前头像的照片ID
 	AccessHash  string    `json:"access_hash" db:"access_hash"`
 	LastMessage string    `json:"last_message" db:"last_message"`
 	LastTime    time.Time `json:"last_time" db:"last_time"`
+	LastMessageID  int    `json:"last_message_id,omitempty" db:"last_message_id"`
+	LastSenderID   int64  `json:"last_sender_id,omitempty" db:"last_sender_id"`
+	LastSenderName string `json:"last_sender_name,omitempty" db:"last_sender_name"`
+	UnreadCount         int `json:"unread_count" db:"unread_count"`
+	UnreadMentionsCount int `json:"unread_mentions_count" db:"unread_mentions_count"`
+	FolderID    int       `json:"folder_id,omitempty" db:"folder_id"` // 0 为主列表, 1 为归档
+	IsForum     bool      `json:"is_forum" db:"is_forum"` // 开启了话题的超级群组
+	LinkedChatID int64    `json:"linked_chat_id,omitempty" db:"linked_chat_id"` // 频道关联的讨论组
+	About             string `json:"about,omitempty" db:"about"` // 群组或频道简介
+	ParticipantsCount int    `json:"participants_count,omitempty" db:"participants_count"`
+	InviteLink        string `json:"invite_link,omitempty" db:"invite_link"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -39,13 +51,305 @@ type Message struct {
 	FromUsername   string    `json:"from_username" db:"from_username"`
 	FromFirstName  string    `json:"from_first_name" db:"from_first_name"`
 	FromLastName   string    `json:"from_last_name" db:"from_last_name"`
+	SenderType     string    `json:"sender_type,omitempty" db:"sender_type"` // user, channel, chat, anonymous_admin
+	PostAuthor     string    `json:"post_author,omitempty" db:"post_author"` // 频道签名或匿名管理员的头衔
+	ViaBotID       int64     `json:"via_bot_id,omitempty" db:"via_bot_id"`   // 通过 inline 机器人发送
 	Content        string    `json:"content" db:"content"`
 	MessageType    string    `json:"message_type" db:"message_type"` // text, photo, video, document, etc.
 	MediaURL       string    `json:"media_url" db:"media_url"`
+	Entities       []MessageEntity `json:"entities,omitempty" db:"entities"` // 格式化实体, 以JSON存储
+	Action         *MessageAction `json:"action,omitempty" db:"action"` // 服务消息的动作, message_type 为 service
+	ReplyToMsgID   int        `json:"reply_to_msg_id,omitempty" db:"reply_to_msg_id"`
+	ReplyToTopID   int        `json:"reply_to_top_id,omitempty" db:"reply_to_top_id"`   // 所在话题/评论串的顶层消息
+	ReplyToPeerID  int64      `json:"reply_to_peer_id,omitempty" db:"reply_to_peer_id"` // 回复其他会话的消息时的会话ID
+	ReplyQuote     string     `json:"reply_quote,omitempty" db:"reply_quote"`           // 引用的原文片段
+	FwdFromID      int64      `json:"fwd_from_id,omitempty" db:"fwd_from_id"`
+	FwdFromType    string     `json:"fwd_from_type,omitempty" db:"fwd_from_type"` // user, chat, channel
+	FwdFromName    string     `json:"fwd_from_name,omitempty" db:"fwd_from_name"`
+	FwdChannelPost int        `json:"fwd_channel_post,omitempty" db:"fwd_channel_post"` // 原频道消息ID
+	FwdPostAuthor  string     `json:"fwd_post_author,omitempty" db:"fwd_post_author"`
+	FwdDate        *time.Time `json:"fwd_date,omitempty" db:"fwd_date"` // 原消息发送时间
+	TopicID        int        `json:"topic_id,omitempty" db:"topic_id"` // 所属论坛话题, 0 表示不属于话题
+	GroupedID      int64      `json:"grouped_id,omitempty" db:"grouped_id"` // 同一相册的消息共享此ID
+	MediaData      *MediaData `json:"media_data,omitempty" db:"media_data"` // 投票、联系人、位置、网页预览的结构化数据, 以JSON存储
+	Views          int        `json:"views,omitempty" db:"views"`         // 频道消息的浏览量
+	Forwards       int        `json:"forwards,omitempty" db:"forwards"`   // 转发次数
+	RepliesCount   int        `json:"replies_count,omitempty" db:"replies_count"` // 回复或评论数
+	Reactions      []Reaction `json:"reactions,omitempty" db:"reactions"` // 以JSON存储
+	IsPinned       bool       `json:"is_pinned,omitempty" db:"is_pinned"`
+	IsOut          bool       `json:"is_out,omitempty" db:"is_out"`         // 由当前账号发出
+	Mentioned      bool       `json:"mentioned,omitempty" db:"mentioned"`   // 提及了当前账号或回复了它的消息
+	Album          []AlbumItem `json:"album,omitempty" db:"-"` // 合并后的相册中的媒体, message_type 为 album
+	Formatted      string    `json:"formatted,omitempty" db:"-"`     // 按请求渲染的 HTML/Markdown
 	Timestamp      time.Time `json:"timestamp" db:"timestamp"`
 	CreatedAt      time.Time `json:"created_at" db:"created_at"`
 }
 
+// MessageEntity marks formatting or a link inside a message text. Offset and
+// Length count UTF-16 code units, as Telegram sends them.
+type MessageEntity struct {
+	Type       string `json:"type"` // bold, italic, underline, strikethrough, code, pre, text_link, link, mention, mention_name, hashtag, cashtag, bot_command, email, phone, spoiler, custom_emoji, blockquote, bank_card
+	Offset     int    `json:"offset"`
+	Length     int    `json:"length"`
+	URL        string `json:"url,omitempty"`
+	UserID     int64  `json:"user_id,omitempty"`
+	Language   string `json:"language,omitempty"`
+	DocumentID int64  `json:"document_id,omitempty"`
+}
+
+// Reaction is one kind of reaction on a message and how often it was given.
+// Custom emoji reactions only have DocumentID.
+type Reaction struct {
+	Emoji      string `json:"emoji,omitempty"`
+	DocumentID int64  `json:"document_id,omitempty"`
+	Count      int    `json:"count"`
+	Chosen     bool   `json:"chosen,omitempty"` // 当前账号添加了此反应
+}
+
+// MessageStats is a snapshot of the engagement counters of a message
+type MessageStats struct {
+	UserID         int64      `json:"user_id" db:"user_id"`
+	ConversationID int64      `json:"conversation_id" db:"conversation_id"`
+	MessageID      int        `json:"message_id" db:"message_id"`
+	Views          int        `json:"views" db:"views"`
+	Forwards       int        `json:"forwards" db:"forwards"`
+	Replies        int        `json:"replies" db:"replies"`
+	Reactions      []Reaction `json:"reactions" db:"reactions"`
+	CapturedAt     time.Time  `json:"captured_at" db:"captured_at"`
+}
+
+// MediaData holds the content of media that has no file to download. Only
+// the field matching the message type is set.
+type MediaData struct {
+	Poll     *Poll     `json:"poll,omitempty"`
+	Contact  *Contact  `json:"contact,omitempty"`
+	Location *Location `json:"location,omitempty"`
+	WebPage  *WebPage  `json:"webpage,omitempty"`
+}
+
+type Poll struct {
+	ID             int64        `json:"id"`
+	Question       string       `json:"question"`
+	Answers        []PollAnswer `json:"answers"`
+	TotalVoters    int          `json:"total_voters"`
+	Closed         bool         `json:"closed"`
+	Quiz           bool         `json:"quiz"`
+	MultipleChoice bool         `json:"multiple_choice"`
+	PublicVoters   bool         `json:"public_voters"`
+	Solution       string       `json:"solution,omitempty"` // 测验的解析
+	CloseDate      int64        `json:"close_date,omitempty"`
+}
+
+type PollAnswer struct {
+	Text    string `json:"text"`
+	Option  []byte `json:"option"`
+	Voters  int    `json:"voters"`
+	Chosen  bool   `json:"chosen"`  // 当前账号选择了此项
+	Correct bool   `json:"correct"` // 测验的正确答案
+}
+
+type Contact struct {
+	PhoneNumber string `json:"phone_number"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	UserID      int64  `json:"user_id,omitempty"`
+	VCard       string `json:"vcard,omitempty"`
+}
+
+// Location is a point on the map, a venue when Title is set or a live
+// location when Live is set
+type Location struct {
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	AccuracyRadius int     `json:"accuracy_radius,omitempty"`
+	Title          string  `json:"title,omitempty"`
+	Address        string  `json:"address,omitempty"`
+	Provider       string  `json:"provider,omitempty"`
+	VenueID        string  `json:"venue_id,omitempty"`
+	VenueType      string  `json:"venue_type,omitempty"`
+	Live           bool    `json:"live,omitempty"`
+	Period         int     `json:"period,omitempty"` // 实时位置的共享时长(秒)
+	Heading        int     `json:"heading,omitempty"`
+}
+
+type WebPage struct {
+	URL         string `json:"url"`
+	DisplayURL  string `json:"display_url,omitempty"`
+	Type        string `json:"type,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Author      string `json:"author,omitempty"`
+	PhotoURL    string `json:"photo_url,omitempty"`
+	EmbedURL    string `json:"embed_url,omitempty"`
+	Duration    int    `json:"duration,omitempty"`
+}
+
+// AlbumItem is one media message of an album assembled into a single message
+type AlbumItem struct {
+	MessageID   int    `json:"message_id"`
+	MessageType string `json:"message_type"`
+	MediaURL    string `json:"media_url"`
+	Content     string `json:"content"`
+}
+
+// GeneralTopicID is the topic of forum messages that were not sent to a
+// specific topic
+const GeneralTopicID = 1
+
+// AccountContact is an entry of the account's contact book
+type AccountContact struct {
+	UserID    int64     `json:"user_id" db:"user_id"` // 账号ID
+	ContactID int64     `json:"contact_id" db:"contact_id"`
+	Phone     string    `json:"phone,omitempty" db:"phone"`
+	FirstName string    `json:"first_name" db:"first_name"`
+	LastName  string    `json:"last_name" db:"last_name"`
+	Username  string    `json:"username,omitempty" db:"username"`
+	Mutual    bool      `json:"mutual" db:"mutual"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Peer is a Telegram user seen by an account with the profile it has now.
+// KnownAs keeps the last name the user had, also after the account was
+// deleted.
+type Peer struct {
+	UserID    int64         `json:"user_id" db:"user_id"` // 账号ID
+	PeerID    int64         `json:"peer_id" db:"peer_id"`
+	Username  string        `json:"username,omitempty" db:"username"`
+	FirstName string        `json:"first_name,omitempty" db:"first_name"`
+	LastName  string        `json:"last_name,omitempty" db:"last_name"`
+	Phone     string        `json:"phone,omitempty" db:"phone"`
+	Bio       string        `json:"bio,omitempty" db:"bio"`
+	PhotoID   int64         `json:"photo_id,omitempty" db:"photo_id"`
+	AccessHash string       `json:"-" db:"access_hash"` // 下载头像用
+	IsBot     bool          `json:"is_bot" db:"is_bot"`
+	IsDeleted bool          `json:"is_deleted" db:"is_deleted"`
+	KnownAs   string        `json:"known_as,omitempty" db:"-"`
+	History   []PeerProfile `json:"history,omitempty" db:"-"`
+	FirstSeen time.Time     `json:"first_seen" db:"first_seen"`
+	LastSeen  time.Time     `json:"last_seen" db:"last_seen"`
+}
+
+// PeerProfile is the profile a user had from ChangedAt on
+type PeerProfile struct {
+	Username  string    `json:"username,omitempty" db:"username"`
+	FirstName string    `json:"first_name,omitempty" db:"first_name"`
+	LastName  string    `json:"last_name,omitempty" db:"last_name"`
+	Bio       string    `json:"bio,omitempty" db:"bio"`
+	PhotoID   int64     `json:"photo_id,omitempty" db:"photo_id"`
+	IsDeleted bool      `json:"is_deleted,omitempty" db:"is_deleted"`
+	ChangedAt time.Time `json:"changed_at" db:"changed_at"`
+}
+
+// Avatar is a profile photo of a user or chat stored in the media store.
+// Photos stay after they are removed on Telegram, IsCurrent marks the one
+// the owner has now.
+type Avatar struct {
+	UserID    int64      `json:"user_id" db:"user_id"`   // 账号ID
+	OwnerID   int64      `json:"owner_id" db:"owner_id"` // 头像所属的用户或群组/频道
+	PhotoID   int64      `json:"photo_id" db:"photo_id"`
+	MediaURL  string     `json:"media_url" db:"media_url"`
+	Date      *time.Time `json:"date,omitempty" db:"date"` // 设置头像的时间, 群组头像未知
+	IsCurrent bool       `json:"is_current" db:"is_current"`
+	FetchedAt time.Time  `json:"fetched_at" db:"fetched_at"`
+}
+
+// ChatSnapshot is the metadata and member list of a group or channel as it
+// was from CapturedAt until at least CheckedAt. A new snapshot is only taken
+// when something changed.
+type ChatSnapshot struct {
+	ID                int64        `json:"id" db:"id"`
+	UserID            int64        `json:"user_id" db:"user_id"`
+	ConversationID    int64        `json:"conversation_id" db:"conversation_id"`
+	Title             string       `json:"title" db:"title"`
+	About             string       `json:"about" db:"about"`
+	ParticipantsCount int          `json:"participants_count" db:"participants_count"`
+	LinkedChatID      int64        `json:"linked_chat_id,omitempty" db:"linked_chat_id"`
+	InviteLink        string       `json:"invite_link,omitempty" db:"invite_link"`
+	HasMembers        bool         `json:"has_members" db:"has_members"` // 无权获取成员列表时为false
+	Members           []ChatMember `json:"members,omitempty" db:"-"`
+	CapturedAt        time.Time    `json:"captured_at" db:"captured_at"`
+	CheckedAt         time.Time    `json:"checked_at" db:"checked_at"`
+}
+
+// ChatMember is a member of a group or channel in a snapshot
+type ChatMember struct {
+	UserID      int64      `json:"user_id" db:"member_id"`
+	Username    string     `json:"username,omitempty" db:"username"`
+	FirstName   string     `json:"first_name,omitempty" db:"first_name"`
+	LastName    string     `json:"last_name,omitempty" db:"last_name"`
+	Role        string     `json:"role" db:"role"` // creator, admin, member
+	Rank        string     `json:"rank,omitempty" db:"rank"`
+	AdminRights []string   `json:"admin_rights,omitempty" db:"admin_rights"` // 以JSON存储
+	InviterID   int64      `json:"inviter_id,omitempty" db:"inviter_id"`
+	JoinedAt    *time.Time `json:"joined_at,omitempty" db:"joined_at"`
+}
+
+// MemberDiff lists how the members of a chat changed between two snapshots
+type MemberDiff struct {
+	From        time.Time    `json:"from"`
+	To          time.Time    `json:"to"`
+	Joined      []ChatMember `json:"joined"`
+	Left        []ChatMember `json:"left"`
+	RoleChanged []ChatMember `json:"role_changed"` // 新的角色
+}
+
+// CommentThread links a channel post to its comments. Comments are messages of
+// the linked discussion group replying to RootMessageID, the group's copy of
+// the post.
+type CommentThread struct {
+	UserID        int64     `json:"user_id" db:"user_id"`
+	ChannelID     int64     `json:"channel_id" db:"channel_id"`
+	PostID        int       `json:"post_id" db:"post_id"`
+	DiscussionID  int64     `json:"discussion_id" db:"discussion_id"`
+	RootMessageID int       `json:"root_message_id" db:"root_message_id"` // 未获取到评论时为0
+	RepliesCount  int       `json:"replies_count" db:"replies_count"`     // 上次获取评论时的评论数
+	NewestID      int       `json:"newest_id" db:"newest_comment_id"`     // 已保存的最新评论
+	OldestID      int       `json:"oldest_id" db:"oldest_comment_id"`     // 已保存的最早评论, 回填从这里继续
+	Complete      bool      `json:"complete" db:"complete"`               // 已回填到第一条评论
+	SyncedAt      time.Time `json:"synced_at" db:"synced_at"`
+}
+
+// ForumTopic is a topic of a supergroup with topics enabled. TopicID is the ID
+// of the message that created the topic.
+type ForumTopic struct {
+	UserID         int64     `json:"user_id" db:"user_id"`
+	ConversationID int64     `json:"conversation_id" db:"conversation_id"`
+	TopicID        int       `json:"topic_id" db:"topic_id"`
+	Title          string    `json:"title" db:"title"`
+	IconColor      int       `json:"icon_color" db:"icon_color"`
+	IconEmojiID    int64     `json:"icon_emoji_id,omitempty" db:"icon_emoji_id"`
+	CreatorID      int64     `json:"creator_id,omitempty" db:"creator_id"`
+	IsClosed       bool      `json:"is_closed" db:"is_closed"`
+	IsHidden       bool      `json:"is_hidden" db:"is_hidden"`
+	IsPinned       bool      `json:"is_pinned" db:"is_pinned"`
+	TopMessage     int       `json:"top_message" db:"top_message"`
+	MessageCount   int       `json:"message_count" db:"-"` // 已备份的消息数
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// MessageAction describes a service message such as a member joining or a
+// pinned message. Type uses the action names of Telegram Desktop exports.
+type MessageAction struct {
+	Type      string   `json:"type"` // create_group, create_channel, edit_group_title, edit_group_photo, delete_group_photo, invite_members, remove_members, join_group_by_link, join_group_by_request, migrate_to_supergroup, migrate_from_group, pin_message, clear_history, phone_call, group_call, invite_to_group_call, group_call_scheduled, take_screenshot, custom_action, set_messages_ttl, edit_chat_theme, topic_created, topic_edit, joined_telegram, score_in_game, send_payment, unknown
+	ActorID   int64    `json:"actor_id,omitempty"`
+	Actor     string   `json:"actor,omitempty"`
+	Title     string   `json:"title,omitempty"`
+	UserIDs   []int64  `json:"user_ids,omitempty"`
+	Members   []string `json:"members,omitempty"` // UserIDs 的显示名称
+	InviterID int64    `json:"inviter_id,omitempty"`
+	MessageID int      `json:"message_id,omitempty"` // 被置顶的消息
+	PeerID    int64    `json:"peer_id,omitempty"`    // 迁移的目标/来源会话
+	Duration  int      `json:"duration,omitempty"`   // 通话时长(秒)
+	Reason    string   `json:"reason,omitempty"`     // 通话结束原因: missed, busy, hangup, disconnect
+	Video     bool     `json:"video,omitempty"`
+	Period    int      `json:"period,omitempty"` // 自动删除时间(秒)
+	Schedule  int64    `json:"schedule,omitempty"` // 预约语音聊天的Unix时间
+	Score     int      `json:"score,omitempty"`
+	Text      string   `json:"text,omitempty"`
+}
+
 type AuthSession struct {
 	ID           int       `json:"id" db:"id"`
 	UserID       int64     `json:"user_id" db:"user_id"`           // 关联的Telegram用户ID
@@ -55,6 +359,9 @@ type AuthSession struct {
 	AppID        int       `json:"app_id" db:"app_id"`
 	AppHash      string    `json:"app_hash" db:"app_hash"`
 	Phone        string    `json:"phone" db:"phone"`
+	LastCheckedAt time.Time `json:"last_checked_at" db:"last_checked_at"` // 最后一次健康检查时间
+	RevokedAt    time.Time `json:"revoked_at" db:"revoked_at"`     // session失效时间
+	RevokeReason string    `json:"revoke_reason" db:"revoke_reason"` // 失效原因, 如 AUTH_KEY_UNREGISTERED
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -64,4 +371,30 @@ type SyncStatus struct {
 	LastSyncTime     time.Time `json:"last_sync_time"`
 	TotalMessages    int       `json:"total_messages"`
 	TotalConversations int     `json:"total_conversations"`
-}
\ No newline at end of file
+}
+
+// SyncCursor is how much of a conversation's history is stored: every
+// message from OldestID up to NewestID. Complete is set once the backfill
+// reached the first message of the conversation.
+type SyncCursor struct {
+	UserID         int64     `json:"user_id" db:"user_id"`
+	ConversationID int64     `json:"conversation_id" db:"conversation_id"`
+	NewestID       int       `json:"newest_id" db:"newest_id"`
+	OldestID       int       `json:"oldest_id" db:"oldest_id"`
+	Complete       bool      `json:"complete" db:"complete"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ScheduleEntry is when a conversation of an account is synced next.
+// ConversationID 0 stands for the updates of the whole account.
+type ScheduleEntry struct {
+	UserID         int64     `json:"user_id" db:"user_id"`
+	ConversationID int64     `json:"conversation_id" db:"conversation_id"`
+	Title          string    `json:"title,omitempty" db:"-"`                 // 会话标题, 查询时从会话表读取
+	Interval       int       `json:"interval" db:"interval_seconds"`         // 秒
+	Rate           float64   `json:"rate" db:"rate"`                         // 最近24小时平均每小时的消息数
+	Priority       int       `json:"priority" db:"priority"`                 // 同步策略的优先级
+	NextRunAt      time.Time `json:"next_run_at" db:"next_run_at"`
+	LastRunAt      time.Time `json:"last_run_at,omitempty" db:"last_run_at"` // 从未运行时为零值
+	Failures       int       `json:"failures,omitempty" db:"failures"`       // 连续失败的次数, 失败后提前重试
+}
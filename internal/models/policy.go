@@ -0,0 +1,153 @@
+package models
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Backfill depths of a sync policy
+const (
+	BackfillAll      = "all"      // the whole history
+	BackfillDays     = "days"     // messages of the last BackfillLimit days
+	BackfillMessages = "messages" // the newest BackfillLimit messages
+	BackfillNone     = "none"     // the newest message at the first sync, then new ones only
+)
+
+// SyncPolicy decides how the conversations it matches are synced. Empty match
+// fields match any conversation. When several policies match, the most
+// specific one applies: a peer ID beats a username pattern, which beats a
+// folder, which beats a type; among equally specific ones the oldest wins.
+type SyncPolicy struct {
+	ID     int64  `json:"id" db:"id"`
+	UserID int64  `json:"user_id" db:"user_id"`
+	Name   string `json:"name" db:"name"`
+
+	PeerID          int64  `json:"peer_id,omitempty" db:"peer_id"`
+	PeerType        string `json:"peer_type,omitempty" db:"peer_type"`               // user, bot, group, channel
+	UsernamePattern string `json:"username_pattern,omitempty" db:"username_pattern"` // 不区分大小写的通配符, 如 *_news
+	FolderID        *int   `json:"folder_id,omitempty" db:"folder_id"`               // 0 为主列表, 1 为归档
+
+	Sync          bool     `json:"sync" db:"sync"`
+	Backfill      string   `json:"backfill" db:"backfill"`                       // all, days, messages, none
+	BackfillLimit int      `json:"backfill_limit,omitempty" db:"backfill_limit"` // 天数或消息数
+	MediaTypes    []string `json:"media_types,omitempty" db:"media_types"`       // 下载媒体的消息类型, 如 photo、video, 以JSON存储
+	MaxMediaSize  int64    `json:"max_media_size,omitempty" db:"max_media_size"` // 字节, 0 表示不限
+	Priority      int      `json:"priority" db:"priority"`                       // 越大越先同步
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// DefaultSyncPolicy applies to conversations no policy matches: the whole
+// history is synced, no media is downloaded
+var DefaultSyncPolicy = SyncPolicy{Name: "default", Sync: true, Backfill: BackfillAll}
+
+// Validate checks the rules of a policy and fills in the default backfill
+func (p *SyncPolicy) Validate() error {
+	switch p.PeerType {
+	case "", "user", "bot", "group", "channel":
+	default:
+		return fmt.Errorf("unknown peer type %q", p.PeerType)
+	}
+	if _, err := path.Match(p.UsernamePattern, ""); err != nil {
+		return fmt.Errorf("invalid username pattern %q", p.UsernamePattern)
+	}
+
+	if p.Backfill == "" {
+		p.Backfill = BackfillAll
+	}
+	switch p.Backfill {
+	case BackfillAll, BackfillNone:
+	case BackfillDays, BackfillMessages:
+		if p.BackfillLimit <= 0 {
+			return fmt.Errorf("backfill %s needs a positive backfill_limit", p.Backfill)
+		}
+	default:
+		return fmt.Errorf("unknown backfill %q", p.Backfill)
+	}
+
+	if p.MaxMediaSize < 0 {
+		return fmt.Errorf("max_media_size must not be negative")
+	}
+	return nil
+}
+
+// Matches reports whether the policy applies to a conversation
+func (p *SyncPolicy) Matches(conv *Conversation) bool {
+	if p.PeerID != 0 && p.PeerID != conv.ID {
+		return false
+	}
+	if p.PeerType != "" && p.PeerType != conv.Type {
+		return false
+	}
+	if p.UsernamePattern != "" {
+		ok, _ := path.Match(strings.ToLower(p.UsernamePattern), strings.ToLower(conv.Username))
+		if !ok || conv.Username == "" {
+			return false
+		}
+	}
+	if p.FolderID != nil && *p.FolderID != conv.FolderID {
+		return false
+	}
+	return true
+}
+
+func (p *SyncPolicy) specificity() int {
+	score := 0
+	if p.PeerID != 0 {
+		score += 8
+	}
+	if p.UsernamePattern != "" {
+		score += 4
+	}
+	if p.FolderID != nil {
+		score += 2
+	}
+	if p.PeerType != "" {
+		score++
+	}
+	return score
+}
+
+// MatchSyncPolicy returns the policy of a conversation among the policies of
+// its account, ordered oldest first as GetSyncPolicies returns them
+func MatchSyncPolicy(policies []SyncPolicy, conv *Conversation) SyncPolicy {
+	best := -1
+	for i := range policies {
+		if !policies[i].Matches(conv) {
+			continue
+		}
+		if best < 0 || policies[i].specificity() > policies[best].specificity() {
+			best = i
+		}
+	}
+	if best < 0 {
+		return DefaultSyncPolicy
+	}
+	return policies[best]
+}
+
+// AllowsMedia reports whether the media of a message of the given type and
+// size in bytes is downloaded. An unknown size of 0 passes the size cap.
+func (p *SyncPolicy) AllowsMedia(messageType string, size int64) bool {
+	if p.MaxMediaSize > 0 && size > p.MaxMediaSize {
+		return false
+	}
+	for _, t := range p.MediaTypes {
+		if t == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+// Since returns the oldest message time a days backfill keeps, zero for the
+// other depths
+func (p *SyncPolicy) Since(now time.Time) time.Time {
+	if p.Backfill != BackfillDays {
+		return time.Time{}
+	}
+	return now.AddDate(0, 0, -p.BackfillLimit)
+}
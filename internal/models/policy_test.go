@@ -0,0 +1,58 @@
+package models
+
+import "testing"
+
+func TestMatchSyncPolicy(t *testing.T) {
+	archive := 1
+	policies := []SyncPolicy{
+		{ID: 1, PeerType: "channel", Priority: 1},
+		{ID: 2, UsernamePattern: "*_NEWS"},
+		{ID: 3, FolderID: &archive},
+		{ID: 4, PeerID: 42},
+		{ID: 5, PeerType: "channel", Priority: 2},
+	}
+
+	tests := []struct {
+		name string
+		conv Conversation
+		want int64
+	}{
+		{"no match", Conversation{ID: 1, Type: "user"}, 0},
+		{"oldest of equally specific", Conversation{ID: 2, Type: "channel"}, 1},
+		{"username over type", Conversation{ID: 3, Type: "channel", Username: "daily_news"}, 2},
+		{"username over folder", Conversation{ID: 4, Type: "channel", Username: "daily_news", FolderID: 1}, 2},
+		{"folder over type", Conversation{ID: 5, Type: "channel", FolderID: 1}, 3},
+		{"peer over all", Conversation{ID: 42, Type: "channel", Username: "daily_news", FolderID: 1}, 4},
+		{"pattern needs a username", Conversation{ID: 6, Type: "group"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MatchSyncPolicy(policies, &tt.conv)
+			if got.ID != tt.want {
+				t.Errorf("policy %d, want %d", got.ID, tt.want)
+			}
+			if tt.want == 0 && !got.Sync {
+				t.Error("default policy does not sync")
+			}
+		})
+	}
+}
+
+func TestAllowsMedia(t *testing.T) {
+	p := SyncPolicy{MediaTypes: []string{"photo", "video"}, MaxMediaSize: 1000}
+	tests := []struct {
+		kind string
+		size int64
+		want bool
+	}{
+		{"photo", 500, true},
+		{"video", 1001, false},
+		{"video", 0, true},
+		{"document", 10, false},
+	}
+	for _, tt := range tests {
+		if got := p.AllowsMedia(tt.kind, tt.size); got != tt.want {
+			t.Errorf("AllowsMedia(%s, %d) = %v", tt.kind, tt.size, got)
+		}
+	}
+}
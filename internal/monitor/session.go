@@ -0,0 +1,181 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram"
+)
+
+const (
+	AlertSessionRevoked     = "session_revoked"
+	AlertSessionUnreachable = "session_unreachable"
+
+	// Consecutive transient failures before an unreachable alert is raised
+	unreachableThreshold = 5
+)
+
+// Alert describes a session problem that needs someone's attention
+type Alert struct {
+	Type      string    `json:"type"`
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username,omitempty"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Notifier delivers an alert somewhere (WebSocket clients, a webhook, ...)
+type Notifier func(alert Alert)
+
+// SessionMonitor checks the Telegram session of a user before it is synced.
+// Revoked or expired sessions are recorded and alerted on, transient errors
+// only count towards an unreachable alert. Checks connect the shared client,
+// so they run in the sync loop rather than on a schedule of their own.
+type SessionMonitor struct {
+	db        *database.DB
+	client    telegram.Auth
+	notifiers []Notifier
+
+	mu       sync.Mutex
+	failures map[int64]int
+}
+
+func NewSessionMonitor(db *database.DB, client telegram.Auth) *SessionMonitor {
+	return &SessionMonitor{
+		db:       db,
+		client:   client,
+		failures: make(map[int64]int),
+	}
+}
+
+// OnAlert registers a notifier for raised alerts
+func (m *SessionMonitor) OnAlert(n Notifier) {
+	m.notifiers = append(m.notifiers, n)
+}
+
+// Check connects with the user's stored session and verifies it is still
+// authorized. It returns true when the session can be used for syncing.
+func (m *SessionMonitor) Check(ctx context.Context, user *models.User) bool {
+	session, err := m.db.GetActiveAuthSessionByUserID(user.ID)
+	if err != nil {
+		log.Printf("No active session for user %d, skipping health check", user.ID)
+		return false
+	}
+
+	if err := m.client.Connect(ctx, session.AppID, session.AppHash); err != nil {
+		m.transientFailure(user, err)
+		return false
+	}
+
+	err = m.client.CheckAuth(ctx)
+	switch {
+	case err == nil:
+		m.mu.Lock()
+		delete(m.failures, user.ID)
+		m.mu.Unlock()
+		if err := m.db.TouchAuthSession(user.ID); err != nil {
+			log.Printf("Failed to record health check for user %d: %v", user.ID, err)
+		}
+		return true
+	case telegram.IsSessionDead(err):
+		m.sessionDead(user, err)
+		return false
+	default:
+		m.transientFailure(user, err)
+		return false
+	}
+}
+
+func (m *SessionMonitor) sessionDead(user *models.User, err error) {
+	reason := telegram.ErrorReason(err)
+	log.Printf("Session of user %d is no longer valid (%s), marking inactive", user.ID, reason)
+
+	m.mu.Lock()
+	delete(m.failures, user.ID)
+	m.mu.Unlock()
+	if err := m.db.MarkAuthSessionRevoked(user.ID, reason); err != nil {
+		log.Printf("Failed to record revoked session for user %d: %v", user.ID, err)
+	}
+	user.IsActive = false
+
+	m.raise(Alert{
+		Type:      AlertSessionRevoked,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Reason:    reason,
+		Message:   fmt.Sprintf("Session of %s was revoked or expired, log in again to keep backing up", displayName(user)),
+		Timestamp: time.Now(),
+	})
+}
+
+func (m *SessionMonitor) transientFailure(user *models.User, err error) {
+	m.mu.Lock()
+	m.failures[user.ID]++
+	count := m.failures[user.ID]
+	m.mu.Unlock()
+	log.Printf("Health check for user %d failed (%d in a row): %v", user.ID, count, err)
+
+	// Alert once when the threshold is reached, not on every following failure
+	if count != unreachableThreshold {
+		return
+	}
+	m.raise(Alert{
+		Type:      AlertSessionUnreachable,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Reason:    telegram.ErrorReason(err),
+		Message:   fmt.Sprintf("Session of %s could not be checked %d times in a row", displayName(user), count),
+		Timestamp: time.Now(),
+	})
+}
+
+// raise hands the alert to every notifier in the background, a slow webhook
+// does not hold up the sync
+func (m *SessionMonitor) raise(alert Alert) {
+	for _, n := range m.notifiers {
+		go n(alert)
+	}
+}
+
+func displayName(user *models.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	if user.FirstName != "" {
+		return user.FirstName
+	}
+	return fmt.Sprintf("user %d", user.ID)
+}
+
+// WebhookNotifier posts alerts as JSON to the given URL
+func WebhookNotifier(url string) Notifier {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	return func(alert Alert) {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			log.Printf("Failed to encode alert: %v", err)
+			return
+		}
+
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Failed to deliver alert to webhook: %v", err)
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			log.Printf("Alert webhook returned status %d", resp.StatusCode)
+		}
+	}
+}
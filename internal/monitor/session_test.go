@@ -0,0 +1,48 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tgerr"
+
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+func TestCheckRevoked(t *testing.T) {
+	user := models.User{ID: 1, FirstName: "Me", IsActive: true}
+	db := telegramtest.NewDB(t, user)
+	if err := db.SaveAuthSession(&models.AuthSession{UserID: 1, IsActive: true, AppID: 1, AppHash: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := telegramtest.NewFake(user)
+	fake.Fail("CheckAuth", tgerr.New(401, "AUTH_KEY_UNREGISTERED"))
+	m := NewSessionMonitor(db, fake)
+
+	// A notifier that never returns must not hold up the check
+	release := make(chan struct{})
+	defer close(release)
+	alerts := make(chan Alert, 1)
+	m.OnAlert(func(alert Alert) {
+		alerts <- alert
+		<-release
+	})
+
+	if m.Check(context.Background(), &user) {
+		t.Fatal("revoked session reported usable")
+	}
+	if user.IsActive {
+		t.Error("user still active")
+	}
+	select {
+	case alert := <-alerts:
+		if alert.Type != AlertSessionRevoked || alert.Reason != "AUTH_KEY_UNREGISTERED" {
+			t.Errorf("alert = %+v", alert)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no alert raised")
+	}
+}
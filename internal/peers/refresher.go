@@ -0,0 +1,93 @@
+package peers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/schedule"
+	"tgbackup/internal/telegram"
+)
+
+const (
+	// Bios are fetched again once they are older than this
+	bioMaxAge = 24 * time.Hour
+	// Bios fetched per refresh, each costs a request
+	biosPerRefresh = 50
+)
+
+// Refresher backs up the contact book of the accounts and the profiles of
+// the users they have seen, so that renamed and deleted accounts can still
+// be identified.
+type Refresher struct {
+	db       *database.DB
+	client   telegram.Peers
+	throttle *schedule.Throttle
+}
+
+func NewRefresher(db *database.DB, client telegram.Peers, interval time.Duration) *Refresher {
+	return &Refresher{
+		db:       db,
+		client:   client,
+		throttle: schedule.NewThrottle(interval),
+	}
+}
+
+// SaveSeen stores the profiles of the users the client came across since the
+// last call. The client must have been used with the user's session only.
+func (r *Refresher) SaveSeen(userID int64) {
+	peers := r.client.TakeSeenPeers()
+	if len(peers) == 0 {
+		return
+	}
+	if err := r.db.SavePeers(userID, peers); err != nil {
+		log.Printf("Failed to save %d peers of user %d: %v", len(peers), userID, err)
+	}
+}
+
+// RefreshIfDue backs up the user's contact book and stale bios at most once
+// per interval, as every bio costs a request. The client must be signed in
+// as the user.
+func (r *Refresher) RefreshIfDue(ctx context.Context, userID int64) {
+	if r.throttle.Due(userID) {
+		r.Refresh(ctx, userID)
+	}
+}
+
+// Refresh backs up the contact book of the user and the bios of the users
+// with a private chat that were not checked recently
+func (r *Refresher) Refresh(ctx context.Context, userID int64) {
+	contacts, peers, err := r.client.GetContacts(ctx)
+	if err != nil {
+		log.Printf("Failed to get contacts of user %d: %v", userID, err)
+	} else {
+		if err := r.db.SavePeers(userID, peers); err != nil {
+			log.Printf("Failed to save contact profiles of user %d: %v", userID, err)
+		}
+		if err := r.db.SaveContacts(userID, contacts); err != nil {
+			log.Printf("Failed to save contacts of user %d: %v", userID, err)
+		}
+	}
+
+	stale, err := r.db.GetPeersWithStaleBio(userID, time.Now().Add(-bioMaxAge), biosPerRefresh)
+	if err != nil {
+		log.Printf("Failed to get peers of user %d: %v", userID, err)
+		return
+	}
+	for _, peerID := range stale {
+		conv, err := r.db.GetConversation(userID, peerID)
+		if err != nil {
+			log.Printf("Failed to get conversation %d of user %d: %v", peerID, userID, err)
+			continue
+		}
+		bio, err := r.client.GetUserBio(ctx, peerID, conv.AccessHash)
+		if err != nil {
+			log.Printf("Failed to get bio of user %d: %v", peerID, err)
+			continue
+		}
+		if err := r.db.SavePeerBio(userID, peerID, bio); err != nil {
+			log.Printf("Failed to save bio of user %d: %v", peerID, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package peers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: 1, FirstName: "Me"}
+	db := telegramtest.NewDB(t, user)
+	fake := telegramtest.NewFake(user)
+	// Ann has a private chat, Bob is only a contact
+	if _, err := db.SaveConversationIfAbsent(&models.Conversation{UserID: 1, ID: 2, Type: "user", Title: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	fake.AddSeenPeer(models.Peer{PeerID: 2, FirstName: "Ann", Username: "ann"})
+	fake.AddContact(models.AccountContact{ContactID: 3, FirstName: "Bob", Phone: "123"},
+		models.Peer{PeerID: 3, FirstName: "Bob", Phone: "123"})
+	fake.SetBio(2, "Hiking and tea")
+	fake.SetBio(3, "Never fetched")
+
+	r := NewRefresher(db, fake, time.Hour)
+	r.SaveSeen(1)
+	r.Refresh(ctx, 1)
+
+	ann, err := db.GetPeer(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ann.Username != "ann" || ann.Bio != "Hiking and tea" {
+		t.Errorf("Ann = %+v, want the seen profile and the bio", ann)
+	}
+	bob, err := db.GetPeer(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.Bio != "" {
+		t.Errorf("Bob's bio = %q, want none without a private chat", bob.Bio)
+	}
+	contacts, err := db.GetContacts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) != 1 || contacts[0].ContactID != 3 {
+		t.Errorf("contacts = %+v, want Bob", contacts)
+	}
+
+	// Bios are not fetched again within bioMaxAge
+	r.Refresh(ctx, 1)
+	if calls := fake.Calls("GetUserBio"); calls != 1 {
+		t.Errorf("GetUserBio calls = %d, want 1", calls)
+	}
+}
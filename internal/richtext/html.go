@@ -0,0 +1,112 @@
+package richtext
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+
+	"tgbackup/internal/models"
+)
+
+// HTML renders text with its entities as an HTML fragment. All text is
+// escaped and only http(s), tg, mailto and tel links are emitted.
+func HTML(text string, entities []models.MessageEntity) string {
+	return render(text, entities, htmlFormat)
+}
+
+var htmlFormat = format{
+	escape: func(s string, st *state) string {
+		return html.EscapeString(s)
+	},
+	open: func(e *models.MessageEntity, text string, st *state) string {
+		switch e.Type {
+		case "bold":
+			return "<b>"
+		case "italic":
+			return "<i>"
+		case "underline":
+			return "<u>"
+		case "strikethrough":
+			return "<s>"
+		case "code":
+			return "<code>"
+		case "pre":
+			if e.Language != "" {
+				return fmt.Sprintf(`<pre><code class="language-%s">`, html.EscapeString(e.Language))
+			}
+			return "<pre><code>"
+		case "spoiler":
+			return `<span class="spoiler">`
+		case "blockquote":
+			return "<blockquote>"
+		case "custom_emoji":
+			return fmt.Sprintf(`<span class="custom-emoji" data-document-id="%d">`, e.DocumentID)
+		case "hashtag", "cashtag", "bot_command", "bank_card":
+			return fmt.Sprintf(`<span class="%s">`, strings.ReplaceAll(e.Type, "_", "-"))
+		}
+		if href := entityHref(e, text); href != "" {
+			return fmt.Sprintf(`<a href="%s">`, html.EscapeString(href))
+		}
+		return ""
+	},
+	close: func(e *models.MessageEntity, text string, st *state) string {
+		switch e.Type {
+		case "bold":
+			return "</b>"
+		case "italic":
+			return "</i>"
+		case "underline":
+			return "</u>"
+		case "strikethrough":
+			return "</s>"
+		case "code":
+			return "</code>"
+		case "pre":
+			return "</code></pre>"
+		case "blockquote":
+			return "</blockquote>"
+		case "spoiler", "custom_emoji", "hashtag", "cashtag", "bot_command", "bank_card":
+			return "</span>"
+		}
+		if entityHref(e, text) != "" {
+			return "</a>"
+		}
+		return ""
+	},
+}
+
+// entityHref returns the link target of a link-like entity, or "" when the
+// entity is not a link or its target uses an unsafe scheme
+func entityHref(e *models.MessageEntity, text string) string {
+	var href string
+	switch e.Type {
+	case "text_link":
+		href = e.URL
+	case "link":
+		href = text
+		if !strings.Contains(href, "://") {
+			href = "http://" + href
+		}
+	case "mention":
+		href = "https://t.me/" + strings.TrimPrefix(text, "@")
+	case "mention_name":
+		href = fmt.Sprintf("tg://user?id=%d", e.UserID)
+	case "email":
+		href = "mailto:" + text
+	case "phone":
+		href = "tel:" + text
+	default:
+		return ""
+	}
+
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "tg", "mailto", "tel":
+		return href
+	}
+	return ""
+}
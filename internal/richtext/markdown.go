@@ -0,0 +1,83 @@
+package richtext
+
+import (
+	"strings"
+
+	"tgbackup/internal/models"
+)
+
+// Markdown renders text with its entities as Markdown. Underline and spoiler
+// have no Markdown syntax and fall back to <u> and ||spoiler|| markers.
+func Markdown(text string, entities []models.MessageEntity) string {
+	return render(text, entities, markdownFormat)
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "~", `\~`, "|", `\|`, "<", `\<`,
+)
+
+var markdownFormat = format{
+	escape: func(s string, st *state) string {
+		if st.code == 0 {
+			s = markdownEscaper.Replace(s)
+		}
+		if st.quote > 0 {
+			s = strings.ReplaceAll(s, "\n", "\n> ")
+		}
+		return s
+	},
+	open: func(e *models.MessageEntity, text string, st *state) string {
+		switch e.Type {
+		case "bold":
+			return "**"
+		case "italic":
+			return "_"
+		case "underline":
+			return "<u>"
+		case "strikethrough":
+			return "~~"
+		case "spoiler":
+			return "||"
+		case "code":
+			st.code++
+			return "`"
+		case "pre":
+			st.code++
+			return "```" + e.Language + "\n"
+		case "blockquote":
+			st.quote++
+			return "\n> "
+		}
+		if entityHref(e, text) != "" {
+			return "["
+		}
+		return ""
+	},
+	close: func(e *models.MessageEntity, text string, st *state) string {
+		switch e.Type {
+		case "bold":
+			return "**"
+		case "italic":
+			return "_"
+		case "underline":
+			return "</u>"
+		case "strikethrough":
+			return "~~"
+		case "spoiler":
+			return "||"
+		case "code":
+			st.code--
+			return "`"
+		case "pre":
+			st.code--
+			return "\n```"
+		case "blockquote":
+			st.quote--
+			return "\n"
+		}
+		if href := entityHref(e, text); href != "" {
+			return "](" + strings.ReplaceAll(href, ")", "%29") + ")"
+		}
+		return ""
+	},
+}
@@ -0,0 +1,159 @@
+// Package richtext renders message texts with their formatting entities.
+package richtext
+
+import (
+	"sort"
+	"unicode/utf16"
+
+	"tgbackup/internal/models"
+)
+
+// Segment is a run of text covered by the same innermost entity. Entity is
+// nil for plain text.
+type Segment struct {
+	Text   string
+	Entity *models.MessageEntity
+}
+
+// Segments splits text at every entity boundary. Each segment carries the
+// shortest entity covering it, which is how Telegram Desktop exports
+// flatten nested formatting.
+func Segments(text string, entities []models.MessageEntity) []Segment {
+	units := utf16.Encode([]rune(text))
+	valid := clampEntities(entities, len(units))
+
+	bounds := map[int]bool{0: true, len(units): true}
+	for _, e := range valid {
+		bounds[e.Offset] = true
+		bounds[e.Offset+e.Length] = true
+	}
+	points := make([]int, 0, len(bounds))
+	for p := range bounds {
+		points = append(points, p)
+	}
+	sort.Ints(points)
+
+	var segments []Segment
+	for i := 0; i+1 < len(points); i++ {
+		start, end := points[i], points[i+1]
+		if start == end {
+			continue
+		}
+
+		var inner *models.MessageEntity
+		for j := range valid {
+			e := &valid[j]
+			if e.Offset <= start && e.Offset+e.Length >= end && (inner == nil || e.Length < inner.Length) {
+				inner = e
+			}
+		}
+
+		segText := string(utf16.Decode(units[start:end]))
+		if n := len(segments); n > 0 && segments[n-1].Entity == inner {
+			segments[n-1].Text += segText
+			continue
+		}
+		segments = append(segments, Segment{Text: segText, Entity: inner})
+	}
+
+	return segments
+}
+
+// format describes how one output format writes text and entity markers
+type format struct {
+	escape func(s string, st *state) string
+	open   func(e *models.MessageEntity, text string, st *state) string
+	close  func(e *models.MessageEntity, text string, st *state) string
+}
+
+// state is shared by the callbacks of a format while rendering
+type state struct {
+	code  int // depth of code/pre entities, text inside is not escaped for markup
+	quote int // depth of blockquotes
+}
+
+// render walks the text once, opening and closing entities in a properly
+// nested order. Overlapping entities are closed and reopened around each
+// other so the output stays well-formed.
+func render(text string, entities []models.MessageEntity, f format) string {
+	units := utf16.Encode([]rune(text))
+	valid := clampEntities(entities, len(units))
+
+	// Longer entities open first so shorter ones nest inside them
+	sort.SliceStable(valid, func(i, j int) bool {
+		if valid[i].Offset != valid[j].Offset {
+			return valid[i].Offset < valid[j].Offset
+		}
+		return valid[i].Length > valid[j].Length
+	})
+
+	entityText := func(e *models.MessageEntity) string {
+		return string(utf16.Decode(units[e.Offset : e.Offset+e.Length]))
+	}
+
+	var out []byte
+	var stack []*models.MessageEntity
+	st := &state{}
+	next := 0
+	pos := 0
+
+	for pos <= len(units) {
+		// Close entities ending here, reopening any that were opened after them
+		for i := len(stack) - 1; i >= 0; i-- {
+			e := stack[i]
+			if e.Offset+e.Length != pos {
+				continue
+			}
+			reopen := stack[i+1:]
+			for j := len(stack) - 1; j > i; j-- {
+				out = append(out, f.close(stack[j], entityText(stack[j]), st)...)
+			}
+			out = append(out, f.close(e, entityText(e), st)...)
+			stack = append(stack[:i:i], reopen...)
+			for _, r := range reopen {
+				out = append(out, f.open(r, entityText(r), st)...)
+			}
+		}
+
+		if pos == len(units) {
+			break
+		}
+
+		for next < len(valid) && valid[next].Offset == pos {
+			e := &valid[next]
+			out = append(out, f.open(e, entityText(e), st)...)
+			stack = append(stack, e)
+			next++
+		}
+
+		// Plain text up to the next boundary
+		end := len(units)
+		if next < len(valid) && valid[next].Offset < end {
+			end = valid[next].Offset
+		}
+		for _, e := range stack {
+			if stop := e.Offset + e.Length; stop < end {
+				end = stop
+			}
+		}
+		out = append(out, f.escape(string(utf16.Decode(units[pos:end])), st)...)
+		pos = end
+	}
+
+	return string(out)
+}
+
+// clampEntities drops entities that are empty or fall outside the text
+func clampEntities(entities []models.MessageEntity, n int) []models.MessageEntity {
+	valid := make([]models.MessageEntity, 0, len(entities))
+	for _, e := range entities {
+		if e.Offset < 0 || e.Length <= 0 || e.Offset >= n {
+			continue
+		}
+		if e.Offset+e.Length > n {
+			e.Length = n - e.Offset
+		}
+		valid = append(valid, e)
+	}
+	return valid
+}
@@ -0,0 +1,38 @@
+// Package schedule paces the background work done for each account.
+package schedule
+
+import (
+	"sync"
+	"time"
+)
+
+// Throttle lets work run for an account at most once per interval
+type Throttle struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	lastRun map[int64]time.Time
+}
+
+func NewThrottle(interval time.Duration) *Throttle {
+	return &Throttle{
+		interval: interval,
+		now:      time.Now,
+		lastRun:  make(map[int64]time.Time),
+	}
+}
+
+// Due reports whether the last run for the user is at least one interval ago
+// and, if it is, records a run now
+func (t *Throttle) Due(userID int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if last, ok := t.lastRun[userID]; ok && now.Sub(last) < t.interval {
+		return false
+	}
+	t.lastRun[userID] = now
+	return true
+}
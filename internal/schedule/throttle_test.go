@@ -0,0 +1,27 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	th := NewThrottle(time.Hour)
+	th.now = func() time.Time { return clock }
+
+	if !th.Due(1) {
+		t.Fatal("first run not due")
+	}
+	if th.Due(1) {
+		t.Error("due again right away")
+	}
+	if !th.Due(2) {
+		t.Error("another user not due")
+	}
+
+	clock = clock.Add(time.Hour)
+	if !th.Due(1) {
+		t.Error("not due after one interval")
+	}
+}
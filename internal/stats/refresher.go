@@ -0,0 +1,94 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/schedule"
+	"tgbackup/internal/telegram"
+)
+
+const (
+	// Messages older than this are not refreshed, their counters rarely change
+	refreshWindow = 7 * 24 * time.Hour
+	// Conversations without a message in this window are not refreshed at all
+	activeWindow = 24 * time.Hour
+	// At most this many of the newest messages are refreshed per conversation
+	maxMessagesPerConversation = 100
+)
+
+// Refresher periodically snapshots the views, forwards, replies and reactions
+// of recent messages. Each snapshot is kept so counters can be followed over
+// time, the message rows always hold the latest values.
+type Refresher struct {
+	db       *database.DB
+	client   telegram.Stats
+	throttle *schedule.Throttle
+}
+
+func NewRefresher(db *database.DB, client telegram.Stats, interval time.Duration) *Refresher {
+	return &Refresher{
+		db:       db,
+		client:   client,
+		throttle: schedule.NewThrottle(interval),
+	}
+}
+
+// RefreshIfDue snapshots the counters of the user's recent messages unless
+// they were snapshotted within the refresher's interval. Counters are read
+// through the client, which must already be connected with the user's
+// session.
+func (r *Refresher) RefreshIfDue(ctx context.Context, userID int64) {
+	if r.throttle.Due(userID) {
+		r.Refresh(ctx, userID)
+	}
+}
+
+// Refresh snapshots the counters of the recent messages of the user's
+// conversations that had a message within activeWindow
+func (r *Refresher) Refresh(ctx context.Context, userID int64) {
+	conversations, err := r.db.GetConversationsByUserID(userID)
+	if err != nil {
+		log.Printf("Stats refresh failed to get conversations of user %d: %v", userID, err)
+		return
+	}
+
+	now := time.Now()
+	since := now.Add(-refreshWindow)
+	total, changed := 0, 0
+	for _, conv := range conversations {
+		if conv.LastTime.Before(now.Add(-activeWindow)) {
+			continue
+		}
+
+		ids, err := r.db.GetRecentMessageIDs(userID, conv.ID, since, maxMessagesPerConversation)
+		if err != nil {
+			log.Printf("Stats refresh failed to get messages of %s %d: %v", conv.Type, conv.ID, err)
+			continue
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		snapshots, err := r.client.GetMessageStats(ctx, conv.ID, conv.Type, conv.AccessHash, ids)
+		if err != nil {
+			log.Printf("Stats refresh failed for %s %d (%s): %v", conv.Type, conv.ID, conv.Title, err)
+			continue
+		}
+		for i := range snapshots {
+			snapshots[i].UserID = userID
+		}
+
+		n, err := r.db.SaveMessageStats(snapshots)
+		if err != nil {
+			log.Printf("Failed to save message stats of %s %d: %v", conv.Type, conv.ID, err)
+			continue
+		}
+		total += len(snapshots)
+		changed += n
+	}
+
+	log.Printf("Refreshed stats of %d messages for user %d, %d changed", total, userID, changed)
+}
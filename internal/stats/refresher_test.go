@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: 1, FirstName: "Me"}
+	db := telegramtest.NewDB(t, user)
+	fake := telegramtest.NewFake(user)
+	now := time.Now()
+	// The channel had a message today, the group two days ago
+	for _, conv := range []struct {
+		models.Conversation
+		sent time.Time
+	}{
+		{models.Conversation{ID: 400, Type: "channel", Title: "News", AccessHash: "7"}, now.Add(-time.Hour)},
+		{models.Conversation{ID: 300, Type: "group", Title: "Family"}, now.Add(-48 * time.Hour)},
+	} {
+		fake.AddDialog(conv.Conversation)
+		fake.AddMessage(conv.ID, &tg.Message{ID: 10, Message: "hello", Date: int(conv.sent.Unix()), Views: 100})
+
+		conv.UserID = 1
+		conv.LastTime = conv.sent
+		if _, err := db.SaveConversationIfAbsent(&conv.Conversation); err != nil {
+			t.Fatal(err)
+		}
+		msg := models.Message{UserID: 1, ConversationID: conv.ID, MessageID: 10, Content: "hello",
+			MessageType: "text", Timestamp: conv.sent}
+		if err := db.SaveMessage(&msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots := func(convID int64) []models.MessageStats {
+		t.Helper()
+		stats, err := db.GetMessageStats(convID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	r := NewRefresher(db, fake, time.Hour)
+	r.Refresh(ctx, 1)
+	if stats := snapshots(400); len(stats) != 1 || stats[0].Views != 100 {
+		t.Fatalf("channel stats = %+v, want one snapshot with 100 views", stats)
+	}
+	if stats := snapshots(300); len(stats) != 0 {
+		t.Errorf("quiet group stats = %+v, want none", stats)
+	}
+	if calls := fake.Calls("GetMessageStats"); calls != 1 {
+		t.Errorf("GetMessageStats calls = %d, want 1 for the active channel", calls)
+	}
+
+	// Unchanged counters add no snapshot
+	r.Refresh(ctx, 1)
+	if stats := snapshots(400); len(stats) != 1 {
+		t.Errorf("stats after an unchanged refresh = %+v, want one snapshot", stats)
+	}
+
+	fake.EditMessage(400, &tg.Message{ID: 10, Message: "hello", Date: int(now.Add(-time.Hour).Unix()), Views: 150})
+	r.Refresh(ctx, 1)
+	if stats := snapshots(400); len(stats) != 2 {
+		t.Errorf("stats after new views = %+v, want two snapshots", stats)
+	}
+	msg, err := db.GetMessage(400, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Views != 150 {
+		t.Errorf("message views = %d, want 150", msg.Views)
+	}
+}
@@ -0,0 +1,507 @@
+// Package sync backs up the dialogs and messages of an account. The engine is
+// shared by the startup sync, the periodic sync and the sync API, so all of
+// them fetch the same way.
+package sync
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/comments"
+	"tgbackup/internal/database"
+	"tgbackup/internal/media"
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram"
+)
+
+const (
+	// Messages fetched per history request
+	pageSize = 100
+	// Older pages fetched per conversation and sync until its backfill is complete
+	backfillPages = 5
+	// Pause between the conversations of a full sync, against rate limits
+	dialogDelay = time.Second
+	// Longer flood waits fail the request instead of being waited out
+	maxFloodWait = 5 * time.Minute
+	// Media downloads tried per conversation and sync, the rest follow later
+	mediaPerSync = 20
+)
+
+// Engine syncs accounts. A full sync saves every dialog and its recent
+// messages and stores the updates state; later syncs fetch what changed
+// since that state and sweep channels and supergroups, whose messages are not
+// part of the account's updates. Every sync also continues the backfill of
+// older messages, a few pages per conversation at a time, and downloads the
+// media its sync policy asks for into the media store.
+type Engine struct {
+	db     *database.DB
+	client telegram.API
+	store  *media.Store
+
+	delay time.Duration
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu      sync.Mutex
+	running map[int64]bool
+}
+
+func NewEngine(db *database.DB, client telegram.API, store *media.Store) *Engine {
+	return &Engine{
+		db:      db,
+		client:  client,
+		store:   store,
+		delay:   dialogDelay,
+		sleep:   sleep,
+		running: make(map[int64]bool),
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run syncs the user: in full the first time, incrementally once an updates
+// state is stored. A run while another one of the same user is in progress
+// is skipped. The client must already be connected with the user's session.
+func (e *Engine) Run(ctx context.Context, userID int64) *Result {
+	pts, qts, date, _, err := e.db.GetUpdatesState(userID)
+	if err != nil {
+		pts, qts, date = 0, 0, 0
+	}
+	if pts == 0 && qts == 0 && date == 0 {
+		return e.Full(ctx, userID)
+	}
+	return e.Incremental(ctx, userID)
+}
+
+// Full saves every dialog and the recent messages of those its sync policies
+// include, then stores the current updates state as the start of incremental
+// syncs
+func (e *Engine) Full(ctx context.Context, userID int64) *Result {
+	result, ok := e.start(userID, "full")
+	if !ok {
+		return result
+	}
+	defer e.finish(result)
+
+	dialogs, err := e.client.GetDialogs(ctx)
+	if err != nil {
+		result.fail(0, "dialogs", err)
+		return result
+	}
+	log.Printf("Found %d dialogs for user %d", len(dialogs), userID)
+
+	for i := range dialogs {
+		dialogs[i].UserID = userID
+		if err := e.db.SaveConversation(&dialogs[i]); err != nil {
+			result.fail(dialogs[i].ID, "save", err)
+		}
+	}
+
+	for i, p := range e.plan(userID, dialogs, result) {
+		if i > 0 && e.sleep(ctx, e.delay) != nil {
+			break
+		}
+		e.syncConversation(ctx, userID, p.conv, p.policy, result)
+	}
+
+	state, err := e.client.GetState(ctx)
+	if err != nil {
+		result.fail(0, "state", err)
+		return result
+	}
+	if err := e.db.SaveUpdatesState(userID, state.Pts, state.Qts, state.Date, state.Seq); err != nil {
+		result.fail(0, "state", err)
+		return result
+	}
+	log.Printf("Saved initial state for user %d: pts=%d, qts=%d, date=%d, seq=%d", userID, state.Pts, state.Qts, state.Date, state.Seq)
+	return result
+}
+
+// Incremental saves the new messages since the stored updates state, then
+// sweeps the channels and supergroups of the user for newer messages and
+// backfills the conversations whose history is not complete yet
+func (e *Engine) Incremental(ctx context.Context, userID int64) *Result {
+	return e.Poll(ctx, userID, true, nil)
+}
+
+// Poll is an incremental sync of only what is due: the account's updates
+// when updates is set, and the given conversations, all of them when nil
+func (e *Engine) Poll(ctx context.Context, userID int64, updates bool, conversations map[int64]bool) *Result {
+	result, ok := e.start(userID, "incremental")
+	if !ok {
+		return result
+	}
+	defer e.finish(result)
+
+	if updates {
+		e.syncUpdates(ctx, userID, result)
+	}
+	e.syncConversations(ctx, userID, conversations, result)
+	return result
+}
+
+func (e *Engine) syncUpdates(ctx context.Context, userID int64, result *Result) {
+	pts, qts, date, _, err := e.db.GetUpdatesState(userID)
+	if err != nil {
+		result.fail(0, "state", err)
+		result.UpdatesFailed = true
+		return
+	}
+
+	var updates *tg.UpdatesDifference
+	err = e.retry(ctx, func() (err error) {
+		updates, err = e.client.GetUpdates(ctx, pts, date, qts)
+		return err
+	})
+	if err != nil {
+		// e.g. PERSISTENT_TIMESTAMP_EMPTY, the channel sweep still runs
+		result.fail(0, "updates", err)
+		result.UpdatesFailed = true
+		return
+	}
+
+	excluded, err := e.excluded(userID)
+	if err != nil {
+		result.fail(0, "policies", err)
+	}
+	var messages []models.Message
+	for _, msg := range e.client.ParseUpdatesMessages(updates, userID) {
+		if !excluded[msg.ConversationID] {
+			messages = append(messages, msg)
+		}
+	}
+	log.Printf("Found %d new messages from updates for user %d", len(messages), userID)
+	if err := e.db.SaveMessages(messages); err != nil {
+		// The state is not saved either, so the next sync fetches them again
+		result.fail(0, "save", err)
+		result.UpdatesFailed = true
+		return
+	}
+	result.Messages += len(messages)
+
+	// Only update state if we have meaningful data
+	state := updates.State
+	if state.Pts > 0 || state.Date > 0 {
+		if err := e.db.SaveUpdatesState(userID, state.Pts, state.Qts, state.Date, state.Seq); err != nil {
+			result.fail(0, "state", err)
+			result.UpdatesFailed = true
+		}
+	}
+}
+
+// syncConversations catches up the channels and supergroups of the user and
+// continues the backfill of the other conversations. A non-nil only limits
+// it to those conversations.
+func (e *Engine) syncConversations(ctx context.Context, userID int64, only map[int64]bool, result *Result) {
+	stored, err := e.db.GetConversationsByUserID(userID)
+	if err != nil {
+		result.fail(0, "dialogs", err)
+		return
+	}
+	var conversations []models.Conversation
+	for _, conv := range stored {
+		if only == nil || only[conv.ID] {
+			conversations = append(conversations, conv)
+		}
+	}
+
+	for _, p := range e.plan(userID, conversations, result) {
+		conv := p.conv
+		if ctx.Err() != nil {
+			return
+		}
+		if (conv.Type == "channel" || conv.Type == "group") && conv.AccessHash != "" {
+			e.syncConversation(ctx, userID, conv, p.policy, result)
+			continue
+		}
+
+		// New messages of the other conversations come with the updates
+		cursor, err := e.db.GetSyncCursor(userID, conv.ID)
+		if err != nil {
+			result.fail(conv.ID, "save", err)
+			continue
+		}
+		e.backfill(ctx, userID, conv, p.policy, cursor, result)
+		e.downloadMedia(ctx, userID, conv, p.policy, result)
+	}
+}
+
+// syncConversation saves the messages of a conversation that are newer than
+// its sync cursor, continues its backfill as deep as its policy allows and
+// downloads the media the policy asks for. Forum topics and channel comments
+// are refreshed along with them.
+func (e *Engine) syncConversation(ctx context.Context, userID int64, conv *models.Conversation, policy models.SyncPolicy, result *Result) {
+	if conv.IsForum {
+		e.syncForumTopics(ctx, userID, conv, result)
+	}
+
+	cursor, err := e.db.GetSyncCursor(userID, conv.ID)
+	if err != nil {
+		result.fail(conv.ID, "save", err)
+		return
+	}
+	if !e.catchUp(ctx, userID, conv, policy, cursor, result) {
+		return
+	}
+	result.Conversations++
+	e.backfill(ctx, userID, conv, policy, cursor, result)
+	e.downloadMedia(ctx, userID, conv, policy, result)
+
+	comments.Sync(ctx, e.db, e.client, userID, conv)
+}
+
+// catchUp saves the messages newer than the cursor, page by page from the
+// newest one until the stored history is reached. Only the last page moves
+// the cursor, so an interrupted catch-up starts over on the next sync. A
+// conversation without cursor only gets its newest page, cut to the policy's
+// depth, the rest is left to the backfill.
+func (e *Engine) catchUp(ctx context.Context, userID int64, conv *models.Conversation, policy models.SyncPolicy, cursor *models.SyncCursor, result *Result) bool {
+	limit := pageSize
+	if cursor.NewestID == 0 {
+		limit = firstPage(policy)
+	}
+
+	newest, offset, saved := 0, 0, 0
+	for {
+		messages, err := e.page(ctx, conv, offset, limit)
+		if err != nil {
+			log.Printf("Failed to get messages of %s %d (%s) for user %d: %v", conv.Type, conv.ID, conv.Title, userID, err)
+			result.fail(conv.ID, "history", err)
+			return false
+		}
+
+		var fresh []models.Message
+		for i := range messages {
+			if messages[i].MessageID > cursor.NewestID {
+				messages[i].UserID = userID
+				fresh = append(fresh, messages[i])
+			}
+		}
+		if newest == 0 && len(fresh) > 0 {
+			newest = fresh[0].MessageID
+		}
+
+		reached := cursor.NewestID == 0 || len(messages) == 0 || len(fresh) < len(messages)
+		if !reached {
+			if err := e.db.SaveMessages(fresh); err != nil {
+				result.fail(conv.ID, "save", err)
+				return false
+			}
+			saved += len(fresh)
+			offset = messages[len(messages)-1].MessageID
+			continue
+		}
+
+		next := *cursor
+		if newest > next.NewestID {
+			next.NewestID = newest
+		}
+		if cursor.NewestID == 0 {
+			fresh, _ = withinDepth(fresh, policy, time.Now())
+			next.Complete = len(fresh) == 0
+			if len(fresh) > 0 {
+				next.OldestID = fresh[len(fresh)-1].MessageID
+			}
+		}
+		if err := e.db.SaveMessagePage(fresh, &next); err != nil {
+			result.fail(conv.ID, "save", err)
+			return false
+		}
+		*cursor = next
+		saved += len(fresh)
+		result.Messages += saved
+		log.Printf("Saved %d new messages of %s %d (%s) for user %d", saved, conv.Type, conv.ID, conv.Title, userID)
+		return true
+	}
+}
+
+// backfill saves up to backfillPages pages of messages older than the
+// cursor, until the depth of the policy is stored
+func (e *Engine) backfill(ctx context.Context, userID int64, conv *models.Conversation, policy models.SyncPolicy, cursor *models.SyncCursor, result *Result) {
+	if policy.Backfill == models.BackfillNone || cursor.Complete || cursor.OldestID == 0 {
+		return
+	}
+	count, oldest, err := e.db.GetMessageRange(userID, conv.ID)
+	if err != nil {
+		result.fail(conv.ID, "save", err)
+		return
+	}
+	now := time.Now()
+
+	saved := 0
+	for i := 0; i < backfillPages && !cursor.Complete; i++ {
+		limit := pageSize
+		if policy.Backfill == models.BackfillMessages {
+			if count >= policy.BackfillLimit {
+				break
+			}
+			if remaining := policy.BackfillLimit - count; remaining < limit {
+				limit = remaining
+			}
+		}
+		if since := policy.Since(now); !oldest.IsZero() && oldest.Before(since) {
+			break
+		}
+
+		messages, err := e.page(ctx, conv, cursor.OldestID, limit)
+		if err != nil {
+			result.fail(conv.ID, "history", err)
+			break
+		}
+		messages, cut := withinDepth(messages, policy, now)
+
+		next := *cursor
+		if len(messages) == 0 {
+			next.Complete = true
+		} else {
+			next.OldestID = messages[len(messages)-1].MessageID
+		}
+		for j := range messages {
+			messages[j].UserID = userID
+		}
+		if err := e.db.SaveMessagePage(messages, &next); err != nil {
+			result.fail(conv.ID, "save", err)
+			break
+		}
+		*cursor = next
+		saved += len(messages)
+		count += len(messages)
+		if cut {
+			break
+		}
+	}
+
+	if saved > 0 {
+		result.Messages += saved
+		log.Printf("Backfilled %d messages of %s %d (%s) for user %d, oldest is now %d", saved, conv.Type, conv.ID, conv.Title, userID, cursor.OldestID)
+	}
+}
+
+// downloadMedia downloads the photos and documents of the stored messages
+// whose type and size the policy allows, newest first, and points the
+// messages at the stored files. At most mediaPerSync downloads are tried per
+// sync; those that fail are tried again on the next one.
+func (e *Engine) downloadMedia(ctx context.Context, userID int64, conv *models.Conversation, policy models.SyncPolicy, result *Result) {
+	if e.store == nil || len(policy.MediaTypes) == 0 {
+		return
+	}
+	pending, err := e.db.GetPendingMedia(userID, conv.ID, policy.MediaTypes)
+	if err != nil {
+		result.fail(conv.ID, "media", err)
+		return
+	}
+
+	tried, downloaded := 0, 0
+	for _, msg := range pending {
+		if tried >= mediaPerSync || ctx.Err() != nil {
+			break
+		}
+		size, _ := media.ParsePlaceholder(msg.MediaURL)
+		if !policy.AllowsMedia(msg.MessageType, size) {
+			continue
+		}
+		tried++
+
+		var buf bytes.Buffer
+		err := e.retry(ctx, func() error {
+			buf.Reset()
+			return e.client.DownloadMessageMedia(ctx, conv.ID, conv.Type, conv.AccessHash, msg.MessageID, &buf)
+		})
+		if err != nil {
+			log.Printf("Failed to download media of message %d in %s %d for user %d: %v", msg.MessageID, conv.Type, conv.ID, userID, err)
+			continue
+		}
+		mediaURL, err := e.store.Save(userID, conv.ID, media.FileName(msg.MediaURL), &buf)
+		if err != nil {
+			result.fail(conv.ID, "media", err)
+			return
+		}
+		if err := e.db.SetMessageMedia(userID, conv.ID, msg.MessageID, mediaURL); err != nil {
+			result.fail(conv.ID, "save", err)
+			return
+		}
+		downloaded++
+	}
+
+	if downloaded > 0 {
+		result.Media += downloaded
+		log.Printf("Downloaded %d media files of %s %d (%s) for user %d", downloaded, conv.Type, conv.ID, conv.Title, userID)
+	}
+}
+
+// page fetches up to limit messages of a conversation older than offsetID
+func (e *Engine) page(ctx context.Context, conv *models.Conversation, offsetID, limit int) (messages []models.Message, err error) {
+	err = e.retry(ctx, func() (err error) {
+		messages, err = e.client.GetHistory(ctx, conv.ID, conv.Type, conv.AccessHash, offsetID, limit)
+		return err
+	})
+	return messages, err
+}
+
+// syncForumTopics refreshes the topic list of a supergroup with topics enabled
+func (e *Engine) syncForumTopics(ctx context.Context, userID int64, conv *models.Conversation, result *Result) {
+	topics, err := e.client.GetForumTopics(ctx, conv.ID, conv.AccessHash)
+	if err != nil {
+		result.fail(conv.ID, "topics", err)
+		return
+	}
+	if err := e.db.SaveForumTopics(userID, conv.ID, topics); err != nil {
+		result.fail(conv.ID, "topics", err)
+	}
+}
+
+// retry calls fn again once after a flood wait, unless the wait is too long
+func (e *Engine) retry(ctx context.Context, fn func() error) error {
+	err := fn()
+	wait, ok := telegram.FloodWait(err)
+	if !ok || wait > maxFloodWait {
+		return err
+	}
+	log.Printf("Flood wait of %s, retrying", wait)
+	if err := e.sleep(ctx, wait); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// start marks a run of the user as in progress, ok is false if one already is
+func (e *Engine) start(userID int64, mode string) (result *Result, ok bool) {
+	result = &Result{UserID: userID, Mode: mode, StartedAt: time.Now()}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.running[userID] {
+		result.Skipped = true
+		result.FinishedAt = result.StartedAt
+		return result, false
+	}
+	e.running[userID] = true
+
+	log.Printf("Starting %s sync for user %d", mode, userID)
+	return result, true
+}
+
+func (e *Engine) finish(result *Result) {
+	result.FinishedAt = time.Now()
+
+	e.mu.Lock()
+	delete(e.running, result.UserID)
+	e.mu.Unlock()
+
+	log.Printf("%s sync of user %d finished in %s: %d conversations, %d messages, %d errors",
+		result.Mode, result.UserID, result.FinishedAt.Sub(result.StartedAt).Round(time.Millisecond),
+		result.Conversations, result.Messages, len(result.Errors))
+}
@@ -0,0 +1,462 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/media"
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+func newTestEngine(t *testing.T) (*Engine, *database.DB, *telegramtest.Fake, *[]time.Duration) {
+	t.Helper()
+	user := models.User{ID: 1, FirstName: "Me"}
+	db := telegramtest.NewDB(t, user)
+	fake := telegramtest.NewFake(user)
+	fake.AddUser(&tg.User{ID: 2, FirstName: "Ann"})
+	fake.AddDialog(models.Conversation{ID: 2, Type: "user", Title: "Ann"})
+	fake.AddDialog(models.Conversation{ID: 300, Type: "group", Title: "Family"})
+	fake.AddDialog(models.Conversation{ID: 400, Type: "channel", Title: "News", AccessHash: "7"})
+
+	var slept []time.Duration
+	e := NewEngine(db, fake, media.NewStore(filepath.Join(t.TempDir(), "media")))
+	e.delay = 0
+	e.sleep = func(ctx context.Context, d time.Duration) error {
+		// Only flood waits, the pause between dialogs is 0
+		if d > 0 {
+			slept = append(slept, d)
+		}
+		return nil
+	}
+	return e, db, fake, &slept
+}
+
+func text(id int, s string) *tg.Message {
+	return &tg.Message{ID: id, Message: s, Date: 1700000000 + id}
+}
+
+func stored(t *testing.T, db *database.DB, convID int64) []int {
+	t.Helper()
+	messages, err := db.GetMessagesByUserAndConversation(1, convID, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, msg := range messages {
+		ids = append(ids, msg.MessageID)
+	}
+	return ids
+}
+
+func TestFullThenIncremental(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+	fake.AddMessage(2, text(1, "hi"))
+	fake.AddMessage(300, text(2, "dinner?"))
+	fake.AddMessage(400, text(10, "post"))
+
+	result := e.Run(ctx, 1)
+	if result.Mode != "full" || result.Conversations != 3 || result.Messages != 3 || len(result.Errors) != 0 {
+		t.Fatalf("first run = %+v", result)
+	}
+	if convs, _ := db.GetConversationsByUserID(1); len(convs) != 3 {
+		t.Errorf("conversations = %d, want 3", len(convs))
+	}
+	if pts, _, _, _, _ := db.GetUpdatesState(1); pts != 2 {
+		t.Errorf("stored pts = %d, want 2", pts)
+	}
+
+	fake.AddMessage(2, text(3, "still there?"))
+	fake.AddMessage(400, text(11, "second post"))
+
+	result = e.Run(ctx, 1)
+	if result.Mode != "incremental" || result.Messages != 2 || len(result.Errors) != 0 {
+		t.Fatalf("second run = %+v", result)
+	}
+	if ids := stored(t, db, 2); len(ids) != 2 {
+		t.Errorf("direct messages = %v, want 2", ids)
+	}
+	// The channel post only comes from the sweep, older posts are not saved again
+	if ids := stored(t, db, 400); len(ids) != 2 || ids[0] != 11 {
+		t.Errorf("channel messages = %v", ids)
+	}
+	if pts, _, _, _, _ := db.GetUpdatesState(1); pts != 3 {
+		t.Errorf("stored pts = %d, want 3", pts)
+	}
+}
+
+func TestFloodWait(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, slept := newTestEngine(t)
+	fake.AddMessage(2, text(1, "hi"))
+	fake.AddMessage(300, text(2, "dinner?"))
+	fake.Fail("GetHistory", telegramtest.FloodWait(3))
+
+	result := e.Full(ctx, 1)
+	if len(result.Errors) != 0 || result.Messages != 2 {
+		t.Fatalf("result = %+v", result)
+	}
+	if len(*slept) != 1 || (*slept)[0] != 3*time.Second {
+		t.Errorf("slept %v, want one 3s wait", *slept)
+	}
+	if ids := stored(t, db, 300); len(ids) != 1 {
+		t.Errorf("group messages = %v", ids)
+	}
+}
+
+func TestLongFloodWait(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, slept := newTestEngine(t)
+	fake.AddMessage(2, text(1, "hi"))
+	fake.AddMessage(300, text(2, "dinner?"))
+	// Dialogs are listed newest first: the channel, the group, then Ann
+	fake.Fail("GetHistory", nil, telegramtest.FloodWait(3600))
+
+	result := e.Full(ctx, 1)
+	if len(*slept) != 0 {
+		t.Errorf("waited %v", *slept)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].ConversationID != 300 || result.Errors[0].Stage != "history" {
+		t.Fatalf("errors = %+v", result.Errors)
+	}
+	// The other conversations and the state are still saved
+	if ids := stored(t, db, 2); len(ids) != 1 {
+		t.Errorf("direct messages = %v", ids)
+	}
+	if _, _, date, _, _ := db.GetUpdatesState(1); date == 0 {
+		t.Error("state not saved")
+	}
+}
+
+func TestUpdatesFailure(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+	e.Full(ctx, 1)
+
+	fake.AddMessage(400, text(10, "post"))
+	fake.Fail("GetUpdates", tgerr.New(400, "PERSISTENT_TIMESTAMP_EMPTY"))
+
+	result := e.Incremental(ctx, 1)
+	if len(result.Errors) != 1 || result.Errors[0].Stage != "updates" {
+		t.Fatalf("errors = %+v", result.Errors)
+	}
+	if ids := stored(t, db, 400); len(ids) != 1 {
+		t.Errorf("channel sweep skipped: %v", ids)
+	}
+}
+
+func TestRunSkipsRunningUser(t *testing.T) {
+	e, _, _, _ := newTestEngine(t)
+	if _, ok := e.start(1, "full"); !ok {
+		t.Fatal("first run not started")
+	}
+
+	result := e.Run(context.Background(), 1)
+	if !result.Skipped {
+		t.Errorf("concurrent run = %+v, want skipped", result)
+	}
+}
+
+func count(t *testing.T, db *database.DB, convID int64) int {
+	t.Helper()
+	messages, err := db.GetMessagesByUserAndConversation(1, convID, 10000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(messages)
+}
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+	for id := 1; id <= 750; id++ {
+		fake.AddMessage(300, text(id, "msg"))
+	}
+
+	// The newest page, then backfillPages older ones
+	e.Run(ctx, 1)
+	if n := count(t, db, 300); n != 600 {
+		t.Fatalf("stored %d messages after the first run, want 600", n)
+	}
+	cursor, err := db.GetSyncCursor(1, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.NewestID != 750 || cursor.OldestID != 151 || cursor.Complete {
+		t.Errorf("cursor = %+v", cursor)
+	}
+	convs, _ := db.GetConversationsByUserID(1)
+	for _, conv := range convs {
+		if conv.ID == 300 && conv.LastMessage != "msg" {
+			t.Errorf("last message = %q", conv.LastMessage)
+		}
+	}
+
+	result := e.Run(ctx, 1)
+	if result.Messages != 150 || len(result.Errors) != 0 {
+		t.Fatalf("second run = %+v", result)
+	}
+	if cursor, _ := db.GetSyncCursor(1, 300); cursor.OldestID != 1 || !cursor.Complete {
+		t.Errorf("cursor = %+v, want a complete backfill", cursor)
+	}
+	if n := count(t, db, 300); n != 750 {
+		t.Errorf("stored %d messages, want 750", n)
+	}
+}
+
+func TestInterruptedCatchUp(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+	fake.AddMessage(400, text(1, "first post"))
+	e.Full(ctx, 1)
+
+	for id := 2; id <= 250; id++ {
+		fake.AddMessage(400, text(id, "post"))
+	}
+	// The second page fails, the first one is kept but not the cursor
+	fake.Fail("GetHistory", nil, tgerr.New(500, "INTERNAL"))
+	result := e.Incremental(ctx, 1)
+	if len(result.Errors) != 1 || result.Errors[0].Stage != "history" {
+		t.Fatalf("errors = %+v", result.Errors)
+	}
+	if n := count(t, db, 400); n != 101 {
+		t.Errorf("stored %d posts, want 101", n)
+	}
+	if cursor, _ := db.GetSyncCursor(1, 400); cursor.NewestID != 1 {
+		t.Errorf("cursor moved to %d before the gap was closed", cursor.NewestID)
+	}
+
+	e.Incremental(ctx, 1)
+	if n := count(t, db, 400); n != 250 {
+		t.Errorf("stored %d posts, want 250", n)
+	}
+	if cursor, _ := db.GetSyncCursor(1, 400); cursor.NewestID != 250 || cursor.OldestID != 1 {
+		t.Errorf("cursor = %+v", cursor)
+	}
+}
+
+func TestLastMessage(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+	fake.AddMessage(300, text(1, "dinner?"))
+	fake.AddMessage(2, text(2, "hi"))
+
+	conversation := func(id int64) models.Conversation {
+		t.Helper()
+		conv, err := db.GetConversation(1, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *conv
+	}
+
+	e.Run(ctx, 1)
+	ann := conversation(2)
+	if ann.LastMessageID != 2 || ann.LastMessage != "hi" || ann.LastSenderName != "Ann" {
+		t.Errorf("after the first run = %+v", ann)
+	}
+	if !ann.LastTime.Equal(time.Unix(1700000002, 0)) {
+		t.Errorf("last time = %v", ann.LastTime)
+	}
+
+	// Chats are listed by their last message, the channel without any last
+	convs, _ := db.GetConversationsByUserID(1)
+	var order []int64
+	for _, conv := range convs {
+		order = append(order, conv.ID)
+	}
+	if len(order) != 3 || order[0] != 2 || order[1] != 300 || order[2] != 400 {
+		t.Errorf("order = %v", order)
+	}
+
+	mention := text(3, "@me look")
+	mention.Mentioned = true
+	fake.AddMessage(2, mention)
+	e.Run(ctx, 1)
+	if ann := conversation(2); ann.LastMessage != "@me look" || ann.UnreadCount != 1 || ann.UnreadMentionsCount != 1 {
+		t.Errorf("after a mention = %+v", ann)
+	}
+
+	// Writing in a chat reads it
+	reply := text(4, "on it")
+	reply.Out = true
+	fake.AddMessage(2, reply)
+	e.Run(ctx, 1)
+	if ann := conversation(2); ann.LastMessageID != 4 || ann.LastSenderID != 0 || ann.UnreadCount != 0 || ann.UnreadMentionsCount != 0 {
+		t.Errorf("after a reply = %+v", ann)
+	}
+}
+
+func addPolicy(t *testing.T, db *database.DB, p models.SyncPolicy) {
+	t.Helper()
+	p.UserID = 1
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSyncPolicy(&p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPolicyExcludes(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+	addPolicy(t, db, models.SyncPolicy{PeerType: "channel", Sync: false})
+	addPolicy(t, db, models.SyncPolicy{PeerID: 2, Sync: false})
+	fake.AddMessage(2, text(1, "hi"))
+	fake.AddMessage(300, text(2, "dinner?"))
+	fake.AddMessage(400, text(10, "post"))
+
+	result := e.Run(ctx, 1)
+	if result.Excluded != 2 || result.Conversations != 1 || result.Messages != 1 {
+		t.Fatalf("result = %+v", result)
+	}
+	// Excluded conversations are still listed
+	if convs, _ := db.GetConversationsByUserID(1); len(convs) != 3 {
+		t.Errorf("conversations = %d, want 3", len(convs))
+	}
+
+	fake.AddMessage(2, text(3, "still there?"))
+	fake.AddMessage(300, text(4, "at 8"))
+	e.Run(ctx, 1)
+	if ids := stored(t, db, 2); len(ids) != 0 {
+		t.Errorf("excluded chat messages = %v", ids)
+	}
+	if ids := stored(t, db, 300); len(ids) != 2 {
+		t.Errorf("group messages = %v", ids)
+	}
+}
+
+func TestPolicyDepth(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+	addPolicy(t, db, models.SyncPolicy{PeerID: 300, Sync: true, Backfill: models.BackfillMessages, BackfillLimit: 150})
+	addPolicy(t, db, models.SyncPolicy{PeerID: 2, Sync: true, Backfill: models.BackfillDays, BackfillLimit: 2})
+	addPolicy(t, db, models.SyncPolicy{PeerID: 400, Sync: true, Backfill: models.BackfillNone})
+
+	now := time.Now()
+	for id := 1; id <= 750; id++ {
+		fake.AddMessage(300, text(id, "msg"))
+		fake.AddMessage(400, text(id, "post"))
+		// One message an hour, the newest half an hour ago
+		age := time.Duration(750-id)*time.Hour + 30*time.Minute
+		fake.AddMessage(2, &tg.Message{ID: id, Message: "hourly", Date: int(now.Add(-age).Unix())})
+	}
+
+	e.Run(ctx, 1)
+	e.Run(ctx, 1)
+	if n := count(t, db, 300); n != 150 {
+		t.Errorf("group messages = %d, want 150", n)
+	}
+	// 48 hours of messages and the first one before them
+	if n := count(t, db, 2); n != 49 {
+		t.Errorf("direct messages = %d, want 49", n)
+	}
+	if n := count(t, db, 400); n != 1 {
+		t.Errorf("channel posts = %d, want 1", n)
+	}
+
+	// The depth is reached without fetching again
+	calls := fake.Calls("GetHistory")
+	e.Incremental(ctx, 1)
+	if extra := fake.Calls("GetHistory") - calls; extra != 1 {
+		t.Errorf("%d history requests, want only the channel's catch-up", extra)
+	}
+}
+
+func TestPolicyPriority(t *testing.T) {
+	e, db, _, _ := newTestEngine(t)
+	addPolicy(t, db, models.SyncPolicy{PeerType: "user", Sync: true, Priority: 10})
+	addPolicy(t, db, models.SyncPolicy{PeerType: "channel", Sync: true, Priority: -5})
+
+	convs := []models.Conversation{{ID: 400, Type: "channel"}, {ID: 300, Type: "group"}, {ID: 2, Type: "user"}}
+	var order []int64
+	for _, p := range e.plan(1, convs, &Result{}) {
+		order = append(order, p.conv.ID)
+	}
+	if len(order) != 3 || order[0] != 2 || order[1] != 300 || order[2] != 400 {
+		t.Errorf("order = %v, want the chat first and the channel last", order)
+	}
+}
+
+func photo(id, photoID int64, size int) *tg.Message {
+	msg := text(int(id), "")
+	msg.Media = &tg.MessageMediaPhoto{Photo: &tg.Photo{ID: photoID,
+		Sizes: []tg.PhotoSizeClass{&tg.PhotoSize{Type: "y", W: 1280, H: 720, Size: size}}}}
+	return msg
+}
+
+func TestPolicyMedia(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+	addPolicy(t, db, models.SyncPolicy{PeerID: 400, Sync: true, MediaTypes: []string{"photo"}, MaxMediaSize: 1000})
+
+	fake.AddMessage(400, photo(10, 501, 500))
+	fake.AddMessage(400, photo(11, 502, 5000))
+	video := text(12, "")
+	video.Media = &tg.MessageMediaDocument{Document: &tg.Document{ID: 503, Size: 100,
+		Attributes: []tg.DocumentAttributeClass{&tg.DocumentAttributeVideo{W: 10, H: 10}}}}
+	fake.AddMessage(400, video)
+	// The default policy downloads nothing
+	fake.AddMessage(300, photo(5, 504, 500))
+	for id := int64(501); id <= 504; id++ {
+		fake.SetPhoto(id, []byte("file"))
+	}
+
+	result := e.Full(ctx, 1)
+	if len(result.Errors) > 0 {
+		t.Fatalf("errors = %v", result.Errors)
+	}
+	if result.Media != 1 {
+		t.Errorf("downloaded %d media files, want the small photo only", result.Media)
+	}
+
+	msg, err := db.GetMessage(400, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.store.Path(msg.MediaURL); !ok {
+		t.Fatalf("photo media URL = %q, want a stored file", msg.MediaURL)
+	}
+	for _, id := range []struct {
+		conv int64
+		msg  int
+	}{{400, 11}, {400, 12}, {300, 5}} {
+		other, err := db.GetMessage(id.conv, id.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if media.IsLocal(other.MediaURL) {
+			t.Errorf("message %d of %d was downloaded", id.msg, id.conv)
+		}
+	}
+
+	// Saving the message again keeps the downloaded file
+	again := *msg
+	again.MediaURL = "telegram://photo/501_500"
+	if err := db.SaveMessage(&again); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := db.GetMessage(400, 10); err != nil || saved.MediaURL != msg.MediaURL {
+		t.Errorf("media URL after saving again = %q (%v), want %q", saved.MediaURL, err, msg.MediaURL)
+	}
+
+	// Downloaded files are not fetched again, failed ones are retried
+	fake.AddMessage(400, photo(13, 505, 500))
+	fake.Fail("DownloadMessageMedia", tgerr.New(400, "FILE_REFERENCE_EXPIRED"))
+	e.Incremental(ctx, 1)
+	if calls := fake.Calls("DownloadMessageMedia"); calls != 2 {
+		t.Errorf("DownloadMessageMedia calls = %d, want 2", calls)
+	}
+	fake.SetPhoto(505, []byte("file"))
+	if result := e.Incremental(ctx, 1); result.Media != 1 {
+		t.Errorf("retry downloaded %d media files, want 1", result.Media)
+	}
+}
@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"sort"
+	"time"
+
+	"tgbackup/internal/models"
+)
+
+// planned is a conversation to sync with the policy that applies to it
+type planned struct {
+	conv   *models.Conversation
+	policy models.SyncPolicy
+}
+
+// plan matches the conversations of a user against their sync policies and
+// returns those to sync, highest priority first. Without policies, e.g. when
+// they cannot be read, every conversation gets the default policy.
+func (e *Engine) plan(userID int64, conversations []models.Conversation, result *Result) []planned {
+	policies, err := e.db.GetSyncPolicies(userID)
+	if err != nil {
+		result.fail(0, "policies", err)
+	}
+
+	var plan []planned
+	for i := range conversations {
+		policy := models.MatchSyncPolicy(policies, &conversations[i])
+		if !policy.Sync {
+			result.Excluded++
+			continue
+		}
+		plan = append(plan, planned{conv: &conversations[i], policy: policy})
+	}
+	sort.SliceStable(plan, func(i, j int) bool { return plan[i].policy.Priority > plan[j].policy.Priority })
+	return plan
+}
+
+// excluded returns the IDs of the conversations whose policy turns sync off
+func (e *Engine) excluded(userID int64) (map[int64]bool, error) {
+	policies, err := e.db.GetSyncPolicies(userID)
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+	conversations, err := e.db.GetConversationsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int64]bool)
+	for i := range conversations {
+		if policy := models.MatchSyncPolicy(policies, &conversations[i]); !policy.Sync {
+			ids[conversations[i].ID] = true
+		}
+	}
+	return ids, nil
+}
+
+// firstPage is the number of messages fetched for a conversation without
+// cursor. Backfill none only keeps the newest message.
+func firstPage(policy models.SyncPolicy) int {
+	switch {
+	case policy.Backfill == models.BackfillNone:
+		return 1
+	case policy.Backfill == models.BackfillMessages && policy.BackfillLimit < pageSize:
+		return policy.BackfillLimit
+	}
+	return pageSize
+}
+
+// withinDepth cuts a page, newest first, at the policy's days. The first
+// message older than the cut is kept, so the stored history shows the depth
+// was reached without fetching the page again. done reports a cut.
+func withinDepth(messages []models.Message, policy models.SyncPolicy, now time.Time) (kept []models.Message, done bool) {
+	since := policy.Since(now)
+	if since.IsZero() {
+		return messages, false
+	}
+	for i := range messages {
+		if messages[i].Timestamp.Before(since) {
+			return messages[:i+1], true
+		}
+	}
+	return messages, false
+}
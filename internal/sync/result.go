@@ -0,0 +1,40 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+)
+
+// Result is the outcome of one sync run of a user
+type Result struct {
+	UserID        int64     `json:"user_id"`
+	Mode          string    `json:"mode"`                     // full, incremental
+	Conversations int       `json:"conversations"`            // conversations whose messages were fetched
+	Messages      int       `json:"messages"`                 // messages saved
+	Media         int       `json:"media,omitempty"`          // media files downloaded
+	Excluded      int       `json:"excluded,omitempty"`       // conversations a sync policy turns off
+	Skipped       bool      `json:"skipped,omitempty"`        // another sync of the user was still running
+	UpdatesFailed bool      `json:"updates_failed,omitempty"` // the account's updates were not fetched or saved
+	Errors        []Error   `json:"errors,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+}
+
+// Error is a failure during a sync run. Most failures only affect one
+// conversation and the run goes on with the next.
+type Error struct {
+	ConversationID int64  `json:"conversation_id,omitempty"`
+	Stage          string `json:"stage"` // dialogs, policies, history, topics, media, updates, state, save
+	Message        string `json:"message"`
+}
+
+func (e Error) Error() string {
+	if e.ConversationID != 0 {
+		return fmt.Sprintf("%s of conversation %d: %s", e.Stage, e.ConversationID, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Stage, e.Message)
+}
+
+func (r *Result) fail(convID int64, stage string, err error) {
+	r.Errors = append(r.Errors, Error{ConversationID: convID, Stage: stage, Message: err.Error()})
+}
@@ -0,0 +1,307 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/models"
+)
+
+const (
+	// Bounds of the poll interval of a conversation
+	minInterval = time.Minute
+	maxInterval = 6 * time.Hour
+	// The account's updates carry every private chat and basic group, they
+	// are fetched at least this often
+	maxUpdatesInterval = 10 * time.Minute
+	// Interval of a conversation without messages in the rate window, before
+	// backing off
+	idleInterval = time.Hour
+	// Conversations polled per run at most, the others follow in later runs
+	maxPerRun = 20
+	// Messages of this window make up the message rate of a conversation
+	rateWindow = 24 * time.Hour
+	// A conversation whose sync failed is retried after this, twice as long
+	// for every further failure in a row, but not later than its interval
+	retryInterval = 2 * time.Minute
+)
+
+// Scheduler decides when the conversations of an account are synced. A
+// conversation is polled about as often as it gets messages and more often
+// for a higher policy priority, idle ones back off up to maxInterval. Next
+// runs are stored, so a restart goes on with the schedule instead of syncing
+// everything at once.
+type Scheduler struct {
+	db     *database.DB
+	engine *Engine
+
+	now       func() time.Time
+	rand      func() float64
+	maxPerRun int
+}
+
+func NewScheduler(db *database.DB, engine *Engine) *Scheduler {
+	return &Scheduler{
+		db:        db,
+		engine:    engine,
+		now:       time.Now,
+		rand:      rand.Float64,
+		maxPerRun: maxPerRun,
+	}
+}
+
+// Due reports whether a sync of the user is due, which it is as long as
+// nothing is scheduled
+func (s *Scheduler) Due(userID int64) bool {
+	next, err := s.db.NextScheduledRun(userID)
+	if err != nil {
+		log.Printf("Failed to get the next scheduled sync of user %d: %v", userID, err)
+		return true
+	}
+	return !next.After(s.now())
+}
+
+// RunDue syncs what of the user is due and schedules it again: the account's
+// updates and the conversations whose next run has come, soonest first and at
+// most maxPerRun of them. What failed is retried soon instead of after its
+// interval. A user without updates state gets the full sync instead. Returns
+// nil when nothing was due.
+func (s *Scheduler) RunDue(ctx context.Context, userID int64) *Result {
+	return s.run(ctx, userID, true)
+}
+
+// Run syncs the account's updates and all its conversations now, as for a
+// sync asked for by hand, and schedules them again like RunDue does. Earlier
+// failures are cleared for whatever synced this time.
+func (s *Scheduler) Run(ctx context.Context, userID int64) *Result {
+	return s.run(ctx, userID, false)
+}
+
+func (s *Scheduler) run(ctx context.Context, userID int64, dueOnly bool) *Result {
+	pts, qts, date, _, err := s.db.GetUpdatesState(userID)
+	if err != nil {
+		log.Printf("Failed to get updates state of user %d: %v", userID, err)
+		return nil
+	}
+	if pts == 0 && qts == 0 && date == 0 {
+		result := s.engine.Full(ctx, userID)
+		if !result.Skipped {
+			// Everything was just synced, the next runs are spread over the intervals
+			if _, err := s.load(userID, s.now()); err != nil {
+				log.Printf("Failed to schedule sync of user %d: %v", userID, err)
+			}
+		}
+		return result
+	}
+
+	now := s.now()
+	entries, err := s.load(userID, now)
+	if err != nil {
+		log.Printf("Failed to load sync schedule of user %d: %v", userID, err)
+		return nil
+	}
+
+	due := entries
+	if dueOnly {
+		due = nil
+		for _, entry := range entries {
+			if !entry.NextRunAt.After(now) {
+				due = append(due, entry)
+			}
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		if !due[i].NextRunAt.Equal(due[j].NextRunAt) {
+			return due[i].NextRunAt.Before(due[j].NextRunAt)
+		}
+		return due[i].Priority > due[j].Priority
+	})
+
+	var batch []*models.ScheduleEntry
+	updates := false
+	only := make(map[int64]bool)
+	for _, entry := range due {
+		if entry.ConversationID == 0 {
+			updates = true
+		} else if !dueOnly || len(only) < s.maxPerRun {
+			only[entry.ConversationID] = true
+		} else {
+			continue
+		}
+		batch = append(batch, entry)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	result := s.engine.Poll(ctx, userID, updates, only)
+	if result.Skipped {
+		return result
+	}
+
+	failed := map[int64]bool{0: result.UpdatesFailed}
+	for _, e := range result.Errors {
+		if e.ConversationID != 0 {
+			failed[e.ConversationID] = true
+		} else if e.Stage == "dialogs" {
+			// The conversations could not be loaded, none of them was synced
+			for id := range only {
+				failed[id] = true
+			}
+		}
+	}
+
+	done := s.now()
+	var next []models.ScheduleEntry
+	for _, entry := range batch {
+		if failed[entry.ConversationID] {
+			s.retry(entry, done)
+		} else {
+			s.reschedule(entry, done)
+		}
+		next = append(next, *entry)
+	}
+	if err := s.db.SaveSchedule(next); err != nil {
+		log.Printf("Failed to save sync schedule of user %d: %v", userID, err)
+	}
+	return result
+}
+
+// load returns the schedule of the account's updates and of every
+// conversation its policies include. Conversations new to the schedule get a
+// next run somewhere within their interval, those no longer synced are
+// removed from it.
+func (s *Scheduler) load(userID int64, now time.Time) ([]*models.ScheduleEntry, error) {
+	stored, err := s.db.GetSchedule(userID)
+	if err != nil {
+		return nil, err
+	}
+	conversations, err := s.db.GetConversationsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := s.db.GetSyncPolicies(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	priorities := map[int64]int{0: 0}
+	for i := range conversations {
+		if policy := models.MatchSyncPolicy(policies, &conversations[i]); policy.Sync {
+			priorities[conversations[i].ID] = policy.Priority
+		}
+	}
+
+	var entries []*models.ScheduleEntry
+	var changed []models.ScheduleEntry
+	var removed []int64
+	for i := range stored {
+		entry := &stored[i]
+		priority, ok := priorities[entry.ConversationID]
+		if !ok {
+			removed = append(removed, entry.ConversationID)
+			continue
+		}
+		delete(priorities, entry.ConversationID)
+		if entry.Priority != priority {
+			entry.Priority = priority
+			changed = append(changed, *entry)
+		}
+		entries = append(entries, entry)
+	}
+
+	for id, priority := range priorities {
+		entry := &models.ScheduleEntry{UserID: userID, ConversationID: id, Priority: priority}
+		d := s.interval(entry, now)
+		entry.Interval = int(d / time.Second)
+		entry.NextRunAt = now.Add(time.Duration(s.rand() * float64(d)))
+		entries = append(entries, entry)
+		changed = append(changed, *entry)
+	}
+
+	if len(removed) > 0 {
+		if err := s.db.DeleteScheduleEntries(userID, removed); err != nil {
+			return nil, err
+		}
+	}
+	if len(changed) > 0 {
+		if err := s.db.SaveSchedule(changed); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// reschedule sets the next run of an entry that just ran, a tenth of the
+// interval earlier or later so conversations with the same interval drift
+// apart
+func (s *Scheduler) reschedule(entry *models.ScheduleEntry, now time.Time) {
+	d := s.interval(entry, now)
+	entry.Interval = int(d / time.Second)
+	entry.LastRunAt = now
+	entry.NextRunAt = now.Add(d + time.Duration((s.rand()*0.2-0.1)*float64(d)))
+	entry.Failures = 0
+}
+
+// retry sets the next run of an entry whose sync just failed to
+// retryInterval later, doubled for every earlier failure in a row and at
+// most its interval. The interval itself is kept for the next success.
+func (s *Scheduler) retry(entry *models.ScheduleEntry, now time.Time) {
+	d := s.interval(entry, now)
+	entry.Failures++
+
+	wait := retryInterval
+	for i := 1; i < entry.Failures && wait < d; i++ {
+		wait *= 2
+	}
+	if wait > d {
+		wait = d
+	}
+	entry.LastRunAt = now
+	entry.NextRunAt = now.Add(wait)
+}
+
+// interval computes the poll interval of an entry from the messages of its
+// conversation, or of the whole account for its updates, in the rate window
+func (s *Scheduler) interval(entry *models.ScheduleEntry, now time.Time) time.Duration {
+	count, err := s.db.CountMessagesSince(entry.UserID, entry.ConversationID, now.Add(-rateWindow))
+	if err != nil {
+		log.Printf("Failed to count recent messages of conversation %d: %v", entry.ConversationID, err)
+	}
+	entry.Rate = float64(count) / rateWindow.Hours()
+
+	longest := maxInterval
+	if entry.ConversationID == 0 {
+		longest = maxUpdatesInterval
+	}
+	return interval(entry.Rate, entry.Priority, time.Duration(entry.Interval)*time.Second, longest)
+}
+
+// interval is the wait before a conversation is polled again: the time
+// between two of its messages at the given rate per hour, halved for every 10
+// points of priority. Without messages the previous interval doubles.
+func interval(rate float64, priority int, previous, longest time.Duration) time.Duration {
+	var d time.Duration
+	if rate <= 0 && previous > 0 {
+		d = previous * 2
+	} else {
+		d = idleInterval
+		if rate > 0 {
+			d = time.Duration(float64(time.Hour) / rate)
+		}
+		d = time.Duration(float64(d) * math.Pow(2, -float64(priority)/10))
+	}
+
+	if d < minInterval {
+		d = minInterval
+	}
+	if d > longest {
+		d = longest
+	}
+	return d
+}
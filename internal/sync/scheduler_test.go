@@ -0,0 +1,238 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tgerr"
+
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram/telegramtest"
+)
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		priority int
+		previous time.Duration
+		longest  time.Duration
+		want     time.Duration
+	}{
+		{"busy", 6, 0, 0, maxInterval, 10 * time.Minute},
+		{"busy ignores previous", 6, 0, 4 * time.Hour, maxInterval, 10 * time.Minute},
+		{"priority halves", 6, 10, 0, maxInterval, 5 * time.Minute},
+		{"negative priority doubles", 6, -10, 0, maxInterval, 20 * time.Minute},
+		{"idle", 0, 0, 0, maxInterval, idleInterval},
+		{"idle backs off", 0, 0, 2 * time.Hour, maxInterval, 4 * time.Hour},
+		{"at most max", 0, 0, 4 * time.Hour, maxInterval, maxInterval},
+		{"at least min", 120, 0, 0, maxInterval, minInterval},
+		{"updates max", 0, 0, 0, maxUpdatesInterval, maxUpdatesInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interval(tt.rate, tt.priority, tt.previous, tt.longest); got != tt.want {
+				t.Errorf("interval = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDue(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+	fake.AddMessage(400, text(10, "post"))
+	fake.AddMessage(400, text(11, "second post"))
+
+	clock := time.Unix(1700000000, 0).Add(time.Hour)
+	newScheduler := func() *Scheduler {
+		s := NewScheduler(db, e)
+		s.now = func() time.Time { return clock }
+		s.rand = func() float64 { return 0.5 }
+		s.maxPerRun = 1
+		return s
+	}
+	s := newScheduler()
+
+	if !s.Due(1) {
+		t.Fatal("nothing scheduled but not due")
+	}
+	if result := s.RunDue(ctx, 1); result == nil || result.Mode != "full" {
+		t.Fatalf("first run = %+v, want full", result)
+	}
+
+	// The account and all 3 conversations are scheduled within their intervals
+	schedule, err := db.GetSchedule(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedule) != 4 {
+		t.Fatalf("schedule = %+v, want 4 entries", schedule)
+	}
+	for _, entry := range schedule {
+		if !entry.NextRunAt.After(clock) {
+			t.Errorf("conversation %d runs at %v, not after the full sync", entry.ConversationID, entry.NextRunAt)
+		}
+		// 2 messages a day is slower than the longest interval
+		if entry.ConversationID == 400 && entry.Interval != int(maxInterval/time.Second) {
+			t.Errorf("channel interval = %ds, want %v", entry.Interval, maxInterval)
+		}
+	}
+
+	// A restart keeps the schedule instead of syncing again
+	restarted := newScheduler()
+	if restarted.Due(1) {
+		t.Error("due right after a restart")
+	}
+	if result := restarted.RunDue(ctx, 1); result != nil {
+		t.Errorf("run after restart = %+v, want nothing due", result)
+	}
+
+	// Everything is due, the account and one conversation are polled
+	clock = clock.Add(maxInterval + time.Minute)
+	before := fake.Calls("GetHistory")
+	result := s.RunDue(ctx, 1)
+	if result == nil || result.Mode != "incremental" {
+		t.Fatalf("second run = %+v, want incremental", result)
+	}
+	if calls := fake.Calls("GetHistory") - before; calls > 1 {
+		t.Errorf("GetHistory calls = %d, want at most 1", calls)
+	}
+
+	schedule, err = db.GetSchedule(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran := map[int64]bool{}
+	for _, entry := range schedule {
+		if entry.LastRunAt.Equal(clock) {
+			ran[entry.ConversationID] = true
+			if !entry.NextRunAt.After(clock) {
+				t.Errorf("conversation %d not rescheduled", entry.ConversationID)
+			}
+		}
+	}
+	if len(ran) != 2 || !ran[0] {
+		t.Errorf("ran = %v, want the account and one conversation", ran)
+	}
+	if !s.Due(1) {
+		t.Error("the conversations left over are not due")
+	}
+}
+
+func TestRunDueRetriesFailures(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+	fake.AddMessage(400, text(10, "post"))
+
+	clock := time.Unix(1700000000, 0).Add(time.Hour)
+	s := NewScheduler(db, e)
+	s.now = func() time.Time { return clock }
+	s.rand = func() float64 { return 0.5 }
+	if result := s.RunDue(ctx, 1); result == nil || result.Mode != "full" {
+		t.Fatalf("first run = %+v, want full", result)
+	}
+
+	// Only the channel is due
+	schedule, err := db.GetSchedule(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range schedule {
+		if schedule[i].ConversationID == 400 {
+			schedule[i].NextRunAt = clock
+		} else {
+			schedule[i].NextRunAt = clock.Add(24 * time.Hour)
+		}
+	}
+	if err := db.SaveSchedule(schedule); err != nil {
+		t.Fatal(err)
+	}
+	channel := func() models.ScheduleEntry {
+		t.Helper()
+		schedule, err := db.GetSchedule(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range schedule {
+			if entry.ConversationID == 400 {
+				return entry
+			}
+		}
+		t.Fatal("channel not scheduled")
+		return models.ScheduleEntry{}
+	}
+
+	// A flood wait too long to wait out fails the sync, it is retried soon
+	// and later after every further failure
+	for i, want := range []time.Duration{retryInterval, 2 * retryInterval} {
+		fake.Fail("GetHistory", telegramtest.FloodWait(int(2*maxFloodWait/time.Second)))
+		result := s.RunDue(ctx, 1)
+		if result == nil || len(result.Errors) == 0 {
+			t.Fatalf("run %d = %+v, want a failed sync", i+1, result)
+		}
+		entry := channel()
+		if entry.Failures != i+1 || !entry.NextRunAt.Equal(clock.Add(want)) {
+			t.Errorf("after failure %d the channel runs at %v with %d failures, want %v later",
+				i+1, entry.NextRunAt, entry.Failures, want)
+		}
+		clock = clock.Add(want)
+	}
+
+	// A success goes back to the normal interval
+	if result := s.RunDue(ctx, 1); result == nil || len(result.Errors) > 0 {
+		t.Fatalf("run after the failures = %+v, want a successful sync", result)
+	}
+	if entry := channel(); entry.Failures != 0 || !entry.NextRunAt.After(clock.Add(2*retryInterval)) {
+		t.Errorf("after a success the channel runs at %v with %d failures, want its interval", entry.NextRunAt, entry.Failures)
+	}
+}
+
+func TestRunClearsFailures(t *testing.T) {
+	ctx := context.Background()
+	e, db, fake, _ := newTestEngine(t)
+
+	clock := time.Unix(1700000000, 0).Add(time.Hour)
+	s := NewScheduler(db, e)
+	s.now = func() time.Time { return clock }
+	s.rand = func() float64 { return 0.5 }
+	if result := s.RunDue(ctx, 1); result == nil || result.Mode != "full" {
+		t.Fatalf("first run = %+v, want full", result)
+	}
+	entry := func(convID int64) models.ScheduleEntry {
+		t.Helper()
+		schedule, err := db.GetSchedule(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range schedule {
+			if entry.ConversationID == convID {
+				return entry
+			}
+		}
+		t.Fatalf("conversation %d not scheduled", convID)
+		return models.ScheduleEntry{}
+	}
+
+	// Only a failure of the updates themselves retries them soon
+	clock = clock.Add(maxUpdatesInterval)
+	fake.Fail("GetUpdates", tgerr.New(400, "PERSISTENT_TIMESTAMP_EMPTY"))
+	result := s.RunDue(ctx, 1)
+	if result == nil || !result.UpdatesFailed {
+		t.Fatalf("run = %+v, want failed updates", result)
+	}
+	if updates := entry(0); updates.Failures != 1 || !updates.NextRunAt.Equal(clock.Add(retryInterval)) {
+		t.Errorf("updates run at %v with %d failures, want a retry", updates.NextRunAt, updates.Failures)
+	}
+
+	// A sync by hand runs everything and clears the failures
+	if result := s.Run(ctx, 1); result == nil || len(result.Errors) > 0 {
+		t.Fatalf("run by hand = %+v, want a successful sync", result)
+	}
+	for _, convID := range []int64{0, 2, 300, 400} {
+		if got := entry(convID); got.Failures != 0 || !got.LastRunAt.Equal(clock) {
+			t.Errorf("conversation %d last ran at %v with %d failures, want a run now", convID, got.LastRunAt, got.Failures)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package telegram
+
+import (
+	"context"
+	"io"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+// API is what the handlers, the sync and the refreshers need from a Telegram
+// connection. *Client implements it over the network, telegramtest.Fake
+// replays scripted dialogs, messages and updates without one.
+type API interface {
+	Auth
+	Dialogs
+	History
+	Updates
+	Files
+	Stats
+	ChatState
+	Peers
+}
+
+// Auth signs an account in and checks its session
+type Auth interface {
+	Connect(ctx context.Context, appID int, appHash string) error
+	StartAuth(ctx context.Context, phone string) (string, error)
+	VerifyCode(ctx context.Context, phone, code, codeHash string) error
+	GenerateQRCode(ctx context.Context) (string, error)
+	CheckQRCode(ctx context.Context, token []byte) error
+	IsAuthenticated(ctx context.Context) bool
+	CheckAuth(ctx context.Context) error
+	GetCurrentUserInfo(ctx context.Context) (*models.User, error)
+	LogOut(ctx context.Context) error
+}
+
+// Dialogs lists the conversations of the account
+type Dialogs interface {
+	GetDialogs(ctx context.Context) ([]models.Conversation, error)
+	GetForumTopics(ctx context.Context, channelID int64, accessHash string) ([]models.ForumTopic, error)
+	GetLinkedChat(ctx context.Context, channelID int64, accessHash string) (*models.Conversation, error)
+}
+
+// History reads the messages of a conversation, newest first
+type History interface {
+	GetMessagesWithConvInfo(ctx context.Context, peerID int64, limit int, convType, accessHash string) ([]models.Message, error)
+	GetHistory(ctx context.Context, peerID int64, convType, accessHash string, offsetID, limit int) ([]models.Message, error)
+	GetPostComments(ctx context.Context, channelID int64, accessHash string, postID int, discussionID int64, offsetID, limit int) ([]models.Message, error)
+}
+
+// Updates returns what changed since a stored updates state
+type Updates interface {
+	GetState(ctx context.Context) (*tg.UpdatesState, error)
+	GetUpdates(ctx context.Context, pts int, date int, qts int) (*tg.UpdatesDifference, error)
+	ParseUpdatesMessages(updates *tg.UpdatesDifference, userID int64) []models.Message
+}
+
+// Files downloads profile photos and the media of messages
+type Files interface {
+	GetUserPhotos(ctx context.Context, userID int64, accessHash string, limit int) ([]ProfilePhoto, error)
+	GetSelfPhotos(ctx context.Context, limit int) ([]ProfilePhoto, error)
+	DownloadProfilePhoto(ctx context.Context, photo ProfilePhoto, w io.Writer) error
+	DownloadPeerPhoto(ctx context.Context, peerID int64, convType, accessHash string, photoID int64, w io.Writer) error
+	DownloadMessageMedia(ctx context.Context, peerID int64, convType, accessHash string, messageID int, w io.Writer) error
+}
+
+// Stats reads the current counters of messages
+type Stats interface {
+	GetMessageStats(ctx context.Context, peerID int64, convType, accessHash string, ids []int) ([]models.MessageStats, error)
+}
+
+// ChatState reads what changes in a conversation without a new message
+type ChatState interface {
+	GetPinnedMessages(ctx context.Context, peerID int64, convType, accessHash string) ([]models.Message, error)
+	GetScheduledMessages(ctx context.Context, peerID int64, convType, accessHash string) ([]models.Message, error)
+	GetChatSnapshot(ctx context.Context, conv *models.Conversation) (*models.ChatSnapshot, error)
+}
+
+// Peers reads the profiles of the users the account knows
+type Peers interface {
+	TakeSeenPeers() []models.Peer
+	GetContacts(ctx context.Context) ([]models.AccountContact, []models.Peer, error)
+	GetUserBio(ctx context.Context, userID int64, accessHash string) (string, error)
+}
+
+var _ API = (*Client)(nil)
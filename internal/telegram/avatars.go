@@ -0,0 +1,144 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+)
+
+// ProfilePhoto is a photo from the profile photo list of a user
+type ProfilePhoto struct {
+	ID    int64
+	Date  time.Time
+	photo *tg.Photo
+}
+
+func userPhotoID(photo tg.UserProfilePhotoClass) int64 {
+	if p, ok := photo.(*tg.UserProfilePhoto); ok {
+		return p.PhotoID
+	}
+	return 0
+}
+
+func chatPhotoID(photo tg.ChatPhotoClass) int64 {
+	if p, ok := photo.(*tg.ChatPhoto); ok {
+		return p.PhotoID
+	}
+	return 0
+}
+
+// GetUserPhotos returns up to limit profile photos of a user, newest first.
+// The current photo is usually the first one.
+func (c *Client) GetUserPhotos(ctx context.Context, userID int64, accessHash string, limit int) ([]ProfilePhoto, error) {
+	input := &tg.InputUser{UserID: userID}
+	if accessHash != "" {
+		hash, err := strconv.ParseInt(accessHash, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access hash: %v", err)
+		}
+		input.AccessHash = hash
+	}
+	return c.getUserPhotos(ctx, input, limit)
+}
+
+// GetSelfPhotos returns up to limit profile photos of the account itself
+func (c *Client) GetSelfPhotos(ctx context.Context, limit int) ([]ProfilePhoto, error) {
+	return c.getUserPhotos(ctx, &tg.InputUserSelf{}, limit)
+}
+
+func (c *Client) getUserPhotos(ctx context.Context, user tg.InputUserClass, limit int) ([]ProfilePhoto, error) {
+	if !c.isConnected || c.api == nil {
+		return nil, fmt.Errorf("client not connected")
+	}
+
+	result, err := c.api.PhotosGetUserPhotos(ctx, &tg.PhotosGetUserPhotosRequest{UserID: user, Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile photos: %v", err)
+	}
+
+	var list []tg.PhotoClass
+	switch r := result.(type) {
+	case *tg.PhotosPhotos:
+		list = r.Photos
+	case *tg.PhotosPhotosSlice:
+		list = r.Photos
+	}
+
+	var photos []ProfilePhoto
+	for _, p := range list {
+		if photo, ok := p.(*tg.Photo); ok {
+			photos = append(photos, ProfilePhoto{ID: photo.ID, Date: time.Unix(int64(photo.Date), 0), photo: photo})
+		}
+	}
+	return photos, nil
+}
+
+// DownloadProfilePhoto writes the largest size of a profile photo to w
+func (c *Client) DownloadProfilePhoto(ctx context.Context, photo ProfilePhoto, w io.Writer) error {
+	if !c.isConnected || c.api == nil {
+		return fmt.Errorf("client not connected")
+	}
+
+	thumb := largestPhotoSize(photo.photo)
+	if thumb == "" {
+		return fmt.Errorf("photo %d has no downloadable size", photo.ID)
+	}
+
+	loc := &tg.InputPhotoFileLocation{
+		ID:            photo.photo.ID,
+		AccessHash:    photo.photo.AccessHash,
+		FileReference: photo.photo.FileReference,
+		ThumbSize:     thumb,
+	}
+	if _, err := downloader.NewDownloader().Download(c.api, loc).Stream(ctx, w); err != nil {
+		return fmt.Errorf("failed to download photo %d: %v", photo.ID, err)
+	}
+	return nil
+}
+
+// DownloadPeerPhoto writes the current photo of a user, group or channel to
+// w. Unlike profile photo lists this also works for chats.
+func (c *Client) DownloadPeerPhoto(ctx context.Context, peerID int64, convType, accessHash string, photoID int64, w io.Writer) error {
+	if !c.isConnected || c.api == nil {
+		return fmt.Errorf("client not connected")
+	}
+
+	peer, err := inputPeer(peerID, convType, accessHash)
+	if err != nil {
+		return err
+	}
+
+	loc := &tg.InputPeerPhotoFileLocation{Big: true, Peer: peer, PhotoID: photoID}
+	if _, err := downloader.NewDownloader().Download(c.api, loc).Stream(ctx, w); err != nil {
+		return fmt.Errorf("failed to download photo %d of %d: %v", photoID, peerID, err)
+	}
+	return nil
+}
+
+// largestPhotoSize returns the type of the largest size of a photo
+func largestPhotoSize(photo *tg.Photo) string {
+	var thumb string
+	var maxArea int
+	for _, size := range photo.Sizes {
+		var w, h int
+		var typ string
+		switch s := size.(type) {
+		case *tg.PhotoSize:
+			typ, w, h = s.Type, s.W, s.H
+		case *tg.PhotoSizeProgressive:
+			typ, w, h = s.Type, s.W, s.H
+		default:
+			continue
+		}
+		if w*h > maxArea {
+			maxArea = w * h
+			thumb = typ
+		}
+	}
+	return thumb
+}
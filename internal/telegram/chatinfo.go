@@ -0,0 +1,234 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/mapper"
+	"tgbackup/internal/models"
+)
+
+const (
+	// participantsPageSize is the number of members requested per call
+	participantsPageSize = 200
+	// Telegram only lists this many members of a supergroup
+	maxParticipants = 10000
+)
+
+// GetChatSnapshot fetches the description, member count, linked chat, invite
+// link and members of a group or channel. Members are only included when the
+// account may list them. Private chats have no snapshot and return nil.
+func (c *Client) GetChatSnapshot(ctx context.Context, conv *models.Conversation) (*models.ChatSnapshot, error) {
+	if !c.isConnected || c.api == nil {
+		return nil, fmt.Errorf("client not connected")
+	}
+
+	switch {
+	case conv.Type == "channel" || (conv.Type == "group" && conv.AccessHash != ""):
+		return c.getChannelSnapshot(ctx, conv)
+	case conv.Type == "group":
+		return c.getChatSnapshot(ctx, conv)
+	default:
+		return nil, nil
+	}
+}
+
+func (c *Client) getChannelSnapshot(ctx context.Context, conv *models.Conversation) (*models.ChatSnapshot, error) {
+	hash, err := strconv.ParseInt(conv.AccessHash, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access hash: %v", err)
+	}
+	channel := &tg.InputChannel{ChannelID: conv.ID, AccessHash: hash}
+
+	full, err := c.api.ChannelsGetFullChannel(ctx, channel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get full info of channel %d: %v", conv.ID, err)
+	}
+	channelFull, ok := full.FullChat.(*tg.ChannelFull)
+	if !ok {
+		return nil, fmt.Errorf("unexpected full info of channel %d", conv.ID)
+	}
+
+	now := time.Now()
+	snap := &models.ChatSnapshot{
+		ConversationID:    conv.ID,
+		Title:             conv.Title,
+		About:             channelFull.About,
+		ParticipantsCount: channelFull.ParticipantsCount,
+		LinkedChatID:      channelFull.LinkedChatID,
+		InviteLink:        inviteLink(channelFull.ExportedInvite),
+		CapturedAt:        now,
+		CheckedAt:         now,
+	}
+
+	if !channelFull.CanViewParticipants || channelFull.ParticipantsHidden {
+		return snap, nil
+	}
+	members, err := c.getChannelMembers(ctx, channel)
+	if err != nil {
+		// Broadcast channels only list their members to admins
+		return snap, nil
+	}
+	snap.Members = members
+	snap.HasMembers = true
+	return snap, nil
+}
+
+func (c *Client) getChannelMembers(ctx context.Context, channel *tg.InputChannel) ([]models.ChatMember, error) {
+	request := &tg.ChannelsGetParticipantsRequest{
+		Channel: channel,
+		Filter:  &tg.ChannelParticipantsRecent{},
+		Limit:   participantsPageSize,
+	}
+
+	var members []models.ChatMember
+	seen := map[int64]bool{}
+	for request.Offset < maxParticipants {
+		result, err := c.api.ChannelsGetParticipants(ctx, request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get members of channel %d: %v", channel.ChannelID, err)
+		}
+		page, ok := result.(*tg.ChannelsChannelParticipants)
+		if !ok {
+			return nil, fmt.Errorf("unexpected members response for channel %d", channel.ChannelID)
+		}
+
+		for _, p := range page.Participants {
+			member, ok := parseChannelParticipant(p)
+			if !ok || seen[member.UserID] {
+				continue
+			}
+			seen[member.UserID] = true
+			fillMemberName(&member, page.Users)
+			members = append(members, member)
+		}
+
+		if len(page.Participants) < request.Limit {
+			break
+		}
+		request.Offset += len(page.Participants)
+	}
+
+	return members, nil
+}
+
+func (c *Client) getChatSnapshot(ctx context.Context, conv *models.Conversation) (*models.ChatSnapshot, error) {
+	full, err := c.api.MessagesGetFullChat(ctx, conv.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get full info of chat %d: %v", conv.ID, err)
+	}
+	chatFull, ok := full.FullChat.(*tg.ChatFull)
+	if !ok {
+		return nil, fmt.Errorf("unexpected full info of chat %d", conv.ID)
+	}
+
+	now := time.Now()
+	snap := &models.ChatSnapshot{
+		ConversationID: conv.ID,
+		Title:          conv.Title,
+		About:          chatFull.About,
+		InviteLink:     inviteLink(chatFull.ExportedInvite),
+		CapturedAt:     now,
+		CheckedAt:      now,
+	}
+
+	participants, ok := chatFull.Participants.(*tg.ChatParticipants)
+	if !ok {
+		// Only the account's own membership is known
+		return snap, nil
+	}
+	for _, p := range participants.Participants {
+		member := parseChatParticipant(p)
+		fillMemberName(&member, full.Users)
+		snap.Members = append(snap.Members, member)
+	}
+	snap.ParticipantsCount = len(snap.Members)
+	snap.HasMembers = true
+	return snap, nil
+}
+
+func parseChannelParticipant(p tg.ChannelParticipantClass) (models.ChatMember, bool) {
+	switch p := p.(type) {
+	case *tg.ChannelParticipant:
+		return models.ChatMember{UserID: p.UserID, Role: "member", JoinedAt: unixTime(p.Date)}, true
+	case *tg.ChannelParticipantSelf:
+		return models.ChatMember{UserID: p.UserID, Role: "member", InviterID: p.InviterID, JoinedAt: unixTime(p.Date)}, true
+	case *tg.ChannelParticipantCreator:
+		return models.ChatMember{UserID: p.UserID, Role: "creator", Rank: p.Rank, AdminRights: adminRights(p.AdminRights)}, true
+	case *tg.ChannelParticipantAdmin:
+		return models.ChatMember{UserID: p.UserID, Role: "admin", Rank: p.Rank, AdminRights: adminRights(p.AdminRights),
+			InviterID: p.InviterID, JoinedAt: unixTime(p.Date)}, true
+	default:
+		// Banned and left participants are not members
+		return models.ChatMember{}, false
+	}
+}
+
+func parseChatParticipant(p tg.ChatParticipantClass) models.ChatMember {
+	switch p := p.(type) {
+	case *tg.ChatParticipantCreator:
+		return models.ChatMember{UserID: p.UserID, Role: "creator"}
+	case *tg.ChatParticipantAdmin:
+		return models.ChatMember{UserID: p.UserID, Role: "admin", InviterID: p.InviterID, JoinedAt: unixTime(p.Date)}
+	case *tg.ChatParticipant:
+		return models.ChatMember{UserID: p.UserID, Role: "member", InviterID: p.InviterID, JoinedAt: unixTime(p.Date)}
+	default:
+		return models.ChatMember{}
+	}
+}
+
+func fillMemberName(member *models.ChatMember, users []tg.UserClass) {
+	if user := mapper.FindUser(users, member.UserID); user != nil {
+		member.Username = user.Username
+		member.FirstName = user.FirstName
+		member.LastName = user.LastName
+	}
+}
+
+// adminRights lists the granted rights by their Bot API names
+func adminRights(r tg.ChatAdminRights) []string {
+	rights := []struct {
+		granted bool
+		name    string
+	}{
+		{r.ChangeInfo, "can_change_info"},
+		{r.PostMessages, "can_post_messages"},
+		{r.EditMessages, "can_edit_messages"},
+		{r.DeleteMessages, "can_delete_messages"},
+		{r.BanUsers, "can_restrict_members"},
+		{r.InviteUsers, "can_invite_users"},
+		{r.PinMessages, "can_pin_messages"},
+		{r.AddAdmins, "can_promote_members"},
+		{r.Anonymous, "is_anonymous"},
+		{r.ManageCall, "can_manage_video_chats"},
+		{r.Other, "can_manage_chat"},
+		{r.ManageTopics, "can_manage_topics"},
+	}
+
+	var granted []string
+	for _, right := range rights {
+		if right.granted {
+			granted = append(granted, right.name)
+		}
+	}
+	return granted
+}
+
+func inviteLink(invite tg.ExportedChatInviteClass) string {
+	if exported, ok := invite.(*tg.ChatInviteExported); ok && !exported.Revoked {
+		return exported.Link
+	}
+	return ""
+}
+
+func unixTime(date int) *time.Time {
+	if date == 0 {
+		return nil
+	}
+	t := time.Unix(int64(date), 0)
+	return &t
+}
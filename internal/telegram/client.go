@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gotd/td/session"
@@ -15,9 +16,12 @@ import (
 	"github.com/gotd/td/telegram/auth"
 	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
+	"tgbackup/internal/mapper"
 	"tgbackup/internal/models"
 )
 
+const sessionDir = "./sessions"
+
 type Client struct {
 	client      *telegram.Client
 	api         *tg.Client
@@ -27,12 +31,32 @@ type Client struct {
 	appHash     string
 	ctx         context.Context
 	cancel      context.CancelFunc
+
+	// Users seen in responses since the last TakeSeenPeers
+	seenMu sync.Mutex
+	seen   map[int64]models.Peer
+
+	mapper *mapper.Mapper
 }
 
 func NewClient() *Client {
 	return &Client{
 		isConnected: false,
+		mapper:      mapper.New(),
+	}
+}
+
+func sessionFilePath(appID int) string {
+	return filepath.Join(sessionDir, fmt.Sprintf("session_%d.json", appID))
+}
+
+// RemoveSession deletes the stored session file for the given app
+func RemoveSession(appID int) error {
+	err := os.Remove(sessionFilePath(appID))
+	if os.IsNotExist(err) {
+		return nil
 	}
+	return err
 }
 
 func (c *Client) Connect(ctx context.Context, appID int, appHash string) error {
@@ -46,6 +70,9 @@ func (c *Client) Connect(ctx context.Context, appID int, appHash string) error {
 		c.cancel()
 		c.isConnected = false
 	}
+
+	// Users seen with another session belong to another account
+	c.TakeSeenPeers()
 	
 	logger, _ := zap.NewDevelopment()
 	
@@ -56,15 +83,13 @@ func (c *Client) Connect(ctx context.Context, appID int, appHash string) error {
 	c.ctx, c.cancel = context.WithCancel(ctx)
 	
 	// Create session storage directory
-	sessionDir := "./sessions"
 	if err := os.MkdirAll(sessionDir, 0755); err != nil {
 		return fmt.Errorf("failed to create session directory: %v", err)
 	}
 	
 	// Create session storage
-	sessionFile := filepath.Join(sessionDir, fmt.Sprintf("session_%d.json", appID))
 	sessionStorage := &session.FileStorage{
-		Path: sessionFile,
+		Path: sessionFilePath(appID),
 	}
 	
 	options := telegram.Options{
@@ -174,12 +199,22 @@ func (c *Client) VerifyCode(ctx context.Context, phone, code, codeHash string) e
 }
 
 func (c *Client) IsAuthenticated(ctx context.Context) bool {
+	return c.CheckAuth(ctx) == nil
+}
+
+// CheckAuth verifies the current authorization. The Telegram error is returned
+// unwrapped so callers can tell revoked sessions from transient failures.
+func (c *Client) CheckAuth(ctx context.Context) error {
 	if !c.isConnected {
-		return false
+		return fmt.Errorf("client not connected")
+	}
+
+	if c.api == nil {
+		return fmt.Errorf("telegram client not ready")
 	}
 
 	_, err := c.api.UsersGetFullUser(ctx, &tg.InputUserSelf{})
-	return err == nil
+	return err
 }
 
 func (c *Client) GetCurrentUserInfo(ctx context.Context) (*models.User, error) {
@@ -240,22 +275,24 @@ func (c *Client) GetDialogs(ctx context.Context) ([]models.Conversation, error)
 		Hash:       0,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get dialogs: %v", err)
+		return nil, fmt.Errorf("failed to get dialogs: %w", err)
 	}
 
 	var conversations []models.Conversation
 
 	switch d := dialogs.(type) {
 	case *tg.MessagesDialogs:
+		c.rememberUsers(d.Users)
 		for _, dialog := range d.Dialogs {
-			conv := c.parseDialog(dialog, d.Chats, d.Users)
+			conv := c.parseDialog(dialog, d.Messages, d.Chats, d.Users)
 			if conv.Title != "" { // Only add if we parsed it successfully
 				conversations = append(conversations, conv)
 			}
 		}
 	case *tg.MessagesDialogsSlice:
+		c.rememberUsers(d.Users)
 		for _, dialog := range d.Dialogs {
-			conv := c.parseDialog(dialog, d.Chats, d.Users)
+			conv := c.parseDialog(dialog, d.Messages, d.Chats, d.Users)
 			if conv.Title != "" { // Only add if we parsed it successfully
 				conversations = append(conversations, conv)
 			}
@@ -265,7 +302,7 @@ func (c *Client) GetDialogs(ctx context.Context) ([]models.Conversation, error)
 	return conversations, nil
 }
 
-func (c *Client) parseDialog(dialog tg.DialogClass, chats []tg.ChatClass, users []tg.UserClass) models.Conversation {
+func (c *Client) parseDialog(dialog tg.DialogClass, messages []tg.MessageClass, chats []tg.ChatClass, users []tg.UserClass) models.Conversation {
 	d, ok := dialog.(*tg.Dialog)
 	if !ok {
 		return models.Conversation{} // Return empty if not a dialog
@@ -289,6 +326,9 @@ func (c *Client) parseDialog(dialog tg.DialogClass, chats []tg.ChatClass, users
 					title = fmt.Sprintf("%s %s", firstName, lastName)
 				}
 				title = strings.TrimSpace(title)
+				if user.Self {
+					title = "Saved Messages"
+				}
 				username = user.Username
 				conv.Type = "user"
 				if user.Bot {
@@ -300,6 +340,7 @@ func (c *Client) parseDialog(dialog tg.DialogClass, chats []tg.ChatClass, users
 				// Get avatar URL from user.Photo
 				if user.Photo != nil {
 					avatarURL = c.getUserPhotoURL(user.Photo)
+					conv.PhotoID = userPhotoID(user.Photo)
 				}
 				break
 			}
@@ -318,6 +359,7 @@ func (c *Client) parseDialog(dialog tg.DialogClass, chats []tg.ChatClass, users
 				// Get avatar URL from chat.Photo
 				if chat.Photo != nil {
 					avatarURL = c.getChatPhotoURL(chat.Photo)
+					conv.PhotoID = chatPhotoID(chat.Photo)
 				}
 				break
 			}
@@ -337,11 +379,13 @@ func (c *Client) parseDialog(dialog tg.DialogClass, chats []tg.ChatClass, users
 					conv.Type = "group" // Supergroup
 				}
 				conv.ID = channel.ID
+				conv.IsForum = channel.Forum
 				// Store access_hash for channel
 				conv.AccessHash = fmt.Sprintf("%d", channel.AccessHash)
 				// Get avatar URL from channel.Photo
 				if channel.Photo != nil {
 					avatarURL = c.getChatPhotoURL(channel.Photo)
+					conv.PhotoID = chatPhotoID(channel.Photo)
 				}
 				break
 			}
@@ -351,11 +395,43 @@ func (c *Client) parseDialog(dialog tg.DialogClass, chats []tg.ChatClass, users
 	conv.Title = title
 	conv.Username = username
 	conv.AvatarURL = avatarURL
-	conv.LastTime = time.Now()
+	conv.UnreadCount = d.UnreadCount
+	conv.UnreadMentionsCount = d.UnreadMentionsCount
+	conv.FolderID = d.FolderID
+	if top, ok := c.topMessage(d, messages, chats, users); ok {
+		conv.LastMessageID = top.MessageID
+		conv.LastMessage = top.Content
+		conv.LastTime = top.Timestamp
+		conv.LastSenderID = top.FromID
+		conv.LastSenderName = strings.TrimSpace(top.FromFirstName + " " + top.FromLastName)
+	}
 
 	return conv
 }
 
+// topMessage finds the last message of a dialog among the messages sent
+// along with the dialogs. Message IDs of channels overlap with other chats,
+// so the peer has to match as well.
+func (c *Client) topMessage(d *tg.Dialog, messages []tg.MessageClass, chats []tg.ChatClass, users []tg.UserClass) (models.Message, bool) {
+	dialogID, dialogType := mapper.PeerInfo(d.Peer)
+	for _, msg := range messages {
+		var peer tg.PeerClass
+		switch m := msg.(type) {
+		case *tg.Message:
+			peer = m.PeerID
+		case *tg.MessageService:
+			peer = m.PeerID
+		default:
+			continue
+		}
+		if id, peerType := mapper.PeerInfo(peer); msg.GetID() != d.TopMessage || id != dialogID || peerType != dialogType {
+			continue
+		}
+		return c.mapper.Message(msg, users, chats)
+	}
+	return models.Message{}, false
+}
+
 func (c *Client) GetMessages(ctx context.Context, peerID int64, limit int) ([]models.Message, error) {
 	if !c.isConnected {
 		return nil, fmt.Errorf("client not connected")
@@ -363,10 +439,16 @@ func (c *Client) GetMessages(ctx context.Context, peerID int64, limit int) ([]mo
 
 	// This is a simplified version that tries different peer types
 	// In the sync process, we should use the proper method with conversation info
-	return c.getMessagesWithFallback(ctx, peerID, limit, "", "")
+	return c.getMessagesWithFallback(ctx, peerID, 0, limit)
 }
 
 func (c *Client) GetMessagesWithConvInfo(ctx context.Context, peerID int64, limit int, convType, accessHash string) ([]models.Message, error) {
+	return c.GetHistory(ctx, peerID, convType, accessHash, 0, limit)
+}
+
+// GetHistory returns up to limit messages older than offsetID, newest first.
+// An offsetID of 0 starts at the newest message of the conversation.
+func (c *Client) GetHistory(ctx context.Context, peerID int64, convType, accessHash string, offsetID, limit int) ([]models.Message, error) {
 	if !c.isConnected {
 		return nil, fmt.Errorf("client not connected")
 	}
@@ -409,38 +491,46 @@ func (c *Client) GetMessagesWithConvInfo(ctx context.Context, peerID int64, limi
 			peer = &tg.InputPeerChat{ChatID: peerID}
 		}
 	default:
-		return c.getMessagesWithFallback(ctx, peerID, limit, convType, accessHash)
+		return c.getMessagesWithFallback(ctx, peerID, offsetID, limit)
 	}
 
 	messages, err := c.api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
-		Peer:  peer,
-		Limit: limit,
+		Peer:     peer,
+		OffsetID: offsetID,
+		Limit:    limit,
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get messages for peer %d (type: %s): %v", peerID, convType, err)
+		return nil, fmt.Errorf("failed to get messages for peer %d (type: %s): %w", peerID, convType, err)
 	}
 
-	return c.parseMessagesResponse(messages, peerID)
+	parsed, err := c.parseMessagesResponse(messages, peerID)
+	if err != nil {
+		return nil, err
+	}
+	c.refreshPollResults(ctx, peer, parsed)
+	return parsed, nil
 }
 
-func (c *Client) getMessagesWithFallback(ctx context.Context, peerID int64, limit int, convType, accessHash string) ([]models.Message, error) {
+func (c *Client) getMessagesWithFallback(ctx context.Context, peerID int64, offsetID, limit int) ([]models.Message, error) {
 	var messages tg.MessagesMessagesClass
 	var err error
 	
 	// Try as user first (includes bots)
 	peer := &tg.InputPeerUser{UserID: peerID}
 	messages, err = c.api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
-		Peer:  peer,
-		Limit: limit,
+		Peer:     peer,
+		OffsetID: offsetID,
+		Limit:    limit,
 	})
 	
 	// If user peer fails, try as channel
 	if err != nil {
 		peer := &tg.InputPeerChannel{ChannelID: peerID}
 		messages, err = c.api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
-			Peer:  peer,
-			Limit: limit,
+			Peer:     peer,
+			OffsetID: offsetID,
+			Limit:    limit,
 		})
 	}
 	
@@ -448,13 +538,14 @@ func (c *Client) getMessagesWithFallback(ctx context.Context, peerID int64, limi
 	if err != nil {
 		peer := &tg.InputPeerChat{ChatID: peerID}
 		messages, err = c.api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
-			Peer:  peer,
-			Limit: limit,
+			Peer:     peer,
+			OffsetID: offsetID,
+			Limit:    limit,
 		})
 	}
 	
 	if err != nil {
-		return nil, fmt.Errorf("failed to get messages for peer %d: %v", peerID, err)
+		return nil, fmt.Errorf("failed to get messages for peer %d: %w", peerID, err)
 	}
 
 	return c.parseMessagesResponse(messages, peerID)
@@ -463,303 +554,27 @@ func (c *Client) getMessagesWithFallback(ctx context.Context, peerID int64, limi
 func (c *Client) parseMessagesResponse(messages tg.MessagesMessagesClass, peerID int64) ([]models.Message, error) {
 	var result []models.Message
 
+	var msgs []tg.MessageClass
+	var users []tg.UserClass
+	var chats []tg.ChatClass
 	switch m := messages.(type) {
 	case *tg.MessagesMessages:
-		for _, msg := range m.Messages {
-			if message, ok := msg.(*tg.Message); ok {
-				parsedMsg := c.parseMessageWithUsers(message, m.Users)
-				parsedMsg.ConversationID = peerID
-				result = append(result, parsedMsg)
-			}
-		}
+		msgs, users, chats = m.Messages, m.Users, m.Chats
 	case *tg.MessagesMessagesSlice:
-		for _, msg := range m.Messages {
-			if message, ok := msg.(*tg.Message); ok {
-				parsedMsg := c.parseMessageWithUsers(message, m.Users)
-				parsedMsg.ConversationID = peerID
-				result = append(result, parsedMsg)
-			}
-		}
+		msgs, users, chats = m.Messages, m.Users, m.Chats
 	case *tg.MessagesChannelMessages:
-		for _, msg := range m.Messages {
-			if message, ok := msg.(*tg.Message); ok {
-				parsedMsg := c.parseMessageWithUsers(message, m.Users)
-				parsedMsg.ConversationID = peerID
-				result = append(result, parsedMsg)
-			}
-		}
-	}
-
-	return result, nil
-}
-
-func (c *Client) parseMessageWithUsers(msg *tg.Message, users []tg.UserClass) models.Message {
-	var content string
-	var messageType string = "text"
-	var mediaURL string
-	var mediaName string
-	var fromUsername string
-	var fromFirstName string
-	var fromLastName string
-
-	content = msg.Message
-
-	// Handle media messages
-	if msg.Media != nil {
-		switch media := msg.Media.(type) {
-		case *tg.MessageMediaPhoto:
-			messageType = "photo"
-			if content == "" {
-				content = "[Photo]"
-			}
-			// Extract photo URL
-			if photo, ok := media.Photo.(*tg.Photo); ok {
-				mediaURL = c.getPhotoURL(photo)
-			}
-		case *tg.MessageMediaDocument:
-			if doc, ok := media.Document.(*tg.Document); ok {
-				// Check if it's a video, audio, or other document
-				for _, attr := range doc.Attributes {
-					switch a := attr.(type) {
-					case *tg.DocumentAttributeVideo:
-						messageType = "video"
-						if content == "" {
-							content = "[Video]"
-						}
-					case *tg.DocumentAttributeAudio:
-						messageType = "audio"
-						if content == "" {
-							content = "[Audio]"
-						}
-						if a.Title != "" {
-							mediaName = a.Title
-						}
-					case *tg.DocumentAttributeFilename:
-						mediaName = a.FileName
-					case *tg.DocumentAttributeImageSize:
-						if messageType == "text" {
-							messageType = "image"
-							if content == "" {
-								content = "[Image]"
-							}
-						}
-					case *tg.DocumentAttributeAnimated:
-						messageType = "gif"
-						if content == "" {
-							content = "[GIF]"
-						}
-					case *tg.DocumentAttributeSticker:
-						messageType = "sticker"
-						if content == "" {
-							content = "[Sticker]"
-						}
-					}
-				}
-				
-				// Default to document if no specific type found
-				if messageType == "text" {
-					messageType = "document"
-					if content == "" {
-						content = "[Document]"
-					}
-				}
-				
-				// Set media name if not already set
-				if mediaName == "" {
-					mediaName = fmt.Sprintf("%s.%s", messageType, "file")
-				}
-				
-				// Get document URL
-				mediaURL = c.getDocumentURL(doc)
-			}
-		case *tg.MessageMediaWebPage:
-			// Keep as text message but note it has a webpage
-			if content == "" {
-				content = "[Webpage]"
-			}
-		case *tg.MessageMediaContact:
-			messageType = "contact"
-			if content == "" {
-				content = "[Contact]"
-			}
-		case *tg.MessageMediaGeo:
-			messageType = "location"
-			if content == "" {
-				content = "[Location]"
-			}
-		case *tg.MessageMediaPoll:
-			messageType = "poll"
-			if content == "" {
-				content = "[Poll]"
-			}
-		}
-	}
-
-	// Handle FromID - it can be nil
-	var fromID int64
-	if msg.FromID != nil {
-		switch from := msg.FromID.(type) {
-		case *tg.PeerUser:
-			fromID = from.UserID
-			// Find user information from users list
-			for _, u := range users {
-				if user, ok := u.(*tg.User); ok && user.ID == from.UserID {
-					fromUsername = user.Username
-					fromFirstName = user.FirstName
-					fromLastName = user.LastName
-					break
-				}
-			}
-		case *tg.PeerChat:
-			fromID = from.ChatID
-		case *tg.PeerChannel:
-			fromID = from.ChannelID
-		}
+		msgs, users, chats = m.Messages, m.Users, m.Chats
 	}
+	c.rememberUsers(users)
 
-	// Add media name to content if available
-	if mediaName != "" && content != "" {
-		content = fmt.Sprintf("%s\n📁 %s", content, mediaName)
-	}
-
-	return models.Message{
-		MessageID:       msg.ID,
-		FromID:          fromID,
-		FromUsername:    fromUsername,
-		FromFirstName:   fromFirstName,
-		FromLastName:    fromLastName,
-		Content:         content,
-		MessageType:     messageType,
-		MediaURL:        mediaURL,
-		Timestamp:       time.Unix(int64(msg.Date), 0),
-	}
-}
-
-func (c *Client) parseMessage(msg *tg.Message) models.Message {
-	var content string
-	var messageType string = "text"
-	var mediaURL string
-	var mediaName string
-
-	content = msg.Message
-
-	// Handle media messages
-	if msg.Media != nil {
-		switch media := msg.Media.(type) {
-		case *tg.MessageMediaPhoto:
-			messageType = "photo"
-			if content == "" {
-				content = "[Photo]"
-			}
-			// Extract photo URL
-			if photo, ok := media.Photo.(*tg.Photo); ok {
-				mediaURL = c.getPhotoURL(photo)
-			}
-		case *tg.MessageMediaDocument:
-			if doc, ok := media.Document.(*tg.Document); ok {
-				// Check if it's a video, audio, or other document
-				for _, attr := range doc.Attributes {
-					switch a := attr.(type) {
-					case *tg.DocumentAttributeVideo:
-						messageType = "video"
-						if content == "" {
-							content = "[Video]"
-						}
-					case *tg.DocumentAttributeAudio:
-						messageType = "audio"
-						if content == "" {
-							content = "[Audio]"
-						}
-						if a.Title != "" {
-							mediaName = a.Title
-						}
-					case *tg.DocumentAttributeFilename:
-						mediaName = a.FileName
-					case *tg.DocumentAttributeImageSize:
-						if messageType == "text" {
-							messageType = "image"
-							if content == "" {
-								content = "[Image]"
-							}
-						}
-					case *tg.DocumentAttributeAnimated:
-						messageType = "gif"
-						if content == "" {
-							content = "[GIF]"
-						}
-					case *tg.DocumentAttributeSticker:
-						messageType = "sticker"
-						if content == "" {
-							content = "[Sticker]"
-						}
-					}
-				}
-				
-				// Default to document if no specific type found
-				if messageType == "text" {
-					messageType = "document"
-					if content == "" {
-						content = "[Document]"
-					}
-				}
-				
-				// Set media name if not already set
-				if mediaName == "" {
-					mediaName = fmt.Sprintf("%s.%s", messageType, "file")
-				}
-				
-				// Get document URL
-				mediaURL = c.getDocumentURL(doc)
-			}
-		case *tg.MessageMediaWebPage:
-			// Keep as text message but note it has a webpage
-			if content == "" {
-				content = "[Webpage]"
-			}
-		case *tg.MessageMediaContact:
-			messageType = "contact"
-			if content == "" {
-				content = "[Contact]"
-			}
-		case *tg.MessageMediaGeo:
-			messageType = "location"
-			if content == "" {
-				content = "[Location]"
-			}
-		case *tg.MessageMediaPoll:
-			messageType = "poll"
-			if content == "" {
-				content = "[Poll]"
-			}
-		}
-	}
-
-	// Handle FromID - it can be nil
-	var fromID int64
-	if msg.FromID != nil {
-		switch from := msg.FromID.(type) {
-		case *tg.PeerUser:
-			fromID = from.UserID
-		case *tg.PeerChat:
-			fromID = from.ChatID
-		case *tg.PeerChannel:
-			fromID = from.ChannelID
+	for _, msg := range msgs {
+		if parsedMsg, ok := c.mapper.Message(msg, users, chats); ok {
+			parsedMsg.ConversationID = peerID
+			result = append(result, parsedMsg)
 		}
 	}
 
-	// Add media name to content if available
-	if mediaName != "" && content != "" {
-		content = fmt.Sprintf("%s\n📁 %s", content, mediaName)
-	}
-
-	return models.Message{
-		MessageID:   msg.ID,
-		FromID:      fromID,
-		Content:     content,
-		MessageType: messageType,
-		MediaURL:    mediaURL,
-		Timestamp:   time.Unix(int64(msg.Date), 0),
-	}
+	return result, nil
 }
 
 func (c *Client) GenerateQRCode(ctx context.Context) (string, error) {
@@ -833,44 +648,6 @@ func (c *Client) CheckQRCode(ctx context.Context, token []byte) error {
 	}
 }
 
-func (c *Client) getPhotoURL(photo *tg.Photo) string {
-	if len(photo.Sizes) == 0 {
-		return ""
-	}
-	
-	// Find the largest photo size
-	var largestSize *tg.PhotoSize
-	var maxSize int
-	
-	for _, size := range photo.Sizes {
-		if photoSize, ok := size.(*tg.PhotoSize); ok {
-			currentSize := photoSize.W * photoSize.H
-			if currentSize > maxSize {
-				maxSize = currentSize
-				largestSize = photoSize
-			}
-		}
-	}
-	
-	if largestSize != nil {
-		// In a real implementation, you would use the file location to generate a proper URL
-		// For now, we'll create a placeholder that includes the file info
-		return fmt.Sprintf("telegram://photo/%d_%d", photo.ID, largestSize.Size)
-	}
-	
-	return ""
-}
-
-func (c *Client) getDocumentURL(doc *tg.Document) string {
-	if doc == nil {
-		return ""
-	}
-	
-	// In a real implementation, you would use the document info to generate a proper URL
-	// For now, we'll create a placeholder that includes the document info
-	return fmt.Sprintf("telegram://document/%d_%d", doc.ID, doc.Size)
-}
-
 func (c *Client) getUserPhotoURL(userPhoto tg.UserProfilePhotoClass) string {
 	if userPhoto == nil {
 		return ""
@@ -912,7 +689,7 @@ func (c *Client) GetUpdates(ctx context.Context, pts int, date int, qts int) (*t
 		Qts:  qts,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get updates: %v", err)
+		return nil, fmt.Errorf("failed to get updates: %w", err)
 	}
 
 	switch u := updates.(type) {
@@ -955,7 +732,7 @@ func (c *Client) GetState(ctx context.Context) (*tg.UpdatesState, error) {
 
 	state, err := c.api.UpdatesGetState(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get state: %v", err)
+		return nil, fmt.Errorf("failed to get state: %w", err)
 	}
 
 	return state, nil
@@ -963,28 +740,34 @@ func (c *Client) GetState(ctx context.Context) (*tg.UpdatesState, error) {
 
 // ParseUpdatesMessages converts updates to our message format
 func (c *Client) ParseUpdatesMessages(updates *tg.UpdatesDifference, userID int64) []models.Message {
+	c.rememberUsers(updates.Users)
+	return MessagesFromUpdates(c.mapper, updates, userID)
+}
+
+// MessagesFromUpdates converts the new messages of an updates difference,
+// each gets the conversation its peer points to
+func MessagesFromUpdates(m *mapper.Mapper, updates *tg.UpdatesDifference, userID int64) []models.Message {
 	var messages []models.Message
 
 	// Parse new messages from updates
 	for _, msg := range updates.NewMessages {
-		if message, ok := msg.(*tg.Message); ok {
-			parsedMsg := c.parseMessageWithUsers(message, updates.Users)
-			parsedMsg.UserID = userID
-			
-			// Determine conversation ID from the message peer
-			if message.PeerID != nil {
-				switch peer := message.PeerID.(type) {
-				case *tg.PeerUser:
-					parsedMsg.ConversationID = peer.UserID
-				case *tg.PeerChat:
-					parsedMsg.ConversationID = peer.ChatID
-				case *tg.PeerChannel:
-					parsedMsg.ConversationID = peer.ChannelID
-				}
-			}
-			
-			messages = append(messages, parsedMsg)
+		parsedMsg, ok := m.Message(msg, updates.Users, updates.Chats)
+		if !ok {
+			continue
 		}
+		parsedMsg.UserID = userID
+
+		// Determine conversation ID from the message peer
+		var peerID tg.PeerClass
+		switch message := msg.(type) {
+		case *tg.Message:
+			peerID = message.PeerID
+		case *tg.MessageService:
+			peerID = message.PeerID
+		}
+		parsedMsg.ConversationID, _ = mapper.PeerInfo(peerID)
+
+		messages = append(messages, parsedMsg)
 	}
 
 	return messages
@@ -1018,10 +801,41 @@ func (c *Client) GetChannelMessages(ctx context.Context, channelID int64, access
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get channel messages: %v", err)
+		return nil, fmt.Errorf("failed to get channel messages: %w", err)
+	}
+
+	parsed, err := c.parseMessagesResponse(messages, channelID)
+	if err != nil {
+		return nil, err
+	}
+	c.refreshPollResults(ctx, peer, parsed)
+	return parsed, nil
+}
+
+// LogOut terminates the current authorization on Telegram, closes the
+// connection and removes the local session file
+func (c *Client) LogOut(ctx context.Context) error {
+	if !c.isConnected {
+		return fmt.Errorf("client not connected")
+	}
+
+	if c.api == nil {
+		return fmt.Errorf("telegram client not ready")
+	}
+
+	_, err := c.api.AuthLogOut(ctx)
+	appID := c.appID
+	c.Close()
+	c.appID, c.appHash = 0, ""
+
+	if rmErr := RemoveSession(appID); rmErr != nil {
+		return fmt.Errorf("failed to remove session file: %v", rmErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to log out: %v", err)
 	}
 
-	return c.parseMessagesResponse(messages, channelID)
+	return nil
 }
 
 func (c *Client) Close() error {
@@ -0,0 +1,101 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+// GetLinkedChat returns the discussion group linked to a channel, or nil when
+// the channel has none
+func (c *Client) GetLinkedChat(ctx context.Context, channelID int64, accessHash string) (*models.Conversation, error) {
+	if !c.isConnected || c.api == nil {
+		return nil, fmt.Errorf("client not connected")
+	}
+
+	hash, err := strconv.ParseInt(accessHash, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access hash: %v", err)
+	}
+
+	full, err := c.api.ChannelsGetFullChannel(ctx, &tg.InputChannel{ChannelID: channelID, AccessHash: hash})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get full info of channel %d: %v", channelID, err)
+	}
+
+	channelFull, ok := full.FullChat.(*tg.ChannelFull)
+	if !ok || channelFull.LinkedChatID == 0 {
+		return nil, nil
+	}
+
+	for _, chat := range full.Chats {
+		group, ok := chat.(*tg.Channel)
+		if !ok || group.ID != channelFull.LinkedChatID {
+			continue
+		}
+
+		conv := &models.Conversation{
+			ID:         group.ID,
+			Type:       "group",
+			Title:      group.Title,
+			Username:   group.Username,
+			AccessHash: fmt.Sprintf("%d", group.AccessHash),
+			IsForum:    group.Forum,
+		}
+		if conv.Title == "" {
+			conv.Title = fmt.Sprintf("Channel %d", group.ID)
+		}
+		if group.Photo != nil {
+			conv.AvatarURL = c.getChatPhotoURL(group.Photo)
+			conv.PhotoID = chatPhotoID(group.Photo)
+		}
+		return conv, nil
+	}
+
+	return nil, fmt.Errorf("linked chat %d of channel %d not in response", channelFull.LinkedChatID, channelID)
+}
+
+// GetPostComments returns a page of up to limit comments of a channel post
+// older than offsetID (0 for the newest), newest first. Comments are messages
+// of the linked discussion group and get discussionID as their conversation.
+func (c *Client) GetPostComments(ctx context.Context, channelID int64, accessHash string, postID int, discussionID int64, offsetID, limit int) ([]models.Message, error) {
+	if !c.isConnected || c.api == nil {
+		return nil, fmt.Errorf("client not connected")
+	}
+
+	hash, err := strconv.ParseInt(accessHash, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access hash: %v", err)
+	}
+
+	result, err := c.api.MessagesGetReplies(ctx, &tg.MessagesGetRepliesRequest{
+		Peer:     &tg.InputPeerChannel{ChannelID: channelID, AccessHash: hash},
+		MsgID:    postID,
+		OffsetID: offsetID,
+		Limit:    limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comments of post %d in channel %d: %v", postID, channelID, err)
+	}
+
+	return c.parseMessagesResponse(result, discussionID)
+}
+
+// CommentsRoot returns the ID of the discussion group's copy of the post the
+// comments belong to. Direct comments reply to it, answers to other comments
+// carry it as the thread top.
+func CommentsRoot(comments []models.Message) int {
+	for _, msg := range comments {
+		if msg.ReplyToTopID != 0 {
+			return msg.ReplyToTopID
+		}
+		if msg.ReplyToMsgID != 0 {
+			return msg.ReplyToMsgID
+		}
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+package telegram
+
+import (
+	"time"
+
+	"github.com/gotd/td/tgerr"
+)
+
+// sessionDeadErrors are RPC error types meaning the authorization is gone
+// for good and the account has to sign in again
+var sessionDeadErrors = []string{
+	"AUTH_KEY_UNREGISTERED",
+	"AUTH_KEY_INVALID",
+	"AUTH_KEY_PERM_EMPTY",
+	"SESSION_REVOKED",
+	"SESSION_EXPIRED",
+	"USER_DEACTIVATED",
+	"USER_DEACTIVATED_BAN",
+}
+
+// IsSessionDead reports whether err means the session was revoked or expired,
+// as opposed to a transient network or server failure
+func IsSessionDead(err error) bool {
+	return tgerr.Is(err, sessionDeadErrors...)
+}
+
+// ErrorReason returns the Telegram error type of err, or its message for
+// errors that did not come from the API
+func ErrorReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	if rpcErr, ok := tgerr.As(err); ok {
+		return rpcErr.Type
+	}
+	return err.Error()
+}
+
+// FloodWait reports whether err asks to wait before the next request and for
+// how long
+func FloodWait(err error) (time.Duration, bool) {
+	return tgerr.AsFloodWait(err)
+}
@@ -0,0 +1,36 @@
+package telegram
+
+import (
+	"context"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/mapper"
+	"tgbackup/internal/models"
+)
+
+// refreshPollResults asks for the current vote counts of the polls among
+// messages, message lists only carry the counts visible to the account. Errors
+// keep the counts that came with the message.
+func (c *Client) refreshPollResults(ctx context.Context, peer tg.InputPeerClass, messages []models.Message) {
+	for i := range messages {
+		msg := &messages[i]
+		if msg.MediaData == nil || msg.MediaData.Poll == nil {
+			continue
+		}
+
+		updates, err := c.api.MessagesGetPollResults(ctx, &tg.MessagesGetPollResultsRequest{
+			Peer:  peer,
+			MsgID: msg.MessageID,
+		})
+		if err != nil {
+			continue
+		}
+
+		for _, update := range updateList(updates) {
+			if poll, ok := update.(*tg.UpdateMessagePoll); ok && poll.PollID == msg.MediaData.Poll.ID {
+				mapper.ApplyPollResults(msg.MediaData.Poll, &poll.Results)
+			}
+		}
+	}
+}
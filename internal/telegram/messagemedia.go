@@ -0,0 +1,100 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+)
+
+// DownloadMessageMedia writes the photo or document of a message to w, the
+// largest size of a photo. The message is fetched again first, as the file
+// reference of a stored message expires.
+func (c *Client) DownloadMessageMedia(ctx context.Context, peerID int64, convType, accessHash string, messageID int, w io.Writer) error {
+	if !c.isConnected || c.api == nil {
+		return fmt.Errorf("client not connected")
+	}
+
+	msg, err := c.getMessage(ctx, peerID, convType, accessHash, messageID)
+	if err != nil {
+		return err
+	}
+
+	var loc tg.InputFileLocationClass
+	switch m := msg.Media.(type) {
+	case *tg.MessageMediaPhoto:
+		photo, ok := m.Photo.(*tg.Photo)
+		if !ok {
+			return fmt.Errorf("photo of message %d was deleted", messageID)
+		}
+		thumb := largestPhotoSize(photo)
+		if thumb == "" {
+			return fmt.Errorf("photo of message %d has no downloadable size", messageID)
+		}
+		loc = &tg.InputPhotoFileLocation{
+			ID:            photo.ID,
+			AccessHash:    photo.AccessHash,
+			FileReference: photo.FileReference,
+			ThumbSize:     thumb,
+		}
+	case *tg.MessageMediaDocument:
+		doc, ok := m.Document.(*tg.Document)
+		if !ok {
+			return fmt.Errorf("document of message %d was deleted", messageID)
+		}
+		loc = &tg.InputDocumentFileLocation{
+			ID:            doc.ID,
+			AccessHash:    doc.AccessHash,
+			FileReference: doc.FileReference,
+		}
+	default:
+		return fmt.Errorf("message %d has no file to download", messageID)
+	}
+
+	if _, err := downloader.NewDownloader().Download(c.api, loc).Stream(ctx, w); err != nil {
+		return fmt.Errorf("failed to download media of message %d in peer %d: %v", messageID, peerID, err)
+	}
+	return nil
+}
+
+// getMessage fetches a single message of a conversation
+func (c *Client) getMessage(ctx context.Context, peerID int64, convType, accessHash string, messageID int) (*tg.Message, error) {
+	ids := []tg.InputMessageClass{&tg.InputMessageID{ID: messageID}}
+
+	var result tg.MessagesMessagesClass
+	var err error
+	if convType == "channel" || (convType == "group" && accessHash != "") {
+		hash, parseErr := strconv.ParseInt(accessHash, 10, 64)
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid access hash: %v", parseErr)
+		}
+		result, err = c.api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+			Channel: &tg.InputChannel{ChannelID: peerID, AccessHash: hash},
+			ID:      ids,
+		})
+	} else {
+		result, err = c.api.MessagesGetMessages(ctx, ids)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message %d of peer %d: %v", messageID, peerID, err)
+	}
+
+	var msgs []tg.MessageClass
+	switch m := result.(type) {
+	case *tg.MessagesMessages:
+		msgs = m.Messages
+	case *tg.MessagesMessagesSlice:
+		msgs = m.Messages
+	case *tg.MessagesChannelMessages:
+		msgs = m.Messages
+	}
+	for _, msg := range msgs {
+		if m, ok := msg.(*tg.Message); ok && m.ID == messageID {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("message %d of peer %d not found", messageID, peerID)
+}
@@ -0,0 +1,126 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/mapper"
+	"tgbackup/internal/models"
+)
+
+// rememberUsers records the users that came with a response so their
+// profiles can be backed up with TakeSeenPeers. Min users only carry part of
+// the profile and are skipped.
+func (c *Client) rememberUsers(users []tg.UserClass) {
+	c.seenMu.Lock()
+	defer c.seenMu.Unlock()
+
+	if c.seen == nil {
+		c.seen = make(map[int64]models.Peer)
+	}
+	for _, u := range users {
+		user, ok := u.(*tg.User)
+		if !ok || user.Min || user.Self {
+			continue
+		}
+		c.seen[user.ID] = peerFromUser(user)
+	}
+}
+
+// TakeSeenPeers returns the users seen since the last call
+func (c *Client) TakeSeenPeers() []models.Peer {
+	c.seenMu.Lock()
+	defer c.seenMu.Unlock()
+
+	peers := make([]models.Peer, 0, len(c.seen))
+	for _, peer := range c.seen {
+		peers = append(peers, peer)
+	}
+	c.seen = nil
+	return peers
+}
+
+func peerFromUser(user *tg.User) models.Peer {
+	peer := models.Peer{
+		PeerID:    user.ID,
+		Username:  user.Username,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Phone:     user.Phone,
+		IsBot:     user.Bot,
+		IsDeleted: user.Deleted,
+	}
+	if peer.Username == "" {
+		// Users with collectible usernames only list them here
+		for _, name := range user.Usernames {
+			if name.Active {
+				peer.Username = name.Username
+				break
+			}
+		}
+	}
+	peer.PhotoID = userPhotoID(user.Photo)
+	if user.AccessHash != 0 {
+		peer.AccessHash = strconv.FormatInt(user.AccessHash, 10)
+	}
+	return peer
+}
+
+// GetContacts returns the account's contact book and the profiles of the
+// contacts
+func (c *Client) GetContacts(ctx context.Context) ([]models.AccountContact, []models.Peer, error) {
+	if !c.isConnected || c.api == nil {
+		return nil, nil, fmt.Errorf("client not connected")
+	}
+
+	result, err := c.api.ContactsGetContacts(ctx, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get contacts: %v", err)
+	}
+	list, ok := result.(*tg.ContactsContacts)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected contacts response")
+	}
+
+	var contacts []models.AccountContact
+	var peers []models.Peer
+	for _, contact := range list.Contacts {
+		entry := models.AccountContact{ContactID: contact.UserID, Mutual: contact.Mutual}
+		if user := mapper.FindUser(list.Users, contact.UserID); user != nil {
+			peer := peerFromUser(user)
+			entry.Phone = peer.Phone
+			entry.FirstName = peer.FirstName
+			entry.LastName = peer.LastName
+			entry.Username = peer.Username
+			peers = append(peers, peer)
+		}
+		contacts = append(contacts, entry)
+	}
+
+	return contacts, peers, nil
+}
+
+// GetUserBio returns the bio of a user
+func (c *Client) GetUserBio(ctx context.Context, userID int64, accessHash string) (string, error) {
+	if !c.isConnected || c.api == nil {
+		return "", fmt.Errorf("client not connected")
+	}
+
+	input := &tg.InputUser{UserID: userID}
+	if accessHash != "" {
+		hash, err := strconv.ParseInt(accessHash, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid access hash: %v", err)
+		}
+		input.AccessHash = hash
+	}
+
+	full, err := c.api.UsersGetFullUser(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to get full info of user %d: %v", userID, err)
+	}
+	return full.FullUser.About, nil
+}
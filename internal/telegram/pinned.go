@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+)
+
+// pinnedPageSize is the number of pinned messages requested per call
+const pinnedPageSize = 100
+
+// GetPinnedMessages returns all pinned messages of a conversation, newest first
+func (c *Client) GetPinnedMessages(ctx context.Context, peerID int64, convType, accessHash string) ([]models.Message, error) {
+	if !c.isConnected {
+		return nil, fmt.Errorf("client not connected")
+	}
+
+	peer, err := inputPeer(peerID, convType, accessHash)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &tg.MessagesSearchRequest{
+		Peer:   peer,
+		Filter: &tg.InputMessagesFilterPinned{},
+		Limit:  pinnedPageSize,
+	}
+
+	var pinned []models.Message
+	for {
+		result, err := c.api.MessagesSearch(ctx, request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search pinned messages of peer %d: %v", peerID, err)
+		}
+
+		page, err := c.parseMessagesResponse(result, peerID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page {
+			// 搜索结果中的消息都已置顶
+			page[i].IsPinned = true
+		}
+		pinned = append(pinned, page...)
+
+		if len(page) < pinnedPageSize {
+			return pinned, nil
+		}
+		request.OffsetID = page[len(page)-1].MessageID
+	}
+}
+
+// GetScheduledMessages returns the messages scheduled to be sent in a
+// conversation. Their timestamp is the time they are scheduled for.
+func (c *Client) GetScheduledMessages(ctx context.Context, peerID int64, convType, accessHash string) ([]models.Message, error) {
+	if !c.isConnected {
+		return nil, fmt.Errorf("client not connected")
+	}
+
+	peer, err := inputPeer(peerID, convType, accessHash)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.api.MessagesGetScheduledHistory(ctx, &tg.MessagesGetScheduledHistoryRequest{Peer: peer})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled messages of peer %d: %v", peerID, err)
+	}
+
+	return c.parseMessagesResponse(result, peerID)
+}
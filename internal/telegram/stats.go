@@ -0,0 +1,121 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/mapper"
+	"tgbackup/internal/models"
+)
+
+// inputPeer builds the input peer of a conversation from its stored type and
+// access hash
+func inputPeer(peerID int64, convType, accessHash string) (tg.InputPeerClass, error) {
+	var hash int64
+	if accessHash != "" {
+		parsed, err := strconv.ParseInt(accessHash, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access_hash %q", accessHash)
+		}
+		hash = parsed
+	}
+
+	switch convType {
+	case "user", "bot":
+		return &tg.InputPeerUser{UserID: peerID, AccessHash: hash}, nil
+	case "channel":
+		if accessHash == "" {
+			return nil, fmt.Errorf("channel requires access_hash")
+		}
+		return &tg.InputPeerChannel{ChannelID: peerID, AccessHash: hash}, nil
+	case "group":
+		// 有access_hash的群组是超级群组
+		if accessHash != "" {
+			return &tg.InputPeerChannel{ChannelID: peerID, AccessHash: hash}, nil
+		}
+		return &tg.InputPeerChat{ChatID: peerID}, nil
+	default:
+		return nil, fmt.Errorf("unknown conversation type %q", convType)
+	}
+}
+
+// updateList flattens the updates returned by methods that answer with Updates
+func updateList(updates tg.UpdatesClass) []tg.UpdateClass {
+	switch u := updates.(type) {
+	case *tg.Updates:
+		return u.Updates
+	case *tg.UpdatesCombined:
+		return u.Updates
+	case *tg.UpdateShort:
+		return []tg.UpdateClass{u.Update}
+	}
+	return nil
+}
+
+// GetMessageStats fetches the current view, forward, reply and reaction
+// counters of messages without counting a view. Views are only known for
+// channels and supergroups, other chats only get their reactions fetched.
+func (c *Client) GetMessageStats(ctx context.Context, peerID int64, convType, accessHash string, ids []int) ([]models.MessageStats, error) {
+	if !c.isConnected {
+		return nil, fmt.Errorf("client not connected")
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	peer, err := inputPeer(peerID, convType, accessHash)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	stats := make([]models.MessageStats, len(ids))
+	index := make(map[int]int, len(ids))
+	for i, id := range ids {
+		stats[i] = models.MessageStats{ConversationID: peerID, MessageID: id, CapturedAt: now}
+		index[id] = i
+	}
+
+	if _, ok := peer.(*tg.InputPeerChannel); ok {
+		views, err := c.api.MessagesGetMessagesViews(ctx, &tg.MessagesGetMessagesViewsRequest{
+			Peer:      peer,
+			ID:        ids,
+			Increment: false,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message views for peer %d: %v", peerID, err)
+		}
+		// 结果与请求的ID一一对应
+		for i, v := range views.Views {
+			if i >= len(stats) {
+				break
+			}
+			stats[i].Views = v.Views
+			stats[i].Forwards = v.Forwards
+			if replies, ok := v.GetReplies(); ok {
+				stats[i].Replies = replies.Replies
+			}
+		}
+	}
+
+	updates, err := c.api.MessagesGetMessagesReactions(ctx, &tg.MessagesGetMessagesReactionsRequest{
+		Peer: peer,
+		ID:   ids,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message reactions for peer %d: %v", peerID, err)
+	}
+	for _, update := range updateList(updates) {
+		if u, ok := update.(*tg.UpdateMessageReactions); ok {
+			if i, found := index[u.MsgID]; found {
+				stats[i].Reactions = mapper.ParseReactions(u.Reactions)
+			}
+		}
+	}
+
+	return stats, nil
+}
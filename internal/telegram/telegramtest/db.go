@@ -0,0 +1,27 @@
+package telegramtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"tgbackup/internal/database"
+	"tgbackup/internal/models"
+)
+
+// NewDB opens an empty database in a temporary directory that is closed when
+// the test ends, and saves the given accounts in it
+func NewDB(t testing.TB, users ...models.User) *database.DB {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, user := range users {
+		if err := db.SaveUser(&user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
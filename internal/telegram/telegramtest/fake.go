@@ -0,0 +1,740 @@
+// Package telegramtest provides a scripted stand-in for a Telegram connection
+// and a throwaway database, so the handlers and the sync can be tested without
+// network access.
+package telegramtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+
+	"tgbackup/internal/mapper"
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram"
+)
+
+// LoginCode is the code VerifyCode accepts
+const LoginCode = "12345"
+
+// FloodWait returns the error Telegram sends when a method has to wait the
+// given number of seconds before it may be called again
+func FloodWait(seconds int) error {
+	return tgerr.New(420, fmt.Sprintf("%s_%d", tgerr.ErrFloodWait, seconds))
+}
+
+// Fake implements telegram.API from scripted state. Dialogs and history are
+// read back as they are. Every message added, edited or deleted outside of a
+// channel is also recorded as an update; as on Telegram, changes in channels
+// and supergroups do not show up in GetUpdates and have to be fetched from
+// the history.
+type Fake struct {
+	mu sync.Mutex
+
+	self       models.User
+	connected  bool
+	authorized bool
+
+	dialogs []models.Conversation
+	history map[int64][]tg.MessageClass // newest first
+	topics  map[int64][]models.ForumTopic
+	linked  map[int64]*models.Conversation
+	photos  map[int64][]byte
+	profile map[int64][]int64 // profile photo IDs per user, newest first
+	users   []tg.UserClass
+	chats   []tg.ChatClass
+
+	scheduled map[int64][]tg.MessageClass
+	snapshots map[int64]*models.ChatSnapshot
+	seen      []models.Peer
+	contacts  []models.AccountContact
+	peers     []models.Peer // profiles of the contacts
+	bios      map[int64]string
+
+	pts     int
+	updates []update
+
+	failures map[string][]error
+	calls    map[string]int
+
+	mapper *mapper.Mapper
+}
+
+// update is one change recorded for GetUpdates
+type update struct {
+	pts     int
+	message tg.MessageClass // new message
+	other   tg.UpdateClass  // edit or deletion
+}
+
+var _ telegram.API = (*Fake)(nil)
+
+// NewFake returns a fake connected and signed in as self, with no dialogs
+func NewFake(self models.User) *Fake {
+	return &Fake{
+		self:       self,
+		connected:  true,
+		authorized: true,
+		history:    make(map[int64][]tg.MessageClass),
+		topics:     make(map[int64][]models.ForumTopic),
+		linked:     make(map[int64]*models.Conversation),
+		photos:     make(map[int64][]byte),
+		profile:    make(map[int64][]int64),
+		scheduled:  make(map[int64][]tg.MessageClass),
+		snapshots:  make(map[int64]*models.ChatSnapshot),
+		bios:       make(map[int64]string),
+		failures:   make(map[string][]error),
+		calls:      make(map[string]int),
+		mapper:     mapper.New(),
+	}
+}
+
+// SetAuthorized signs the account in or out
+func (f *Fake) SetAuthorized(authorized bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.authorized = authorized
+}
+
+// AddDialog adds a conversation, later dialogs are listed first
+func (f *Fake) AddDialog(conv models.Conversation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dialogs = append([]models.Conversation{conv}, f.dialogs...)
+}
+
+// AddUser adds a user sent along with every response, it names senders
+func (f *Fake) AddUser(user *tg.User) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users = append(f.users, user)
+}
+
+// AddChat adds a chat or channel sent along with every response
+func (f *Fake) AddChat(chat tg.ChatClass) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chats = append(f.chats, chat)
+}
+
+// SetTopics sets the forum topics of a supergroup
+func (f *Fake) SetTopics(channelID int64, topics []models.ForumTopic) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.topics[channelID] = topics
+}
+
+// SetLinkedChat sets the discussion group of a channel
+func (f *Fake) SetLinkedChat(channelID int64, discussion models.Conversation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.linked[channelID] = &discussion
+}
+
+// SetPhoto sets the content of a profile or chat photo, or of the photo or
+// document of a message by its document ID
+func (f *Fake) SetPhoto(photoID int64, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.photos[photoID] = data
+}
+
+// SetProfilePhotos sets the profile photos of a user, newest first. Their
+// content is set with SetPhoto.
+func (f *Fake) SetProfilePhotos(userID int64, photoIDs ...int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.profile[userID] = photoIDs
+}
+
+// SetScheduled sets the messages scheduled to be sent in a conversation
+func (f *Fake) SetScheduled(convID int64, messages ...tg.MessageClass) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, msg := range messages {
+		f.setPeer(convID, msg)
+	}
+	f.scheduled[convID] = messages
+}
+
+// SetChatSnapshot sets the info and members of a group or channel
+func (f *Fake) SetChatSnapshot(snap models.ChatSnapshot) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.snapshots[snap.ConversationID] = &snap
+}
+
+// AddSeenPeer adds a user profile returned by the next TakeSeenPeers
+func (f *Fake) AddSeenPeer(peer models.Peer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seen = append(f.seen, peer)
+}
+
+// AddContact adds a contact of the account and its profile
+func (f *Fake) AddContact(contact models.AccountContact, peer models.Peer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.contacts = append(f.contacts, contact)
+	f.peers = append(f.peers, peer)
+}
+
+// SetBio sets the bio of a user
+func (f *Fake) SetBio(userID int64, bio string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bios[userID] = bio
+}
+
+// AddMessage adds a message to a conversation. Its peer is set from the
+// conversation if missing.
+func (f *Fake) AddMessage(convID int64, msg tg.MessageClass) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.setPeer(convID, msg)
+	history := append(f.history[convID], msg)
+	sort.SliceStable(history, func(i, j int) bool { return history[i].GetID() > history[j].GetID() })
+	f.history[convID] = history
+
+	if !f.isChannel(convID) {
+		f.pts++
+		f.updates = append(f.updates, update{pts: f.pts, message: msg})
+	}
+}
+
+// EditMessage replaces a message of a conversation with its edited version
+func (f *Fake) EditMessage(convID int64, msg *tg.Message) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.setPeer(convID, msg)
+	for i, m := range f.history[convID] {
+		if m.GetID() == msg.ID {
+			f.history[convID][i] = msg
+		}
+	}
+
+	if !f.isChannel(convID) {
+		f.pts++
+		f.updates = append(f.updates, update{pts: f.pts, other: &tg.UpdateEditMessage{Message: msg, Pts: f.pts, PtsCount: 1}})
+	}
+}
+
+// DeleteMessages removes messages from a conversation
+func (f *Fake) DeleteMessages(convID int64, ids ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deleted := make(map[int]bool)
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	var kept []tg.MessageClass
+	for _, m := range f.history[convID] {
+		if !deleted[m.GetID()] {
+			kept = append(kept, m)
+		}
+	}
+	f.history[convID] = kept
+
+	if !f.isChannel(convID) {
+		f.pts++
+		f.updates = append(f.updates, update{pts: f.pts, other: &tg.UpdateDeleteMessages{Messages: ids, Pts: f.pts, PtsCount: 1}})
+	}
+}
+
+// Fail makes the next calls of method return errs, one per call, before it
+// answers normally again
+func (f *Fake) Fail(method string, errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method] = append(f.failures[method], errs...)
+}
+
+// Calls returns how often method was called, failed calls included
+func (f *Fake) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// call records a call of method and returns its next scripted failure.
+// f.mu must be held.
+func (f *Fake) call(method string) error {
+	f.calls[method]++
+	if errs := f.failures[method]; len(errs) > 0 {
+		f.failures[method] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+// ready fails calls made while signed out, like an unauthorized session.
+// f.mu must be held.
+func (f *Fake) ready(method string) error {
+	if err := f.call(method); err != nil {
+		return err
+	}
+	if !f.connected {
+		return fmt.Errorf("client not connected")
+	}
+	if !f.authorized {
+		return tgerr.New(401, "AUTH_KEY_UNREGISTERED")
+	}
+	return nil
+}
+
+func (f *Fake) dialog(convID int64) (models.Conversation, bool) {
+	for _, d := range f.dialogs {
+		if d.ID == convID {
+			return d, true
+		}
+	}
+	return models.Conversation{}, false
+}
+
+// isChannel reports whether a conversation is a channel or a supergroup,
+// basic groups have no access hash
+func (f *Fake) isChannel(convID int64) bool {
+	d, ok := f.dialog(convID)
+	return ok && (d.Type == "channel" || (d.Type == "group" && d.AccessHash != ""))
+}
+
+func (f *Fake) setPeer(convID int64, msg tg.MessageClass) {
+	var peer tg.PeerClass
+	d, _ := f.dialog(convID)
+	switch {
+	case f.isChannel(convID):
+		peer = &tg.PeerChannel{ChannelID: convID}
+	case d.Type == "group":
+		peer = &tg.PeerChat{ChatID: convID}
+	default:
+		peer = &tg.PeerUser{UserID: convID}
+	}
+
+	switch m := msg.(type) {
+	case *tg.Message:
+		if m.PeerID == nil {
+			m.PeerID = peer
+		}
+	case *tg.MessageService:
+		if m.PeerID == nil {
+			m.PeerID = peer
+		}
+	}
+}
+
+func (f *Fake) Connect(ctx context.Context, appID int, appHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("Connect"); err != nil {
+		return err
+	}
+	f.connected = true
+	return nil
+}
+
+func (f *Fake) StartAuth(ctx context.Context, phone string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("StartAuth"); err != nil {
+		return "", err
+	}
+	return "code-hash", nil
+}
+
+func (f *Fake) VerifyCode(ctx context.Context, phone, code, codeHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("VerifyCode"); err != nil {
+		return err
+	}
+	if code != LoginCode || codeHash != "code-hash" {
+		return tgerr.New(400, "PHONE_CODE_INVALID")
+	}
+	f.authorized = true
+	return nil
+}
+
+func (f *Fake) GenerateQRCode(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GenerateQRCode"); err != nil {
+		return "", err
+	}
+	return "tg://login?token=ZmFrZQ", nil
+}
+
+func (f *Fake) CheckQRCode(ctx context.Context, token []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CheckQRCode"); err != nil {
+		return err
+	}
+	if !f.authorized {
+		return tgerr.New(400, "AUTH_TOKEN_INVALID")
+	}
+	return nil
+}
+
+func (f *Fake) IsAuthenticated(ctx context.Context) bool {
+	return f.CheckAuth(ctx) == nil
+}
+
+func (f *Fake) CheckAuth(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ready("CheckAuth")
+}
+
+func (f *Fake) GetCurrentUserInfo(ctx context.Context) (*models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetCurrentUserInfo"); err != nil {
+		return nil, err
+	}
+	user := f.self
+	user.IsActive = true
+	user.LastSyncTime = time.Now()
+	return &user, nil
+}
+
+func (f *Fake) LogOut(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("LogOut"); err != nil {
+		return err
+	}
+	f.connected, f.authorized = false, false
+	return nil
+}
+
+func (f *Fake) GetDialogs(ctx context.Context) ([]models.Conversation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetDialogs"); err != nil {
+		return nil, err
+	}
+	dialogs := append([]models.Conversation(nil), f.dialogs...)
+	// The newest message of the history is the top message of the dialog
+	for i := range dialogs {
+		if top := f.messages(f.history[dialogs[i].ID], dialogs[i].ID); len(top) > 0 {
+			dialogs[i].LastMessageID = top[0].MessageID
+			dialogs[i].LastMessage = top[0].Content
+			dialogs[i].LastTime = top[0].Timestamp
+			dialogs[i].LastSenderID = top[0].FromID
+			dialogs[i].LastSenderName = strings.TrimSpace(top[0].FromFirstName + " " + top[0].FromLastName)
+		}
+	}
+	return dialogs, nil
+}
+
+func (f *Fake) GetForumTopics(ctx context.Context, channelID int64, accessHash string) ([]models.ForumTopic, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetForumTopics"); err != nil {
+		return nil, err
+	}
+	return append([]models.ForumTopic(nil), f.topics[channelID]...), nil
+}
+
+func (f *Fake) GetLinkedChat(ctx context.Context, channelID int64, accessHash string) (*models.Conversation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetLinkedChat"); err != nil {
+		return nil, err
+	}
+	if linked := f.linked[channelID]; linked != nil {
+		conv := *linked
+		return &conv, nil
+	}
+	return nil, nil
+}
+
+func (f *Fake) GetMessagesWithConvInfo(ctx context.Context, peerID int64, limit int, convType, accessHash string) ([]models.Message, error) {
+	return f.historyPage("GetMessagesWithConvInfo", peerID, 0, limit)
+}
+
+func (f *Fake) GetHistory(ctx context.Context, peerID int64, convType, accessHash string, offsetID, limit int) ([]models.Message, error) {
+	return f.historyPage("GetHistory", peerID, offsetID, limit)
+}
+
+func (f *Fake) historyPage(method string, peerID int64, offsetID, limit int) ([]models.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready(method); err != nil {
+		return nil, err
+	}
+	if _, ok := f.dialog(peerID); !ok {
+		return nil, tgerr.New(400, "PEER_ID_INVALID")
+	}
+
+	var history []tg.MessageClass
+	for _, msg := range f.history[peerID] {
+		if len(history) == limit {
+			break
+		}
+		if offsetID == 0 || msg.GetID() < offsetID {
+			history = append(history, msg)
+		}
+	}
+	return f.messages(history, peerID), nil
+}
+
+// GetPostComments returns a page of the replies to postID among the
+// discussion group's messages older than offsetID
+func (f *Fake) GetPostComments(ctx context.Context, channelID int64, accessHash string, postID int, discussionID int64, offsetID, limit int) ([]models.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetPostComments"); err != nil {
+		return nil, err
+	}
+
+	var comments []models.Message
+	for _, msg := range f.messages(f.history[discussionID], discussionID) {
+		if offsetID != 0 && msg.MessageID >= offsetID {
+			continue
+		}
+		if len(comments) < limit && (msg.ReplyToMsgID == postID || msg.ReplyToTopID == postID) {
+			comments = append(comments, msg)
+		}
+	}
+	return comments, nil
+}
+
+func (f *Fake) messages(history []tg.MessageClass, convID int64) []models.Message {
+	var result []models.Message
+	for _, msg := range history {
+		if parsed, ok := f.mapper.Message(msg, f.users, f.chats); ok {
+			parsed.ConversationID = convID
+			result = append(result, parsed)
+		}
+	}
+	return result
+}
+
+func (f *Fake) GetState(ctx context.Context) (*tg.UpdatesState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetState"); err != nil {
+		return nil, err
+	}
+	return f.state(), nil
+}
+
+func (f *Fake) state() *tg.UpdatesState {
+	return &tg.UpdatesState{Pts: f.pts, Date: int(time.Now().Unix()), Seq: f.pts}
+}
+
+// GetUpdates returns the messages, edits and deletions recorded after pts
+func (f *Fake) GetUpdates(ctx context.Context, pts int, date int, qts int) (*tg.UpdatesDifference, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetUpdates"); err != nil {
+		return nil, err
+	}
+
+	difference := &tg.UpdatesDifference{Users: f.users, Chats: f.chats, State: *f.state()}
+	for _, u := range f.updates {
+		if u.pts <= pts {
+			continue
+		}
+		if u.message != nil {
+			difference.NewMessages = append(difference.NewMessages, u.message)
+		} else {
+			difference.OtherUpdates = append(difference.OtherUpdates, u.other)
+		}
+	}
+	return difference, nil
+}
+
+func (f *Fake) ParseUpdatesMessages(updates *tg.UpdatesDifference, userID int64) []models.Message {
+	return telegram.MessagesFromUpdates(f.mapper, updates, userID)
+}
+
+func (f *Fake) GetUserPhotos(ctx context.Context, userID int64, accessHash string, limit int) ([]telegram.ProfilePhoto, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetUserPhotos"); err != nil {
+		return nil, err
+	}
+	return f.profilePhotos(userID, limit), nil
+}
+
+func (f *Fake) GetSelfPhotos(ctx context.Context, limit int) ([]telegram.ProfilePhoto, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetSelfPhotos"); err != nil {
+		return nil, err
+	}
+	return f.profilePhotos(f.self.ID, limit), nil
+}
+
+func (f *Fake) profilePhotos(userID int64, limit int) []telegram.ProfilePhoto {
+	var photos []telegram.ProfilePhoto
+	for _, id := range f.profile[userID] {
+		if len(photos) < limit {
+			photos = append(photos, telegram.ProfilePhoto{ID: id})
+		}
+	}
+	return photos
+}
+
+func (f *Fake) DownloadProfilePhoto(ctx context.Context, photo telegram.ProfilePhoto, w io.Writer) error {
+	return f.download("DownloadProfilePhoto", photo.ID, w)
+}
+
+func (f *Fake) DownloadPeerPhoto(ctx context.Context, peerID int64, convType, accessHash string, photoID int64, w io.Writer) error {
+	return f.download("DownloadPeerPhoto", photoID, w)
+}
+
+// DownloadMessageMedia writes the content of the photo or document of a
+// message set with SetPhoto
+func (f *Fake) DownloadMessageMedia(ctx context.Context, peerID int64, convType, accessHash string, messageID int, w io.Writer) error {
+	f.mu.Lock()
+	var fileID int64
+	for _, m := range f.history[peerID] {
+		msg, ok := m.(*tg.Message)
+		if !ok || msg.ID != messageID {
+			continue
+		}
+		switch media := msg.Media.(type) {
+		case *tg.MessageMediaPhoto:
+			if photo, ok := media.Photo.(*tg.Photo); ok {
+				fileID = photo.ID
+			}
+		case *tg.MessageMediaDocument:
+			if doc, ok := media.Document.(*tg.Document); ok {
+				fileID = doc.ID
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	return f.download("DownloadMessageMedia", fileID, w)
+}
+
+func (f *Fake) download(method string, photoID int64, w io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready(method); err != nil {
+		return err
+	}
+	data, ok := f.photos[photoID]
+	if !ok {
+		return tgerr.New(400, "FILE_REFERENCE_EXPIRED")
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// GetMessageStats returns the counters the messages carry in the history.
+// As on Telegram, views are only known in channels and supergroups.
+func (f *Fake) GetMessageStats(ctx context.Context, peerID int64, convType, accessHash string, ids []int) ([]models.MessageStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetMessageStats"); err != nil {
+		return nil, err
+	}
+
+	current := make(map[int]models.Message)
+	for _, msg := range f.messages(f.history[peerID], peerID) {
+		current[msg.MessageID] = msg
+	}
+
+	now := time.Now()
+	stats := make([]models.MessageStats, len(ids))
+	for i, id := range ids {
+		stats[i] = models.MessageStats{ConversationID: peerID, MessageID: id, CapturedAt: now}
+		msg, ok := current[id]
+		if !ok {
+			continue
+		}
+		if f.isChannel(peerID) {
+			stats[i].Views = msg.Views
+			stats[i].Forwards = msg.Forwards
+			stats[i].Replies = msg.RepliesCount
+		}
+		stats[i].Reactions = msg.Reactions
+	}
+	return stats, nil
+}
+
+// GetPinnedMessages returns the pinned messages of the history, newest first
+func (f *Fake) GetPinnedMessages(ctx context.Context, peerID int64, convType, accessHash string) ([]models.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetPinnedMessages"); err != nil {
+		return nil, err
+	}
+
+	var pinned []models.Message
+	for _, msg := range f.messages(f.history[peerID], peerID) {
+		if msg.IsPinned {
+			pinned = append(pinned, msg)
+		}
+	}
+	return pinned, nil
+}
+
+func (f *Fake) GetScheduledMessages(ctx context.Context, peerID int64, convType, accessHash string) ([]models.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetScheduledMessages"); err != nil {
+		return nil, err
+	}
+	return f.messages(f.scheduled[peerID], peerID), nil
+}
+
+// GetChatSnapshot returns a copy of the snapshot set with SetChatSnapshot
+// taken now, nil if none is set
+func (f *Fake) GetChatSnapshot(ctx context.Context, conv *models.Conversation) (*models.ChatSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetChatSnapshot"); err != nil {
+		return nil, err
+	}
+
+	snap, ok := f.snapshots[conv.ID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *snap
+	copied.CapturedAt = time.Now()
+	copied.CheckedAt = copied.CapturedAt
+	copied.Members = append([]models.ChatMember(nil), snap.Members...)
+	return &copied, nil
+}
+
+func (f *Fake) TakeSeenPeers() []models.Peer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	peers := f.seen
+	f.seen = nil
+	return peers
+}
+
+func (f *Fake) GetContacts(ctx context.Context) ([]models.AccountContact, []models.Peer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetContacts"); err != nil {
+		return nil, nil, err
+	}
+	return append([]models.AccountContact(nil), f.contacts...), append([]models.Peer(nil), f.peers...), nil
+}
+
+func (f *Fake) GetUserBio(ctx context.Context, userID int64, accessHash string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.ready("GetUserBio"); err != nil {
+		return "", err
+	}
+	return f.bios[userID], nil
+}
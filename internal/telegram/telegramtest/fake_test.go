@@ -0,0 +1,182 @@
+package telegramtest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/models"
+	"tgbackup/internal/telegram"
+)
+
+func newFake() *Fake {
+	f := NewFake(models.User{ID: 1, FirstName: "Me"})
+	f.AddUser(&tg.User{ID: 2, FirstName: "Ann"})
+	f.AddDialog(models.Conversation{ID: 2, Type: "user", Title: "Ann"})
+	f.AddDialog(models.Conversation{ID: 300, Type: "group", Title: "Family"})
+	f.AddDialog(models.Conversation{ID: 400, Type: "channel", Title: "News", AccessHash: "7"})
+	return f
+}
+
+func text(id int, s string) *tg.Message {
+	return &tg.Message{ID: id, Message: s, Date: 1700000000 + id}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	f := newFake()
+	for i := 1; i <= 5; i++ {
+		f.AddMessage(2, text(i, "hi"))
+	}
+
+	messages, err := f.GetMessagesWithConvInfo(ctx, 2, 3, "user", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, msg := range messages {
+		ids = append(ids, msg.MessageID)
+		if msg.ConversationID != 2 || msg.FromID != 2 || msg.FromFirstName != "Ann" {
+			t.Errorf("message %d: conversation %d, from %d %q", msg.MessageID, msg.ConversationID, msg.FromID, msg.FromFirstName)
+		}
+	}
+	if len(ids) != 3 || ids[0] != 5 || ids[2] != 3 {
+		t.Errorf("ids = %v, want newest three", ids)
+	}
+
+	older, err := f.GetHistory(ctx, 2, "user", "", 3, 10)
+	if err != nil || len(older) != 2 || older[0].MessageID != 2 {
+		t.Errorf("page before 3 = %+v, %v", older, err)
+	}
+
+	if _, err := f.GetMessagesWithConvInfo(ctx, 999, 10, "user", ""); err == nil {
+		t.Error("unknown peer answered")
+	}
+}
+
+func TestUpdates(t *testing.T) {
+	ctx := context.Background()
+	f := newFake()
+	f.AddMessage(2, text(1, "before"))
+
+	state, err := f.GetState(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.AddMessage(300, text(2, "new"))
+	f.AddMessage(400, text(50, "channel post"))
+	f.EditMessage(2, text(1, "edited"))
+	f.DeleteMessages(300, 2)
+
+	diff, err := f.GetUpdates(ctx, state.Pts, state.Date, state.Qts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := f.ParseUpdatesMessages(diff, 1)
+	if len(messages) != 1 || messages[0].MessageID != 2 || messages[0].ConversationID != 300 || messages[0].UserID != 1 {
+		t.Fatalf("new messages = %+v", messages)
+	}
+	if len(diff.OtherUpdates) != 2 {
+		t.Fatalf("other updates = %d, want 2", len(diff.OtherUpdates))
+	}
+	if edit, ok := diff.OtherUpdates[0].(*tg.UpdateEditMessage); !ok || edit.Message.(*tg.Message).Message != "edited" {
+		t.Errorf("first update = %#v, want the edit", diff.OtherUpdates[0])
+	}
+	if del, ok := diff.OtherUpdates[1].(*tg.UpdateDeleteMessages); !ok || len(del.Messages) != 1 || del.Messages[0] != 2 {
+		t.Errorf("second update = %#v, want the deletion", diff.OtherUpdates[1])
+	}
+	if diff.State.Pts <= state.Pts {
+		t.Errorf("pts did not advance: %d -> %d", state.Pts, diff.State.Pts)
+	}
+
+	// The channel post is only in the history, the deleted message is gone
+	posts, _ := f.GetMessagesWithConvInfo(ctx, 400, 10, "channel", "7")
+	if len(posts) != 1 || posts[0].Content != "channel post" {
+		t.Errorf("channel history = %+v", posts)
+	}
+	if group, _ := f.GetMessagesWithConvInfo(ctx, 300, 10, "group", ""); len(group) != 0 {
+		t.Errorf("group history = %+v, want empty", group)
+	}
+
+	diff, _ = f.GetUpdates(ctx, diff.State.Pts, diff.State.Date, 0)
+	if len(diff.NewMessages)+len(diff.OtherUpdates) != 0 {
+		t.Errorf("updates repeated after the new state")
+	}
+}
+
+func TestFail(t *testing.T) {
+	ctx := context.Background()
+	f := newFake()
+	f.Fail("GetDialogs", FloodWait(30))
+
+	_, err := f.GetDialogs(ctx)
+	wait, ok := telegram.FloodWait(err)
+	if !ok || wait != 30*time.Second {
+		t.Fatalf("err = %v, want a 30s flood wait", err)
+	}
+	if _, err := f.GetDialogs(ctx); err != nil {
+		t.Fatalf("second call: %v", err)
+	}
+	if calls := f.Calls("GetDialogs"); calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	ctx := context.Background()
+	f := newFake()
+	f.SetAuthorized(false)
+
+	if f.IsAuthenticated(ctx) {
+		t.Fatal("signed out fake is authenticated")
+	}
+	if err := f.CheckAuth(ctx); !telegram.IsSessionDead(err) {
+		t.Errorf("CheckAuth = %v, want a dead session", err)
+	}
+
+	hash, err := f.StartAuth(ctx, "+100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.VerifyCode(ctx, "+100", "00000", hash); err == nil {
+		t.Error("wrong code accepted")
+	}
+	if err := f.VerifyCode(ctx, "+100", LoginCode, hash); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := f.GetCurrentUserInfo(ctx); err != nil || user.ID != 1 {
+		t.Errorf("user = %v, %v", user, err)
+	}
+
+	if err := f.LogOut(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if f.IsAuthenticated(ctx) {
+		t.Error("still authenticated after logging out")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	ctx := context.Background()
+	f := newFake()
+	f.SetProfilePhotos(2, 11, 10)
+	f.SetPhoto(11, []byte("new"))
+
+	photos, err := f.GetUserPhotos(ctx, 2, "", 1)
+	if err != nil || len(photos) != 1 || photos[0].ID != 11 {
+		t.Fatalf("photos = %v, %v", photos, err)
+	}
+
+	var buf bytes.Buffer
+	if err := f.DownloadProfilePhoto(ctx, photos[0], &buf); err != nil || buf.String() != "new" {
+		t.Errorf("download = %q, %v", buf.String(), err)
+	}
+	if err := f.DownloadPeerPhoto(ctx, 2, "user", "", 10, &buf); err == nil {
+		t.Error("photo without content downloaded")
+	}
+}
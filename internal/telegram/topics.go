@@ -0,0 +1,95 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"tgbackup/internal/mapper"
+	"tgbackup/internal/models"
+)
+
+// forumTopicsPageSize is the number of topics requested per call
+const forumTopicsPageSize = 100
+
+// GetForumTopics returns all topics of a supergroup with topics enabled
+func (c *Client) GetForumTopics(ctx context.Context, channelID int64, accessHash string) ([]models.ForumTopic, error) {
+	if !c.isConnected || c.api == nil {
+		return nil, fmt.Errorf("client not connected")
+	}
+
+	hash, err := strconv.ParseInt(accessHash, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access hash: %v", err)
+	}
+
+	request := &tg.ChannelsGetForumTopicsRequest{
+		Channel: &tg.InputChannel{ChannelID: channelID, AccessHash: hash},
+		Limit:   forumTopicsPageSize,
+	}
+
+	var topics []models.ForumTopic
+	seen := map[int]bool{}
+	for {
+		result, err := c.api.ChannelsGetForumTopics(ctx, request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get topics of channel %d: %v", channelID, err)
+		}
+
+		var last *tg.ForumTopic
+		for _, t := range result.Topics {
+			topic, ok := t.(*tg.ForumTopic)
+			if !ok || seen[topic.ID] {
+				continue
+			}
+			seen[topic.ID] = true
+			last = topic
+			topics = append(topics, parseForumTopic(topic, channelID))
+		}
+
+		if last == nil || len(topics) >= result.Count {
+			return topics, nil
+		}
+
+		// The next page starts after the top message of the last topic
+		request.OffsetTopic = last.ID
+		request.OffsetID = last.TopMessage
+		request.OffsetDate = messageDate(result.Messages, last.TopMessage)
+	}
+}
+
+func parseForumTopic(topic *tg.ForumTopic, channelID int64) models.ForumTopic {
+	creatorID, _ := mapper.PeerInfo(topic.FromID)
+	return models.ForumTopic{
+		ConversationID: channelID,
+		TopicID:        topic.ID,
+		Title:          topic.Title,
+		IconColor:      topic.IconColor,
+		IconEmojiID:    topic.IconEmojiID,
+		CreatorID:      creatorID,
+		IsClosed:       topic.Closed,
+		IsHidden:       topic.Hidden,
+		IsPinned:       topic.Pinned,
+		TopMessage:     topic.TopMessage,
+		CreatedAt:      time.Unix(int64(topic.Date), 0),
+	}
+}
+
+// messageDate returns the date of a message from a result's message list
+func messageDate(messages []tg.MessageClass, id int) int {
+	for _, m := range messages {
+		if m.GetID() != id {
+			continue
+		}
+		switch msg := m.(type) {
+		case *tg.Message:
+			return msg.Date
+		case *tg.MessageService:
+			return msg.Date
+		}
+	}
+	return 0
+}
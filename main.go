@@ -4,182 +4,79 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/cors"
 	"tgbackup/internal/api"
+	"tgbackup/internal/avatars"
+	"tgbackup/internal/chatstate"
 	"tgbackup/internal/database"
+	"tgbackup/internal/media"
+	"tgbackup/internal/monitor"
+	"tgbackup/internal/peers"
+	"tgbackup/internal/stats"
+	tgsync "tgbackup/internal/sync"
 	"tgbackup/internal/telegram"
 )
 
+// mediaDir is where downloaded and imported media files are stored
+const mediaDir = "./media"
+
 func main() {
 	// Initialize database
 	db, err := database.InitDB()
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
+
+	// Subcommands such as export run instead of the server
+	if len(os.Args) > 1 {
+		code := runCommand(db, os.Args[1:])
+		db.Close()
+		os.Exit(code)
+	}
 	defer db.Close()
 
 	// Initialize Telegram client
 	tgClient := telegram.NewClient()
 
-	// Auto-sync function with incremental updates
-	autoSyncUser := func(ctx context.Context, client *telegram.Client, database *database.DB, userID int64) {
-		log.Printf("Starting auto-sync for user %d", userID)
-		
-		// Get stored updates state
-		storedPts, storedQts, storedDate, storedSeq, err := database.GetUpdatesState(userID)
-		if err != nil {
-			log.Printf("Failed to get updates state for user %d: %v", userID, err)
-			storedPts, storedQts, storedDate, storedSeq = 0, 0, 0, 0
-		}
-		
-		// If no stored state, do initial full sync
-		if storedPts == 0 && storedQts == 0 && storedDate == 0 {
-			log.Printf("No stored state found, doing initial full sync for user %d", userID)
-			
-			// Get dialogs from Telegram
-			dialogs, err := client.GetDialogs(ctx)
-			if err != nil {
-				log.Printf("Auto-sync failed to get dialogs for user %d: %v", userID, err)
-				return
-			}
+	// WebSocket hub for pushing events to the frontend
+	hub := api.NewHub()
 
-			log.Printf("Found %d dialogs for user %d", len(dialogs), userID)
+	// Downloaded message media, avatars and imported files
+	mediaStore := media.NewStore(mediaDir)
 
-			// Save conversations with user_id
-			for i, dialog := range dialogs {
-				dialog.UserID = userID
-				log.Printf("Saving conversation %d/%d: %d (%s) - %s", i+1, len(dialogs), dialog.ID, dialog.Type, dialog.Title)
-				if err := database.SaveConversation(&dialog); err != nil {
-					log.Printf("Auto-sync failed to save conversation %d for user %d: %v", dialog.ID, userID, err)
-				}
-			}
+	// Session health monitor, alerts go to WebSocket clients and the optional webhook
+	// Checks run in the sync loop, which is the only background user of the client
+	sessionMonitor := monitor.NewSessionMonitor(db, tgClient)
+	sessionMonitor.OnAlert(func(alert monitor.Alert) {
+		hub.Broadcast(map[string]interface{}{
+			"type":  "alert",
+			"alert": alert,
+		})
+	})
+	if webhookURL := os.Getenv("TGBACKUP_ALERT_WEBHOOK"); webhookURL != "" {
+		sessionMonitor.OnAlert(monitor.WebhookNotifier(webhookURL))
+	}
 
-			// Sync recent messages for each conversation
-			for i, dialog := range dialogs {
-				log.Printf("Auto-syncing messages %d/%d for user %d, conversation %d (%s) - %s", i+1, len(dialogs), userID, dialog.ID, dialog.Type, dialog.Title)
-				
-				// Add delay between requests to avoid rate limiting
-				if i > 0 {
-					time.Sleep(1 * time.Second)
-				}
-				
-				messages, err := client.GetMessagesWithConvInfo(ctx, dialog.ID, 50, dialog.Type, dialog.AccessHash)
-				if err != nil {
-					log.Printf("Auto-sync failed to get messages for user %d, conversation %d (%s): %v", userID, dialog.ID, dialog.Title, err)
-					continue
-				}
+	// Snapshots of views, forwards and reactions of recent messages
+	statsRefresher := stats.NewRefresher(db, tgClient, 30*time.Minute)
 
-				log.Printf("Auto-sync retrieved %d messages for user %d, conversation %d (%s)", len(messages), userID, dialog.ID, dialog.Title)
-				for _, msg := range messages {
-					msg.UserID = userID
-					if err := database.SaveMessage(&msg); err != nil {
-						log.Printf("Auto-sync failed to save message %d for user %d, conversation %d: %v", msg.MessageID, userID, dialog.ID, err)
-					}
-				}
-			}
-			
-			// Get current state after initial sync
-			state, err := client.GetState(ctx)
-			if err != nil {
-				log.Printf("Failed to get current state for user %d: %v", userID, err)
-			} else {
-				// Save the current state
-				database.SaveUpdatesState(userID, state.Pts, state.Qts, state.Date, state.Seq)
-				log.Printf("Saved initial state for user %d: pts=%d, qts=%d, date=%d, seq=%d", userID, state.Pts, state.Qts, state.Date, state.Seq)
-			}
-		} else {
-			// Do incremental sync using updates
-			log.Printf("Doing incremental sync for user %d with state: pts=%d, qts=%d, date=%d, seq=%d", userID, storedPts, storedQts, storedDate, storedSeq)
-			
-			updates, err := client.GetUpdates(ctx, storedPts, storedDate, storedQts)
-			if err != nil {
-				log.Printf("Failed to get updates for user %d: %v", userID, err)
-				// If incremental sync fails (e.g., PERSISTENT_TIMESTAMP_EMPTY), fall back to just channel sync
-				log.Printf("Falling back to channel sync only for user %d", userID)
-			} else {
-				// Parse and save new messages from updates
-				newMessages := client.ParseUpdatesMessages(updates, userID)
-				log.Printf("Found %d new messages from updates for user %d", len(newMessages), userID)
-				
-				for _, msg := range newMessages {
-					if err := database.SaveMessage(&msg); err != nil {
-						log.Printf("Failed to save new message %d for user %d: %v", msg.MessageID, userID, err)
-					}
-				}
-				
-				// Only update state if we have meaningful data
-				state := updates.State
-				if state.Pts > 0 || state.Date > 0 {
-					database.SaveUpdatesState(userID, state.Pts, state.Qts, state.Date, state.Seq)
-					log.Printf("Updated state for user %d: pts=%d, qts=%d, date=%d, seq=%d", userID, state.Pts, state.Qts, state.Date, state.Seq)
-				} else {
-					log.Printf("Received empty state, keeping existing state for user %d", userID)
-				}
-			}
-			
-			// Always sync channels separately as they might not appear in regular updates
-			go func() {
-				channels, err := database.GetConversationsByUserID(userID)
-				if err != nil {
-					log.Printf("Failed to get conversations for channel sync: %v", err)
-					return
-				}
-				
-				for _, conv := range channels {
-					if (conv.Type == "channel" || conv.Type == "group") && conv.AccessHash != "" {
-						log.Printf("Syncing %s %d (%s) for user %d", conv.Type, conv.ID, conv.Title, userID)
-						
-						// Get the latest message ID from database
-						latestMessages, err := database.GetMessagesByUserAndConversation(userID, conv.ID, 1, 0)
-						var offsetID int = 0
-						if err == nil && len(latestMessages) > 0 {
-							offsetID = latestMessages[0].MessageID
-							log.Printf("%s %d (%s) latest message ID in DB: %d", conv.Type, conv.ID, conv.Title, offsetID)
-						} else {
-							log.Printf("%s %d (%s) has no messages in DB, fetching recent messages", conv.Type, conv.ID, conv.Title)
-						}
-						
-						// Get recent messages from channel/group - fetch more messages to ensure we get new ones
-						channelMessages, err := client.GetMessagesWithConvInfo(ctx, conv.ID, 50, conv.Type, conv.AccessHash)
-						if err != nil {
-							log.Printf("Failed to sync %s %d (%s): %v", conv.Type, conv.ID, conv.Title, err)
-							continue
-						}
-						
-						log.Printf("%s %d (%s) fetched %d messages from Telegram", conv.Type, conv.ID, conv.Title, len(channelMessages))
-						
-						newChannelMsgCount := 0
-						for _, msg := range channelMessages {
-							msg.UserID = userID
-							log.Printf("%s message: ID=%d, Date=%s, Content=%.50s...", conv.Type, msg.MessageID, msg.Timestamp.Format("2006-01-02 15:04:05"), msg.Content)
-							// Only save if this is a newer message or if we have no messages yet
-							if offsetID == 0 || msg.MessageID > offsetID {
-								if err := database.SaveMessage(&msg); err != nil {
-									log.Printf("Failed to save %s message %d: %v", conv.Type, msg.MessageID, err)
-								} else {
-									newChannelMsgCount++
-									log.Printf("Saved new %s message %d", conv.Type, msg.MessageID)
-								}
-							} else {
-								log.Printf("Skipping old message %d (not newer than %d)", msg.MessageID, offsetID)
-							}
-						}
-						
-						if newChannelMsgCount > 0 {
-							log.Printf("Successfully synced %d new messages from %s %d (%s)", newChannelMsgCount, conv.Type, conv.ID, conv.Title)
-						} else {
-							log.Printf("No new messages found for %s %d (%s)", conv.Type, conv.ID, conv.Title)
-						}
-					}
-				}
-			}()
-		}
-		
-		log.Printf("Auto-sync completed for user %d", userID)
-	}
+	// Pinned and scheduled messages of every conversation
+	chatStateRefresher := chatstate.NewRefresher(db, tgClient, 30*time.Minute)
+
+	// Contacts and profile history of the users seen in messages
+	peerRefresher := peers.NewRefresher(db, tgClient, 6*time.Hour)
+
+	// Current and past avatars of the account, its contacts and its chats
+	avatarRefresher := avatars.NewRefresher(db, tgClient, mediaStore, 6*time.Hour)
+
+	// Full sync of new accounts, incremental sync and channel sweep afterwards
+	syncEngine := tgsync.NewEngine(db, tgClient, mediaStore)
+	// Polls each conversation as often as it is active, next runs survive restarts
+	syncScheduler := tgsync.NewScheduler(db, syncEngine)
 
 	// Try to restore session on startup and auto-sync
 	go func() {
@@ -210,15 +107,15 @@ func main() {
 					
 					// Auto-sync after startup for this user
 					log.Printf("Starting initial auto-sync for user %d after startup", userInfo.ID)
-					autoSyncUser(ctx, tgClient, db, userInfo.ID)
+					syncScheduler.RunDue(ctx, userInfo.ID)
 				}
 			}
 		}
 	}()
 
-	// Start periodic auto-sync for all active users
+	// Start scheduled auto-sync for all active users
 	go func() {
-		ticker := time.NewTicker(1 * time.Minute) // 1分钟间隔
+		ticker := time.NewTicker(15 * time.Second) // 只同步到期的会话
 		defer ticker.Stop()
 
 		for range ticker.C {
@@ -235,36 +132,30 @@ func main() {
 				if !user.IsActive {
 					continue // Skip inactive users
 				}
-
-				// Check if we have a valid session for this user
-				session, err := db.GetActiveAuthSessionByUserID(user.ID)
-				if err != nil {
-					log.Printf("No active session for user %d, skipping periodic sync", user.ID)
-					continue
-				}
-
-				// Try to connect with user's session
-				if err := tgClient.Connect(ctx, session.AppID, session.AppHash); err != nil {
-					log.Printf("Failed to connect for user %d periodic sync: %v", user.ID, err)
+				if !syncScheduler.Due(user.ID) {
 					continue
 				}
 
-				// Check if still authenticated
-				if !tgClient.IsAuthenticated(ctx) {
-					log.Printf("User %d session no longer authenticated, marking inactive", user.ID)
-					user.IsActive = false
-					db.SaveUser(&user)
+				// Connect with the user's session and make sure it is still valid,
+				// revoked sessions are recorded and alerted on by the monitor
+				if !sessionMonitor.Check(ctx, &user) {
+					log.Printf("Session of user %d not usable, skipping periodic sync", user.ID)
 					continue
 				}
 
-				log.Printf("Starting periodic sync for user %d (%s)", user.ID, user.FirstName)
-				autoSyncUser(ctx, tgClient, db, user.ID)
+				log.Printf("Starting scheduled sync for user %d (%s)", user.ID, user.FirstName)
+				syncScheduler.RunDue(ctx, user.ID)
+				statsRefresher.RefreshIfDue(ctx, user.ID)
+				chatStateRefresher.RefreshIfDue(ctx, user.ID)
+				peerRefresher.RefreshIfDue(ctx, user.ID)
+				peerRefresher.SaveSeen(user.ID)
+				avatarRefresher.RefreshIfDue(ctx, user.ID)
 			}
 		}
 	}()
 
 	// Initialize API handlers
-	apiHandler := api.NewHandler(db, tgClient)
+	apiHandler := api.NewHandler(db, tgClient, hub, mediaStore, syncScheduler)
 
 	// Setup Gin router
 	r := gin.Default()
@@ -286,9 +177,32 @@ func main() {
 		v1.GET("/auth/qr-status", apiHandler.CheckQRStatus)
 		v1.GET("/users", apiHandler.GetUsers)
 		v1.GET("/users/:id/conversations", apiHandler.GetUserConversations)
+		v1.GET("/users/:id/session", apiHandler.GetUserSession)
+		v1.GET("/users/:id/contacts", apiHandler.GetContacts)
+		v1.GET("/users/:id/peers", apiHandler.GetPeers)
+		v1.GET("/users/:id/peers/:peer_id", apiHandler.GetPeer)
+		v1.GET("/users/:id/avatars/:owner_id", apiHandler.GetAvatars)
+		v1.GET("/users/:id/sync-policies", apiHandler.GetSyncPolicies)
+		v1.POST("/users/:id/sync-policies", apiHandler.CreateSyncPolicy)
+		v1.PUT("/users/:id/sync-policies/:policy_id", apiHandler.UpdateSyncPolicy)
+		v1.DELETE("/users/:id/sync-policies/:policy_id", apiHandler.DeleteSyncPolicy)
+		v1.GET("/users/:id/schedule", apiHandler.GetSchedule)
+		v1.POST("/users/:id/logout", apiHandler.Logout)
+		v1.DELETE("/users/:id", apiHandler.DeleteUser)
 		v1.GET("/conversations", apiHandler.GetConversations)
 		v1.GET("/conversations/:id/messages", apiHandler.GetMessages)
+		v1.GET("/conversations/:id/messages/:message_id/thread", apiHandler.GetThread)
+		v1.GET("/conversations/:id/topics", apiHandler.GetTopics)
+		v1.GET("/conversations/:id/pinned", apiHandler.GetPinnedMessages)
+		v1.GET("/conversations/:id/scheduled", apiHandler.GetScheduledMessages)
+		v1.GET("/conversations/:id/snapshots", apiHandler.GetChatSnapshots)
+		v1.GET("/conversations/:id/members", apiHandler.GetChatMembers)
+		v1.GET("/conversations/:id/members/diff", apiHandler.GetMemberDiff)
+		v1.GET("/conversations/:id/messages/:message_id/stats", apiHandler.GetMessageStats)
+		v1.GET("/conversations/:id/messages/:message_id/comments", apiHandler.GetComments)
 		v1.POST("/sync", apiHandler.SyncMessages)
+		v1.GET("/export", apiHandler.Export)
+		v1.GET("/media/*path", apiHandler.GetMedia)
 		v1.GET("/ws", apiHandler.WebSocketHandler)
 	}
 
@@ -2,6 +2,7 @@ import React, { useState, useEffect, useRef, useCallback } from 'react';
 import styled from 'styled-components';
 import { FaDownload, FaImage, FaFile, FaVideo } from 'react-icons/fa';
 import axios from 'axios';
+import { mediaSrc } from '../media';
 
 const ChatContainer = styled.div`
   display: flex;
@@ -57,6 +58,33 @@ const MessageGroup = styled.div`
   margin-bottom: 20px;
 `;
 
+const ServiceMessage = styled.div`
+  text-align: center;
+  margin: 10px 0;
+
+  span {
+    display: inline-block;
+    background: rgba(0, 0, 0, 0.06);
+    color: #555;
+    font-size: 13px;
+    padding: 4px 12px;
+    border-radius: 12px;
+  }
+`;
+
+const SenderLabel = styled.span`
+  font-size: 12px;
+  font-weight: normal;
+  color: #666;
+  margin-left: 6px;
+`;
+
+const MessageMeta = styled.div`
+  font-size: 12px;
+  color: #0088cc;
+  margin-bottom: 4px;
+`;
+
 const DateSeparator = styled.div`
   text-align: center;
   margin: 20px 0;
@@ -248,10 +276,11 @@ const ChatView = ({ conversation }) => {
         setMessages(prev => [...newMessages, ...prev]);
       }
 
-      const newOffset = currentOffset + newMessages.length;
+      // Albums arrive merged, the server tells how many stored messages the page covered
+      const newOffset = response.data.next_offset;
       setOffset(newOffset);
       offsetRef.current = newOffset;
-      setHasMore(newMessages.length === 50);
+      setHasMore(response.data.has_more);
       
     } catch (error) {
       console.error('Failed to load messages:', error);
@@ -326,6 +355,15 @@ const ChatView = ({ conversation }) => {
   };
 
   const renderMessage = (message) => {
+    // 服务消息(入群、退群、置顶、改名、通话等)居中显示
+    if (message.message_type === 'service') {
+      return (
+        <ServiceMessage key={`${message.id}-${message.message_id}`}>
+          <span>{message.content} · {formatTime(message.timestamp)}</span>
+        </ServiceMessage>
+      );
+    }
+
     const isMedia = message.message_type !== 'text';
     // 构建发送者显示名称，优先显示昵称+用户名组合
     let senderName = '';
@@ -342,11 +380,30 @@ const ChatView = ({ conversation }) => {
     } else if (username) {
       // 只有用户名：显示 "@用户名"
       senderName = `@${username}`;
+    } else if (message.sender_type === 'channel' || message.sender_type === 'anonymous_admin') {
+      // 频道或匿名管理员发送但没有名称：显示会话名称
+      senderName = conversation.title;
     } else {
       // 都没有：显示 "未知用户"
       senderName = '未知用户';
     }
 
+    // 发送者身份标签: 频道、匿名管理员、频道签名和 inline 机器人
+    const senderLabels = [];
+    if (message.sender_type === 'channel') {
+      senderLabels.push('频道');
+    } else if (message.sender_type === 'chat') {
+      senderLabels.push('群组');
+    } else if (message.sender_type === 'anonymous_admin') {
+      senderLabels.push('匿名管理员');
+    }
+    if (message.post_author) {
+      senderLabels.push(message.post_author);
+    }
+    if (message.via_bot_id) {
+      senderLabels.push('通过机器人');
+    }
+
     return (
       <Message key={`${message.id}-${message.message_id}`}>
         <MessageAvatar>
@@ -354,18 +411,29 @@ const ChatView = ({ conversation }) => {
         </MessageAvatar>
         <MessageContent>
           <MessageHeader>
-            <MessageSender>{senderName}</MessageSender>
-            <MessageTime>{formatTime(message.timestamp)}</MessageTime>
+            <MessageSender>
+              {senderName}
+              {senderLabels.length > 0 && <SenderLabel>{senderLabels.join(' · ')}</SenderLabel>}
+            </MessageSender>
+            <MessageTime>{message.is_pinned ? '📌 ' : ''}{formatTime(message.timestamp)}</MessageTime>
           </MessageHeader>
+          {message.fwd_from_name && (
+            <MessageMeta>转发自 {message.fwd_from_name}</MessageMeta>
+          )}
+          {message.reply_to_msg_id && (
+            <MessageMeta>回复消息 #{message.reply_to_msg_id}{message.reply_quote ? `: ${message.reply_quote}` : ''}</MessageMeta>
+          )}
           
           {isMedia ? (
-            <MediaMessage onClick={() => message.media_url && window.open(message.media_url)}>
+            <MediaMessage onClick={() => mediaSrc(message.media_url) && window.open(mediaSrc(message.media_url))}>
               <MediaIcon>
                 {getMediaIcon(message.message_type)}
               </MediaIcon>
               <MediaInfo>
                 <MediaTitle>{message.content}</MediaTitle>
-                <MediaDescription>{message.message_type}</MediaDescription>
+                <MediaDescription>
+                  {message.album ? `album · ${message.album.length} items` : message.message_type}
+                </MediaDescription>
               </MediaInfo>
               <FaDownload />
             </MediaMessage>
@@ -374,6 +442,12 @@ const ChatView = ({ conversation }) => {
               {message.content}
             </MessageBubble>
           )}
+          {(message.reactions || message.views) && (
+            <MessageMeta>
+              {(message.reactions || []).map(r => `${r.emoji || '★'} ${r.count}`).join('  ')}
+              {message.views ? `  · ${message.views} 次浏览` : ''}
+            </MessageMeta>
+          )}
         </MessageContent>
       </Message>
     );
@@ -410,7 +484,15 @@ const ChatView = ({ conversation }) => {
     <ChatContainer>
       <ChatHeader>
         <Avatar color="#0088cc">
-          {getInitials(conversation.title)}
+          {mediaSrc(conversation.avatar_url) ? (
+            <img
+              src={mediaSrc(conversation.avatar_url)}
+              alt={conversation.title}
+              style={{ width: '100%', height: '100%', borderRadius: '50%', objectFit: 'cover' }}
+            />
+          ) : (
+            getInitials(conversation.title)
+          )}
         </Avatar>
         <ChatInfo>
           <ChatTitle>{conversation.title}</ChatTitle>
@@ -1,5 +1,6 @@
 import React from 'react';
 import styled from 'styled-components';
+import { mediaSrc } from '../media';
 
 const ListContainer = styled.div`
   flex: 1;
@@ -164,9 +165,9 @@ const ConversationList = ({
         >
           <ConversationHeader>
             <Avatar color={getAvatarColor(conversation.type)}>
-              {conversation.avatar_url && !conversation.avatar_url.startsWith('telegram://') ? (
+              {mediaSrc(conversation.avatar_url) ? (
                 <img 
-                  src={conversation.avatar_url} 
+                  src={mediaSrc(conversation.avatar_url)} 
                   alt={conversation.title}
                   style={{ 
                     width: '100%', 
@@ -0,0 +1,12 @@
+// mediaSrc turns a media_url or avatar_url into a link the browser can load.
+// Files in the local media store (media://...) are served by the API,
+// telegram:// placeholders are files that were never downloaded.
+export const mediaSrc = (url) => {
+  if (!url || url.startsWith('telegram://')) {
+    return null;
+  }
+  if (url.startsWith('media://')) {
+    return `/api/v1/media/${url.slice('media://'.length)}`;
+  }
+  return url;
+};